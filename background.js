@@ -27,6 +27,10 @@ let settings = {
   detectNewSources: true // Auto-detect new analytics sources
 };
 
+// Bodies on non-analytics paths up to this size are checked for a known
+// vendor's payload (first-party proxies); larger ones are likely uploads
+const MAX_SNIFFED_BODY_BYTES = 64 * 1024;
+
 // Track last event activity time (in-memory, resets on extension reload)
 let lastEventTime = Date.now();
 let autoPaused = false; // Track if we auto-paused (vs manual pause)
@@ -190,8 +194,12 @@ chrome.webRequest.onBeforeRequest.addListener(
     const source = configManager.findSourceForUrl(details.url);
 
     if (!source) {
-      // Track unmatched analytics requests for suggestions (if enabled)
-      if (settings.detectNewSources && looksLikeAnalyticsEndpoint(details.url) && details.requestBody) {
+      // Track unmatched analytics requests for suggestions (if enabled). Small
+      // bodies on other paths are checked too: a first-party proxy of Segment,
+      // RudderStack or Amplitude is recognised by its payload
+      const bodyBytes = (details.requestBody?.raw || []).reduce((total, chunk) => total + (chunk.bytes?.byteLength || 0), 0);
+      if (settings.detectNewSources && details.requestBody &&
+          (looksLikeAnalyticsEndpoint(details.url) || bodyBytes <= MAX_SNIFFED_BODY_BYTES)) {
         AnalyticsParser.decodeRequestBodyAsync(details.requestBody).then(payload => {
           if (payload) {
             configManager.trackUnmatchedRequest(details.url, payload);
@@ -468,6 +476,14 @@ chrome.runtime.onMessage.addListener((message, sender, sendResponse) => {
       });
       return true;
 
+    case 'applySyncedSources':
+      configManager.applySyncedSources(message.sources || []).then(adopted => {
+        sendResponse({ success: true, adopted });
+      }).catch(err => {
+        sendResponse({ success: false, error: err.message });
+      });
+      return true;
+
     case 'removeSource':
       configManager.removeSource(message.id).then(success => {
         sendResponse({ success });
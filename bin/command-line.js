@@ -0,0 +1,185 @@
+/**
+ * Command table, flag parsing and help text for loggy-proxy
+ *
+ * Each command declares its words ("sources add"), positional arguments,
+ * options and handler. Parsing rejects unknown options and missing or extra
+ * arguments with a UsageError (exit code 2), and every command and command
+ * group answers --help from the same declarations.
+ */
+
+// Exit codes shared by every command
+export const EXIT = {
+  OK: 0,
+  FAILURE: 1, // The command ran but failed (or, for checks, found a problem)
+  USAGE: 2    // Bad command line
+};
+
+export class UsageError extends Error {
+  /**
+   * @param {string} message
+   * @param {object} command - Command the error is about (its help is suggested)
+   */
+  constructor(message, command = null) {
+    super(message);
+    this.name = 'UsageError';
+    this.command = command;
+  }
+}
+
+/**
+ * Required and optional positionals from an args spec such as "<id> [file]"
+ * (a last "[args...]" takes any number)
+ */
+function positionalSpec(args = '') {
+  const tokens = args.split(/\s+/).filter(Boolean);
+  return {
+    min: tokens.filter(token => token.startsWith('<')).length,
+    max: tokens.some(token => token.endsWith('...]')) ? Infinity : tokens.length
+  };
+}
+
+/**
+ * Find the command named by the leading positionals (longest match first)
+ * @returns {{command: object|null, rest: Array<string>}}
+ */
+function findCommand(commands, positionals) {
+  const matches = commands
+    .filter(command => {
+      const words = command.name.split(' ');
+      return words.every((word, i) => positionals[i] === word);
+    })
+    .sort((a, b) => b.name.split(' ').length - a.name.split(' ').length);
+
+  if (matches.length === 0) return { command: null, rest: positionals };
+  return { command: matches[0], rest: positionals.slice(matches[0].name.split(' ').length) };
+}
+
+/**
+ * Split raw arguments into positionals and "--name value" / "--name=value" / "--flag" options
+ * @param {Array<string>} args
+ * @param {Array<object>} optionSpecs - { name, value } (value set = takes an argument)
+ * @param {object} command - For error messages
+ * @param {boolean} strict - Reject unknown options and missing values
+ */
+function parseOptions(args, optionSpecs, command, strict = true) {
+  const positionals = [];
+  const options = {};
+
+  for (let i = 0; i < args.length; i++) {
+    const arg = args[i];
+    if (arg === '--') {
+      positionals.push(...args.slice(i + 1));
+      break;
+    }
+    if (!arg.startsWith('--') || arg === '-') {
+      positionals.push(arg);
+      continue;
+    }
+
+    const [name, inline] = arg.slice(2).split(/=(.*)/s);
+    const spec = optionSpecs.find(option => option.name === name);
+    if (!spec) {
+      if (strict) throw new UsageError(`Unknown option --${name}`, command);
+      continue;
+    }
+
+    if (!spec.value) {
+      if (inline !== undefined && strict) {
+        throw new UsageError(`--${name} does not take a value`, command);
+      }
+      options[name] = true;
+    } else if (inline !== undefined) {
+      options[name] = inline;
+    } else if (i + 1 < args.length && !args[i + 1].startsWith('--')) {
+      options[name] = args[++i];
+    } else if (strict) {
+      throw new UsageError(`--${name} needs a value ${spec.value}`, command);
+    }
+  }
+
+  return { positionals, options };
+}
+
+/**
+ * Resolve a command line against the command table
+ * @param {Array<string>} args - process.argv.slice(2)
+ * @param {Array<object>} commands - { name, args, summary, description, options, run }
+ * @param {Array<object>} globalOptions - Options every command accepts
+ * @returns {object} - { command, positionals, options, help } (command null for top-level help)
+ */
+export function parseCommandLine(args, commands, globalOptions) {
+  // Options can come anywhere, so find the command words among the positionals first
+  const allOptions = [...globalOptions, ...commands.flatMap(command => command.options || [])];
+  const specs = [...new Map(allOptions.map(option => [option.name, option])).values()];
+  const words = parseOptions(args, specs, null, false).positionals;
+
+  // (a --help after "--" belongs to the arguments, e.g. a command to run)
+  const ownArgs = args.includes('--') ? args.slice(0, args.indexOf('--')) : args;
+  const helpRequested = ownArgs.includes('--help') || ownArgs.includes('-h') || words[0] === 'help';
+  const commandWords = words[0] === 'help' ? words.slice(1) : words;
+  const { command } = findCommand(commands, commandWords);
+
+  if (helpRequested) {
+    return { command, group: command ? null : commandWords[0] || null, help: true };
+  }
+  if (!command) {
+    if (commandWords.length === 0) {
+      return { command: null, group: null, help: true };
+    }
+    const group = commands.some(c => c.name.split(' ')[0] === commandWords[0]) ? commandWords[0] : null;
+    throw new UsageError(group && commandWords.length > 1
+      ? `Unknown command "${group} ${commandWords[1]}"`
+      : group ? `"${group}" needs a subcommand` : `Unknown command "${commandWords[0]}"`, group ? { name: group, group: true } : null);
+  }
+
+  // Parse again with only the options this command (and the globals) accept
+  const parsed = parseOptions(args, [...globalOptions, ...(command.options || [])], command);
+  const positionals = findCommand([command], parsed.positionals).rest;
+  const { min, max } = positionalSpec(command.args);
+  if (positionals.length < min) {
+    throw new UsageError(`Missing ${command.args.split(/\s+/)[positionals.length]}`, command);
+  }
+  if (positionals.length > max) {
+    throw new UsageError(`Unexpected argument "${positionals[max]}"`, command);
+  }
+
+  return { command, positionals, options: parsed.options, help: false };
+}
+
+function optionLines(options) {
+  const rows = options.map(option => [`--${option.name}${option.value ? ` ${option.value}` : ''}`, option.description]);
+  const width = Math.max(...rows.map(([flag]) => flag.length)) + 2;
+  return rows.map(([flag, description]) => `  ${flag.padEnd(width)}${description}`);
+}
+
+/**
+ * Help for one command, a group ("cert"), or everything (command and group null)
+ * @returns {string}
+ */
+export function formatHelp({ program, commands, globalOptions, command = null, group = null }) {
+  const lines = [];
+
+  if (command) {
+    lines.push(`Usage: ${program} ${command.name}${command.args ? ` ${command.args}` : ''}${(command.options || []).length ? ' [options]' : ''}`);
+    lines.push('', command.description || command.summary);
+    if ((command.options || []).length) {
+      lines.push('', 'Options:', ...optionLines(command.options));
+    }
+    lines.push('', 'Global options:', ...optionLines(globalOptions));
+    return lines.join('\n');
+  }
+
+  const listed = group ? commands.filter(c => c.name.split(' ')[0] === group) : commands;
+  if (group && listed.length === 0) {
+    return formatHelp({ program, commands, globalOptions });
+  }
+
+  const rows = listed.map(c => [`${c.name}${c.args ? ` ${c.args}` : ''}`, c.summary]);
+  const width = Math.max(...rows.map(([usage]) => usage.length)) + 2;
+  lines.push(`Usage: ${program} [global options] ${group || '<command>'}${group ? ' <subcommand>' : ''} [options]`);
+  lines.push('', 'Commands:', ...rows.map(([usage, summary]) => `  ${usage.padEnd(width)}${summary}`));
+  lines.push('', 'Global options:', ...optionLines(globalOptions));
+  lines.push('', `Run "${program} <command> --help" for a command's options.`);
+  lines.push(`Exit codes: ${EXIT.OK} success, ${EXIT.FAILURE} failure, ${EXIT.USAGE} bad command line.`);
+  return lines.join('\n');
+}
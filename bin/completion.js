@@ -0,0 +1,222 @@
+/**
+ * Shell completion for loggy-proxy
+ *
+ * `loggy-proxy completion <shell>` prints a small script that, on every Tab,
+ * runs `loggy-proxy __complete <words...>` with the words typed so far (the
+ * last one being completed, possibly empty). That prints one candidate per
+ * line, "value<Tab>description", or just ":files" when the shell should
+ * complete file names itself. Candidates come from the command table:
+ *
+ * - command words, and options (with their descriptions)
+ * - option values listed in the placeholder, e.g. "<json|ndjson|csv>"
+ * - values named by `complete` on an option, or `completeArgs` on a command
+ *   for its positionals: an array, or "sources"/"profiles" (looked up when
+ *   completing)
+ * - file names for <file> placeholders
+ */
+
+export const SHELLS = ['bash', 'zsh', 'fish'];
+
+const FILES = ':files';
+
+/**
+ * Join the "--name" "=" "value" words bash splits "--name=value" into
+ */
+function joinAssignments(words) {
+  const joined = [];
+  for (let i = 0; i < words.length; i++) {
+    if (words[i] === '=' && joined.length && joined[joined.length - 1].startsWith('--')) {
+      joined[joined.length - 1] += '=' + (i + 1 < words.length ? words[++i] : '');
+    } else {
+      joined.push(words[i]);
+    }
+  }
+  return joined;
+}
+
+/**
+ * Values for an option or positional: its `complete` list or lookup, the
+ * choices in its placeholder, or file names
+ */
+function valuesFor(placeholder, complete, lookups) {
+  if (Array.isArray(complete)) return complete;
+  if (complete) return lookups[complete] ? lookups[complete]() : [];
+  if (/^<[^>]*\|[^>]*>$/.test(placeholder || '')) return placeholder.slice(1, -1).split('|');
+  if (/file/.test(placeholder || '')) return FILES;
+  return [];
+}
+
+function matching(candidates, current, prefix = '') {
+  return candidates
+    .map(candidate => (typeof candidate === 'string' ? { value: candidate } : candidate))
+    .map(candidate => ({ ...candidate, value: prefix + candidate.value }))
+    .filter(candidate => candidate.value.startsWith(current));
+}
+
+/**
+ * Candidates for the last word of a partial command line
+ * @param {Array<string>} words - Words after the program name; the last is being completed
+ * @param {Array<object>} commands - Command table
+ * @param {Array<object>} globalOptions - Options every command accepts
+ * @param {object} lookups - { sources: () => [ids], profiles: () => [names] }
+ * @returns {Array<object>|string} - { value, description } candidates, or ":files"
+ */
+export function complete(words, commands, globalOptions, lookups = {}) {
+  const typed = joinAssignments(words.length ? words : ['']);
+  const current = typed.pop();
+
+  const allOptions = [...globalOptions, ...commands.flatMap(command => command.options || [])];
+  const optionSpec = name => allOptions.find(option => option.name === name);
+
+  // Words before the current one, without options and their values
+  const positionals = [];
+  let pendingOption = null;
+  let afterSeparator = false;
+  for (const word of typed) {
+    if (pendingOption) {
+      pendingOption = null;
+    } else if (afterSeparator || !word.startsWith('--')) {
+      positionals.push(word);
+    } else if (word === '--') {
+      afterSeparator = true;
+    } else {
+      const spec = optionSpec(word.slice(2));
+      if (spec && spec.value && !word.includes('=')) pendingOption = spec;
+    }
+  }
+
+  const commandWords = positionals[0] === 'help' ? positionals.slice(1) : positionals;
+  const command = commands
+    .filter(c => c.name.split(' ').every((word, i) => commandWords[i] === word))
+    .sort((a, b) => b.name.split(' ').length - a.name.split(' ').length)[0];
+  const options = [...(command ? command.options || [] : []), ...globalOptions];
+  // Option names are shared between commands ("--format"), so look the
+  // pending one up again among this command's
+  if (pendingOption) {
+    pendingOption = options.find(option => option.name === pendingOption.name) || pendingOption;
+  }
+
+  const optionValues = (spec, prefix) => {
+    const values = valuesFor(spec.value, spec.complete, lookups);
+    if (values === FILES) return FILES;
+    // Comma-separated lists ("--source a,b") complete their last item
+    const listPrefix = spec.complete === 'sources' ? current.slice(prefix.length).replace(/[^,]*$/, '') : '';
+    return matching(values, current, prefix + listPrefix);
+  };
+
+  if (pendingOption) {
+    return optionValues(pendingOption, '');
+  }
+  if (current.startsWith('--') && current.includes('=') && !afterSeparator) {
+    const spec = options.find(option => option.name === current.slice(2, current.indexOf('=')));
+    return spec && spec.value ? optionValues(spec, current.slice(0, current.indexOf('=') + 1)) : [];
+  }
+  if (current.startsWith('-') && !afterSeparator) {
+    return matching(options
+      .filter(option => option.name !== 'help' || !positionals.includes('help'))
+      .map(option => ({ value: `--${option.name}`, description: option.description })), current);
+  }
+
+  if (!command || positionals[0] === 'help') {
+    // Next command word: a group, a command or a subcommand
+    const next = new Map();
+    if (commandWords.length === 0 && positionals[0] !== 'help') {
+      next.set('help', 'Show help for a command');
+    }
+    for (const c of commands) {
+      const words = c.name.split(' ');
+      if (words.length <= commandWords.length || !commandWords.every((word, i) => words[i] === word)) continue;
+      const word = words[commandWords.length];
+      if (!next.has(word)) next.set(word, words.length === commandWords.length + 1 ? c.summary : `${word} commands`);
+    }
+    return matching([...next].map(([value, description]) => ({ value, description })), current);
+  }
+
+  const index = commandWords.length - command.name.split(' ').length;
+  const placeholder = (command.args || '').split(/\s+/).filter(Boolean)[index];
+  if (!placeholder) return [];
+  const values = valuesFor(placeholder, (command.completeArgs || [])[index], lookups);
+  return values === FILES ? FILES : matching(values, current);
+}
+
+/**
+ * Lines printed by `__complete`
+ */
+export function formatCandidates(candidates) {
+  if (candidates === FILES) return FILES;
+  return candidates.map(({ value, description }) => (description ? `${value}\t${description.split('\n')[0]}` : value)).join('\n');
+}
+
+const SCRIPTS = {
+  bash: program => `# bash completion for ${program} (generated by "${program} completion bash")
+# Install: ${program} completion bash > ~/.local/share/bash-completion/completions/${program}
+_loggy_proxy() {
+  local cur=\${COMP_WORDS[COMP_CWORD]} line
+  local -a candidates=()
+  while IFS= read -r line; do
+    if [[ $line == "${FILES}" ]]; then
+      compopt -o filenames 2>/dev/null
+      COMPREPLY=($(compgen -f -- "$cur"))
+      return
+    fi
+    line=\${line%%$'\\t'*}
+    # bash completes "--name=value" from after the "="
+    [[ $line == --*=* && $COMP_WORDBREAKS == *=* ]] && line=\${line#*=}
+    [[ -n $line ]] && candidates+=("$line")
+  done < <(${program} __complete "\${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null)
+  COMPREPLY=("\${candidates[@]}")
+}
+complete -F _loggy_proxy ${program}
+`,
+
+  zsh: program => `#compdef ${program}
+# zsh completion for ${program} (generated by "${program} completion zsh")
+# Install: ${program} completion zsh > "\${fpath[1]}/_${program}"
+_loggy_proxy() {
+  local -a lines candidates
+  local line
+  lines=("\${(@f)$(${program} __complete "\${(@)words[2,CURRENT]}" 2>/dev/null)}")
+  if [[ \${lines[1]} == "${FILES}" ]]; then
+    _files
+    return
+  fi
+  for line in $lines; do
+    [[ -z $line ]] && continue
+    if [[ $line == *$'\\t'* ]]; then
+      candidates+=("\${\${line%%$'\\t'*}//:/\\\\:}:\${line#*$'\\t'}")
+    else
+      candidates+=("\${line//:/\\\\:}")
+    fi
+  done
+  _describe -t values '${program}' candidates
+}
+compdef _loggy_proxy ${program}
+`,
+
+  fish: program => `# fish completion for ${program} (generated by "${program} completion fish")
+# Install: ${program} completion fish > ~/.config/fish/completions/${program}.fish
+function __loggy_proxy_complete
+    set -l words (commandline -opc)[2..-1] (commandline -ct)
+    set -l lines (${program} __complete $words 2>/dev/null)
+    if test "$lines[1]" = "${FILES}"
+        __fish_complete_path (commandline -ct)
+        return
+    end
+    printf '%s\\n' $lines
+end
+complete -c ${program} -f -a '(__loggy_proxy_complete)'
+`
+};
+
+/**
+ * Completion script for a shell
+ * @param {string} shell - bash, zsh or fish
+ * @param {string} program - Command name the script completes
+ * @returns {string}
+ */
+export function completionScript(shell, program) {
+  if (!SCRIPTS[shell]) {
+    throw new Error(`Unknown shell "${shell}" (expected ${SHELLS.join(', ')})`);
+  }
+  return SCRIPTS[shell](program);
+}
@@ -0,0 +1,89 @@
+/**
+ * Apply the global flags before anything reads proxy settings
+ *
+ * config/proxy-settings.js resolves the profile's paths when it is first
+ * imported, so loggy-proxy imports this module ahead of everything else.
+ * Each flag sets the environment variable it stands for, which a proxy
+ * started with --inline inherits:
+ *
+ *   --profile <name>          LOGGY_PROFILE
+ *   --config <file>           LOGGY_PROXY_SETTINGS
+ *   --ports <proxy>[,<api>]   LOGGY_PROXY_PORT, LOGGY_API_PORT
+ *   --log-level <level>       LOGGY_LOG_LEVEL
+ *   --quiet                   LOGGY_LOG_LEVEL=error
+ *   --log-format <format>     LOGGY_LOG_FORMAT
+ */
+
+import fs from 'fs';
+import path from 'path';
+import profiles from '../config/profile.cjs';
+import logging from '../proxy/logger.cjs';
+
+// Shell completion (`loggy-proxy __complete <words>`) passes a partial
+// command line: the word being completed is left out, and a bad value is
+// ignored instead of ending the process
+const completing = process.argv[2] === '__complete';
+const args = completing ? process.argv.slice(3, -1) : process.argv.slice(2);
+
+/**
+ * Value of a global flag ("--name value" or "--name=value"), or undefined
+ */
+function flagValue(name) {
+  const index = args.findIndex(arg => arg === `--${name}` || arg.startsWith(`--${name}=`));
+  if (index === -1) return undefined;
+  return args[index].includes('=') ? args[index].slice(args[index].indexOf('=') + 1) : args[index + 1];
+}
+
+const { LOG_FORMATS, LOG_LEVELS } = logging;
+
+function fail(message) {
+  if (completing) return;
+  console.error(`Error: ${message}`);
+  process.exit(2);
+}
+
+function parsePort(value) {
+  const port = Number(value);
+  return Number.isInteger(port) && port > 0 && port < 65536 ? port : null;
+}
+
+const profile = flagValue('profile');
+if (profile !== undefined) {
+  const error = profiles.validateProfileName(profile);
+  if (error) fail(`invalid profile "${profile || ''}": ${error}`);
+  else process.env.LOGGY_PROFILE = profile;
+}
+
+const config = flagValue('config');
+if (config !== undefined) {
+  if (!config || !fs.existsSync(config)) fail(`--config: no settings file at "${config || ''}"`);
+  else process.env.LOGGY_PROXY_SETTINGS = path.resolve(config);
+}
+
+const ports = flagValue('ports');
+if (ports !== undefined) {
+  const [proxyPort, apiPort] = String(ports || '').split(',').map(parsePort);
+  if (!proxyPort || (String(ports).includes(',') && !apiPort)) {
+    fail(`--ports: expected <proxy port>[,<api port>], got "${ports || ''}"`);
+  } else {
+    process.env.LOGGY_PROXY_PORT = String(proxyPort);
+    process.env.LOGGY_API_PORT = String(apiPort || proxyPort + 1);
+  }
+}
+
+const logLevel = flagValue('log-level');
+if (logLevel !== undefined) {
+  if (!LOG_LEVELS.includes(logLevel)) fail(`--log-level: expected one of ${LOG_LEVELS.join(', ')}`);
+  else process.env.LOGGY_LOG_LEVEL = logLevel;
+}
+
+if (args.includes('--quiet')) {
+  if (logLevel !== undefined) fail('--quiet and --log-level cannot be combined');
+  else process.env.LOGGY_LOG_LEVEL = 'error';
+}
+
+const logFormat = flagValue('log-format');
+if (logFormat !== undefined) {
+  if (!LOG_FORMATS.includes(logFormat)) fail(`--log-format: expected one of ${LOG_FORMATS.join(', ')}`);
+  else process.env.LOGGY_LOG_FORMAT = logFormat;
+}
@@ -0,0 +1,2510 @@
+#!/usr/bin/env node
+
+/**
+ * loggy-proxy - Command line tools for the MITM proxy
+ *
+ * Commands are declared in COMMANDS at the bottom of this file; the table
+ * drives option parsing, --help and exit codes (see command-line.js). Run
+ * `loggy-proxy --help` for the list.
+ *
+ * Global flags (--profile, --config, --ports, --log-level, --quiet,
+ * --log-format) are applied by global-flags.js before the settings module
+ * loads.
+ */
+
+// Must stay first: applies the global flags before the settings module loads
+import './global-flags.js';
+import { spawn } from 'child_process';
+import fs from 'fs';
+import http from 'http';
+import path from 'path';
+import { fileURLToPath } from 'url';
+import profiles from '../config/profile.cjs';
+import { SourceConfig } from '../config/config-manager-node.js';
+import { loadProxySettings, saveProxySettings, resolvePath, LOGGY_HOME, PROFILE_PATHS } from '../config/proxy-settings.js';
+import { ProxyApiClient } from '../proxy/api-client.js';
+import { caDirs, resolvePassphrase } from '../proxy/certificate-authority.js';
+import { caCertPath, encryptCAKey, exportCA, generateCA, getCAInfo, isCAKeyEncrypted, rotateCA, trustCA, untrustCA } from '../proxy/cert-tools.js';
+import { filterEvents, formatEvents, listSessions, parseDuration, parseIndent, readEventsFile, readStoredEvents, resolveFormat, transformEvents } from '../proxy/event-export.js';
+import { checkExpectations, formatReport, loadExpectations } from '../proxy/event-assertions.js';
+import { resolveReportFormat, writeReport } from '../proxy/assertion-report.js';
+import { BROWSERS, browserLaunchConfig } from '../proxy/browser-args.js';
+import { EventGenerator, parseRate, requestTarget, sendThroughProxy } from '../proxy/event-generator.js';
+import { findFixtures, readFixture, replayFixture } from '../proxy/fixtures.js';
+import { readFlows, writeFlows } from '../proxy/mitmproxy-flows.js';
+import { builtinCases, fixtureCases, profileCpu, runBenchmarks } from '../proxy/parser-bench.js';
+import { eventMatcher, formatEvent, useColor } from '../proxy/event-format.js';
+import versionInfo from '../proxy/version.cjs';
+import { EXIT, UsageError, formatHelp, parseCommandLine } from './command-line.js';
+import { SHELLS, complete, completionScript, formatCandidates } from './completion.js';
+import { runChecks } from '../proxy/doctor.js';
+import { CHECKSUMS_ASSET, INSTALL_DIR, RELEASE_ASSET, RELEASE_KEY_PATH, SIGNATURE_ASSET, carryOverUserFiles, downloadAsset, extractRelease, findAsset, nativeHostPath, reinstallNativeHost, swapInstall, updatePaths, verifyChecksum, verifySignature } from '../proxy/self-update.js';
+import logging from '../proxy/logger.cjs';
+import { promptHidden } from '../proxy/prompt.js';
+import { addSource, editSource, loadSources, parseFieldMappings, removeSource, testSource } from '../proxy/source-tools.js';
+import { EventBrowser } from '../proxy/tui.js';
+import { API_SCOPES, generateApiKey, validateApiKeys } from '../proxy/api-keys.js';
+import { BROWSER_DATA_DIRS, WEB_STORE_UPDATE_URL, enterprisePolicy, extensionIdFor, isExtensionId, manifestReport, writeManifest } from '../proxy/native-host-manifest.js';
+
+const __dirname = path.dirname(fileURLToPath(import.meta.url));
+
+// Written by the native host when it starts the proxy
+const PID_FILE = PROFILE_PATHS.pidFile;
+
+function runningProxyPid() {
+  try {
+    const pid = parseInt(fs.readFileSync(PID_FILE, 'utf8'), 10);
+    process.kill(pid, 0);
+    return pid;
+  } catch (err) {
+    return null;
+  }
+}
+
+async function certInfo(json) {
+  const info = await getCAInfo(loadProxySettings());
+
+  if (json) {
+    console.log(JSON.stringify(info, null, 2));
+    return info.generated ? EXIT.OK : EXIT.FAILURE;
+  }
+
+  if (!info.generated) {
+    console.error(`No ${info.keyType.toUpperCase()} CA at ${info.certPath} (create it with "loggy-proxy cert generate", or start the proxy)`);
+    return EXIT.FAILURE;
+  }
+
+  const trust = info.trusted === null ? 'unknown' : info.trusted ? 'yes' : 'no';
+  console.log(`CA certificate: ${info.certPath}`);
+  console.log(`  Subject:     ${info.subject}`);
+  console.log(`  Key type:    ${info.keyAlgorithm}`);
+  console.log(`  Fingerprint: ${info.fingerprint} (SHA-256)`);
+  console.log(`  Valid from:  ${info.validFrom}`);
+  console.log(`  Expires:     ${info.validTo}${info.expired ? ' (EXPIRED)' : ` (${info.daysRemaining} days)`}`);
+  console.log(`  Trusted:     ${trust}`);
+  if (info.trusted !== true && info.details) {
+    console.log(`               ${info.details}`);
+  }
+
+  return EXIT.OK;
+}
+
+function certExport(file, options) {
+  const result = exportCA(loadProxySettings(), file, {
+    format: options.format,
+    password: typeof options.password === 'string' ? options.password : ''
+  });
+  console.log(`Exported ${result.format.toUpperCase()} CA certificate to ${result.outputPath}`);
+  console.log(`  Fingerprint: ${result.fingerprint} (SHA-256)`);
+  return EXIT.OK;
+}
+
+/**
+ * Passphrase for writing a new encrypted CA key (keyStorage "encrypted")
+ */
+function passphraseFor(settings) {
+  const { keyType, keyStorage } = settings.certificates;
+  return keyType === 'ecdsa' && keyStorage === 'encrypted' ? resolvePassphrase(caDirs(settings).ecdsa) : null;
+}
+
+async function certTrust(options) {
+  const result = await trustCA(loadProxySettings(), { system: !!options.system });
+
+  if (!result.generated) {
+    console.error(`No ${result.keyType.toUpperCase()} CA at ${result.certPath} (create it with "loggy-proxy cert generate", or start the proxy)`);
+    return EXIT.FAILURE;
+  }
+  if (!result.supported) {
+    console.error(result.output);
+    return EXIT.FAILURE;
+  }
+  if (!result.trusted) {
+    console.error(`Could not trust ${result.certPath}${result.output ? `: ${result.output}` : ''}`);
+    return EXIT.FAILURE;
+  }
+
+  console.log(`Trusted ${result.certPath}${options.system ? ' in the System keychain' : ''}`);
+  return EXIT.OK;
+}
+
+async function certGenerate(options) {
+  const settings = loadProxySettings();
+  const report = generateCA(settings, { passphrase: await passphraseFor(settings), force: !!options.force });
+
+  console.log(`Generated ${report.keyType.toUpperCase()} CA: ${report.certPath}`);
+  if (report.previousFingerprint) {
+    console.log(`  Replaced:    ${report.previousFingerprint} (remove it from your trust store if it was trusted)`);
+  }
+  console.log(`  Fingerprint: ${report.fingerprint} (SHA-256)`);
+
+  const pid = runningProxyPid();
+  if (pid && report.previousFingerprint) {
+    console.log(`\nThe proxy (pid ${pid}) is still using the old CA. Restart it to switch.`);
+  }
+
+  return options.trust ? certTrust(options) : EXIT.OK;
+}
+
+async function certUntrust(options) {
+  const result = await untrustCA(loadProxySettings(), { system: !!options.system });
+
+  if (!result.generated) {
+    console.error(`No ${result.keyType.toUpperCase()} CA at ${result.certPath}`);
+    return EXIT.FAILURE;
+  }
+  if (!result.supported) {
+    console.error(result.output);
+    return EXIT.FAILURE;
+  }
+  if (result.trusted) {
+    console.error(`Could not untrust ${result.certPath}${result.output ? `: ${result.output}` : ''}`);
+    return EXIT.FAILURE;
+  }
+
+  console.log(result.removed
+    ? `Removed ${result.certPath} from the trust store`
+    : `${result.certPath} was not trusted`);
+  return EXIT.OK;
+}
+
+async function certRotate(options) {
+  const settings = loadProxySettings();
+  const report = await rotateCA(settings, { passphrase: await passphraseFor(settings), system: !!options.system });
+
+  console.log(`Rotated ${report.keyType.toUpperCase()} CA: ${report.certPath}`);
+  if (report.previousFingerprint) {
+    console.log(`  Old fingerprint: ${report.previousFingerprint}`);
+    if (report.untrusted === null) {
+      console.log('  Old CA: remove it from your trust store manually');
+    } else {
+      console.log(`  Old CA: ${report.untrusted ? 'removed from the trust store' : `not removed from the trust store (${report.untrustOutput || 'not found'})`}`);
+    }
+  }
+  console.log(`  New fingerprint: ${report.fingerprint}`);
+  console.log('  Leaf certificate cache cleared');
+
+  if (report.trusted) {
+    console.log('  New CA trusted');
+  } else {
+    console.error(`  Could not trust the new CA${report.trustOutput ? `: ${report.trustOutput}` : ''}`);
+  }
+
+  const pid = runningProxyPid();
+  if (pid) {
+    console.log(`\nThe proxy (pid ${pid}) is still using the old CA. Restart it to switch.`);
+  }
+
+  return report.trusted ? EXIT.OK : EXIT.FAILURE;
+}
+
+async function certEncryptKey(options) {
+  const settings = loadProxySettings();
+  const passphrase = isCAKeyEncrypted(settings) ? await resolvePassphrase(caDirs(settings).ecdsa) : null;
+
+  // LOGGY_CA_PASSPHRASE unlocks an already encrypted key, so re-encrypting
+  // from a script takes the new one from LOGGY_CA_NEW_PASSPHRASE
+  let newPassphrase = process.env.LOGGY_CA_NEW_PASSPHRASE || (passphrase ? null : process.env.LOGGY_CA_PASSPHRASE);
+  if (!newPassphrase && process.stdin.isTTY) {
+    newPassphrase = await promptHidden('New passphrase: ');
+    if (newPassphrase !== await promptHidden('Repeat new passphrase: ')) {
+      console.error('Passphrases do not match');
+      return EXIT.FAILURE;
+    }
+  }
+  if (!newPassphrase) {
+    console.error(passphrase
+      ? 'No new passphrase given (run from a terminal or set LOGGY_CA_NEW_PASSPHRASE)'
+      : 'No passphrase given (run from a terminal or set LOGGY_CA_PASSPHRASE)');
+    return EXIT.FAILURE;
+  }
+
+  const result = encryptCAKey(settings, { passphrase, newPassphrase, keychain: !!options.keychain });
+  console.log(`Encrypted ${result.keyPath}`);
+  if (result.keychain) {
+    console.log('  Passphrase stored in the login keychain');
+  }
+  if (settings.certificates.keyStorage !== 'encrypted') {
+    console.log('\nSet certificates.keyStorage to "encrypted" so the proxy asks for the passphrase at startup.');
+  }
+  return EXIT.OK;
+}
+
+function describeVersion(info) {
+  const build = info.build === 'release' ? `built ${info.buildDate || 'unknown'}` : 'source checkout';
+  return `${info.version} (commit ${info.commit || 'unknown'}, ${build}, default sources ${info.defaultSourcesRevision || 'unknown'})`;
+}
+
+async function version(options) {
+  const local = versionInfo.getVersionInfo();
+  const settings = loadProxySettings(null, { quiet: true });
+
+  let proxy = null;
+  try {
+    proxy = (await new ProxyApiClient({ apiPort: settings.apiPort, timeoutMs: 3000 }).get('/status')).version || null;
+  } catch (err) {
+    // Not running
+  }
+
+  let update = null;
+  if (options['check-update']) {
+    update = await versionInfo.checkForUpdate(local.version)
+      .catch(err => ({ updateAvailable: null, updateCheckError: err.message }));
+  }
+
+  const mismatch = !!proxy && (proxy.version !== local.version || proxy.commit !== local.commit);
+
+  if (options.json) {
+    console.log(JSON.stringify({ ...local, node: process.version, platform: process.platform, proxy, mismatch, ...update }, null, 2));
+    return EXIT.OK;
+  }
+
+  console.log(`loggy-proxy ${describeVersion(local)}`);
+  console.log(`  Node ${process.version} on ${process.platform}/${process.arch}`);
+  if (proxy) {
+    console.log(`Running proxy: ${describeVersion(proxy)}`);
+    if (mismatch) {
+      console.log('  The running proxy is a different version; restart it to use this one');
+    }
+  }
+  if (update && update.updateCheckError) {
+    console.log(`Update check failed: ${update.updateCheckError}`);
+  } else if (update) {
+    console.log(update.updateAvailable ? `Update available: ${update.latestVersion} (${update.releaseUrl})` : 'Up to date');
+  }
+  return EXIT.OK;
+}
+
+/**
+ * Replace this release install with the latest GitHub release
+ */
+async function selfUpdate(options) {
+  const local = versionInfo.getVersionInfo();
+  if (local.build !== 'release') {
+    console.error(`${INSTALL_DIR} is a source checkout; update it with "git pull" and "npm install"`);
+    return EXIT.FAILURE;
+  }
+
+  const release = await versionInfo.fetchLatestRelease();
+  const latestVersion = String(release.tag_name || '').replace(/^v/, '');
+  if (versionInfo.compareVersions(latestVersion, local.version) <= 0 && !options.force) {
+    console.log(`Up to date (${local.version})`);
+    return EXIT.OK;
+  }
+  console.log(`Latest release: ${latestVersion} (installed: ${local.version})`);
+  if (options.check) {
+    return EXIT.OK;
+  }
+
+  const settings = loadProxySettings(null, { quiet: true });
+  if (runningProxyPid() || await new ProxyApiClient({ apiPort: settings.apiPort }).isReachable()) {
+    console.error('The proxy is running; stop it first (Stop Proxy in the extension, or Ctrl+C), then update');
+    return EXIT.FAILURE;
+  }
+
+  const zipAsset = findAsset(release, RELEASE_ASSET);
+  const sumsAsset = findAsset(release, CHECKSUMS_ASSET);
+  if (!zipAsset || !sumsAsset) {
+    console.error(`Release ${latestVersion} has no ${!zipAsset ? RELEASE_ASSET : CHECKSUMS_ASSET}; update by hand from ${release.html_url}`);
+    return EXIT.FAILURE;
+  }
+
+  const keyFile = typeof options['public-key'] === 'string' ? options['public-key'] : RELEASE_KEY_PATH;
+  const sigAsset = findAsset(release, SIGNATURE_ASSET);
+  if (!options.insecure) {
+    if (!fs.existsSync(keyFile)) {
+      console.error(`No release key at ${keyFile}; reinstall, or pass --insecure to update without checking the signature`);
+      return EXIT.FAILURE;
+    }
+    if (!sigAsset) {
+      console.error(`Release ${latestVersion} is not signed (no ${SIGNATURE_ASSET}); update by hand from ${release.html_url}`);
+      return EXIT.FAILURE;
+    }
+  }
+
+  console.log(`Downloading ${zipAsset.name}...`);
+  const [zip, checksums] = await Promise.all([downloadAsset(zipAsset), downloadAsset(sumsAsset)]);
+  if (options.insecure) {
+    console.log('  --insecure: the signature is not checked');
+  } else {
+    verifySignature(checksums, await downloadAsset(sigAsset), fs.readFileSync(keyFile, 'utf8'));
+    console.log(`  Signature verified with ${keyFile}`);
+  }
+  verifyChecksum(zip, zipAsset.name, checksums.toString('utf8'));
+  console.log('  Checksum verified');
+
+  const { staging, previous } = updatePaths();
+  const zipFile = `${staging}.zip`;
+  fs.writeFileSync(zipFile, zip);
+  try {
+    const build = extractRelease(zipFile, staging);
+    if (build.version !== latestVersion) {
+      throw new Error(`${zipAsset.name} holds version ${build.version}, not ${latestVersion}`);
+    }
+    const carried = carryOverUserFiles(INSTALL_DIR, staging);
+    const previousHost = nativeHostPath(INSTALL_DIR);
+    swapInstall(INSTALL_DIR, staging, previous);
+
+    console.log(`\nUpdated ${INSTALL_DIR} to ${latestVersion}`);
+    if (carried.length) {
+      console.log(`  Kept ${carried.join(' and ')}`);
+    }
+    for (const manifest of reinstallNativeHost(INSTALL_DIR, previousHost)) {
+      console.log(`  Native host path changed; updated ${manifest}`);
+    }
+    console.log(`  The previous version is in ${previous} until the next update`);
+  } catch (err) {
+    fs.rmSync(staging, { recursive: true, force: true });
+    throw err;
+  } finally {
+    fs.rmSync(zipFile, { force: true });
+  }
+  return EXIT.OK;
+}
+
+/**
+ * "3d 4h", "2h 5m", "45s"
+ */
+function formatDuration(seconds) {
+  const units = [['d', 86400], ['h', 3600], ['m', 60], ['s', 1]];
+  const parts = [];
+  for (const [unit, size] of units) {
+    if (seconds >= size || (unit === 's' && parts.length === 0)) {
+      parts.push(`${Math.floor(seconds / size)}${unit}`);
+      seconds %= size;
+    }
+    if (parts.length === 2) break;
+  }
+  return parts.join(' ');
+}
+
+/**
+ * "512 B", "3.4 KB", "12.0 MB"
+ */
+function formatBytes(bytes) {
+  if (bytes < 1024) return `${bytes} B`;
+  const units = ['KB', 'MB', 'GB'];
+  let value = bytes / 1024;
+  let unit = 0;
+  while (value >= 1024 && unit < units.length - 1) {
+    value /= 1024;
+    unit++;
+  }
+  return `${value.toFixed(1)} ${units[unit]}`;
+}
+
+async function status(options) {
+  const settings = loadProxySettings(null, { quiet: true });
+  const client = new ProxyApiClient({ apiPort: settings.apiPort, timeoutMs: 3000 });
+  const pid = runningProxyPid();
+
+  let current = null;
+  try {
+    current = await client.get('/status');
+  } catch (err) {
+    // Not running, or not answering
+  }
+
+  if (options.json) {
+    console.log(JSON.stringify(current ? { running: true, ...current } : { running: false, pid, proxyPort: settings.proxyPort, apiPort: settings.apiPort }, null, 2));
+    return current ? EXIT.OK : EXIT.FAILURE;
+  }
+
+  if (!current) {
+    console.log(pid
+      ? `Proxy: pid ${pid} is running but its API does not answer on port ${settings.apiPort} (see ${path.join(LOGGY_HOME, 'logs', 'proxy.log')})`
+      : `Proxy: not running (ports ${settings.proxyPort}/${settings.apiPort} when started)`);
+    return EXIT.FAILURE;
+  }
+
+  const { buffer } = current;
+  const trust = current.trust.trusted === null ? 'unknown' : current.trust.trusted ? 'trusted' : 'NOT trusted';
+  console.log(`Proxy:      running, pid ${current.pid}, up ${formatDuration(current.uptimeSeconds)}${current.profile ? `, profile "${current.profile}"` : ''}`);
+  if (current.cdp) {
+    const { cdp } = current;
+    console.log(`Ports:      API ${current.apiPort} (no proxy port: capturing over CDP)`);
+    console.log(`Chrome:     ${cdp.connected ? `connected to ${cdp.browser || cdp.endpoint}, ${cdp.targets} targets` : `not connected (${cdp.endpoint}${cdp.lastError ? `: ${cdp.lastError}` : ''})`}, ` +
+      `${cdp.requests} requests, ${cdp.missingBodies} bodies missing`);
+  } else if (current.pcap) {
+    const { pcap } = current;
+    console.log(`Ports:      API ${current.apiPort} (no proxy port: capturing from network traffic)`);
+    console.log(`Packets:    ${pcap.file || `interface ${pcap.interface}`} (${pcap.mode}, ${pcap.running ? 'running' : 'stopped'}${pcap.lastError ? `: ${pcap.lastError}` : ''}), ` +
+      `${pcap.packets} packets, ${pcap.requests} requests`);
+  } else {
+    console.log(`Ports:      proxy ${current.proxyPort}, API ${current.apiPort}`);
+  }
+  console.log(`Session:    ${current.session}`);
+  if (!current.cdp && !current.pcap) console.log(`CA:         ${trust}`);
+  console.log(`Buffer:     ${buffer.events} / ${buffer.maxEvents} events (${Math.round(buffer.events / buffer.maxEvents * 100)}%), ${current.capturedTotal} captured since start`);
+  if (current.parsing) {
+    const { parsing } = current;
+    console.log(`Parsing:    ${parsing.queued} queued, ${parsing.inFlight} in progress (${parsing.workers || 'no'} workers), ` +
+      `${parsing.dropped} dropped, ${parsing.failed} failed`);
+  }
+  if (current.tunnel) {
+    console.log(`Tunnelled:  ${current.tunnel.tunnelled} connections to ${current.tunnel.unmatchedHosts} hosts no source matches`);
+  }
+  if (current.sources.length > 0) {
+    console.log('Sources:');
+    for (const source of current.sources) {
+      console.log(`  ${(source.name || source.id).padEnd(24)} ${String(source.events).padStart(6)}`);
+    }
+  }
+  if (current.lastError) {
+    const ago = formatDuration(Math.round((Date.now() - new Date(current.lastError.at).getTime()) / 1000));
+    console.log(`Last error: ${current.lastError.message} (${ago} ago)`);
+  } else {
+    console.log('Last error: none');
+  }
+  return EXIT.OK;
+}
+
+async function stats(options) {
+  const settings = loadProxySettings(null, { quiet: true });
+  const client = new ProxyApiClient({ apiPort: settings.apiPort, timeoutMs: 5000 });
+
+  let current;
+  try {
+    current = await client.get('/stats');
+  } catch (err) {
+    console.error(`The proxy is not running (no answer on port ${settings.apiPort})`);
+    return EXIT.FAILURE;
+  }
+  if (!current.memory) {
+    console.error('This proxy does not report statistics; restart it to update');
+    return EXIT.FAILURE;
+  }
+
+  if (options.json) {
+    console.log(JSON.stringify(current, null, 2));
+    return EXIT.OK;
+  }
+
+  const { memory, buffer } = current;
+  console.log(`Memory:     ${formatBytes(memory.rss)} resident, ${formatBytes(memory.heapUsed)} / ${formatBytes(memory.heapTotal)} heap`);
+  console.log(`Buffer:     ${buffer.events} / ${buffer.maxEvents} events (${buffer.occupancy}%), ` +
+    `about ${formatBytes(buffer.bytes)} (${formatBytes(buffer.avgEventBytes)} per event)`);
+  if (current.parsing) {
+    console.log(`Parsing:    ${current.parsing.processed} parsed, ${current.parsing.dropped} dropped (maxQueue ${current.parsing.maxQueue})`);
+  }
+  if (current.drops) {
+    const reasons = Object.entries(current.drops.byReason).map(([reason, count]) => `${count} ${reason}`);
+    console.log(`Dropped:    ${current.drops.total}${reasons.length ? ` (${reasons.join(', ')})` : ''}`);
+    for (const [source, { total, ...bySource }] of Object.entries(current.drops.bySource)) {
+      const detail = Object.entries(bySource).map(([reason, count]) => `${count} ${reason}`).join(', ');
+      console.log(`  ${source.padEnd(24)} ${String(total).padStart(8)}  ${detail}`);
+    }
+  }
+  if (current.parseErrors && current.parseErrors.length > 0) {
+    console.log(`Parse errors: ${current.parseErrors.reduce((sum, entry) => sum + entry.count, 0)}`);
+    for (const entry of current.parseErrors) {
+      console.log(`  ${(entry.name || entry.id).padEnd(24)} ${String(entry.count).padStart(8)}  last: ${entry.lastError}`);
+    }
+  }
+  if (current.timings) {
+    console.log('Stages (count, average, max):');
+    for (const [stage, timing] of Object.entries(current.timings)) {
+      console.log(`  ${stage.padEnd(24)} ${String(timing.count).padStart(8)} ${`${timing.avgMs} ms`.padStart(11)} ${`${timing.maxMs} ms`.padStart(11)}`);
+    }
+  }
+  if (current.payloads.length === 0) {
+    console.log('Payloads:   none captured yet');
+    return EXIT.OK;
+  }
+  console.log('Payloads (recent request bodies, as received):');
+  console.log(`  ${'Source'.padEnd(24)} ${'Requests'.padStart(8)} ${'Total'.padStart(9)} ${'Avg'.padStart(9)} ` +
+    `${'p50'.padStart(9)} ${'p90'.padStart(9)} ${'p99'.padStart(9)} ${'Max'.padStart(9)}  Compressed`);
+  for (const source of current.payloads) {
+    const { recent } = source;
+    console.log(`  ${(source.name || source.id).padEnd(24)} ${String(source.requests).padStart(8)} ${formatBytes(source.bytes).padStart(9)} ` +
+      [recent.avg, recent.p50, recent.p90, recent.p99, recent.max].map(bytes => formatBytes(bytes).padStart(9)).join(' ') +
+      `  ${Math.round(source.compressedRequests / source.requests * 100)}%`);
+  }
+  return EXIT.OK;
+}
+
+const CHECK_MARKS = { pass: '✓', warn: '!', fail: '✗' };
+
+async function doctor(options) {
+  const checks = await runChecks(loadProxySettings(null, { quiet: true }));
+  const failed = checks.filter(check => check.status === 'fail').length;
+
+  if (options.json) {
+    console.log(JSON.stringify({ ok: failed === 0, checks }, null, 2));
+    return failed === 0 ? EXIT.OK : EXIT.FAILURE;
+  }
+
+  for (const check of checks) {
+    console.log(`${CHECK_MARKS[check.status]} ${check.title}: ${check.message}`);
+    if (check.fix) {
+      console.log(`    Fix: ${check.fix}`);
+    }
+  }
+  const warned = checks.filter(check => check.status === 'warn').length;
+  console.log(`\n${failed === 0 ? 'No problems found' : `${failed} problem(s) found`}${warned ? `, ${warned} warning(s)` : ''}`);
+  return failed === 0 ? EXIT.OK : EXIT.FAILURE;
+}
+
+/**
+ * The --extension-id option, checked
+ */
+function extensionIdOption(options, name) {
+  const id = options['extension-id'];
+  if (id === undefined) return null;
+  if (typeof id !== 'string' || !isExtensionId(id)) {
+    throw new UsageError(`Invalid --extension-id "${id}" (32 letters a-p, as chrome://extensions shows it)`, { name });
+  }
+  return id;
+}
+
+function nativeHostCheck(options) {
+  const reports = manifestReport();
+  if (!reports) {
+    console.error(`Not supported on ${process.platform}: native host manifests are registered in the registry`);
+    return EXIT.FAILURE;
+  }
+  const expected = extensionIdFor();
+  const ok = reports.every(report => !report.error && report.missing.length === 0 && (report.manifest || report.installed.length === 0));
+
+  if (options.json) {
+    console.log(JSON.stringify({
+      ok,
+      extensionId: expected.id,
+      extensionIdFrom: expected.from,
+      browsers: reports.map(({ manifest, ...report }) => ({ ...report, hostPath: manifest ? manifest.path : null }))
+    }, null, 2));
+    return ok ? EXIT.OK : EXIT.FAILURE;
+  }
+
+  console.log(`This directory loads as extension ${expected.id} (from its ${expected.from === 'key' ? 'manifest key' : 'path'})`);
+  if (reports.length === 0) {
+    console.log('No Chromium browser has been run by this user yet');
+  }
+  for (const report of reports) {
+    console.log(`\n${report.browser}: ${report.manifestPath}`);
+    if (report.error) {
+      console.log(`  ${CHECK_MARKS.fail} Manifest is ${report.error}`);
+    } else if (!report.manifest) {
+      console.log('  No manifest');
+    } else {
+      console.log(`  Allows:    ${report.allowed.join(', ') || '(no extension IDs)'}`);
+      console.log(`  Host:      ${report.manifest.path}`);
+    }
+    if (report.installed.length > 0) {
+      console.log(`  Installed: ${report.installed.map(extension => `${extension.id} (${extension.profile}${extension.unpacked ? ', unpacked' : ''})`).join(', ')}`);
+    }
+    if (report.missing.length > 0) {
+      console.log(`  ${CHECK_MARKS.fail} ${report.manifest ? 'Does not allow' : 'No manifest for'} ${report.missing.join(', ')}`);
+    }
+  }
+  if (!ok) {
+    console.log('\nFix with: loggy-proxy native-host repair');
+  }
+  return ok ? EXIT.OK : EXIT.FAILURE;
+}
+
+function nativeHostRepair(options) {
+  const extensionId = extensionIdOption(options, 'native-host repair');
+  const reports = manifestReport();
+  if (!reports) {
+    console.error(`Not supported on ${process.platform}: native host manifests are registered in the registry`);
+    return EXIT.FAILURE;
+  }
+  const only = typeof options.browser === 'string' ? options.browser : null;
+  const hostPath = nativeHostPath(INSTALL_DIR);
+
+  let written = 0;
+  for (const report of reports.filter(candidate => !only || candidate.browser === only)) {
+    // The installed extension's IDs replace the ones the manifest had, which
+    // belong to an earlier load of the checkout
+    const ids = extensionId ? [extensionId] : [...new Set(report.installed.map(extension => extension.id))];
+    if (ids.length === 0) {
+      console.log(`${report.browser}: the extension is not installed (use --extension-id to write a manifest anyway)`);
+      continue;
+    }
+    writeManifest(report.manifestPath, hostPath, ids);
+    written++;
+    console.log(`${report.browser}: wrote ${report.manifestPath} (allows ${ids.join(', ')})`);
+  }
+
+  if (written === 0) {
+    console.error(only && !reports.some(report => report.browser === only)
+      ? `No data directory for browser "${only}" (run it once first)`
+      : 'No manifest written: load the extension from chrome://extensions first, or pass --extension-id');
+    return EXIT.FAILURE;
+  }
+  console.log('\nReload the extension in chrome://extensions to reconnect to the native host');
+  return EXIT.OK;
+}
+
+function nativeHostPolicy(options) {
+  let extensionId = extensionIdOption(options, 'native-host policy');
+  if (!extensionId) {
+    const expected = extensionIdFor();
+    if (expected.from !== 'key') {
+      throw new UsageError('--extension-id is required: manifest.json has no "key", so the packed extension\'s ID is not known here', { name: 'native-host policy' });
+    }
+    extensionId = expected.id;
+  }
+  const updateUrl = typeof options['update-url'] === 'string' ? options['update-url'] : WEB_STORE_UPDATE_URL;
+  console.log(JSON.stringify(enterprisePolicy(extensionId, updateUrl), null, 2));
+  return EXIT.OK;
+}
+
+function isRunning(pidFile) {
+  try {
+    process.kill(parseInt(fs.readFileSync(pidFile, 'utf8'), 10), 0);
+    return true;
+  } catch (err) {
+    return false;
+  }
+}
+
+/**
+ * Have the proxy this command starts record fixtures into --record-fixtures
+ */
+function applyRecordFixtures(options) {
+  if (typeof options['record-fixtures'] === 'string') {
+    process.env.LOGGY_RECORD_FIXTURES = path.resolve(options['record-fixtures']);
+  }
+}
+
+/**
+ * Have the proxy this command starts capture every POST/PUT (--promiscuous)
+ */
+function applyPromiscuous(options) {
+  if (options.promiscuous) {
+    process.env.LOGGY_PROMISCUOUS = '1';
+  }
+}
+
+/**
+ * Label the events of the proxy this command starts (--environment)
+ */
+function applyEnvironmentLabel(options) {
+  if (typeof options.environment === 'string') {
+    process.env.LOGGY_ENVIRONMENT = options.environment;
+  }
+}
+
+/**
+ * Have the proxy this command starts read requests from Chrome over CDP
+ * (--cdp) instead of intercepting them
+ */
+function applyCdp(options) {
+  if (options.cdp) {
+    process.env.LOGGY_CDP = '1';
+  }
+}
+
+/**
+ * Have the proxy this command starts read requests from network traffic
+ * (--pcap [interface], or --pcap-file) instead of intercepting them
+ */
+function applyPcap(options) {
+  if (typeof options['pcap-file'] === 'string') {
+    process.env.LOGGY_PCAP_FILE = path.resolve(options['pcap-file']);
+  }
+  if (options.pcap || options['pcap-file']) {
+    process.env.LOGGY_PCAP = typeof options.pcap === 'string' ? options.pcap : '1';
+  }
+}
+
+/**
+ * Run the proxy in the foreground, as "npm run proxy" does but with the
+ * global flags applied; signals are passed on (SIGHUP reloads its settings)
+ */
+function start(options) {
+  applyRecordFixtures(options);
+  applyPromiscuous(options);
+  applyEnvironmentLabel(options);
+  applyCdp(options);
+  applyPcap(options);
+  const child = spawn(process.execPath, [path.join(__dirname, '..', 'proxy-server-mitm.js')], { stdio: 'inherit' });
+  for (const signal of ['SIGINT', 'SIGTERM', 'SIGHUP']) {
+    process.on(signal, () => child.kill(signal));
+  }
+  return new Promise(resolve => {
+    child.on('exit', code => resolve(code === 0 || code === null ? EXIT.OK : EXIT.FAILURE));
+  });
+}
+
+// Events fetched per poll; more arriving between two polls are skipped
+const TAIL_PAGE_SIZE = 200;
+const TAIL_POLL_MS = 500;
+
+/**
+ * Run the proxy as a child of this command. It writes (and rotates) the proxy
+ * log itself; stderr is appended too, for crashes.
+ */
+function startInlineProxy() {
+  const logFile = path.join(LOGGY_HOME, 'logs', 'proxy.log');
+  fs.mkdirSync(path.dirname(logFile), { recursive: true });
+  const logFd = fs.openSync(logFile, 'a');
+  const child = spawn(process.execPath, [path.join(__dirname, '..', 'proxy-server-mitm.js')], {
+    stdio: ['ignore', 'ignore', logFd],
+    env: { ...process.env, LOGGY_LOG_FILE: logFile }
+  });
+  fs.closeSync(logFd);
+
+  child.on('exit', code => {
+    console.error(`\nProxy exited${code !== null ? ` with code ${code}` : ''}; see ${logFile}`);
+    process.exit(1);
+  });
+  return child;
+}
+
+async function waitUntilReachable(client, timeoutMs) {
+  const deadline = Date.now() + timeoutMs;
+  while (Date.now() < deadline) {
+    if (await client.isReachable()) return true;
+    await new Promise(resolve => setTimeout(resolve, 250));
+  }
+  return false;
+}
+
+/**
+ * Client for the running proxy, or for one started with --inline
+ * @returns {Promise<ProxyApiClient|null>} - null after printing why there is no proxy
+ */
+async function connectToProxy(settings, command, options) {
+  const client = new ProxyApiClient({ apiPort: settings.apiPort });
+  if (await client.isReachable()) {
+    return client;
+  }
+
+  if (!options.inline) {
+    console.error(`No proxy is answering on port ${settings.apiPort}. Start it, or run "loggy-proxy ${command} --inline".`);
+    return null;
+  }
+  const child = startInlineProxy();
+  // Stop the inline proxy however this command ends
+  process.on('exit', () => child.kill());
+  for (const signal of ['SIGINT', 'SIGTERM', 'SIGHUP']) {
+    process.on(signal, () => process.exit(0));
+  }
+  if (!await waitUntilReachable(client, 15000)) {
+    console.error('The proxy did not start within 15 seconds');
+    return null;
+  }
+  console.error(`Started proxy on port ${settings.proxyPort} (stops with this command)`);
+  return client;
+}
+
+/**
+ * Wait for one event (POST /events/wait); exits 1 if none arrives in time
+ */
+async function waitForEvent(eventName, options) {
+  const option = name => (typeof options[name] === 'string' ? options[name] : null);
+  let timeoutMs;
+  try {
+    timeoutMs = option('timeout') ? parseDuration(option('timeout')) : 30 * 1000;
+  } catch (err) {
+    throw new UsageError(err.message, { name: 'wait' });
+  }
+  const properties = {};
+  for (const pair of (option('properties') || '').split(',').map(p => p.trim()).filter(Boolean)) {
+    const [property, ...rest] = pair.split('=');
+    if (!property || rest.length === 0) {
+      throw new UsageError(`Invalid property "${pair}" (expected path=value, or path=* for any value)`, { name: 'wait' });
+    }
+    properties[property.trim()] = rest.join('=').trim();
+  }
+
+  const settings = loadProxySettings(null, { quiet: true });
+  const client = await connectToProxy(settings, 'wait', options);
+  if (!client) return EXIT.FAILURE;
+  client.timeoutMs = timeoutMs + 5000;
+
+  const answer = await client.request('POST', '/events/wait', {
+    event: eventName,
+    ...(option('source') ? { source: option('source') } : {}),
+    ...(Object.keys(properties).length > 0 ? { properties } : {}),
+    timeout: timeoutMs,
+    since: options.new ? 'now' : 0
+  });
+  if (answer.error) {
+    console.error(answer.error);
+    return EXIT.FAILURE;
+  }
+  if (!answer.matched) {
+    console.error(`No "${eventName}" event within ${Math.round(timeoutMs / 1000)}s`);
+    return EXIT.FAILURE;
+  }
+  console.log(options.json ? JSON.stringify(answer.event) : formatEvent(answer.event, { color: useColor(process.stdout, false), width: process.stdout.columns || 120 }));
+  return EXIT.OK;
+}
+
+async function tail(options) {
+  const settings = loadProxySettings(null, { quiet: true });
+  const client = await connectToProxy(settings, 'tail', options);
+  if (!client) return EXIT.FAILURE;
+
+  if (options.severity !== undefined && !['warn', 'error'].includes(options.severity)) {
+    throw new UsageError('--severity must be "warn" or "error"', { name: 'tail' });
+  }
+  const matches = eventMatcher({
+    filter: typeof options.filter === 'string' ? options.filter : null,
+    source: typeof options.source === 'string' ? options.source : null,
+    severity: options.severity || null
+  });
+  const color = useColor(process.stdout, !!options['no-color']);
+  const print = event => {
+    if (!matches(event)) return;
+    console.log(options.json ? JSON.stringify(event) : formatEvent(event, { color, width: process.stdout.columns || 120 }));
+  };
+
+  const recent = await client.getRecentEvents(TAIL_PAGE_SIZE);
+  const lines = options.lines !== undefined ? parseInt(options.lines, 10) || 0 : 10;
+  recent.filter(matches).slice(0, lines).reverse().forEach(print);
+  // Track the highest _sequence seen rather than the first event's, so
+  // nothing depends on the order the events are served in
+  const newestSequence = events => Math.max(...events.map(event => event._sequence || 0));
+  let lastSequence = recent.length > 0 ? newestSequence(recent) : null;
+
+  let connected = true;
+  const poll = async () => {
+    try {
+      const events = await client.getRecentEvents(TAIL_PAGE_SIZE);
+      if (!connected) {
+        console.error('Reconnected to the proxy');
+        connected = true;
+      }
+      // Events numbered after the last one we saw are new (all of them if
+      // the proxy restarted, as numbering starts again)
+      const newest = events.length > 0 ? newestSequence(events) : null;
+      const restarted = lastSequence !== null && newest !== null && newest < lastSequence;
+      const fresh = (lastSequence === null || restarted ? events : events.filter(e => e._sequence > lastSequence))
+        .sort((a, b) => a._sequence - b._sequence);
+      const [oldest] = fresh;
+      if (oldest && lastSequence !== null && !restarted && oldest._sequence > lastSequence + 1) {
+        const missed = oldest._sequence - lastSequence - 1;
+        console.error(`(${missed} event${missed === 1 ? '' : 's'} missed: dropped by the proxy, or more than ${TAIL_PAGE_SIZE} arrived between polls)`);
+      }
+      if (newest !== null) lastSequence = newest;
+      fresh.forEach(print);
+    } catch (err) {
+      if (connected) {
+        console.error(`Lost connection to the proxy (${err.message}); retrying...`);
+        connected = false;
+      }
+    }
+    setTimeout(poll, TAIL_POLL_MS);
+  };
+  setTimeout(poll, TAIL_POLL_MS);
+
+  // Runs until interrupted
+  return new Promise(() => {});
+}
+
+async function ui(options) {
+  if (!process.stdin.isTTY || !process.stdout.isTTY) {
+    console.error('loggy-proxy ui needs an interactive terminal (use "loggy-proxy tail" for pipes)');
+    return EXIT.FAILURE;
+  }
+
+  const settings = loadProxySettings(null, { quiet: true });
+  const client = await connectToProxy(settings, 'ui', options);
+  if (!client) return EXIT.FAILURE;
+
+  const title = PROFILE_PATHS.profile ? `loggy-proxy [${PROFILE_PATHS.profile}]` : 'loggy-proxy';
+  await new EventBrowser(client, { maxEvents: settings.maxEvents, title }).run();
+  return EXIT.OK;
+}
+
+/**
+ * Events from the running proxy or, without one, the file sink's files
+ * @returns {Promise<object>} - { events (oldest first), origin }
+ */
+async function loadEventsForExport(settings, from) {
+  if (from !== 'store') {
+    const client = new ProxyApiClient({ apiPort: settings.apiPort });
+    if (await client.isReachable()) {
+      const { events = [] } = await client.get('/events');
+      return { events: events.reverse(), origin: `the proxy on port ${settings.apiPort}` };
+    }
+    if (from === 'proxy') {
+      throw new Error(`No proxy is answering on port ${settings.apiPort}`);
+    }
+  }
+
+  const filePath = resolvePath(settings.sinks.file.path);
+  const { events, files } = readStoredEvents(filePath);
+  return {
+    events,
+    origin: files.length > 0 ? `${files.length} file(s) in ${path.dirname(filePath)}` : `${filePath} (no stored events; enable sinks.file to keep them)`
+  };
+}
+
+/**
+ * Serialize events for export or capture, shaped by --fields, --exclude,
+ * --flatten and --indent
+ */
+function writeExport(events, format, options) {
+  const option = name => (typeof options[name] === 'string' ? options[name] : null);
+  const indent = parseIndent(option('indent'));
+  const flatten = !!options.flatten;
+  const shaped = transformEvents(events, { fields: option('fields'), exclude: option('exclude'), flatten });
+  return formatEvents(shaped, format, { indent, columns: flatten || option('fields') ? 'keys' : 'panel' });
+}
+
+async function exportEvents(file, options) {
+  const settings = loadProxySettings(null, { quiet: true });
+  const toStdout = !file || file === '-';
+  const format = resolveFormat(options.format, toStdout ? null : file);
+  const option = name => (typeof options[name] === 'string' ? options[name] : null);
+  parseIndent(option('indent'));
+
+  const { events, origin } = await loadEventsForExport(settings, option('from'));
+
+  if (options['list-sessions']) {
+    const sessions = listSessions(events);
+    if (sessions.length === 0) {
+      console.error(`No events in ${origin}`);
+      return EXIT.FAILURE;
+    }
+    for (const session of sessions) {
+      console.log(`${session.session.padEnd(26)} ${String(session.events).padStart(6)} events  ${session.first} - ${session.last}`);
+    }
+    return EXIT.OK;
+  }
+
+  const selected = filterEvents(events, {
+    source: option('source'),
+    name: option('name'),
+    since: option('since'),
+    until: option('until'),
+    session: option('session')
+  });
+  const contents = writeExport(selected, format, options);
+
+  if (toStdout) {
+    process.stdout.write(contents);
+  } else {
+    fs.mkdirSync(path.dirname(path.resolve(file)), { recursive: true });
+    fs.writeFileSync(file, contents);
+  }
+  console.error(`Exported ${selected.length} of ${events.length} events from ${origin}${toStdout ? '' : ` to ${path.resolve(file)}`} (${format.toUpperCase()})`);
+  return EXIT.OK;
+}
+
+// How often capture copies its proxy's buffer (which keeps the last maxEvents)
+const CAPTURE_POLL_MS = 1000;
+
+/**
+ * Start a proxy of its own, capture until --duration passes, the command
+ * after "--" exits or a SIGINT/SIGTERM arrives, then write the events and
+ * stop the proxy
+ */
+async function capture(commandArgs, options) {
+  const settings = loadProxySettings(null, { quiet: true });
+  const option = name => (typeof options[name] === 'string' ? options[name] : null);
+  const output = option('output');
+  const toStdout = !output || output === '-';
+  const format = resolveFormat(option('format'), toStdout ? null : output);
+  const durationMs = option('duration') ? parseDuration(option('duration')) : null;
+  parseIndent(option('indent'));
+  // Read before the run, so a broken spec fails fast
+  const expectations = option('expect') ? loadExpectations(option('expect')) : null;
+  if (option('report')) {
+    if (!expectations) throw new UsageError('--report needs --expect', { name: 'capture' });
+    resolveReportFormat(option('report-format'), option('report'));
+  }
+
+  const client = new ProxyApiClient({ apiPort: settings.apiPort });
+  if (await client.isReachable()) {
+    console.error(`A proxy is already running on API port ${settings.apiPort}. Stop it, or give the capture its own ports with --ports or --profile.`);
+    return EXIT.FAILURE;
+  }
+
+  applyRecordFixtures(options);
+  applyPromiscuous(options);
+  applyEnvironmentLabel(options);
+  const proxy = startInlineProxy();
+  if (!await waitUntilReachable(client, 15000)) {
+    proxy.kill();
+    console.error('The proxy did not start within 15 seconds');
+    return EXIT.FAILURE;
+  }
+
+  // Keyed by _sequence (IDs repeat when an SDK retries): each poll returns
+  // the whole buffer, newest first
+  const collected = new Map();
+  const collect = async () => {
+    const { events = [] } = await client.get('/events');
+    for (const event of events.reverse()) {
+      const key = event._sequence ?? event.id;
+      if (!collected.has(key)) collected.set(key, event);
+    }
+  };
+  const poller = setInterval(() => collect().catch(() => {}), CAPTURE_POLL_MS);
+
+  const startedAt = Date.now();
+  const proxyUrl = `http://127.0.0.1:${settings.proxyPort}`;
+  console.error(`Capturing through ${proxyUrl}${durationMs ? ` for ${formatDuration(durationMs / 1000)}` : ''}`);
+
+  let command = null;
+  const outcome = await new Promise(resolve => {
+    if (durationMs) {
+      setTimeout(() => resolve({ reason: 'duration reached' }), durationMs);
+    }
+    for (const signal of ['SIGINT', 'SIGTERM', 'SIGHUP']) {
+      process.once(signal, () => resolve({ reason: signal }));
+    }
+    if (commandArgs.length > 0) {
+      command = spawn(commandArgs[0], commandArgs.slice(1), {
+        // Keep stdout for the events when they go there
+        stdio: ['inherit', toStdout ? process.stderr : 'inherit', 'inherit'],
+        env: {
+          ...process.env,
+          HTTP_PROXY: proxyUrl,
+          HTTPS_PROXY: proxyUrl,
+          http_proxy: proxyUrl,
+          https_proxy: proxyUrl,
+          NODE_EXTRA_CA_CERTS: caCertPath(settings)
+        }
+      });
+      command.on('error', err => resolve({ reason: `${commandArgs[0]} failed to start (${err.message})`, failed: true }));
+      command.on('exit', (code, signal) => resolve(code === 0
+        ? { reason: `${commandArgs[0]} finished` }
+        : { reason: `${commandArgs[0]} exited with ${signal || `code ${code}`}`, failed: true }));
+    }
+  });
+
+  clearInterval(poller);
+  if (command && command.exitCode === null && command.signalCode === null) {
+    command.kill('SIGTERM');
+  }
+
+  let capturedTotal = null;
+  try {
+    await collect();
+    capturedTotal = (await client.get('/status')).capturedTotal;
+  } catch (err) {
+    console.error(`Could not read the last events from the proxy (${err.message})`);
+  }
+
+  proxy.removeAllListeners('exit');
+  proxy.kill();
+
+  const events = [...collected.values()];
+  const selected = filterEvents(events, { source: option('source'), name: option('name') });
+  const contents = writeExport(selected, format, options);
+  if (toStdout) {
+    process.stdout.write(contents);
+  } else {
+    fs.mkdirSync(path.dirname(path.resolve(output)), { recursive: true });
+    fs.writeFileSync(output, contents);
+  }
+
+  const seconds = Math.round((Date.now() - startedAt) / 1000);
+  console.error(`Captured ${selected.length} of ${events.length} events in ${formatDuration(seconds)} (${outcome.reason})` +
+    `${toStdout ? '' : ` to ${path.resolve(output)}`} (${format.toUpperCase()})`);
+  if (capturedTotal !== null && capturedTotal > events.length) {
+    console.error(`${capturedTotal - events.length} events left the buffer before they were read; raise maxEvents`);
+  }
+
+  let met = true;
+  if (expectations) {
+    const report = checkExpectations(expectations, selected);
+    console.error(`\n${formatReport(report)}`);
+    met = report.passed;
+    saveAssertionReport(report, option('expect'), selected.length, options);
+  }
+  return outcome.failed || !met ? EXIT.FAILURE : EXIT.OK;
+}
+
+/**
+ * Write --report (JUnit XML or TAP) when given
+ */
+function saveAssertionReport(report, specFile, events, options) {
+  if (typeof options.report !== 'string') return;
+  const format = writeReport(report, options.report, {
+    format: typeof options['report-format'] === 'string' ? options['report-format'] : null,
+    suite: path.basename(specFile, path.extname(specFile)),
+    events
+  });
+  console.error(`Wrote ${format === 'junit' ? 'JUnit XML' : 'TAP'} report to ${path.resolve(options.report)}`);
+}
+
+async function assertEvents(specFile, file, options) {
+  const spec = loadExpectations(specFile);
+  const option = name => (typeof options[name] === 'string' ? options[name] : null);
+  if (option('report')) {
+    resolveReportFormat(option('report-format'), option('report'));
+  }
+
+  let events;
+  let origin;
+  if (file) {
+    events = readEventsFile(file);
+    origin = file === '-' ? 'stdin' : file;
+  } else {
+    ({ events, origin } = await loadEventsForExport(loadProxySettings(null, { quiet: true }), option('from')));
+  }
+  const selected = filterEvents(events, { source: option('source'), session: option('session') });
+  const report = checkExpectations(spec, selected);
+
+  if (options.json) {
+    console.log(JSON.stringify({ ...report, events: selected.length, origin }, null, 2));
+  } else {
+    console.log(`Checking ${selected.length} events from ${origin} against ${specFile}\n`);
+    console.log(formatReport(report));
+  }
+  saveAssertionReport(report, specFile, selected.length, options);
+  return report.passed ? EXIT.OK : EXIT.FAILURE;
+}
+
+/**
+ * Add the events of an export or capture file to the proxy's buffer under an
+ * environment label, to compare with "loggy-proxy compare"
+ */
+async function importCapture(file, options) {
+  if (typeof options.environment !== 'string' || !options.environment) {
+    throw new UsageError('--environment is required (the label to compare by, e.g. staging)', { name: 'import' });
+  }
+  let events;
+  try {
+    events = readEventsFile(file);
+  } catch (err) {
+    console.error(`Cannot read ${file}: ${err.message}`);
+    return EXIT.FAILURE;
+  }
+
+  const settings = loadProxySettings(null, { quiet: true });
+  const client = await connectToProxy(settings, 'import', options);
+  if (!client) return EXIT.FAILURE;
+  const answer = await client.request('POST', '/events/import', { environment: options.environment, events });
+  if (!answer.success) {
+    console.error(answer.error || 'The proxy refused the events');
+    return EXIT.FAILURE;
+  }
+  console.log(`Imported ${answer.imported} events as environment "${options.environment}"`);
+  return EXIT.OK;
+}
+
+/**
+ * The running proxy's API client, or null (with a message) when none is running
+ */
+async function runningProxy(settings, what) {
+  const client = new ProxyApiClient({ apiPort: settings.apiPort });
+  if (await client.isReachable()) return client;
+  console.error(`No proxy is answering on port ${settings.apiPort}; ${what}`);
+  return null;
+}
+
+/**
+ * Pin a buffered event, so it outlives /clear and the buffer rolling over
+ */
+async function pin(id, options) {
+  const client = await runningProxy(loadProxySettings(null, { quiet: true }), 'pins are kept by a running proxy');
+  if (!client) return EXIT.FAILURE;
+  const answer = await client.request('POST', `/events/${encodeURIComponent(id)}/pin`, typeof options.note === 'string' ? { note: options.note } : {});
+  if (!answer.success) {
+    console.error(answer.error || `Could not pin ${id}`);
+    return EXIT.FAILURE;
+  }
+  console.log(`Pinned ${answer.event.event} (${id})`);
+  return EXIT.OK;
+}
+
+async function unpin(id, options) {
+  if (!id && !options.all) throw new UsageError('Give an event ID, or --all', { name: 'unpin' });
+  const client = await runningProxy(loadProxySettings(null, { quiet: true }), 'pins are kept by a running proxy');
+  if (!client) return EXIT.FAILURE;
+  const answer = options.all
+    ? await client.request('DELETE', '/events/pinned')
+    : await client.request('DELETE', `/events/${encodeURIComponent(id)}/pin`);
+  if (!answer.success) {
+    console.error(answer.error || `Could not unpin ${id}`);
+    return EXIT.FAILURE;
+  }
+  console.log(options.all ? `Unpinned ${answer.unpinned} events` : `Unpinned ${id}`);
+  return EXIT.OK;
+}
+
+/**
+ * List the pinned events, newest pin first
+ */
+async function pinned(options) {
+  const client = await runningProxy(loadProxySettings(null, { quiet: true }), 'pins are kept by a running proxy');
+  if (!client) return EXIT.FAILURE;
+  const { events = [] } = await client.get('/events/pinned');
+  if (options.json) {
+    console.log(JSON.stringify(events, null, 2));
+    return EXIT.OK;
+  }
+  if (events.length === 0) {
+    console.log('No pinned events');
+    return EXIT.OK;
+  }
+  const color = useColor();
+  for (const event of events) {
+    console.log(formatEvent(event, { color, width: process.stdout.columns || 120 }));
+    console.log(`    ${event.id}${event._pinned.note ? `  ${event._pinned.note}` : ''}`);
+  }
+  return EXIT.OK;
+}
+
+/**
+ * Severity counts of the running proxy's buffer, per source and per rule
+ */
+async function severity(options) {
+  const client = await runningProxy(loadProxySettings(null, { quiet: true }), 'severity is counted over a running proxy\'s buffer');
+  if (!client) return EXIT.FAILURE;
+  const query = typeof options.source === 'string' ? `?source=${encodeURIComponent(options.source)}` : '';
+  const counts = await client.get(`/severity${query}`);
+  if (options.json) {
+    console.log(JSON.stringify(counts, null, 2));
+    return counts.error > 0 ? EXIT.FAILURE : EXIT.OK;
+  }
+  if (counts.ruleCount === 0) {
+    console.log('No severity rules are set (the "severity" setting), so every event is ok');
+  }
+  console.log(`${counts.count} buffered events: ${counts.ok} ok, ${counts.warn} warn, ${counts.error} error`);
+  if (counts.sources.length > 0) {
+    console.log('\nBy source:');
+    for (const source of counts.sources) {
+      console.log(`  ${(source.name || source.id).padEnd(24)} ${String(source.ok).padStart(6)} ok ${String(source.warn).padStart(6)} warn ${String(source.error).padStart(6)} error`);
+    }
+  }
+  if (counts.rules.length > 0) {
+    console.log('\nBy rule:');
+    for (const rule of counts.rules) {
+      console.log(`  ${rule.rule.padEnd(24)} ${rule.level.padEnd(5)} ${rule.events} event${rule.events === 1 ? '' : 's'}, ${rule.issues} issue${rule.issues === 1 ? '' : 's'}`);
+    }
+  }
+  return counts.error > 0 ? EXIT.FAILURE : EXIT.OK;
+}
+
+/**
+ * Parse the running proxy's buffered requests again with its current sources
+ */
+async function reprocess(options) {
+  const settings = loadProxySettings(null, { quiet: true });
+  const client = await runningProxy(settings, 'reprocessing works on a running proxy\'s buffer');
+  if (!client) return EXIT.FAILURE;
+  const answer = await client.request('POST', '/reprocess', typeof options.source === 'string' ? { source: options.source } : {});
+  if (!answer.success) {
+    console.error(answer.error || 'The proxy could not reprocess its requests');
+    return EXIT.FAILURE;
+  }
+  if (options.json) {
+    console.log(JSON.stringify(answer, null, 2));
+    return EXIT.OK;
+  }
+  console.log(`Reprocessed ${answer.reprocessed} of ${answer.requests} requests: ${answer.eventsBefore} events -> ${answer.eventsAfter}`);
+  if (answer.unchanged > 0) console.log(`  ${answer.unchanged} parsed to the same events`);
+  if (answer.notKept > 0) console.log(`  ${answer.notKept} no longer kept (reprocess.maxRequests, reprocess.maxBytes); their events are unchanged`);
+  if (answer.unmatched > 0) console.log(`  ${answer.unmatched} no longer match a source; their events are unchanged`);
+  if (answer.failed > 0) console.log(`  ${answer.failed} did not parse; their events are unchanged`);
+  return EXIT.OK;
+}
+
+/**
+ * Run the HTTP requests of a mitmproxy flow file through the proxy's source
+ * matching and parser
+ */
+async function flowsImport(file, options) {
+  let requests;
+  try {
+    requests = readFlows(fs.readFileSync(file === '-' ? 0 : file));
+  } catch (err) {
+    console.error(`Cannot read ${file}: ${err.message}`);
+    return EXIT.FAILURE;
+  }
+  if (requests.length === 0) {
+    console.error(`No HTTP flows in ${file}`);
+    return EXIT.FAILURE;
+  }
+
+  const settings = loadProxySettings(null, { quiet: true });
+  const client = await connectToProxy(settings, 'flows import', options);
+  if (!client) return EXIT.FAILURE;
+  const answer = await client.request('POST', '/requests', {
+    requests: requests.map(request => ({ ...request, body: request.body.toString('base64') }))
+  });
+  if (!answer.success) {
+    console.error(answer.error || 'The proxy refused the requests');
+    return EXIT.FAILURE;
+  }
+  console.log(`Sent ${answer.requests} requests from ${file}; ${answer.captured} matched a source`);
+  return EXIT.OK;
+}
+
+/**
+ * Write recorded fixtures as a mitmproxy flow file
+ */
+function flowsExport(output, paths) {
+  const settings = loadProxySettings(null, { quiet: true });
+  const files = findFixtures(paths.length ? paths : [resolvePath(settings.fixtures.dir)]);
+  if (!files.length) {
+    console.error(`No fixtures in ${paths.length ? paths.join(', ') : resolvePath(settings.fixtures.dir)}. Record some with "loggy-proxy start --record-fixtures <dir>".`);
+    return EXIT.FAILURE;
+  }
+
+  const requests = files.map(file => {
+    const fixture = readFixture(file);
+    return { method: fixture.method, url: fixture.url, headers: fixture.headers, body: Buffer.from(fixture.body, 'base64'), timestamp: fixture.recordedAt };
+  });
+  fs.writeFileSync(output, writeFlows(requests));
+  console.log(`Wrote ${requests.length} flows to ${output}`);
+  return EXIT.OK;
+}
+
+/**
+ * Compare event coverage and schemas between two environments in the buffer
+ */
+async function compare(a, b, options) {
+  const settings = loadProxySettings(null, { quiet: true });
+  const client = await connectToProxy(settings, 'compare', options);
+  if (!client) return EXIT.FAILURE;
+  const report = await client.get(`/environments/compare?a=${encodeURIComponent(a)}&b=${encodeURIComponent(b)}`);
+  if (!report.coverage) {
+    console.error(report.error || 'This proxy cannot compare environments; restart it to update');
+    return EXIT.FAILURE;
+  }
+
+  const { coverage, schema, summary } = report;
+  const differs = summary.onlyInA + summary.onlyInB + summary.schemaDifferences > 0;
+  if (options.json) {
+    console.log(JSON.stringify(report, null, 2));
+    return differs ? EXIT.FAILURE : EXIT.OK;
+  }
+
+  for (const [label, count] of [[a, report.counts.a], [b, report.counts.b]]) {
+    if (count === 0) console.error(`No buffered events from environment "${label}"`);
+  }
+  console.log(`Comparing ${a} (${report.counts.a} events) with ${b} (${report.counts.b} events): ${summary.both} event names in both\n`);
+  const listNames = (label, names) => {
+    if (names.length === 0) return;
+    console.log(`Only in ${label} (${names.length}):`);
+    for (const name of names) {
+      console.log(`  ${name.source.padEnd(16)} ${name.event}  (${name.count})`);
+    }
+  };
+  listNames(a, coverage.onlyInA);
+  listNames(b, coverage.onlyInB);
+  if (schema.length > 0) {
+    console.log(`Schema differences (${schema.length}):`);
+    for (const difference of schema) {
+      console.log(`  ${difference.source.padEnd(16)} ${difference.event}`);
+      if (difference.onlyInA.length > 0) console.log(`    only in ${a}: ${difference.onlyInA.join(', ')}`);
+      if (difference.onlyInB.length > 0) console.log(`    only in ${b}: ${difference.onlyInB.join(', ')}`);
+      for (const change of difference.typeChanges) {
+        console.log(`    ${change.path}: ${change.a.join('|')} in ${a}, ${change.b.join('|')} in ${b}`);
+      }
+    }
+  }
+  if (!differs) console.log('No differences');
+  return differs ? EXIT.FAILURE : EXIT.OK;
+}
+
+/**
+ * Parse recorded fixtures again with the current sources and parser
+ */
+function replay(paths, options) {
+  const settings = loadProxySettings(null, { quiet: true });
+  const files = findFixtures(paths.length ? paths : [resolvePath(settings.fixtures.dir)]);
+  if (!files.length) {
+    console.error(`No fixtures in ${paths.length ? paths.join(', ') : resolvePath(settings.fixtures.dir)}. Record some with "loggy-proxy start --record-fixtures <dir>".`);
+    return EXIT.FAILURE;
+  }
+
+  const configManager = loadSources(settings);
+  const results = files.map(file => {
+    try {
+      const fixture = readFixture(file);
+      const result = replayFixture(fixture, configManager);
+      if (options.update && !result.passed && result.source) {
+        fs.writeFileSync(file, JSON.stringify({ ...fixture, source: result.source, expected: result.events }, null, 2) + '\n');
+        return { file, ...result, updated: true };
+      }
+      return { file, ...result };
+    } catch (err) {
+      return { file, passed: false, source: null, events: [], differences: [err.message] };
+    }
+  });
+  const failed = results.filter(result => !result.passed && !result.updated);
+
+  if (options.json) {
+    console.log(JSON.stringify(results.map(({ events, ...result }) => ({ ...result, events: events.length })), null, 2));
+  } else {
+    for (const result of results) {
+      const mark = result.passed ? '✓' : result.updated ? '↻' : '✗';
+      console.log(`${mark} ${result.file} (${result.source || 'no source'}, ${result.events.length} event(s))`);
+      for (const line of result.passed ? [] : result.differences) {
+        console.log(`    ${line}`);
+      }
+    }
+    const updated = results.filter(result => result.updated).length;
+    console.log(`\n${results.length - failed.length - updated} passed, ${failed.length} failed${updated ? `, ${updated} updated` : ''}`);
+  }
+  return failed.length ? EXIT.FAILURE : EXIT.OK;
+}
+
+async function bench(paths, options) {
+  const settings = loadProxySettings(null, { quiet: true });
+  const iterations = typeof options.iterations === 'string' ? parseInt(options.iterations, 10) : 1000;
+  if (!(iterations > 0)) {
+    throw new UsageError('--iterations takes a positive number', { name: 'bench' });
+  }
+
+  // Without arguments: the built-in corpus, plus any recorded fixtures
+  const fixtureDir = resolvePath(settings.fixtures.dir);
+  const files = paths.length ? findFixtures(paths) : fs.existsSync(fixtureDir) ? findFixtures([fixtureDir]) : [];
+  const cases = [...(paths.length ? [] : builtinCases()), ...fixtureCases(files, loadSources(settings))];
+  if (!cases.length) {
+    console.error(`No fixtures in ${paths.join(', ')}`);
+    return EXIT.FAILURE;
+  }
+
+  const run = () => runBenchmarks(cases, { iterations, warmup: Math.min(100, iterations) });
+  const profileFile = typeof options['cpu-profile'] === 'string' ? path.resolve(options['cpu-profile']) : null;
+  const results = profileFile ? await profileCpu(profileFile, run) : run();
+
+  if (options.json) {
+    console.log(JSON.stringify(results, null, 2));
+  } else {
+    console.log(`${'Payload'.padEnd(28)} ${'Bytes'.padStart(8)} ${'Events'.padStart(6)} ${'ops/s'.padStart(8)} ` +
+      `${'mean µs'.padStart(9)} ${'p99 µs'.padStart(9)}  decompress / JSON / extract µs`);
+    for (const result of results) {
+      const name = result.name.length > 28 ? `…${result.name.slice(-27)}` : result.name;
+      if (result.error) {
+        console.log(`${name.padEnd(28)} does not parse: ${result.error}`);
+        continue;
+      }
+      const { stages } = result;
+      console.log(`${name.padEnd(28)} ${String(result.bytes).padStart(8)} ${String(result.events).padStart(6)} ` +
+        `${String(result.opsPerSec).padStart(8)} ${String(result.meanUs).padStart(9)} ${String(result.p99Us).padStart(9)}  ` +
+        `${stages.decompressUs} / ${stages.jsonUs} / ${stages.extractUs}`);
+    }
+  }
+  if (profileFile) {
+    console.error(`Wrote a CPU profile to ${profileFile} (open it in Chrome DevTools > Performance)`);
+  }
+  return results.some(result => result.error) ? EXIT.FAILURE : EXIT.OK;
+}
+
+// Quote a browser flag for pasting into a shell
+function shellQuote(arg) {
+  return /^[\w@%+=:,./-]+$/.test(arg) ? arg : `'${arg.replace(/'/g, `'\\''`)}'`;
+}
+
+async function browserArgs(options) {
+  const browser = typeof options.browser === 'string' ? options.browser : 'chromium';
+  if (!BROWSERS.includes(browser)) {
+    throw new UsageError(`Unknown browser "${browser}" (expected ${BROWSERS.join(' or ')})`, { name: 'browser-args' });
+  }
+  const settings = loadProxySettings(null, { quiet: true });
+  const config = await browserLaunchConfig(settings, {
+    browser,
+    userDataDir: typeof options['user-data-dir'] === 'string' ? options['user-data-dir'] : null
+  });
+
+  if (typeof options.output === 'string') {
+    fs.mkdirSync(path.dirname(path.resolve(options.output)), { recursive: true });
+    fs.writeFileSync(options.output, JSON.stringify(config, null, 2) + '\n');
+    console.error(`Wrote ${config.browser} launch settings to ${path.resolve(options.output)}`);
+  }
+  if (options.json) {
+    console.log(JSON.stringify(config, null, 2));
+    return EXIT.OK;
+  }
+  if (typeof options.output === 'string') {
+    return EXIT.OK;
+  }
+
+  console.log(`Proxy server:   ${config.proxyServer}`);
+  console.log(`CA certificate: ${config.caCertPath} (${config.caTrusted ? 'trusted' : 'not trusted; certificate errors are ignored'})`);
+  if (config.userDataDir) {
+    console.log(`Profile:        ${config.userDataDir}`);
+  }
+  if (config.args.length > 0) {
+    console.log(`Browser flags:  ${config.args.map(shellQuote).join(' ')}`);
+  }
+  console.log('\nPlaywright:');
+  console.log(`  launch options:  ${JSON.stringify(config.playwright.launchOptions)}`);
+  console.log(`  context options: ${JSON.stringify(config.playwright.contextOptions)}`);
+  if (config.puppeteer) {
+    console.log('Puppeteer:');
+    console.log(`  launch options:  ${JSON.stringify(config.puppeteer.launchOptions)}`);
+  }
+  if (config.notes.length > 0) {
+    console.log(`\n${config.notes.map(note => `Note: ${note}`).join('\n')}`);
+  }
+  return EXIT.OK;
+}
+
+// Generated requests allowed in flight; past that, generate waits
+const GENERATE_MAX_IN_FLIGHT = 64;
+const GENERATE_TICK_MS = 20;
+
+async function generate(options) {
+  const settings = loadProxySettings(null, { quiet: true });
+  const option = name => (typeof options[name] === 'string' ? options[name] : null);
+  const via = option('via') || 'proxy';
+  if (!['proxy', 'api'].includes(via)) {
+    throw new UsageError(`Unknown --via "${via}" (expected proxy or api)`, { name: 'generate' });
+  }
+  const rate = parseRate(option('rate') || '1/s');
+  const batchSize = option('batch') ? parseInt(option('batch'), 10) : 1;
+  const count = option('count') ? parseInt(option('count'), 10) : null;
+  if (!(batchSize > 0) || (count !== null && !(count > 0))) {
+    throw new UsageError('--batch and --count take a positive number', { name: 'generate' });
+  }
+  const durationMs = option('duration') ? parseDuration(option('duration')) : null;
+
+  const client = await connectToProxy(settings, 'generate', options);
+  if (!client) return EXIT.FAILURE;
+
+  // The proxy's own sources, so the requests match what it captures
+  const { configManager } = await currentSources(settings);
+  const wanted = option('source') ? option('source').split(',').map(s => s.trim().toLowerCase()).filter(Boolean) : null;
+  const sources = configManager.getAllSources().filter(source => wanted
+    ? wanted.includes(source.id.toLowerCase()) || wanted.includes(String(source.name).toLowerCase())
+    : source.enabled);
+  for (const name of wanted || []) {
+    if (!sources.some(source => source.id.toLowerCase() === name || String(source.name).toLowerCase() === name)) {
+      console.error(`Unknown source "${name}" (add it with "loggy-proxy sources add ${name} --domain <domain>")`);
+      return EXIT.FAILURE;
+    }
+  }
+  const disabled = sources.filter(source => !source.enabled);
+  if (disabled.length > 0) {
+    console.error(`Not capturing ${disabled.map(source => source.id).join(', ')}: enable with "loggy-proxy sources edit <id> --enable"`);
+    return EXIT.FAILURE;
+  }
+  if (sources.length === 0) {
+    console.error('No enabled sources to generate events for');
+    return EXIT.FAILURE;
+  }
+
+  const generator = new EventGenerator();
+  const agent = new http.Agent({ keepAlive: true, maxSockets: GENERATE_MAX_IN_FLIGHT });
+  const send = via === 'api'
+    ? (source, events) => {
+        const { host, path: urlPath } = requestTarget(source);
+        return client.request('POST', '/events', { source: source.id, events, url: `https://${host}${urlPath}` }).then(answer => {
+          if (!answer.success) throw new Error(answer.error || 'The proxy rejected the events');
+        });
+      }
+    : (source, events) => sendThroughProxy({ proxyPort: settings.proxyPort, agent }, source, events);
+
+  console.error(`Generating ${option('rate') || '1/s'} events for ` +
+    `${sources.map(source => source.name).join(', ')} ${via === 'api' ? 'into the proxy\'s buffer' : `through the proxy on port ${settings.proxyPort}`}` +
+    ' (Ctrl+C stops)');
+
+  const startedAt = Date.now();
+  let generated = 0;
+  let sent = 0;
+  let failed = 0;
+  let dropped = 0;
+  let inFlight = 0;
+  let lastError = null;
+  let fatal = null;
+  let next = 0;
+
+  await new Promise(resolve => {
+    const stop = () => {
+      clearInterval(timer);
+      // Let requests in flight finish
+      const drain = setInterval(() => {
+        if (inFlight === 0) {
+          clearInterval(drain);
+          resolve();
+        }
+      }, GENERATE_TICK_MS);
+    };
+    for (const signal of ['SIGINT', 'SIGTERM', 'SIGHUP']) {
+      process.once(signal, stop);
+    }
+
+    const timer = setInterval(() => {
+      const elapsed = Date.now() - startedAt;
+      const limit = count !== null ? count : Infinity;
+      if (fatal || generated >= limit || (durationMs && elapsed >= durationMs)) {
+        stop();
+        return;
+      }
+      // Events due by now, in whole batches (the last one may be short)
+      let due = Math.min(Math.floor(elapsed / 1000 * rate) + 1, limit) - generated;
+      while (due >= Math.min(batchSize, limit - generated) && due > 0 && inFlight < GENERATE_MAX_IN_FLIGHT) {
+        const size = Math.min(batchSize, limit - generated);
+        const source = sources[next++ % sources.length];
+        const events = Array.from({ length: size }, () => generator.next());
+        generated += size;
+        due -= size;
+        inFlight++;
+        send(source, events)
+          .then(() => { sent += size; })
+          .catch(err => {
+            if (err.dropped) {
+              dropped += size;
+              return;
+            }
+            failed += size;
+            lastError = err.message;
+            if (err.forwarded) fatal = err;
+          })
+          .finally(() => { inFlight--; });
+      }
+    }, GENERATE_TICK_MS);
+  });
+  agent.destroy();
+
+  if (fatal) {
+    console.error(fatal.message);
+    return EXIT.FAILURE;
+  }
+  const seconds = (Date.now() - startedAt) / 1000;
+  console.error(`Sent ${sent} events in ${formatDuration(seconds)} (${(sent / Math.max(seconds, 0.001)).toFixed(1)}/s)` +
+    `${failed > 0 ? `; ${failed} failed (${lastError})` : ''}` +
+    `${dropped > 0 ? `; ${dropped} dropped by the proxy (parse queue full; see parsing in its settings)` : ''}`);
+  return failed > 0 || dropped > 0 ? EXIT.FAILURE : EXIT.OK;
+}
+
+/**
+ * Sources as the proxy sees them: the running proxy's (which include any the
+ * extension pushed over the API), else the built-in ones and the sources file
+ * @returns {Promise<object>} - { configManager, origin }
+ */
+async function currentSources(settings) {
+  const configManager = loadSources(settings);
+  const client = new ProxyApiClient({ apiPort: settings.apiPort });
+  if (!await client.isReachable()) {
+    return { configManager, origin: PROFILE_PATHS.sourcesPath };
+  }
+
+  const { sources = [] } = await client.get('/sources');
+  configManager.sources.clear();
+  for (const source of sources) {
+    configManager.sources.set(source.id, new SourceConfig(source.id, source));
+  }
+  return { configManager, origin: `the proxy on port ${settings.apiPort}` };
+}
+
+/**
+ * Have a running proxy pick up a changed sources file
+ */
+async function reloadProxySources(settings) {
+  const client = new ProxyApiClient({ apiPort: settings.apiPort });
+  if (!await client.isReachable()) {
+    console.log('  The proxy is not running; it loads the change when it starts');
+    return;
+  }
+  const result = await client.request('POST', '/sources/reload');
+  console.log(result.success ? '  Reloaded in the running proxy' : `  Could not reload the running proxy: ${result.error}`);
+}
+
+/**
+ * Source properties from add/edit options (undefined = not given)
+ */
+function sourceOptions(options) {
+  const text = name => (typeof options[name] === 'string' ? options[name] : undefined);
+  return {
+    name: text('name'),
+    domain: text('domain'),
+    urlPattern: text('url-pattern'),
+    aliases: text('alias') !== undefined ? options.alias.split(',').map(alias => alias.trim()).filter(Boolean) : undefined,
+    color: text('color'),
+    icon: text('icon'),
+    fieldMappings: text('map') !== undefined ? parseFieldMappings(options.map) : undefined,
+    enabled: options.disable ? false : options.enable ? true : undefined,
+    websocket: options['no-websocket'] ? false : options.websocket ? true : undefined
+  };
+}
+
+function describeSource(source) {
+  const aliases = source.aliases && source.aliases.length > 0 ? ` (+${source.aliases.join(', ')})` : '';
+  const domain = source.domain || (source.signature ? `signature ${source.signature}` : '(no domain)');
+  return `${domain}${source.urlPattern ? ` ${source.urlPattern}` : ''}${aliases}${source.websocket ? ' +WebSocket' : ''}`;
+}
+
+async function sourcesList(options) {
+  const settings = loadProxySettings(null, { quiet: true });
+  const { configManager, origin } = await currentSources(settings);
+  const sources = configManager.getAllSources();
+
+  if (options.json) {
+    console.log(JSON.stringify(sources.map(source => source.toJSON()), null, 2));
+    return EXIT.OK;
+  }
+
+  for (const source of sources) {
+    const allowed = !configManager.enabledSourceIds || configManager.enabledSourceIds.includes(source.id);
+    const state = !source.enabled ? 'disabled' : allowed ? 'enabled' : 'not in enabledSources';
+    console.log(`${source.id.padEnd(20)} ${source.name.padEnd(20)} ${describeSource(source).padEnd(32)} ${state.padEnd(9)} ${source.stats.eventsCapture || 0} events  (${source.createdBy})`);
+  }
+  console.error(`\n${sources.length} sources from ${origin}`);
+  return EXIT.OK;
+}
+
+async function sourcesAdd(id, options) {
+  const source = addSource(id, sourceOptions(options));
+  console.log(`Added source "${source.id}" (${source.name}): ${describeSource(source)}${source.enabled ? '' : ', disabled'}`);
+  await reloadProxySources(loadProxySettings(null, { quiet: true }));
+  return EXIT.OK;
+}
+
+async function sourcesEdit(id, options) {
+  const source = editSource(id, sourceOptions(options));
+  console.log(`Updated source "${source.id}" (${source.name}): ${describeSource(source)}${source.enabled ? '' : ', disabled'}`);
+  await reloadProxySources(loadProxySettings(null, { quiet: true }));
+  return EXIT.OK;
+}
+
+async function sourcesRemove(id) {
+  const result = removeSource(id);
+  console.log(result.restoredDefault
+    ? `Removed your changes to "${id}"; the built-in definition applies again`
+    : `Removed source "${id}"`);
+  await reloadProxySources(loadProxySettings(null, { quiet: true }));
+  return EXIT.OK;
+}
+
+async function sourcesTest(url, options) {
+  const settings = loadProxySettings(null, { quiet: true });
+  const body = typeof options.body === 'string'
+    ? fs.readFileSync(options.body === '-' ? 0 : options.body, 'utf8')
+    : null;
+  const { configManager, origin } = await currentSources(settings);
+  const result = testSource(configManager, url, body);
+  const parsed = body === null || (result.events && result.events.length > 0);
+
+  if (options.json) {
+    console.log(JSON.stringify(result, null, 2));
+    return result.source && parsed ? EXIT.OK : EXIT.FAILURE;
+  }
+
+  if (result.source) {
+    console.log(`Source:  ${result.source.name} (${result.source.id}), ${describeSource(result.source)}`);
+  } else {
+    console.log(`Source:  none of the ${configManager.getAllSources().length} sources from ${origin} match`);
+    if (result.looksLikeAnalytics) {
+      console.log(`         This looks like an analytics endpoint; capture it with:`);
+      console.log(`         loggy-proxy sources add <id> --domain ${result.domain}`);
+    }
+  }
+  for (const candidate of result.candidates) {
+    console.log(`Skipped: ${candidate.name} (${candidate.id}): ${candidate.reason}`);
+  }
+
+  if (result.parseError) {
+    console.log(`Body:    not JSON (${result.parseError}); the proxy would not capture it`);
+  } else if (result.events) {
+    console.log(`Events:  ${result.events.length}${result.source ? '' : ' (parsed without a source, so nothing would be captured)'}`);
+    const color = useColor(process.stdout, !!options['no-color']);
+    for (const event of result.events) {
+      console.log(`  ${formatEvent(event, { color, width: (process.stdout.columns || 120) - 2 })}`);
+    }
+  }
+
+  return result.source && parsed ? EXIT.OK : EXIT.FAILURE;
+}
+
+function profileList(options) {
+  const list = profiles.listProfiles();
+  if (options.json) {
+    console.log(JSON.stringify(list.map(profile => ({ ...profile, running: isRunning(profiles.profilePaths(profile.name).pidFile) })), null, 2));
+    return EXIT.OK;
+  }
+  if (list.length === 0) {
+    console.log('No profiles (create one with: loggy-proxy profile create <name>)');
+    return EXIT.OK;
+  }
+  for (const profile of list) {
+    const running = isRunning(profiles.profilePaths(profile.name).pidFile) ? '  running' : '';
+    const ca = profile.sharedCA ? ', default CA' : '';
+    console.log(`${profile.name.padEnd(16)} proxy ${profile.proxyPort}, api ${profile.apiPort}${ca}  ${profile.home}${running}`);
+  }
+  return EXIT.OK;
+}
+
+/**
+ * Every proxy instance on this machine: the default one and each profile
+ */
+function instances(options) {
+  const list = profiles.listInstances();
+  if (options.json) {
+    console.log(JSON.stringify(list, null, 2));
+    return EXIT.OK;
+  }
+  for (const instance of list) {
+    const state = instance.running ? `running (pid ${instance.pid})` : 'stopped';
+    const ca = instance.name && instance.sharedCA ? ', default CA' : '';
+    console.log(`${(instance.name || '(default)').padEnd(16)} proxy ${instance.proxyPort}, api ${instance.apiPort}${ca}  ${state}`);
+  }
+  return EXIT.OK;
+}
+
+function profileCreate(name, options) {
+  const profile = profiles.createProfile(name, {
+    proxyPort: options['proxy-port'] ? parseInt(options['proxy-port'], 10) : null,
+    apiPort: options['api-port'] ? parseInt(options['api-port'], 10) : null,
+    sharedCA: !!options['shared-ca']
+  });
+  if (!profile.created) {
+    console.error(`Profile "${name}" already exists (${profile.home})`);
+    return EXIT.FAILURE;
+  }
+
+  console.log(`Created profile "${name}" in ${profile.home}`);
+  console.log(`  Proxy port: ${profile.proxyPort}, API port: ${profile.apiPort}`);
+  if (profile.sharedCA) {
+    console.log('\nIt uses the default CA, so a browser that trusts that one needs nothing more.');
+  } else {
+    console.log(`\nIts CA is generated on first start; trust it with: loggy-proxy --profile ${name} cert trust`);
+  }
+  return EXIT.OK;
+}
+
+/**
+ * Have a running proxy re-read its settings (SIGHUP, as the native host does)
+ */
+function reloadProxySettings() {
+  const pid = runningProxyPid();
+  if (!pid) {
+    console.log('  The proxy is not running; it loads the change when it starts');
+  } else if (process.platform === 'win32') {
+    console.log(`  Restart the proxy (pid ${pid}) to apply the change`);
+  } else {
+    process.kill(pid, 'SIGHUP');
+    console.log(`  Reloaded in the running proxy (pid ${pid})`);
+  }
+}
+
+function apiKeyList(options) {
+  const keys = loadProxySettings(null, { quiet: true }).apiKeys;
+  // Keys are only shown when created
+  const listed = keys.map(({ name, scopes }) => ({ name, scopes }));
+  if (options.json) {
+    console.log(JSON.stringify(listed, null, 2));
+    return EXIT.OK;
+  }
+  if (listed.length === 0) {
+    console.log('No API keys: the API is open to anyone who can reach it (create one with: loggy-proxy api-key create <name>)');
+    return EXIT.OK;
+  }
+  for (const key of listed) {
+    console.log(`${key.name.padEnd(20)} ${key.scopes.join(', ')}`);
+  }
+  return EXIT.OK;
+}
+
+function apiKeyCreate(name, options) {
+  const keys = loadProxySettings(null, { quiet: true }).apiKeys;
+  const scopes = typeof options.scopes === 'string' ? options.scopes.split(',').map(scope => scope.trim()).filter(Boolean) : ['read'];
+  const entry = { name, key: generateApiKey(), scopes };
+  const problem = validateApiKeys([...keys, entry]);
+  if (problem) {
+    throw new UsageError(problem.replace(/^apiKeys\[\d+\]: /, ''), { name: 'api-key create' });
+  }
+  saveProxySettings({ apiKeys: [...keys, entry] });
+
+  console.log(`Created API key "${name}" (${scopes.join(', ')}):\n\n  ${entry.key}\n`);
+  console.log('Clients beyond this machine send it as "Authorization: Bearer <key>". It is not shown again.');
+  reloadProxySettings();
+  return EXIT.OK;
+}
+
+function apiKeyRevoke(name) {
+  const keys = loadProxySettings(null, { quiet: true }).apiKeys;
+  if (!keys.some(key => key.name === name)) {
+    console.error(`No API key named "${name}"`);
+    return EXIT.FAILURE;
+  }
+  saveProxySettings({ apiKeys: keys.filter(key => key.name !== name) });
+  console.log(`Revoked API key "${name}"`);
+  reloadProxySettings();
+  return EXIT.OK;
+}
+
+const JSON_OPTION = { name: 'json', description: 'Print JSON' };
+const REPORT_OPTIONS = [
+  { name: 'report', value: '<file>', description: 'Also write the results as JUnit XML (.xml) or TAP (.tap)' },
+  { name: 'report-format', value: '<junit|tap>', description: 'Override the report format' }
+];
+// Shape exported events for their consumer (see transformEvents)
+const TRANSFORM_OPTIONS = [
+  { name: 'fields', value: '<paths>', description: 'Only these fields, comma-separated dot paths (e.g. event,properties.plan)' },
+  { name: 'exclude', value: '<paths>', description: 'Drop these fields (e.g. _rawPayload,_metadata.headers)' },
+  { name: 'flatten', description: 'Nested values as dot keys; CSV gets a column per key' },
+  { name: 'indent', value: '<n>', description: 'JSON indentation, 0 to minify (default 2)' }
+];
+const INLINE_OPTION = { name: 'inline', description: 'Start a proxy for this session if none is running' };
+const RECORD_FIXTURES_OPTION = { name: 'record-fixtures', value: '<dir>', description: 'Save each matched request as a fixture for "replay"' };
+const PROMISCUOUS_OPTION = { name: 'promiscuous', description: 'Also capture every POST/PUT no source matches, as source "uncategorized"' };
+const ENVIRONMENT_OPTION = { name: 'environment', value: '<label>', description: 'Label captured events with this environment (e.g. staging)' };
+
+const SOURCE_OPTIONS = [
+  { name: 'domain', value: '<domain>', description: 'Base domain to match, subdomains included' },
+  { name: 'name', value: '<name>', description: 'Display name (default: the ID)' },
+  { name: 'url-pattern', value: '<glob>', description: 'Only URL paths matching this glob (e.g. /v1/*; "" removes it)' },
+  { name: 'alias', value: '<host[/glob],...>', description: 'Other hosts or endpoints of the source, e.g. events.eu1.segmentapis.com ("" removes them)' },
+  { name: 'color', value: '<#RRGGBB>', description: 'Badge colour' },
+  { name: 'icon', value: '<emoji>', description: 'Icon' },
+  { name: 'map', value: '<field=path,...>', description: 'Field mappings, e.g. eventName=code,propertyContainer=data' },
+  { name: 'websocket', description: 'Also capture JSON frames the page sends over WebSockets to the source' },
+  { name: 'disable', description: 'Disable the source' }
+];
+
+// Applied by global-flags.js before anything else loads; listed here for
+// parsing and help
+const GLOBAL_OPTIONS = [
+  { name: 'profile', value: '<name>', description: 'Act on a named profile (LOGGY_PROFILE)', complete: 'profiles' },
+  { name: 'config', value: '<file>', description: 'Proxy settings file (LOGGY_PROXY_SETTINGS)' },
+  { name: 'ports', value: '<proxy>[,<api>]', description: 'Proxy and API ports for this run (API default: proxy + 1)' },
+  { name: 'log-level', value: '<level>', description: 'error, warn, info or debug, for a proxy started with --inline', complete: logging.LOG_LEVELS },
+  { name: 'quiet', description: 'Only errors (on stderr) from a proxy this command starts; same as --log-level error' },
+  { name: 'log-format', value: '<text|json>', description: 'json: a proxy this command starts logs one JSON object per line', complete: logging.LOG_FORMATS },
+  { name: 'help', description: 'Show help for the command' }
+];
+
+const COMMANDS = [
+  {
+    name: 'cert info',
+    summary: 'Show the CA\'s fingerprint, expiry and trust status',
+    options: [JSON_OPTION],
+    run: ({ options }) => certInfo(!!options.json)
+  },
+  {
+    name: 'cert generate',
+    summary: 'Create the CA now instead of on the proxy\'s first start',
+    description: 'Create the CA now instead of on the proxy\'s first start. Fails if one exists\nunless --force is given (which, unlike "cert rotate", leaves the old CA trusted).',
+    options: [
+      { name: 'force', description: 'Replace an existing CA' },
+      { name: 'trust', description: 'Trust it afterwards (as "cert trust")' },
+      { name: 'system', description: 'macOS: with --trust, the System keychain (uses sudo)' }
+    ],
+    run: ({ options }) => certGenerate(options)
+  },
+  {
+    name: 'cert export',
+    args: '<file>',
+    summary: 'Write the CA certificate as PEM, DER or PKCS#12',
+    description: 'Write the CA certificate (never its key). The format follows the extension:\n.pem, .der/.crt/.cer or .p12/.pfx.',
+    options: [
+      { name: 'format', value: '<pem|der|p12>', description: 'Override the format' },
+      { name: 'password', value: '<password>', description: 'PKCS#12 password (default: empty)' }
+    ],
+    run: ({ positionals: [file], options }) => certExport(file, options)
+  },
+  {
+    name: 'cert trust',
+    summary: 'Trust the CA (login keychain on macOS, NSS database on Linux)',
+    options: [{ name: 'system', description: 'macOS: System keychain, for every user (uses sudo)' }],
+    run: ({ options }) => certTrust(options)
+  },
+  {
+    name: 'cert untrust',
+    summary: 'Remove the CA from the trust store (the CA is kept)',
+    options: [{ name: 'system', description: 'macOS: System keychain (uses sudo)' }],
+    run: ({ options }) => certUntrust(options)
+  },
+  {
+    name: 'cert rotate',
+    summary: 'Replace the CA, re-trust it and clear cached leaf certificates',
+    options: [{ name: 'system', description: 'macOS: untrust/trust in the System keychain (uses sudo)' }],
+    run: ({ options }) => certRotate(options)
+  },
+  {
+    name: 'cert encrypt-key',
+    summary: 'Encrypt (or re-encrypt) the ECDSA CA key with a passphrase',
+    description: 'Encrypt (or re-encrypt) the ECDSA CA key with a passphrase. Reads\nLOGGY_CA_PASSPHRASE (and, to re-encrypt, LOGGY_CA_NEW_PASSPHRASE) when set;\notherwise asks in a terminal.',
+    options: [{ name: 'keychain', description: 'Remember the passphrase in the login keychain (macOS)' }],
+    run: ({ options }) => certEncryptKey(options)
+  },
+  {
+    name: 'version',
+    summary: 'Show the version, commit, build date and default sources revision',
+    description: 'Show the version, commit, build date and default sources revision of this\ninstall and of the running proxy.',
+    options: [JSON_OPTION, { name: 'check-update', description: 'Also look up the latest release' }],
+    run: ({ options }) => version(options)
+  },
+  {
+    name: 'self-update',
+    summary: 'Update this install to the latest release',
+    description: 'Download the latest GitHub release, check the signature of the\n' +
+      'release\'s SHA256SUMS with the pinned release key and the zip against\n' +
+      'SHA256SUMS, and swap it in for this install. Settings and\n' +
+      'sources files are kept, and native host manifests are pointed at the new\n' +
+      'native host if its path changed. Stop the proxy first. Source checkouts\n' +
+      'update with git instead.',
+    options: [
+      { name: 'check', description: 'Only report whether an update is available' },
+      { name: 'force', description: 'Reinstall even if this install is up to date' },
+      { name: 'public-key', value: '<pem-file>', description: 'Ed25519 key that must have signed the release\'s SHA256SUMS (default: config/release-key.pem)' },
+      { name: 'insecure', description: 'Skip the signature check; only the checksum is checked' }
+    ],
+    run: ({ options }) => selfUpdate(options)
+  },
+  {
+    name: 'status',
+    summary: 'Show whether the proxy is running, its buffer and last error',
+    description: 'Show whether the proxy is running (PID, uptime, ports), events per source, buffer\nusage and the last error. Exits 1 if it is not running.',
+    options: [JSON_OPTION],
+    run: ({ options }) => status(options)
+  },
+  {
+    name: 'stats',
+    summary: 'Show memory use, buffer occupancy and payload sizes per source',
+    description: 'Show the proxy\'s memory use, how full the event buffer is and how much memory it\nholds, and request body sizes per source (average and percentiles), for tuning\nmaxEvents and parsing settings.',
+    options: [JSON_OPTION],
+    run: ({ options }) => stats(options)
+  },
+  {
+    name: 'doctor',
+    summary: 'Check ports, CA trust, native host manifest, browser and API',
+    description: 'Check the setup: ports, CA trust, native host manifest, browser and proxy API,\nwith a fix for each problem. Exits 1 if a check fails.',
+    options: [JSON_OPTION],
+    run: ({ options }) => doctor(options)
+  },
+  {
+    name: 'native-host check',
+    summary: 'Check native host manifests against the installed extension\'s ID',
+    description: 'Compare each browser\'s native host manifest with the extension IDs its\n' +
+      'profiles have installed from this directory. Loading the unpacked extension\n' +
+      'from another directory changes its ID, and the manifest then stops the\n' +
+      'extension from starting the proxy. Exits 1 on a mismatch.',
+    options: [JSON_OPTION],
+    run: ({ options }) => nativeHostCheck(options)
+  },
+  {
+    name: 'native-host repair',
+    summary: 'Rewrite native host manifests for the installed extension',
+    description: 'Write the native host manifest for each browser that has the extension\n' +
+      'installed, allowing the IDs it is installed under and pointing at this\n' +
+      'install\'s native host. IDs the manifest allowed before are dropped.',
+    options: [
+      { name: 'extension-id', value: '<id>', description: 'Allow this ID instead of the installed ones (and write the manifest even where it is not installed)' },
+      { name: 'browser', value: '<id>', description: 'Only this browser', complete: Object.keys(BROWSER_DATA_DIRS[process.platform] || {}) }
+    ],
+    run: ({ options }) => nativeHostRepair(options)
+  },
+  {
+    name: 'native-host policy',
+    summary: 'Print a Chrome enterprise policy that force-installs the extension',
+    description: 'Print Chrome policy JSON that force-installs the packed extension\n' +
+      '(ExtensionInstallForcelist) and allows its native host (NativeMessagingAllowlist).\n' +
+      'On Linux, save it in /etc/opt/chrome/policies/managed/; on macOS and Windows,\n' +
+      'deploy the same keys with a configuration profile or Group Policy.',
+    options: [
+      { name: 'extension-id', value: '<id>', description: 'The packed extension\'s ID (default: from manifest.json\'s "key")' },
+      { name: 'update-url', value: '<url>', description: 'Its update manifest URL (default: the Chrome Web Store)' }
+    ],
+    run: ({ options }) => nativeHostPolicy(options)
+  },
+  {
+    name: 'tail',
+    summary: 'Print captured events as they arrive',
+    options: [
+      { name: 'filter', value: '<name>', description: 'Only events whose name matches (glob; plain text matches anywhere)' },
+      { name: 'source', value: '<ids>', description: 'Only these sources (comma-separated IDs or names)', complete: 'sources' },
+      { name: 'severity', value: '<level>', description: 'Only events with issues: "warn" (warn and error) or "error"' },
+      { name: 'lines', value: '<n>', description: 'Recent events to show first (default: 10)' },
+      { name: 'json', description: 'One JSON event per line' },
+      { name: 'no-color', description: 'Plain output (also NO_COLOR)' },
+      INLINE_OPTION
+    ],
+    run: ({ options }) => tail(options)
+  },
+  {
+    name: 'wait',
+    args: '<event>',
+    summary: 'Wait until a matching event is captured (for UI tests)',
+    description: 'Wait until the proxy captures an event whose name matches <event> (a glob),\n' +
+      'print it and exit 0, or exit 1 when --timeout passes. Events already in the\n' +
+      'buffer count unless --new is given. For example:\n\n' +
+      '  loggy-proxy wait "Order Completed" --properties order_id=*,currency=USD --timeout 20s',
+    options: [
+      { name: 'source', value: '<id>', description: 'Only events from this source (ID or name)', complete: 'sources' },
+      { name: 'properties', value: '<path=value,...>', description: 'Properties the event must have (value * = any value)' },
+      { name: 'timeout', value: '<time>', description: 'Give up after this long (90, 30s, 5m; default 30s, at most 5m)' },
+      { name: 'new', description: 'Ignore events captured before this command' },
+      { name: 'json', description: 'Print the event as JSON' },
+      INLINE_OPTION
+    ],
+    run: ({ positionals: [eventName], options }) => waitForEvent(eventName, options)
+  },
+  {
+    name: 'pin',
+    args: '<event-id>',
+    summary: 'Pin a buffered event so /clear and buffer eviction keep it',
+    description: 'Copy an event from the running proxy\'s buffer into its pinned set, which\n' +
+      'survives POST /clear and the buffer rolling over (until the proxy stops).\n' +
+      'Event IDs are shown by "pinned", "tail --json" and GET /events.',
+    options: [{ name: 'note', value: '<text>', description: 'Why it is pinned (e.g. "repro for checkout bug")' }],
+    run: ({ positionals: [id], options }) => pin(id, options)
+  },
+  {
+    name: 'unpin',
+    args: '[event-id]',
+    summary: 'Unpin an event, or every event with --all',
+    options: [{ name: 'all', description: 'Unpin every event' }],
+    run: ({ positionals: [id], options }) => unpin(id, options)
+  },
+  {
+    name: 'pinned',
+    summary: 'List pinned events, newest pin first',
+    options: [JSON_OPTION],
+    run: ({ options }) => pinned(options)
+  },
+  {
+    name: 'severity',
+    summary: 'Count buffered events by severity (ok, warn, error)',
+    description: 'Count the running proxy\'s buffered events by severity, per source and per\n' +
+      'rule (the "severity" setting). Exits 1 when any event is an error, so CI can\n' +
+      'fail on tracking problems.',
+    options: [
+      { name: 'source', value: '<id>', description: 'Only events from this source', complete: 'sources' },
+      JSON_OPTION
+    ],
+    run: ({ options }) => severity(options)
+  },
+  {
+    name: 'ui',
+    summary: 'Browse captured events full-screen',
+    description: 'Browse events full-screen: list, search, source toggles, JSON detail and copy to\nclipboard (keys are shown at the bottom).',
+    options: [INLINE_OPTION],
+    run: ({ options }) => ui(options)
+  },
+  {
+    name: 'export',
+    args: '[file]',
+    summary: 'Export captured events as JSON, NDJSON or CSV',
+    description: 'Export captured events to a file, or stdout without one. The format follows the\nextension: .json, .jsonl/.ndjson or .csv.',
+    options: [
+      { name: 'format', value: '<json|ndjson|csv>', description: 'Override the format' },
+      { name: 'source', value: '<ids>', description: 'Only these sources (comma-separated IDs or names)', complete: 'sources' },
+      { name: 'name', value: '<name>', description: 'Only events whose name matches (glob; plain text matches anywhere)' },
+      { name: 'since', value: '<time>', description: 'From this time (ISO date, or relative: 30s, 15m, 2h, 7d)' },
+      { name: 'until', value: '<time>', description: 'Up to this time' },
+      { name: 'session', value: '<id>', description: 'One proxy run: an ID from --list-sessions, "latest" or "all" (default)' },
+      { name: 'from', value: '<proxy|store>', description: 'Running proxy\'s buffer or the file sink\'s files (default: proxy if running)' },
+      { name: 'list-sessions', description: 'List sessions instead of exporting' },
+      ...TRANSFORM_OPTIONS
+    ],
+    run: ({ positionals: [file], options }) => exportEvents(file, options)
+  },
+  {
+    name: 'start',
+    summary: 'Run the proxy in the foreground (Ctrl+C or SIGTERM stops it)',
+    description: 'Run the proxy in the foreground, logging to the terminal. Ctrl+C or SIGTERM\nstops it and SIGHUP reloads its settings. Unlike "npm run proxy", this takes\nthe global options, e.g. "loggy-proxy --profile ci --ports 9100 start".',
+    options: [
+      RECORD_FIXTURES_OPTION,
+      PROMISCUOUS_OPTION,
+      ENVIRONMENT_OPTION,
+      { name: 'cdp', description: 'Capture from Chrome over the DevTools Protocol (cdp.endpoint) instead of as a proxy' },
+      { name: 'pcap', value: '<interface>', description: 'Capture passively from network traffic on this interface (Linux, needs root)' },
+      { name: 'pcap-file', value: '<file>', description: 'Capture from this pcap file (tcpdump -w) instead' }
+    ],
+    run: ({ options }) => start(options)
+  },
+  {
+    name: 'capture',
+    args: '[command...]',
+    summary: 'Start a proxy, capture events to a file, then stop (for CI)',
+    description: 'Start a proxy of its own, capture events and write them out when --duration\n' +
+      'passes, when the command given after "--" exits, or on SIGINT/SIGTERM. The\n' +
+      'command runs with HTTP(S)_PROXY pointing at the proxy and NODE_EXTRA_CA_CERTS\n' +
+      'at its CA; capture exits 1 if the command fails. For example:\n\n' +
+      '  loggy-proxy capture --output events.jsonl -- npx playwright test\n' +
+      '  loggy-proxy capture --duration 60s --output events.jsonl',
+    options: [
+      { name: 'duration', value: '<time>', description: 'Stop after this long (90, 30s, 15m, 2h)' },
+      { name: 'output', value: '<file>', description: 'Write the events here (default: stdout)' },
+      { name: 'format', value: '<json|ndjson|csv>', description: 'Override the format (default: from --output, else JSON)' },
+      { name: 'source', value: '<ids>', description: 'Only these sources (comma-separated IDs or names)', complete: 'sources' },
+      { name: 'name', value: '<name>', description: 'Only events whose name matches (glob; plain text matches anywhere)' },
+      { name: 'expect', value: '<spec-file>', description: 'Check the events against this spec (see "assert"); exit 1 if unmet' },
+      ...TRANSFORM_OPTIONS,
+      RECORD_FIXTURES_OPTION,
+      PROMISCUOUS_OPTION,
+      ENVIRONMENT_OPTION,
+      ...REPORT_OPTIONS
+    ],
+    run: ({ positionals, options }) => capture(positionals, options)
+  },
+  {
+    name: 'assert',
+    args: '<spec-file> [events-file]',
+    summary: 'Check captured events against an expected-events spec',
+    description: 'Check events against a JSON spec of expected event names, properties, counts\n' +
+      'and order, and exit 1 with the differences if it is not met. Events come from\n' +
+      'a file written by export or capture ("-" for stdin), else as for export.\n' +
+      'The spec format is described in PROXY-MODE.md ("Asserting Events").',
+    options: [
+      { name: 'from', value: '<proxy|store>', description: 'Without a file: running proxy\'s buffer or the file sink\'s files' },
+      { name: 'session', value: '<id>', description: 'One proxy run: an ID from "export --list-sessions", "latest" or "all" (default)' },
+      { name: 'source', value: '<ids>', description: 'Only these sources (comma-separated IDs or names)', complete: 'sources' },
+      JSON_OPTION,
+      ...REPORT_OPTIONS
+    ],
+    run: ({ positionals: [specFile, file], options }) => assertEvents(specFile, file, options)
+  },
+  {
+    name: 'import',
+    args: '<events-file>',
+    summary: 'Add events from an export or capture file, labelled with an environment',
+    description: 'Add the events of a file written by export or capture ("-" for stdin) to the\n' +
+      'running proxy\'s buffer, labelled with --environment, to compare them with\n' +
+      'another environment. They are not sent to sinks. For example:\n\n' +
+      '  loggy-proxy import prod.jsonl --environment prod',
+    options: [
+      { name: 'environment', value: '<label>', description: 'Environment the events came from (required)' },
+      INLINE_OPTION
+    ],
+    run: ({ positionals: [file], options }) => importCapture(file, options)
+  },
+  {
+    name: 'flows import',
+    args: '<flow-file>',
+    summary: 'Parse the requests of a mitmproxy .flow file into events',
+    description: 'Send the HTTP requests of a mitmproxy flow file ("mitmdump -w", "-" for stdin)\n' +
+      'to the running proxy, which captures them as if it had intercepted them:\n' +
+      'matched to sources, parsed, buffered and sent to sinks.',
+    options: [INLINE_OPTION],
+    run: ({ positionals: [file], options }) => flowsImport(file, options)
+  },
+  {
+    name: 'flows export',
+    args: '<flow-file> [fixtures...]',
+    summary: 'Write recorded request fixtures as a mitmproxy .flow file',
+    description: 'Write requests recorded with --record-fixtures as a mitmproxy flow file, for\n' +
+      '"mitmproxy -r" or "mitmdump -r". Takes fixture files or directories (default:\n' +
+      'fixtures.dir). The flows have requests only; no responses were recorded.',
+    run: ({ positionals: [output, ...paths] }) => flowsExport(output, paths)
+  },
+  {
+    name: 'compare',
+    args: '<environment-a> <environment-b>',
+    summary: 'Compare event coverage and schemas between two environments',
+    description: 'Compare the buffered events of two environments (from --environment on start or\n' +
+      'capture, or import): event names only one fired, and properties only one sent\n' +
+      'or sent with another type. Exits 1 if they differ. For example:\n\n' +
+      '  loggy-proxy compare prod staging',
+    options: [JSON_OPTION, INLINE_OPTION],
+    run: ({ positionals: [a, b], options }) => compare(a, b, options)
+  },
+  {
+    name: 'replay',
+    args: '[fixtures...]',
+    summary: 'Parse recorded request fixtures again and report any change',
+    description: 'Run requests recorded with --record-fixtures (on start or capture) through\n' +
+      'source matching and the parser again, and exit 1 if any now match another\n' +
+      'source or parse to different events. Takes fixture files or directories\n' +
+      '(default: fixtures.dir). --update accepts the new results as expected.',
+    options: [
+      { name: 'update', description: 'Rewrite changed fixtures with the new results' },
+      JSON_OPTION
+    ],
+    run: ({ positionals, options }) => replay(positionals, options)
+  },
+  {
+    name: 'reprocess',
+    summary: 'Parse the buffered events\' requests again with the current sources',
+    description: 'Run the raw requests behind the running proxy\'s buffered events through the\n' +
+      'current sources and parser again, replacing their events (names, properties,\n' +
+      'batch splitting), e.g. after fixing a source\'s field mappings. Capture times\n' +
+      'are kept, and the new events are not sent to sinks.',
+    options: [
+      { name: 'source', value: '<id>', description: 'Only requests whose events came from this source', complete: 'sources' },
+      JSON_OPTION
+    ],
+    run: ({ options }) => reprocess(options)
+  },
+  {
+    name: 'bench',
+    args: '[fixtures...]',
+    summary: 'Benchmark the parser on sample payloads and recorded fixtures',
+    description: 'Time decompression, JSON parsing and event extraction for a built-in set of\n' +
+      'payloads (GA4, Segment batches, Heap, gzip and brotli bodies, deep fieldMappings)\n' +
+      'and the fixtures in fixtures.dir, or only the given fixture files or directories.\n' +
+      '--cpu-profile writes a V8 profile of the run for Chrome DevTools.',
+    options: [
+      { name: 'iterations', value: '<n>', description: 'Timed runs per payload (default: 1000)' },
+      { name: 'cpu-profile', value: '<file>', description: 'Write a .cpuprofile of the run' },
+      JSON_OPTION
+    ],
+    run: ({ positionals, options }) => bench(positionals, options)
+  },
+  {
+    name: 'generate',
+    summary: 'Send made-up analytics events through the proxy (demos, UI work, load tests)',
+    description: 'Fabricate realistic analytics traffic: simulated visitors browsing a shop,\n' +
+      'searching, adding to cart and ordering. By default the events are sent\n' +
+      'through the proxy as requests to each source\'s domain, which the proxy\n' +
+      'captures and answers itself, so they never reach the real endpoint. With\n' +
+      '--via api they are added to the buffer directly. Runs until --count,\n' +
+      '--duration or Ctrl+C. For example:\n\n' +
+      '  loggy-proxy generate --source reddit --rate 10/s\n' +
+      '  loggy-proxy generate --rate 2000/s --batch 20 --duration 1m',
+    options: [
+      { name: 'source', value: '<ids>', description: 'Sources to send as (comma-separated; default: every enabled source)', complete: 'sources' },
+      { name: 'rate', value: '<n/s|n/m|n/h>', description: 'Events per second, minute or hour (default: 1/s)' },
+      { name: 'batch', value: '<n>', description: 'Events per request (default: 1)' },
+      { name: 'count', value: '<n>', description: 'Stop after this many events' },
+      { name: 'duration', value: '<time>', description: 'Stop after this long (90, 30s, 15m, 2h)' },
+      { name: 'via', value: '<proxy|api>', description: 'Send requests through the proxy (default) or add events over its API', complete: ['proxy', 'api'] },
+      INLINE_OPTION
+    ],
+    run: ({ options }) => generate(options)
+  },
+  {
+    name: 'browser-args',
+    summary: 'Print the proxy, CA and launch options for Playwright or Puppeteer',
+    description: 'Print what a Playwright or Puppeteer browser needs to run through the proxy:\n' +
+      'the proxy server, whether the CA is trusted (if not, certificate errors are\n' +
+      'ignored) and the launch options. --user-data-dir creates an isolated profile;\n' +
+      'a Firefox profile gets the proxy set and the CA imported (needs certutil).\n' +
+      'For example:\n\n' +
+      '  loggy-proxy browser-args --output loggy-browser.json\n' +
+      '  loggy-proxy browser-args --browser firefox --user-data-dir .loggy-firefox',
+    options: [
+      { name: 'browser', value: '<chromium|firefox>', description: 'Browser engine (default: chromium)', complete: BROWSERS },
+      { name: 'user-data-dir', value: '<dir>', description: 'Create an isolated browser profile here' },
+      { name: 'output', value: '<file>', description: 'Write the settings as JSON to this file' },
+      JSON_OPTION
+    ],
+    run: ({ options }) => browserArgs(options)
+  },
+
+  {
+    name: 'sources list',
+    summary: 'List sources: built-in, extension and your own',
+    options: [JSON_OPTION],
+    run: ({ options }) => sourcesList(options)
+  },
+  {
+    name: 'sources add',
+    args: '<id>',
+    summary: 'Add a source',
+    description: 'Add a source (--domain is required). A running proxy picks it up right away.',
+    options: SOURCE_OPTIONS,
+    run: ({ positionals: [id], options }) => sourcesAdd(id, options)
+  },
+  {
+    name: 'sources edit',
+    args: '<id>',
+    completeArgs: ['sources'],
+    summary: 'Change a source',
+    options: [
+      ...SOURCE_OPTIONS,
+      { name: 'no-websocket', description: 'Stop capturing WebSocket frames' },
+      { name: 'enable', description: 'Enable the source' }
+    ],
+    run: ({ positionals: [id], options }) => sourcesEdit(id, options)
+  },
+  {
+    name: 'sources remove',
+    args: '<id>',
+    completeArgs: ['sources'],
+    summary: 'Remove a source (built-in ones can only be disabled)',
+    run: ({ positionals: [id] }) => sourcesRemove(id)
+  },
+  {
+    name: 'sources test',
+    args: '<url>',
+    summary: 'Show which source would capture a request to <url>',
+    description: 'Show which source would capture a request to <url>. Exits 1 if none does, or if\n--body gives no events.',
+    options: [
+      { name: 'body', value: '<file>', description: 'Also parse this JSON body ("-" for stdin) and show the events' },
+      JSON_OPTION,
+      { name: 'no-color', description: 'Plain output (also NO_COLOR)' }
+    ],
+    run: ({ positionals: [url], options }) => sourcesTest(url, options)
+  },
+  {
+    name: 'profile list',
+    summary: 'List named profiles and their ports',
+    options: [JSON_OPTION],
+    run: ({ options }) => profileList(options)
+  },
+  {
+    name: 'profile create',
+    args: '<name>',
+    summary: 'Create a profile (own ports, CA, settings and captures)',
+    options: [
+      { name: 'proxy-port', value: '<port>', description: 'Proxy port (default: next free pair after 8888/8889)' },
+      { name: 'api-port', value: '<port>', description: 'API port (default: proxy port + 1)' },
+      { name: 'shared-ca', description: 'Use the default CA instead of a CA of its own' }
+    ],
+    run: ({ positionals: [name], options }) => profileCreate(name, options)
+  },
+  {
+    name: 'instances',
+    summary: 'List proxy instances (default and profiles) and which are running',
+    options: [JSON_OPTION],
+    run: ({ options }) => instances(options)
+  },
+  {
+    name: 'api-key list',
+    summary: 'List API keys for clients beyond loopback, and their scopes',
+    options: [JSON_OPTION],
+    run: ({ options }) => apiKeyList(options)
+  },
+  {
+    name: 'api-key create',
+    args: '<name>',
+    summary: 'Create an API key and print it',
+    description: 'Create an API key for API clients on other machines (LAN mode) and print it.\nOnce any key exists, such clients must send one. Scopes:\n\n  read       Read events, stats and everything else under GET\n  clear      Clear the event buffer, anomalies and pinned domains\n  configure  Change sources, funnels and settings, and add events',
+    options: [
+      { name: 'scopes', value: '<scope,...>', description: `Any of ${API_SCOPES.join(', ')} (default: read)`, complete: API_SCOPES }
+    ],
+    run: ({ positionals: [name], options }) => apiKeyCreate(name, options)
+  },
+  {
+    name: 'api-key revoke',
+    args: '<name>',
+    summary: 'Delete an API key',
+    run: ({ positionals: [name] }) => apiKeyRevoke(name)
+  },
+  {
+    name: 'completion',
+    args: '<bash|zsh|fish>',
+    summary: 'Print a shell completion script',
+    description: 'Print a completion script for bash, zsh or fish. It completes commands,\noptions, source IDs, profiles and formats. For example:\n\n  loggy-proxy completion bash > ~/.local/share/bash-completion/completions/loggy-proxy\n  loggy-proxy completion zsh > "${fpath[1]}/_loggy-proxy"\n  loggy-proxy completion fish > ~/.config/fish/completions/loggy-proxy.fish',
+    run: ({ command, positionals: [shell] }) => {
+      if (!SHELLS.includes(shell)) {
+        throw new UsageError(`Unknown shell "${shell}" (expected ${SHELLS.join(', ')})`, command);
+      }
+      console.log(completionScript(shell, 'loggy-proxy'));
+      return EXIT.OK;
+    }
+  }
+];
+
+// Looked up while completing ("complete" / "completeArgs" in COMMANDS)
+const COMPLETION_LOOKUPS = {
+  sources: () => loadSources(loadProxySettings(null, { quiet: true })).getAllSources()
+    .map(source => ({ value: source.id, description: source.name })),
+  profiles: () => profiles.listProfiles().map(profile => ({ value: profile.name, description: `ports ${profile.proxyPort}/${profile.apiPort}` }))
+};
+
+async function main(args) {
+  // Called by the completion scripts (see completion.js); not a listed command
+  if (args[0] === '__complete') {
+    const candidates = complete(args.slice(1), COMMANDS, GLOBAL_OPTIONS, COMPLETION_LOOKUPS);
+    console.log(formatCandidates(candidates));
+    return EXIT.OK;
+  }
+
+  const parsed = parseCommandLine(args, COMMANDS, GLOBAL_OPTIONS);
+  if (parsed.help) {
+    console.log(formatHelp({ program: 'loggy-proxy', commands: COMMANDS, globalOptions: GLOBAL_OPTIONS, ...parsed }));
+    return EXIT.OK;
+  }
+  return parsed.command.run(parsed);
+}
+
+main(process.argv.slice(2))
+  .then(code => process.exit(code))
+  .catch(err => {
+    if (err instanceof UsageError) {
+      const help = err.command ? `loggy-proxy ${err.command.name} --help` : 'loggy-proxy --help';
+      console.error(`Error: ${err.message}\nRun "${help}" for usage.`);
+      process.exit(EXIT.USAGE);
+    }
+    console.error('Error:', err.message);
+    process.exit(EXIT.FAILURE);
+  });
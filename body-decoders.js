@@ -0,0 +1,318 @@
+// Binary request body decoders (MessagePack, Protocol Buffers)
+// Shared by the extension and the proxy through AnalyticsParser.decodeBody.
+// Both turn a body into plain objects the generic parser can walk; neither
+// needs a schema, so protobuf fields are keyed by field number.
+
+const MAX_DEPTH = 32;
+
+function toBase64(bytes) {
+  let binary = '';
+  for (let i = 0; i < bytes.length; i++) binary += String.fromCharCode(bytes[i]);
+  return btoa(binary);
+}
+
+// 64-bit integers as numbers when they fit, else as decimal strings
+function fromBigInt(value) {
+  return value >= BigInt(Number.MIN_SAFE_INTEGER) && value <= BigInt(Number.MAX_SAFE_INTEGER)
+    ? Number(value)
+    : value.toString();
+}
+
+/**
+ * Decode a MessagePack body (one value; throws on malformed or trailing bytes)
+ * Binary values become base64 strings and extension types { type, data }.
+ * @param {Uint8Array} bytes
+ * @returns {*}
+ */
+export function decodeMsgpack(bytes) {
+  const view = new DataView(bytes.buffer, bytes.byteOffset, bytes.byteLength);
+  const text = new TextDecoder('utf-8');
+  let offset = 0;
+
+  const take = length => {
+    if (offset + length > bytes.length) throw new Error('MessagePack body ends early');
+    const start = offset;
+    offset += length;
+    return start;
+  };
+  const str = length => text.decode(bytes.subarray(take(length), offset));
+  const bin = length => toBase64(bytes.subarray(take(length), offset));
+  const array = (length, depth) => Array.from({ length }, () => value(depth + 1));
+  const map = (length, depth) => {
+    const result = {};
+    for (let i = 0; i < length; i++) {
+      const key = value(depth + 1);
+      result[String(key)] = value(depth + 1);
+    }
+    return result;
+  };
+  const ext = length => {
+    const type = view.getInt8(take(1));
+    return { type, data: bin(length) };
+  };
+
+  function value(depth = 0) {
+    if (depth > MAX_DEPTH) throw new Error('MessagePack body is nested too deeply');
+    const byte = view.getUint8(take(1));
+
+    if (byte <= 0x7f) return byte;
+    if (byte >= 0xe0) return byte - 0x100;
+    if ((byte & 0xf0) === 0x80) return map(byte & 0x0f, depth);
+    if ((byte & 0xf0) === 0x90) return array(byte & 0x0f, depth);
+    if ((byte & 0xe0) === 0xa0) return str(byte & 0x1f);
+
+    switch (byte) {
+      case 0xc0: return null;
+      case 0xc2: return false;
+      case 0xc3: return true;
+      case 0xc4: return bin(view.getUint8(take(1)));
+      case 0xc5: return bin(view.getUint16(take(2)));
+      case 0xc6: return bin(view.getUint32(take(4)));
+      case 0xc7: return ext(view.getUint8(take(1)));
+      case 0xc8: return ext(view.getUint16(take(2)));
+      case 0xc9: return ext(view.getUint32(take(4)));
+      case 0xca: return view.getFloat32(take(4));
+      case 0xcb: return view.getFloat64(take(8));
+      case 0xcc: return view.getUint8(take(1));
+      case 0xcd: return view.getUint16(take(2));
+      case 0xce: return view.getUint32(take(4));
+      case 0xcf: return fromBigInt(view.getBigUint64(take(8)));
+      case 0xd0: return view.getInt8(take(1));
+      case 0xd1: return view.getInt16(take(2));
+      case 0xd2: return view.getInt32(take(4));
+      case 0xd3: return fromBigInt(view.getBigInt64(take(8)));
+      case 0xd4: return ext(1);
+      case 0xd5: return ext(2);
+      case 0xd6: return ext(4);
+      case 0xd7: return ext(8);
+      case 0xd8: return ext(16);
+      case 0xd9: return str(view.getUint8(take(1)));
+      case 0xda: return str(view.getUint16(take(2)));
+      case 0xdb: return str(view.getUint32(take(4)));
+      case 0xdc: return array(view.getUint16(take(2)), depth);
+      case 0xdd: return array(view.getUint32(take(4)), depth);
+      case 0xde: return map(view.getUint16(take(2)), depth);
+      case 0xdf: return map(view.getUint32(take(4)), depth);
+      default: throw new Error(`Unknown MessagePack type 0x${byte.toString(16)}`);
+    }
+  }
+
+  const result = value();
+  if (offset !== bytes.length) throw new Error('Trailing bytes after MessagePack value');
+  return result;
+}
+
+/**
+ * The fields of a Protocol Buffers message in order, undecoded, for reading
+ * a message whose schema is known (throws if the body is not a valid message)
+ * @param {Uint8Array} bytes
+ * @returns {Array<object>} - { field, wireType, value }: a BigInt for varint
+ *   and fixed64 fields, a number for fixed32, the bytes for length-delimited
+ */
+export function protobufFields(bytes) {
+  const view = new DataView(bytes.buffer, bytes.byteOffset, bytes.byteLength);
+  const fields = [];
+  let offset = 0;
+
+  const varint = () => {
+    let result = 0n;
+    for (let shift = 0n; shift < 70n; shift += 7n) {
+      if (offset >= bytes.length) throw new Error('Protobuf message ends inside a varint');
+      const byte = bytes[offset++];
+      result |= BigInt(byte & 0x7f) << shift;
+      if ((byte & 0x80) === 0) return result;
+    }
+    throw new Error('Protobuf varint is too long');
+  };
+  const take = length => {
+    if (length < 0 || offset + length > bytes.length) throw new Error('Protobuf message ends inside a field');
+    const start = offset;
+    offset += length;
+    return start;
+  };
+
+  while (offset < bytes.length) {
+    const key = varint();
+    const field = Number(key >> 3n);
+    const wireType = Number(key & 7n);
+    if (field === 0) throw new Error('Protobuf field number 0');
+
+    let value;
+    if (wireType === 0) {
+      value = varint();
+    } else if (wireType === 1) {
+      value = view.getBigUint64(take(8), true);
+    } else if (wireType === 2) {
+      const length = Number(varint());
+      const start = take(length);
+      value = bytes.subarray(start, start + length);
+    } else if (wireType === 5) {
+      value = view.getUint32(take(4), true);
+    } else {
+      throw new Error(`Unsupported protobuf wire type ${wireType}`);
+    }
+    fields.push({ field, wireType, value });
+  }
+  return fields;
+}
+
+/**
+ * Decode a Protocol Buffers message without its schema (throws if the body
+ * is not a valid message)
+ * Fields are keyed by number ("1", "2", ...) and repeat as arrays.
+ * Length-delimited fields are decoded as text when they are printable UTF-8,
+ * else as a nested message when they parse as one, else as base64. Varints
+ * are unsigned; fixed32/fixed64 fields are read as unsigned integers.
+ * @param {Uint8Array} bytes
+ * @returns {object}
+ */
+export function decodeProtobuf(bytes, depth = 0) {
+  if (depth > MAX_DEPTH) throw new Error('Protobuf message is nested too deeply');
+  const message = {};
+
+  for (const { field, wireType, value: raw } of protobufFields(bytes)) {
+    const value = wireType === 2 ? decodeLengthDelimited(raw, depth) : typeof raw === 'bigint' ? fromBigInt(raw) : raw;
+    const name = String(field);
+    if (!(name in message)) {
+      message[name] = value;
+    } else if (Array.isArray(message[name])) {
+      message[name].push(value);
+    } else {
+      message[name] = [message[name], value];
+    }
+  }
+  return message;
+}
+
+function decodeLengthDelimited(bytes, depth) {
+  // Printable text first: a short string often also parses as a message
+  try {
+    const text = new TextDecoder('utf-8', { fatal: true }).decode(bytes);
+    if (!/[\x00-\x08\x0b\x0c\x0e-\x1f]/.test(text)) return text;
+  } catch {
+    // Not UTF-8
+  }
+  try {
+    return decodeProtobuf(bytes, depth + 1);
+  } catch {
+    return toBase64(bytes);
+  }
+}
+
+// Google App Measurement (Firebase Analytics) abbreviates automatically
+// collected events and parameters; these are their documented names
+const APP_MEASUREMENT_EVENTS = {
+  _ab: 'app_background',
+  _ae: 'app_exception',
+  _au: 'app_update',
+  _cd: 'app_clear_data',
+  _e: 'user_engagement',
+  _err: 'error',
+  _f: 'first_open',
+  _iap: 'in_app_purchase',
+  _in: 'app_install',
+  _nd: 'notification_dismiss',
+  _nf: 'notification_foreground',
+  _no: 'notification_open',
+  _nr: 'notification_receive',
+  _ou: 'os_update',
+  _s: 'session_start',
+  _ug: 'app_upgrade',
+  _ui: 'app_remove',
+  _v: 'first_visit',
+  _vs: 'screen_view'
+};
+const APP_MEASUREMENT_PARAMS = {
+  _et: 'engagement_time_msec',
+  _o: 'firebase_event_origin',
+  _sc: 'firebase_screen_class',
+  _si: 'firebase_screen_id',
+  _sn: 'firebase_screen'
+};
+const GMP_APP_ID = /^\d+:\d+:(android|ios|web):[0-9a-f]+$/;
+
+const utf8 = bytes => new TextDecoder('utf-8').decode(bytes);
+
+// A param or user property value: string, int64 (signed), float or double
+function measurementValue(fields) {
+  const view = new DataView(new ArrayBuffer(8));
+  for (const { field, value } of fields) {
+    if (field === 2 && value instanceof Uint8Array) return utf8(value);
+    if (field === 3 && typeof value === 'bigint') return fromBigInt(BigInt.asIntN(64, value));
+    if (field === 4 && typeof value === 'number') {
+      view.setUint32(0, value, true);
+      return view.getFloat32(0, true);
+    }
+    if (field === 5 && typeof value === 'bigint') {
+      view.setBigUint64(0, value, true);
+      return view.getFloat64(0, true);
+    }
+  }
+  return null;
+}
+
+// Event params: { name: value }. A param with nested params (items) holds
+// one unnamed param per item, whose own nested params are the item's fields
+function measurementParams(paramMessages) {
+  const params = {};
+  for (const bytes of paramMessages) {
+    const fields = protobufFields(bytes);
+    const nameField = fields.find(({ field }) => field === 1);
+    if (!nameField) continue;
+    const name = utf8(nameField.value);
+    const nested = fields.filter(({ field }) => field === 6).map(({ value }) => value);
+    params[APP_MEASUREMENT_PARAMS[name] || name] = nested.length > 0
+      ? nested.map(item => measurementParams(protobufFields(item).filter(({ field }) => field === 6).map(({ value }) => value)))
+      : measurementValue(fields);
+  }
+  return params;
+}
+
+/**
+ * Decode a Google App Measurement upload (app-measurement.com/a), the batch
+ * the Firebase Analytics SDKs send: bundles of events, each event with its
+ * params, plus the bundle's user properties
+ * Abbreviated automatic events (_s, _vs, ...) get their documented names.
+ * @param {Uint8Array} bytes
+ * @returns {object} - { events: [{ name, params, timestamp, context }] }
+ */
+export function decodeAppMeasurement(bytes) {
+  const numbered = (fields, number) => fields.filter(({ field }) => field === number);
+  const events = [];
+
+  for (const { value } of numbered(protobufFields(bytes), 1)) {
+    if (!(value instanceof Uint8Array)) continue;
+    const bundle = protobufFields(value);
+
+    const context = {};
+    for (const { field, value: metadata } of bundle) {
+      // Bundle metadata is recognised by its shape rather than field number
+      if (field === 2 || field === 3 || !(metadata instanceof Uint8Array)) continue;
+      const text = utf8(metadata);
+      if (text === 'android' || text === 'ios') context.platform = text;
+      else if (GMP_APP_ID.test(text)) context.gmpAppId = text;
+    }
+    const userProperties = {};
+    for (const { value: attribute } of numbered(bundle, 3)) {
+      const fields = protobufFields(attribute);
+      const [name] = numbered(fields, 2);
+      // A user property's string value is field 3, where a param's is 2
+      if (name) userProperties[utf8(name.value)] = measurementValue(fields.filter(({ field }) => field !== 2).map(entry => ({ ...entry, field: entry.field - 1 })));
+    }
+    if (Object.keys(userProperties).length > 0) context.userProperties = userProperties;
+
+    for (const { value: eventBytes } of numbered(bundle, 2)) {
+      const event = protobufFields(eventBytes);
+      const [name] = numbered(event, 2);
+      const [timestamp] = numbered(event, 3);
+      const rawName = name ? utf8(name.value) : 'unknown';
+      events.push({
+        name: APP_MEASUREMENT_EVENTS[rawName] || rawName,
+        params: measurementParams(numbered(event, 1).map(({ value: param }) => param)),
+        timestamp: timestamp ? fromBigInt(timestamp.value) : null,
+        context: { ...context }
+      });
+    }
+  }
+  return { events };
+}
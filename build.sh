@@ -6,6 +6,16 @@ set -e
 
 echo "Building Loggy for distribution..."
 
+# self-update refuses releases not signed with the key config/release-key.pem pins
+if [ -z "$LOGGY_SIGNING_KEY" ]; then
+    echo "LOGGY_SIGNING_KEY must name the release signing key (Ed25519, PEM)" >&2
+    exit 1
+fi
+if [ "$(openssl pkey -in "$LOGGY_SIGNING_KEY" -pubout)" != "$(cat config/release-key.pem)" ]; then
+    echo "$LOGGY_SIGNING_KEY is not the key config/release-key.pem pins" >&2
+    exit 1
+fi
+
 # Install dependencies
 echo "Installing dependencies..."
 npm install --production
@@ -19,24 +29,44 @@ echo "Copying files..."
 cp manifest.json dist/
 cp background.js dist/
 cp parsers.js dist/
+cp body-decoders.js dist/
 cp storage.js dist/
+cp package.json dist/
+cp proxy-server-mitm.js dist/
 cp setup.js dist/
 cp SETUP-INSTRUCTIONS.html dist/
 cp -r panel dist/
 cp -r config dist/
 cp -r native-host dist/
+cp -r proxy dist/
+cp -r bin dist/
 cp -r icons dist/ 2>/dev/null || true
 cp -r node_modules dist/
 
+# Record build metadata for the native host's getVersion action and `loggy-proxy version`
+cat > dist/build-info.json <<EOF
+{
+  "version": "$(node -p "require('./package.json').version")",
+  "commit": "$(git rev-parse --short HEAD 2>/dev/null || echo unknown)",
+  "buildDate": "$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+}
+EOF
+
+
 # Create zip for Chrome Web Store
 echo "Creating zip..."
 cd dist
 zip -r ../loggy-extension.zip . -x "*.DS_Store"
 cd ..
 
+# Checksums and an Ed25519 signature of them for `loggy-proxy self-update`
+shasum -a 256 loggy-extension.zip > SHA256SUMS
+openssl pkeyutl -sign -inkey "$LOGGY_SIGNING_KEY" -rawin -in SHA256SUMS -out SHA256SUMS.sig
+
 echo ""
 echo "Build complete!"
 echo "  - dist/ folder contains the unpacked extension"
 echo "  - loggy-extension.zip is ready for Chrome Web Store upload"
+echo "  - Attach loggy-extension.zip, SHA256SUMS and SHA256SUMS.sig to the GitHub release"
 echo ""
 ls -lh loggy-extension.zip
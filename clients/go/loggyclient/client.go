@@ -0,0 +1,248 @@
+// Package loggyclient controls the Loggy analytics proxy from Go tests.
+//
+// It starts and stops the proxy through the loggy-proxy command, reads and
+// clears captured events, waits for an event to arrive and manages sources,
+// all over the proxy's local API (see PROXY-MODE.md, "Go Client"):
+//
+//	proxy, err := loggyclient.Start(ctx, loggyclient.StartOptions{Profile: "e2e", ProxyPort: 9100})
+//	if err != nil {
+//		t.Fatal(err)
+//	}
+//	defer proxy.Stop()
+//
+//	// Point the browser at proxy.URL(), run the test, then:
+//	event, err := proxy.Client.WaitForEvent(ctx, 10*time.Second, loggyclient.NameMatches("Checkout*"))
+//
+// Like the loggy-proxy CLI, the client tries the profile's local socket
+// first (when SocketPath is set) and falls back to the TCP API port.
+package loggyclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// DefaultAPIPort is the API port of the default profile (settings.apiPort).
+const DefaultAPIPort = 8889
+
+// Options configures a Client.
+type Options struct {
+	// APIPort is the proxy's TCP API port (default DefaultAPIPort).
+	APIPort int
+	// SocketPath is the proxy's local API socket, tried before the TCP port.
+	// Empty means TCP only.
+	SocketPath string
+	// Timeout limits each request (default 5s).
+	Timeout time.Duration
+}
+
+// Client talks to a running proxy's API.
+type Client struct {
+	apiPort int
+	tcp     *http.Client
+	socket  *http.Client
+}
+
+// New returns a Client for the proxy answering on opts.APIPort (or
+// opts.SocketPath). It does not contact the proxy.
+func New(opts Options) *Client {
+	if opts.APIPort == 0 {
+		opts.APIPort = DefaultAPIPort
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 5 * time.Second
+	}
+
+	c := &Client{
+		apiPort: opts.APIPort,
+		tcp:     &http.Client{Timeout: opts.Timeout},
+	}
+	if opts.SocketPath != "" {
+		socketPath := opts.SocketPath
+		c.socket = &http.Client{
+			Timeout: opts.Timeout,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", socketPath)
+				},
+			},
+		}
+	}
+	return c
+}
+
+// APIError is a non-2xx answer from the proxy's API.
+type APIError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("loggy: %s %s: HTTP %d: %s", e.Method, e.Path, e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("loggy: %s %s: HTTP %d", e.Method, e.Path, e.StatusCode)
+}
+
+// do sends a request and decodes the JSON answer into out (if not nil).
+// A 503 from /healthz is a valid answer (a degraded proxy), so callers that
+// accept it pass it in okStatus.
+func (c *Client) do(ctx context.Context, method, path string, body any, out any, okStatus ...int) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+
+	send := func(client *http.Client, host string) (*http.Response, error) {
+		var reader io.Reader
+		if payload != nil {
+			reader = bytes.NewReader(payload)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, "http://"+host+path, reader)
+		if err != nil {
+			return nil, err
+		}
+		if payload != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		return client.Do(req)
+	}
+
+	var resp *http.Response
+	var err error
+	if c.socket != nil {
+		resp, err = send(c.socket, "loggy")
+	}
+	if c.socket == nil || err != nil {
+		resp, err = send(c.tcp, fmt.Sprintf("127.0.0.1:%d", c.apiPort))
+	}
+	if err != nil {
+		return fmt.Errorf("loggy: %s %s: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("loggy: %s %s: %w", method, path, err)
+	}
+
+	ok := resp.StatusCode >= 200 && resp.StatusCode < 300
+	for _, status := range okStatus {
+		ok = ok || resp.StatusCode == status
+	}
+	if !ok {
+		var answer struct {
+			Error string `json:"error"`
+		}
+		_ = json.Unmarshal(data, &answer)
+		return &APIError{Method: method, Path: path, StatusCode: resp.StatusCode, Message: answer.Error}
+	}
+
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("loggy: %s %s: invalid response: %w", method, path, err)
+	}
+	return nil
+}
+
+// Health is the answer of GET /healthz.
+type Health struct {
+	// Status is "ok" or "degraded".
+	Status string `json:"status"`
+	// Problems lists why the proxy is degraded (CA_NOT_TRUSTED, CA_EXPIRED).
+	Problems      []string `json:"problems"`
+	PID           int      `json:"pid"`
+	UptimeSeconds int      `json:"uptimeSeconds"`
+	Events        int      `json:"events"`
+}
+
+// Health reports whether the proxy is up and its CA trusted. A degraded
+// proxy is not an error; check Health.Status.
+func (c *Client) Health(ctx context.Context) (*Health, error) {
+	var health Health
+	if err := c.do(ctx, http.MethodGet, "/healthz", nil, &health, http.StatusServiceUnavailable); err != nil {
+		return nil, err
+	}
+	return &health, nil
+}
+
+// Reachable reports whether a proxy answers on the socket or port.
+func (c *Client) Reachable(ctx context.Context) bool {
+	_, err := c.Health(ctx)
+	return err == nil
+}
+
+// Status is the answer of GET /status.
+type Status struct {
+	PID     int `json:"pid"`
+	Version struct {
+		Version   string `json:"version"`
+		Commit    string `json:"commit"`
+		BuildDate string `json:"buildDate"`
+		// Build is "release" or "source"
+		Build string `json:"build"`
+	} `json:"version"`
+	Profile       string `json:"profile"`
+	Session       string `json:"session"`
+	StartedAt     string `json:"startedAt"`
+	UptimeSeconds int    `json:"uptimeSeconds"`
+	ProxyPort     int    `json:"proxyPort"`
+	APIPort       int    `json:"apiPort"`
+	APISocket     string `json:"apiSocket"`
+	Buffer        struct {
+		Events    int `json:"events"`
+		MaxEvents int `json:"maxEvents"`
+	} `json:"buffer"`
+	// CapturedTotal counts every event since startup, including ones that
+	// have left the buffer.
+	CapturedTotal int `json:"capturedTotal"`
+	Sources       []struct {
+		ID     string `json:"id"`
+		Name   string `json:"name"`
+		Events int    `json:"events"`
+	} `json:"sources"`
+	LastError *struct {
+		Message string `json:"message"`
+		At      string `json:"at"`
+	} `json:"lastError"`
+}
+
+// Status returns the proxy's ports, version, buffer and per-source counts.
+func (c *Client) Status(ctx context.Context) (*Status, error) {
+	var status Status
+	if err := c.do(ctx, http.MethodGet, "/status", nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// WaitUntilReachable polls the proxy until it answers or ctx is done.
+func (c *Client) WaitUntilReachable(ctx context.Context) error {
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		if c.Reachable(ctx) {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return errors.New("loggy: proxy not reachable: " + ctx.Err().Error())
+		case <-ticker.C:
+		}
+	}
+}
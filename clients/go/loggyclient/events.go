@@ -0,0 +1,306 @@
+package loggyclient
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Event is a captured analytics event, as the proxy stores it. Sequence
+// numbers events in capture order within a proxy run; a gap between two
+// events means the ones in between were dropped, rotated out or replaced
+// by reprocessing. RequestID is shared by all events parsed from one
+// intercepted request (empty for events added through the API). Environment is the proxy's environment
+// label, or the one the events were imported under (empty when unset).
+// Imported events get a new ID; ImportedID is the one they had.
+// Reprocessed marks events that replaced others when their request was
+// parsed again (Client.Reprocess). Severity is "ok", "warn" or "error", from
+// the proxy's severity rules, and Issues says what the rules found.
+type Event struct {
+	ID          string         `json:"id"`
+	Timestamp   string         `json:"timestamp"`
+	Event       string         `json:"event"`
+	Type        string         `json:"type"`
+	Properties  map[string]any `json:"properties"`
+	Context     map[string]any `json:"context"`
+	UserID      any            `json:"userId"`
+	AnonymousID any            `json:"anonymousId"`
+	Source      string         `json:"_source"`
+	SourceName  string         `json:"_sourceName"`
+	Sequence    int64          `json:"_sequence"`
+	RequestID   string         `json:"_requestId"`
+	Environment string         `json:"_environment"`
+	ImportedID  string         `json:"_importedId"`
+	Reprocessed bool           `json:"_reprocessed"`
+	Severity    string         `json:"_severity"`
+	Issues      []Issue        `json:"_issues"`
+	Metadata    struct {
+		URL        string `json:"url"`
+		Referer    string `json:"referer"`
+		Origin     string `json:"origin"`
+		CapturedAt string `json:"capturedAt"`
+		Session    string `json:"session"`
+	} `json:"_metadata"`
+}
+
+// Issue is something a severity rule found wrong with an event: a missing
+// required property, a name outside the tracking plan, or PII (by path, not
+// value).
+type Issue struct {
+	Rule    string `json:"rule"`
+	Level   string `json:"level"`
+	Message string `json:"message"`
+}
+
+// SeverityCounts is the answer of GET /severity: buffered events per
+// severity, overall, per source and per rule.
+type SeverityCounts struct {
+	Count   int `json:"count"`
+	OK      int `json:"ok"`
+	Warn    int `json:"warn"`
+	Error   int `json:"error"`
+	Sources []struct {
+		ID    string `json:"id"`
+		Name  string `json:"name"`
+		OK    int    `json:"ok"`
+		Warn  int    `json:"warn"`
+		Error int    `json:"error"`
+	} `json:"sources"`
+	Rules []struct {
+		Rule   string `json:"rule"`
+		Level  string `json:"level"`
+		Events int    `json:"events"`
+		Issues int    `json:"issues"`
+	} `json:"rules"`
+	// RuleCount is the number of severity rules the proxy applies.
+	RuleCount int `json:"ruleCount"`
+}
+
+// Severity counts the buffered events by severity; sourceID limits it to
+// one source's events (empty for all).
+func (c *Client) Severity(ctx context.Context, sourceID string) (*SeverityCounts, error) {
+	path := "/severity"
+	if sourceID != "" {
+		path += "?source=" + url.QueryEscape(sourceID)
+	}
+	var counts SeverityCounts
+	if err := c.do(ctx, http.MethodGet, path, nil, &counts); err != nil {
+		return nil, err
+	}
+	return &counts, nil
+}
+
+// Property returns a property by dotted path ("cart.items.0.sku"), and
+// whether it is present.
+func (e Event) Property(path string) (any, bool) {
+	var value any = e.Properties
+	for _, key := range strings.Split(path, ".") {
+		switch container := value.(type) {
+		case map[string]any:
+			var ok bool
+			if value, ok = container[key]; !ok {
+				return nil, false
+			}
+		case []any:
+			var index int
+			if _, err := fmt.Sscanf(key, "%d", &index); err != nil || index < 0 || index >= len(container) {
+				return nil, false
+			}
+			value = container[index]
+		default:
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// Events returns every event in the proxy's buffer, newest first.
+func (c *Client) Events(ctx context.Context) ([]Event, error) {
+	var answer struct {
+		Events []Event `json:"events"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/events", nil, &answer); err != nil {
+		return nil, err
+	}
+	return answer.Events, nil
+}
+
+// RecentEvents returns up to limit of the newest events, newest first.
+func (c *Client) RecentEvents(ctx context.Context, limit int) ([]Event, error) {
+	var answer struct {
+		Events []Event `json:"events"`
+	}
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/events?limit=%d", limit), nil, &answer); err != nil {
+		return nil, err
+	}
+	return answer.Events, nil
+}
+
+// Clear empties the proxy's event buffer, e.g. between tests.
+func (c *Client) Clear(ctx context.Context) error {
+	return c.do(ctx, http.MethodPost, "/clear", nil, nil)
+}
+
+// PinnedEvent is an event in the proxy's pinned set, with when and why it
+// was pinned.
+type PinnedEvent struct {
+	Event
+	Pinned struct {
+		At   string `json:"at"`
+		Note string `json:"note"`
+	} `json:"_pinned"`
+}
+
+// Pin copies a buffered event into the proxy's pinned set, which survives
+// Clear and the buffer rolling over. The note may be empty.
+func (c *Client) Pin(ctx context.Context, id, note string) error {
+	body := map[string]string{}
+	if note != "" {
+		body["note"] = note
+	}
+	return c.do(ctx, http.MethodPost, "/events/"+url.PathEscape(id)+"/pin", body, nil)
+}
+
+// Unpin removes an event from the pinned set.
+func (c *Client) Unpin(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/events/"+url.PathEscape(id)+"/pin", nil, nil)
+}
+
+// PinnedEvents returns the pinned events, newest pin first.
+func (c *Client) PinnedEvents(ctx context.Context) ([]PinnedEvent, error) {
+	var answer struct {
+		Events []PinnedEvent `json:"events"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/events/pinned", nil, &answer); err != nil {
+		return nil, err
+	}
+	return answer.Events, nil
+}
+
+// Events fetched per poll by Stream; more arriving between two polls are
+// skipped (as with "loggy-proxy tail")
+const streamPageSize = 200
+
+// StreamOptions configures Stream.
+type StreamOptions struct {
+	// Interval between polls (default 250ms).
+	Interval time.Duration
+	// Backlog also delivers the events already in the buffer, oldest first.
+	Backlog bool
+}
+
+// Stream calls fn with each new event, oldest first, until ctx is done or
+// fn returns an error (which Stream returns). Polling errors are retried.
+func (c *Client) Stream(ctx context.Context, opts StreamOptions, fn func(Event) error) error {
+	if opts.Interval == 0 {
+		opts.Interval = 250 * time.Millisecond
+	}
+
+	lastID := ""
+	first := true
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		events, err := c.RecentEvents(ctx, streamPageSize)
+		if err == nil {
+			// Newest first: everything before the last delivered event is new
+			// (all of it if that event was cleared or rotated out)
+			fresh := events
+			for i, event := range events {
+				if lastID != "" && event.ID == lastID {
+					fresh = events[:i]
+					break
+				}
+			}
+			if first && !opts.Backlog {
+				fresh = nil
+			}
+			first = false
+			if len(events) > 0 {
+				lastID = events[0].ID
+			}
+			for i := len(fresh) - 1; i >= 0; i-- {
+				if err := fn(fresh[i]); err != nil {
+					return err
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Matcher selects events for WaitForEvent.
+type Matcher func(Event) bool
+
+// NameMatches matches events whose name matches a glob ("Checkout*"; case
+// insensitive, as in alert rules and expectation specs).
+func NameMatches(pattern string) Matcher {
+	escaped := strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*")
+	re := regexp.MustCompile("(?i)^" + escaped + "$")
+	return func(e Event) bool { return re.MatchString(e.Event) }
+}
+
+// FromSource matches events captured by a source, by ID or name.
+func FromSource(source string) Matcher {
+	return func(e Event) bool {
+		return strings.EqualFold(e.Source, source) || strings.EqualFold(e.SourceName, source)
+	}
+}
+
+// HasProperty matches events with a property at path; with a value, it must
+// also equal it (compared as text, like alert rules).
+func HasProperty(path string, value ...any) Matcher {
+	return func(e Event) bool {
+		got, ok := e.Property(path)
+		if !ok {
+			return false
+		}
+		return len(value) == 0 || fmt.Sprint(got) == fmt.Sprint(value[0])
+	}
+}
+
+// All matches events that every matcher matches.
+func All(matchers ...Matcher) Matcher {
+	return func(e Event) bool {
+		for _, match := range matchers {
+			if !match(e) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// WaitForEvent returns the first event matching match, looking at the
+// buffer first and then at new events, or an error after timeout.
+func (c *Client) WaitForEvent(ctx context.Context, timeout time.Duration, match Matcher) (*Event, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var found *Event
+	errFound := fmt.Errorf("found")
+	err := c.Stream(ctx, StreamOptions{Backlog: true}, func(e Event) error {
+		if match(e) {
+			found = &e
+			return errFound
+		}
+		return nil
+	})
+	if found != nil {
+		return found, nil
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("loggy: no matching event within %s", timeout)
+	}
+	return nil, err
+}
@@ -0,0 +1,3 @@
+module github.com/jnakagawa/loggy/clients/go/loggyclient
+
+go 1.21
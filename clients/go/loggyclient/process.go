@@ -0,0 +1,213 @@
+package loggyclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// StartOptions configures Start.
+type StartOptions struct {
+	// Binary is the loggy-proxy command (default "loggy-proxy" on PATH).
+	Binary string
+	// Profile runs the proxy under a named profile ("loggy-proxy profile
+	// create"), with its own settings, CA and captures.
+	Profile string
+	// ProxyPort and APIPort override the settings' ports; APIPort defaults
+	// to ProxyPort+1 when only ProxyPort is set.
+	ProxyPort int
+	APIPort   int
+	// LogLevel is "error", "warn", "info" or "debug".
+	LogLevel string
+	// Env is added to the current environment (e.g. LOGGY_CA_PASSPHRASE=...).
+	Env []string
+	// Stdout and Stderr receive the proxy's log (default: discarded).
+	Stdout io.Writer
+	Stderr io.Writer
+	// StartTimeout limits the wait for the API to answer (default 15s).
+	StartTimeout time.Duration
+}
+
+// Proxy is a proxy started by Start.
+type Proxy struct {
+	// Client talks to this proxy's API.
+	Client *Client
+
+	opts      StartOptions
+	cmd       *exec.Cmd
+	exited    chan struct{}
+	proxyPort int
+}
+
+// globalArgs are the loggy-proxy global options for these settings.
+func (o StartOptions) globalArgs() []string {
+	var args []string
+	if o.Profile != "" {
+		args = append(args, "--profile", o.Profile)
+	}
+	if o.ProxyPort != 0 {
+		ports := strconv.Itoa(o.ProxyPort)
+		if o.APIPort != 0 {
+			ports += "," + strconv.Itoa(o.APIPort)
+		}
+		args = append(args, "--ports", ports)
+	}
+	if o.LogLevel != "" {
+		args = append(args, "--log-level", o.LogLevel)
+	}
+	return args
+}
+
+func (o StartOptions) command(ctx context.Context, args ...string) *exec.Cmd {
+	binary := o.Binary
+	if binary == "" {
+		binary = "loggy-proxy"
+	}
+	cmd := exec.CommandContext(ctx, binary, append(o.globalArgs(), args...)...)
+	cmd.Env = append(os.Environ(), o.Env...)
+	return cmd
+}
+
+// Start runs "loggy-proxy start" and waits until its API answers. It fails
+// if a proxy already answers on the API port, so a test never reads events
+// from someone else's proxy.
+func Start(ctx context.Context, opts StartOptions) (*Proxy, error) {
+	if opts.StartTimeout == 0 {
+		opts.StartTimeout = 15 * time.Second
+	}
+	apiPort := opts.APIPort
+	if apiPort == 0 && opts.ProxyPort != 0 {
+		apiPort = opts.ProxyPort + 1
+	}
+	if apiPort == 0 {
+		// The profile's port: ask the CLI rather than re-reading its settings
+		port, err := profileAPIPort(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		apiPort = port
+	}
+
+	client := New(Options{APIPort: apiPort})
+	if client.Reachable(ctx) {
+		return nil, fmt.Errorf("loggy: a proxy is already running on API port %d; stop it or use other ports", apiPort)
+	}
+
+	// Not tied to ctx: the proxy outlives Start and is ended by Stop
+	cmd := opts.command(context.Background(), "start")
+	cmd.Stdout = opts.Stdout
+	cmd.Stderr = opts.Stderr
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("loggy: starting %s: %w", cmd.Path, err)
+	}
+
+	p := &Proxy{Client: client, opts: opts, cmd: cmd, exited: make(chan struct{})}
+	go func() {
+		_ = cmd.Wait()
+		close(p.exited)
+	}()
+
+	waitCtx, cancel := context.WithTimeout(ctx, opts.StartTimeout)
+	defer cancel()
+	reachable := make(chan error, 1)
+	go func() { reachable <- client.WaitUntilReachable(waitCtx) }()
+
+	select {
+	case <-p.exited:
+		return nil, fmt.Errorf("loggy: proxy exited during startup (%s)", cmd.ProcessState)
+	case err := <-reachable:
+		if err != nil {
+			_ = p.Stop()
+			return nil, err
+		}
+	}
+
+	status, err := client.Status(ctx)
+	if err != nil {
+		_ = p.Stop()
+		return nil, err
+	}
+	p.proxyPort = status.ProxyPort
+	return p, nil
+}
+
+// profileAPIPort is the API port of opts.Profile ("loggy-proxy profile list")
+func profileAPIPort(ctx context.Context, opts StartOptions) (int, error) {
+	if opts.Profile == "" {
+		return DefaultAPIPort, nil
+	}
+	out, err := opts.command(ctx, "profile", "list", "--json").Output()
+	if err != nil {
+		return 0, fmt.Errorf("loggy: listing profiles: %w", err)
+	}
+	var profiles []struct {
+		Name    string `json:"name"`
+		APIPort int    `json:"apiPort"`
+	}
+	if err := json.Unmarshal(out, &profiles); err != nil {
+		return 0, fmt.Errorf("loggy: listing profiles: %w", err)
+	}
+	for _, profile := range profiles {
+		if profile.Name == opts.Profile {
+			return profile.APIPort, nil
+		}
+	}
+	return 0, fmt.Errorf("loggy: no profile %q (create it with \"loggy-proxy profile create %s\")", opts.Profile, opts.Profile)
+}
+
+// URL is the proxy address to give a browser (e.g. Playwright's proxy.server).
+func (p *Proxy) URL() string {
+	return fmt.Sprintf("http://127.0.0.1:%d", p.proxyPort)
+}
+
+// CACertPath is the CA certificate the browser has to trust.
+func (c *Client) CACertPath(ctx context.Context) (string, error) {
+	var answer struct {
+		CACertPath string `json:"caCertPath"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/certificates", nil, &answer); err != nil {
+		return "", err
+	}
+	return answer.CACertPath, nil
+}
+
+// Run runs another loggy-proxy command with this proxy's profile and ports,
+// e.g. p.Run(ctx, "sources", "add", "segment", "--domain", "segment.io").
+func (p *Proxy) Run(ctx context.Context, args ...string) ([]byte, error) {
+	out, err := p.opts.command(ctx, args...).CombinedOutput()
+	if err != nil {
+		return out, fmt.Errorf("loggy-proxy %v: %w: %s", args, err, out)
+	}
+	return out, nil
+}
+
+// Stop ends the proxy (SIGTERM, then a kill after 10s) and waits for it.
+func (p *Proxy) Stop() error {
+	select {
+	case <-p.exited:
+		return nil
+	default:
+	}
+
+	if err := p.cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		// No SIGTERM on Windows
+		if killErr := p.cmd.Process.Kill(); killErr != nil && !errors.Is(killErr, os.ErrProcessDone) {
+			return killErr
+		}
+	}
+	select {
+	case <-p.exited:
+	case <-time.After(10 * time.Second):
+		_ = p.cmd.Process.Kill()
+		<-p.exited
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package loggyclient
+
+import (
+	"context"
+	"net/http"
+)
+
+// Source is an analytics source: which requests the proxy captures and how
+// their events are parsed.
+type Source struct {
+	ID         string `json:"id"`
+	Name       string `json:"name,omitempty"`
+	Domain     string `json:"domain"`
+	URLPattern string `json:"urlPattern,omitempty"`
+	Color      string `json:"color,omitempty"`
+	Icon       string `json:"icon,omitempty"`
+	Enabled    bool   `json:"enabled"`
+	// CreatedBy is "system" for built-in sources, "user" or "extension".
+	CreatedBy     string            `json:"createdBy,omitempty"`
+	FieldMappings map[string]string `json:"fieldMappings,omitempty"`
+	// WebSocket makes the proxy also capture JSON frames the page sends over
+	// WebSocket connections to the source.
+	WebSocket bool `json:"websocket,omitempty"`
+	// Version goes up with each change to the source's definition; UpdatedAt
+	// is when it last did.
+	Version   int    `json:"version,omitempty"`
+	UpdatedAt string `json:"updatedAt,omitempty"`
+}
+
+// Sources returns the proxy's sources, including ones added with SetSources.
+func (c *Client) Sources(ctx context.Context) ([]Source, error) {
+	var answer struct {
+		Sources []Source `json:"sources"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/sources", nil, &answer); err != nil {
+		return nil, err
+	}
+	return answer.Sources, nil
+}
+
+// SetSources adds or replaces sources by ID in the running proxy. Unlike the
+// extension's sync, the given sources replace the proxy's copies even where
+// those were edited since. They last until the proxy stops; to keep a
+// source, use "loggy-proxy sources add" (Proxy.Run) instead.
+func (c *Client) SetSources(ctx context.Context, sources ...Source) (int, error) {
+	var answer struct {
+		Synced int `json:"synced"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/sources?onConflict=replace", sources, &answer); err != nil {
+		return 0, err
+	}
+	return answer.Synced, nil
+}
+
+// ReloadSources makes the proxy re-read its sources file, after it was
+// changed by something other than "loggy-proxy sources".
+func (c *Client) ReloadSources(ctx context.Context) (int, error) {
+	var answer struct {
+		Count int `json:"count"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/sources/reload", nil, &answer); err != nil {
+		return 0, err
+	}
+	return answer.Count, nil
+}
+
+// ReprocessResult counts what Reprocess did. Requests that were no longer
+// kept, no longer match a source or fail to parse keep their events.
+type ReprocessResult struct {
+	Requests     int `json:"requests"`
+	Reprocessed  int `json:"reprocessed"`
+	Unchanged    int `json:"unchanged"`
+	Unmatched    int `json:"unmatched"`
+	Failed       int `json:"failed"`
+	NotKept      int `json:"notKept"`
+	EventsBefore int `json:"eventsBefore"`
+	EventsAfter  int `json:"eventsAfter"`
+}
+
+// Reprocess parses the raw requests behind the buffered events again with
+// the proxy's current sources, replacing their events, e.g. after a source's
+// field mappings were fixed. A non-empty sourceID limits it to that source's
+// events.
+func (c *Client) Reprocess(ctx context.Context, sourceID string) (*ReprocessResult, error) {
+	body := map[string]string{}
+	if sourceID != "" {
+		body["source"] = sourceID
+	}
+	var answer ReprocessResult
+	if err := c.do(ctx, http.MethodPost, "/reprocess", body, &answer); err != nil {
+		return nil, err
+	}
+	return &answer, nil
+}
@@ -4,13 +4,27 @@
  * This is a simplified version of ConfigManager that works in Node.js
  * environment, using file system for storage instead of chrome.storage.
  * Uses domain-based matching like the browser version.
+ *
+ * The sources file keeps a checksum of each source's definition, so a source
+ * edited by hand gets a new version when it is next loaded (and the
+ * extension's next sync sees the change instead of writing over it).
  */
 
+import crypto from 'crypto';
 import fs from 'fs';
 import path from 'path';
 import { fileURLToPath } from 'url';
 import { SourceConfig } from './source-config.js';
-import { DEFAULT_SOURCES, looksLikeAnalyticsEndpoint } from './default-sources.js';
+import { mergeSources, reviseSource } from './source-sync.js';
+import {
+  DEFAULT_SOURCES,
+  DEFAULT_UNMATCHED_SKIP_DOMAINS,
+  detectVendorPayload,
+  isSkippedDomain,
+  looksLikeAnalyticsEndpoint,
+  looksLikeAnalyticsHost,
+  suggestedVendorSource
+} from './default-sources.js';
 
 // ES6 module equivalent of __dirname
 const __filename = fileURLToPath(import.meta.url);
@@ -25,6 +39,8 @@ export class ConfigManagerNode {
     this.configPath = configPath || path.join(__dirname, 'proxy-sources.json');
     this.loaded = false;
     this.unmatchedDomains = new Map();
+    this.unmatchedSkipDomains = [...DEFAULT_UNMATCHED_SKIP_DOMAINS]; // Never tracked as unmatched
+    this.enabledSourceIds = null; // Optional allow-list from proxy settings
   }
 
   load() {
@@ -41,11 +57,23 @@ export class ConfigManagerNode {
         const data = fs.readFileSync(this.configPath, 'utf8');
         const userConfig = JSON.parse(data);
 
+        let edited = 0;
         for (const [id, config] of Object.entries(userConfig)) {
-          this.sources.set(id, new SourceConfig(id, config));
+          const source = new SourceConfig(id, config);
+          if (config.checksum && config.checksum !== ConfigManagerNode.checksum(source.toJSON())) {
+            source.version++;
+            source.updatedAt = new Date().toISOString();
+            edited++;
+          }
+          this.sources.set(id, source);
         }
 
         console.log('[ConfigManager] Loaded', this.sources.size, 'sources from file');
+        if (edited > 0) {
+          console.log('[ConfigManager]', edited, 'source(s) edited in the file since they were saved; versions raised');
+          this.loaded = true;
+          this.save();
+        }
       }
     } catch (err) {
       console.error('[ConfigManager] Error loading from file:', err.message);
@@ -54,12 +82,21 @@ export class ConfigManagerNode {
     this.loaded = true;
   }
 
+  /**
+   * Drop in-memory sources and load them again from defaults and the file
+   */
+  reload() {
+    this.sources.clear();
+    this.loaded = false;
+    this.load();
+  }
+
   save() {
     const userSources = {};
 
     for (const [id, source] of this.sources) {
       if (source.createdBy === 'user') {
-        userSources[id] = source.toJSON();
+        userSources[id] = { ...source.toJSON(), checksum: ConfigManagerNode.checksum(source.toJSON()) };
       }
     }
 
@@ -71,15 +108,44 @@ export class ConfigManagerNode {
   }
 
   /**
-   * Find source for URL using domain matching
+   * Find source for URL using domain matching; the most specific match wins
+   * (see SourceConfig.getMatchScore), the first source among equals
    */
   findSourceForUrl(url) {
+    let bestMatch = null;
+    let bestScore = 0;
     for (const [id, source] of this.sources) {
-      if (source.enabled && source.matches(url)) {
-        return source;
+      if (this.enabledSourceIds && !this.enabledSourceIds.includes(id)) continue;
+      const score = source.getMatchScore(url);
+      if (score > bestScore) {
+        bestScore = score;
+        bestMatch = source;
       }
     }
-    return null;
+    return bestMatch;
+  }
+
+  /**
+   * Whether any request to a host could match a source (ignoring the path,
+   * which a CONNECT does not reveal)
+   * @param {string} hostname - Hostname without port
+   */
+  canMatchHost(hostname) {
+    for (const [id, source] of this.sources) {
+      if (this.enabledSourceIds && !this.enabledSourceIds.includes(id)) continue;
+      if (source.enabled && source.matchesHost(hostname)) {
+        return true;
+      }
+    }
+    return false;
+  }
+
+  /**
+   * Restrict matching to the given source IDs (null = no restriction)
+   * @param {Array<string>|null} ids - Source IDs
+   */
+  setEnabledSourceIds(ids) {
+    this.enabledSourceIds = Array.isArray(ids) ? ids : null;
   }
 
   /**
@@ -96,19 +162,29 @@ export class ConfigManagerNode {
   }
 
   /**
-   * Track unmatched analytics request
+   * Track unmatched analytics request: one to an analytics-looking endpoint,
+   * or a Segment, RudderStack or Amplitude payload on any path (a
+   * first-party proxy of their collector), which gets a suggested source
+   * @param {string} url - Request URL
+   * @param {object} payload - Decoded request body
+   * @param {object} headers - Request headers (optional)
+   * @returns {boolean} - True if this is the first request seen from the domain
    */
-  trackUnmatchedRequest(url, payload) {
-    if (!looksLikeAnalyticsEndpoint(url)) return;
+  trackUnmatchedRequest(url, payload, headers = {}) {
+    const detected = detectVendorPayload(payload, headers);
+    if (!detected && !looksLikeAnalyticsEndpoint(url)) return false;
+    if (isSkippedDomain(new URL(url).hostname, this.unmatchedSkipDomains)) return false;
 
     const domain = SourceConfig.extractBaseDomainFromUrl(url);
-    if (!domain || this.findSourceByDomain(domain)) return;
+    if (!domain || this.findSourceByDomain(domain)) return false;
 
     const existing = this.unmatchedDomains.get(domain);
     if (existing) {
       existing.count++;
       existing.lastSeen = Date.now();
       if (payload) existing.payload = payload;
+      if (detected && !existing.vendor) Object.assign(existing, this.vendorSuggestion(domain, detected));
+      return false;
     } else {
       this.unmatchedDomains.set(domain, {
         domain,
@@ -116,21 +192,70 @@ export class ConfigManagerNode {
         payload,
         count: 1,
         firstSeen: Date.now(),
-        lastSeen: Date.now()
+        lastSeen: Date.now(),
+        ...(detected ? this.vendorSuggestion(domain, detected) : {})
       });
+      return true;
     }
   }
 
+  /**
+   * Fields of an unmatched domain whose payloads are a known vendor's
+   */
+  vendorSuggestion(domain, detected) {
+    return { vendor: detected.vendor, evidence: detected.evidence, suggestedSource: suggestedVendorSource(domain, detected) };
+  }
+
   getUnmatchedDomains() {
     return Array.from(this.unmatchedDomains.values())
       .sort((a, b) => b.count - a.count);
   }
 
+  /**
+   * Replace the skip list (see isSkippedDomain), forgetting tracked domains
+   * it now covers
+   * @param {Array<string>} patterns - Domain patterns
+   */
+  setUnmatchedSkipDomains(patterns) {
+    this.unmatchedSkipDomains = [...patterns];
+    for (const [domain, entry] of this.unmatchedDomains) {
+      if (isSkippedDomain(new URL(entry.url).hostname, patterns)) this.unmatchedDomains.delete(domain);
+    }
+  }
+
+  /**
+   * Short hash of a source's definition, kept in the sources file
+   */
+  static checksum(json) {
+    return crypto.createHash('sha256').update(SourceConfig.definitionKey(json)).digest('hex').slice(0, 16);
+  }
+
   getAllSources() {
     return Array.from(this.sources.values());
   }
 
+  /**
+   * Merge sources pushed by the extension (POST /sources) into these,
+   * instead of replacing them, and save (see source-sync.js)
+   * @param {Array<object>} incoming - Pushed sources, with version and syncedVersion
+   * @param {object} options - { onConflict }
+   * @returns {object} - mergeSources' result
+   */
+  mergeSources(incoming, options = {}) {
+    const local = Object.fromEntries([...this.sources].map(([id, source]) => [id, source.toJSON()]));
+    const result = mergeSources(local, incoming, options);
+    for (const [id, config] of Object.entries(result.sources)) {
+      this.sources.set(id, new SourceConfig(id, config));
+    }
+    this.save();
+    return result;
+  }
+
   addSource(source) {
+    const previous = this.sources.get(source.id);
+    const revised = reviseSource(previous ? previous.toJSON() : null, source.toJSON());
+    source.version = revised.version;
+    source.updatedAt = revised.updatedAt;
     this.sources.set(source.id, source);
     if (source.domain) {
       this.unmatchedDomains.delete(source.domain);
@@ -140,4 +265,4 @@ export class ConfigManagerNode {
 }
 
 // Re-export for convenience
-export { SourceConfig, looksLikeAnalyticsEndpoint };
+export { SourceConfig, looksLikeAnalyticsEndpoint, looksLikeAnalyticsHost, isSkippedDomain };
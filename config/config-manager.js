@@ -11,7 +11,8 @@
  */
 
 import { SourceConfig } from './source-config.js';
-import { DEFAULT_SOURCES, looksLikeAnalyticsEndpoint } from './default-sources.js';
+import { reviseSource } from './source-sync.js';
+import { DEFAULT_SOURCES, DEFAULT_UNMATCHED_SKIP_DOMAINS, detectVendorPayload, isSkippedDomain, looksLikeAnalyticsEndpoint, suggestedVendorSource } from './default-sources.js';
 
 export class ConfigManager {
   constructor() {
@@ -19,6 +20,7 @@ export class ConfigManager {
     this.loaded = false;
     // Track unmatched analytics requests for suggestions
     this.unmatchedDomains = new Map(); // domain -> { url, payload, count, lastSeen }
+    this.unmatchedSkipDomains = [...DEFAULT_UNMATCHED_SKIP_DOMAINS]; // CDNs, fonts, ad tech
   }
 
   /**
@@ -141,8 +143,15 @@ export class ConfigManager {
    * @param {object} payload - Request payload
    */
   trackUnmatchedRequest(url, payload) {
-    // Only track if it looks like an analytics endpoint
-    if (!looksLikeAnalyticsEndpoint(url)) {
+    // Only track analytics-looking endpoints, or a Segment, RudderStack or
+    // Amplitude payload sent through a first-party proxy on any path
+    const detected = detectVendorPayload(payload);
+    if (!detected && !looksLikeAnalyticsEndpoint(url)) {
+      return;
+    }
+
+    // Skip CDNs, fonts and ad tech
+    if (isSkippedDomain(new URL(url).hostname, this.unmatchedSkipDomains)) {
       return;
     }
 
@@ -160,6 +169,9 @@ export class ConfigManager {
       existing.lastSeen = Date.now();
       // Keep the most recent payload
       if (payload) existing.payload = payload;
+      if (detected && !existing.vendor) {
+        Object.assign(existing, { vendor: detected.vendor, evidence: detected.evidence, suggestedSource: suggestedVendorSource(domain, detected) });
+      }
     } else {
       this.unmatchedDomains.set(domain, {
         domain,
@@ -167,7 +179,8 @@ export class ConfigManager {
         payload,
         count: 1,
         firstSeen: Date.now(),
-        lastSeen: Date.now()
+        lastSeen: Date.now(),
+        ...(detected ? { vendor: detected.vendor, evidence: detected.evidence, suggestedSource: suggestedVendorSource(domain, detected) } : {})
       });
     }
   }
@@ -211,6 +224,9 @@ export class ConfigManager {
       existing.count = Math.max(existing.count, unmatched.count || 1);
       existing.lastSeen = Math.max(existing.lastSeen, unmatched.lastSeen || Date.now());
       if (unmatched.payload) existing.payload = unmatched.payload;
+      if (unmatched.suggestedSource && !existing.suggestedSource) {
+        Object.assign(existing, { vendor: unmatched.vendor, evidence: unmatched.evidence, suggestedSource: unmatched.suggestedSource });
+      }
     } else {
       // Add new unmatched domain
       this.unmatchedDomains.set(domain, {
@@ -219,7 +235,8 @@ export class ConfigManager {
         payload: unmatched.payload,
         count: unmatched.count || 1,
         firstSeen: unmatched.firstSeen || Date.now(),
-        lastSeen: unmatched.lastSeen || Date.now()
+        lastSeen: unmatched.lastSeen || Date.now(),
+        ...(unmatched.suggestedSource ? { vendor: unmatched.vendor, evidence: unmatched.evidence, suggestedSource: unmatched.suggestedSource } : {})
       });
     }
   }
@@ -242,17 +259,52 @@ export class ConfigManager {
   }
 
   /**
-   * Add or update a source
+   * Add or update a source; a changed definition raises its version
    * @param {SourceConfig} source - Source to add
    * @returns {Promise<void>}
    */
   async addSource(source) {
+    const previous = this.sources.get(source.id);
+    if (previous) {
+      const revised = reviseSource(previous.toJSON(), source.toJSON());
+      source.version = revised.version;
+      source.updatedAt = revised.updatedAt;
+      source.syncedVersion = source.syncedVersion ?? previous.syncedVersion;
+    }
     this.sources.set(source.id, source);
     // Clear from unmatched if we're adding a source for this domain
     this.clearUnmatchedDomain(source.domain);
     await this.save();
   }
 
+  /**
+   * Adopt the sources a proxy sync answered with (POST /sources): the merged
+   * copies, with syncedVersion set. Sources edited here since the push keep
+   * the newer local copy, for the next sync.
+   * @param {Array<object>} sources - Sources in toJSON form
+   * @returns {Promise<number>} - Sources adopted
+   */
+  async applySyncedSources(sources) {
+    let adopted = 0;
+    for (const config of sources) {
+      const current = this.sources.get(config.id);
+      if (current && current.version > config.version) continue;
+      if (current && SourceConfig.definitionKey(current.toJSON()) === SourceConfig.definitionKey(config)) {
+        // Same definition: only record the version both sides have now
+        // (unsynced sources count from version 1)
+        if (config.version === 1 || (current.version === config.version && current.syncedVersion === config.syncedVersion)) continue;
+        current.version = config.version;
+        current.updatedAt = config.updatedAt;
+        current.syncedVersion = config.syncedVersion;
+      } else {
+        this.sources.set(config.id, SourceConfig.fromJSON({ ...config, stats: current ? current.stats : config.stats }));
+      }
+      adopted++;
+    }
+    if (adopted > 0) await this.save();
+    return adopted;
+  }
+
   /**
    * Remove a source
    * @param {string} id - Source ID to remove
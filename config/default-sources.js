@@ -6,6 +6,8 @@
  *
  * New format:
  * - `domain`: Base domain to match (e.g., "segment.io" matches api.segment.io, cdn.segment.io, etc.)
+ * - `aliases`: OPTIONAL other hosts or endpoints ("host" or "host/path-glob")
+ * - `signature`: instead of a domain, a request signature matched on any host
  * - `fieldMappings`: OPTIONAL overrides for auto-detection (only set if parser gets it wrong)
  */
 
@@ -57,6 +59,29 @@ export const DEFAULT_SOURCES = {
     enabled: true,
     domain: 'grammarly.com',
     createdBy: 'system'
+  },
+
+  // Firebase Analytics in mobile and hybrid apps: protobuf batches to
+  // app-measurement.com/a, and Firebase Installations registrations
+  'firebase': {
+    name: 'Firebase Analytics',
+    color: '#FFCA28',
+    icon: '🔥',
+    enabled: true,
+    domain: 'app-measurement.com',
+    aliases: ['firebaseinstallations.googleapis.com'],
+    createdBy: 'system'
+  },
+
+  // GA4 hits to a server-side GTM container on the site's own domain, on
+  // any host (see SourceConfig.SIGNATURES)
+  'sgtm': {
+    name: 'Server-side GTM',
+    color: '#4285F4',
+    icon: '🏷️',
+    enabled: true,
+    signature: 'ga4-first-party',
+    createdBy: 'system'
   }
 };
 
@@ -93,3 +118,173 @@ export function looksLikeAnalyticsEndpoint(url) {
     return false;
   }
 }
+
+/**
+ * Domains never suggested as sources: CDNs, font and asset hosts, and ad-tech
+ * endpoints whose paths look like analytics but aren't worth capturing
+ * Patterns as for isSkippedDomain
+ */
+export const DEFAULT_UNMATCHED_SKIP_DOMAINS = [
+  // CDNs and asset hosts
+  'cloudfront.net',
+  'akamaihd.net',
+  'akamaized.net',
+  'fastly.net',
+  'cloudflare.com',
+  'jsdelivr.net',
+  'unpkg.com',
+  'cdnjs.com',
+  'gstatic.com',
+  'googleusercontent.com',
+  'azureedge.net',
+  // Fonts
+  'fonts.googleapis.com',
+  'typekit.net',
+  'fontawesome.com',
+  // Ad tech
+  'doubleclick.net',
+  'googlesyndication.com',
+  'googleadservices.com',
+  'adnxs.com',
+  'criteo.com',
+  'criteo.net',
+  'rubiconproject.com',
+  'pubmatic.com',
+  'casalemedia.com',
+  'openx.net',
+  'amazon-adsystem.com',
+  'taboola.com',
+  'outbrain.com',
+  'moatads.com',
+  'scorecardresearch.com',
+  'quantserve.com',
+  'adsrvr.org',
+  // Captchas and avatars
+  'recaptcha.net',
+  'hcaptcha.com',
+  'gravatar.com'
+];
+
+/**
+ * Check if a hostname is on a skip list
+ * A pattern without "*" matches that domain and its subdomains; "*" matches
+ * any run of characters ("*.cdn.example.com", "ads*.example.net")
+ * @param {string} hostname - Hostname without port
+ * @param {Array<string>} patterns - Skip list
+ * @returns {boolean}
+ */
+export function isSkippedDomain(hostname, patterns) {
+  const host = hostname.toLowerCase();
+  return patterns.some(pattern => {
+    const lower = pattern.toLowerCase();
+    if (!lower.includes('*')) {
+      return host === lower || host.endsWith(`.${lower}`);
+    }
+    const regex = new RegExp(`^${lower.split('*').map(part => part.replace(/[.+?^${}()|[\]\\]/g, '\\$&')).join('.*')}$`);
+    return regex.test(host);
+  });
+}
+
+/**
+ * Hostname labels typical of analytics collectors (e.g. "events.example.com")
+ * Hosts like these are still intercepted when no source matches them, so
+ * their endpoints can be suggested as sources
+ */
+export const ANALYTICS_HOST_LABELS = [
+  'analytics',
+  'events',
+  'event',
+  'track',
+  'tracking',
+  'collect',
+  'collector',
+  'log',
+  'logs',
+  'beacon',
+  'telemetry',
+  'metrics',
+  'stats',
+  'pixel',
+  // Server-side GTM tagging servers
+  'gtm',
+  'sgtm',
+  'sst',
+  'tagging'
+];
+
+/**
+ * Check if a hostname looks like an analytics collector
+ * @param {string} hostname - Hostname without port
+ * @returns {boolean} - True if one of its subdomain labels matches
+ */
+export function looksLikeAnalyticsHost(hostname) {
+  const labels = hostname.toLowerCase().split('.').slice(0, -2);
+  return labels.some(label => ANALYTICS_HOST_LABELS.some(pattern => label === pattern || label.startsWith(`${pattern}-`)));
+}
+
+/**
+ * Vendors whose collectors are often proxied through a first-party domain
+ * (a CNAME such as "t.shop.com" in front of api.segment.io), with what a
+ * source for such a proxy needs; recognised by detectVendorPayload
+ */
+export const PROXIED_VENDORS = {
+  rudderstack: { name: 'RudderStack', icon: '🐓', color: '#1D1D3E', fieldMappings: {} },
+  segment: { name: 'Segment', icon: '🟢', color: '#52BD94', fieldMappings: {} },
+  amplitude: {
+    name: 'Amplitude',
+    icon: '📈',
+    color: '#1E61F0',
+    fieldMappings: { eventName: 'event_type', timestamp: 'time', userId: 'user_id' }
+  }
+};
+
+/**
+ * Recognise a Segment, RudderStack or Amplitude payload by its structure
+ * and headers, whatever host it was sent to
+ *
+ * - RudderStack: a Segment-like payload whose context.library is the
+ *   RudderStack SDK, or the AnonymousId header its SDKs send
+ * - Segment: a writeKey in the body, or a batch with sentAt whose messages
+ *   have a messageId and a type
+ * - Amplitude: an api_key with events that have event_type (HTTP API v2),
+ *   or the form fields client and e (the browser SDK's v1 upload)
+ * @param {object} payload - Decoded request body
+ * @param {object} headers - Request headers, lower-case names (optional)
+ * @returns {object|null} - { vendor, evidence: [reasons] }, null if none match
+ */
+export function detectVendorPayload(payload, headers = {}) {
+  if (!payload || typeof payload !== 'object') return null;
+  const messages = Array.isArray(payload) ? payload : Array.isArray(payload.batch) ? payload.batch : [payload];
+  const first = messages.find(message => message && typeof message === 'object') || {};
+  const library = String(first.context?.library?.name || payload.context?.library?.name || '');
+  const basicAuth = /^basic\s/i.test(headers.authorization || '');
+
+  if (/rudder/i.test(library)) return { vendor: 'rudderstack', evidence: [`context.library.name is "${library}"`] };
+  if (headers.anonymousid && basicAuth) return { vendor: 'rudderstack', evidence: ['AnonymousId header with basic auth'] };
+
+  if (typeof payload.writeKey === 'string' || typeof first.writeKey === 'string') {
+    return { vendor: 'segment', evidence: ['writeKey in the body'] };
+  }
+  if (Array.isArray(payload.batch) && payload.sentAt && first.messageId && first.type) {
+    return { vendor: 'segment', evidence: ['batch with sentAt', 'messages have messageId and type'] };
+  }
+
+  if (typeof payload.api_key === 'string' && Array.isArray(payload.events) && payload.events.some(event => event && event.event_type)) {
+    return { vendor: 'amplitude', evidence: ['api_key with events that have event_type'] };
+  }
+  if (typeof payload.client === 'string' && Array.isArray(payload.e) && payload.e.some(event => event && event.event_type)) {
+    return { vendor: 'amplitude', evidence: ['form fields client and e'] };
+  }
+  return null;
+}
+
+/**
+ * A source suggested for a domain that proxies a vendor's collector
+ * @param {string} domain - Base domain the requests went to
+ * @param {object} detected - detectVendorPayload result
+ * @returns {object} - Source config (name, domain, icon, color, fieldMappings)
+ */
+export function suggestedVendorSource(domain, detected) {
+  const { name, icon, color, fieldMappings } = PROXIED_VENDORS[detected.vendor];
+  return { name: `${name} (${domain})`, domain, icon, color, fieldMappings: { ...fieldMappings } };
+}
@@ -0,0 +1,180 @@
+/**
+ * Proxy Profiles - Isolated proxy instances (e.g. "work" and "personal")
+ *
+ * Setting LOGGY_PROFILE gives the proxy its own data directory
+ * (~/.loggy-proxy/profiles/<name>) holding its settings, sources, CA, logs,
+ * captures and PID file, so two profiles never share captured data. A
+ * profile created with sharedCA uses the default CA instead of its own, so a
+ * browser that trusts one root can use any of them. Without a profile
+ * everything stays in its original place.
+ */
+
+const fs = require('fs');
+const os = require('os');
+const path = require('path');
+
+const REPO_ROOT = path.join(__dirname, '..');
+const PROFILE_NAME_PATTERN = /^[a-z0-9][a-z0-9_-]{0,31}$/i;
+
+// Base data directory; profiles live under <base>/profiles
+function baseHome() {
+  return process.env.LOGGY_HOME || path.join(os.homedir(), '.loggy-proxy');
+}
+
+function currentProfile() {
+  return process.env.LOGGY_PROFILE || null;
+}
+
+/**
+ * @returns {string|null} - Why the name can't be used, or null if it's valid
+ */
+function validateProfileName(name) {
+  if (typeof name !== 'string' || !PROFILE_NAME_PATTERN.test(name)) {
+    return 'Profile names are 1-32 letters, digits, "-" or "_", starting with a letter or digit';
+  }
+  return null;
+}
+
+/**
+ * Options a profile was created with (<home>/profile.json)
+ * @returns {object} - { sharedCA }
+ */
+function profileOptions(home) {
+  try {
+    const options = JSON.parse(fs.readFileSync(path.join(home, 'profile.json'), 'utf8'));
+    return { sharedCA: options.sharedCA === true };
+  } catch (err) {
+    return { sharedCA: false };
+  }
+}
+
+/**
+ * Files and directories owned by a profile (null = the default profile)
+ * @param {string|null} profile - Defaults to LOGGY_PROFILE
+ */
+function profilePaths(profile = currentProfile()) {
+  const home = profile ? path.join(baseHome(), 'profiles', profile) : baseHome();
+  const ownCA = profile && !profileOptions(home).sharedCA;
+  return {
+    profile,
+    home,
+    settingsPath: profile ? path.join(home, 'proxy-settings.json') : path.join(REPO_ROOT, 'config', 'proxy-settings.json'),
+    sourcesPath: profile ? path.join(home, 'proxy-sources.json') : path.join(REPO_ROOT, 'config', 'proxy-sources.json'),
+    pidFile: profile ? path.join(home, 'proxy.pid') : path.join(REPO_ROOT, 'native-host', '.proxy.pid'),
+    apiSocket: process.platform === 'win32'
+      ? `\\\\.\\pipe\\loggy-proxy-api${profile ? `-${profile}` : ''}`
+      : path.join(home, 'proxy.sock'),
+    // Both CAs (and the leaf cache) go here unless certificates.dir is set
+    certDir: ownCA ? path.join(home, 'certs') : null,
+    // The profile whose CA this one uses (null = the default CA), and the
+    // home holding that CA's ECDSA directory
+    caProfile: ownCA ? profile : null,
+    caHome: ownCA ? home : baseHome()
+  };
+}
+
+/**
+ * Named profiles that have been created
+ * @returns {Array<object>} - { name, home, proxyPort, apiPort }
+ */
+function listProfiles() {
+  const dir = path.join(baseHome(), 'profiles');
+  if (!fs.existsSync(dir)) return [];
+
+  return fs.readdirSync(dir, { withFileTypes: true })
+    .filter(entry => entry.isDirectory() && !validateProfileName(entry.name))
+    .map(entry => {
+      const paths = profilePaths(entry.name);
+      let settings = {};
+      try {
+        settings = JSON.parse(fs.readFileSync(paths.settingsPath, 'utf8'));
+      } catch (err) {
+        // No settings yet: default ports
+      }
+      return {
+        name: entry.name,
+        home: paths.home,
+        proxyPort: settings.proxyPort || 8888,
+        apiPort: settings.apiPort || 8889,
+        sharedCA: !paths.caProfile
+      };
+    });
+}
+
+/**
+ * PID of the proxy running for a PID file, or null
+ */
+function runningPid(pidFile) {
+  let pid;
+  try {
+    pid = parseInt(fs.readFileSync(pidFile, 'utf8'), 10);
+    process.kill(pid, 0);
+    return pid;
+  } catch (err) {
+    return err.code === 'EPERM' ? pid : null;
+  }
+}
+
+/**
+ * The default instance and every profile, with whether each is running
+ * @returns {Array<object>} - { name (null = default), home, proxyPort, apiPort, sharedCA, running, pid }
+ */
+function listInstances() {
+  const defaults = profilePaths(null);
+  let settings = {};
+  try {
+    settings = JSON.parse(fs.readFileSync(defaults.settingsPath, 'utf8'));
+  } catch (err) {
+    // No settings file: default ports
+  }
+  const instances = [
+    { name: null, home: defaults.home, proxyPort: settings.proxyPort || 8888, apiPort: settings.apiPort || 8889, sharedCA: true },
+    ...listProfiles()
+  ];
+  return instances.map(instance => {
+    const pid = runningPid(profilePaths(instance.name).pidFile);
+    return { ...instance, running: pid !== null, pid };
+  });
+}
+
+/**
+ * Create a profile with its own port pair (the first pair, in steps of 10
+ * from 8888/8889, not used by another profile)
+ * @returns {object} - { name, home, proxyPort, apiPort, created }
+ */
+function createProfile(name, { proxyPort = null, apiPort = null, sharedCA = false } = {}) {
+  const error = validateProfileName(name);
+  if (error) throw new Error(error);
+
+  const paths = profilePaths(name);
+  if (fs.existsSync(paths.settingsPath)) {
+    return { ...listProfiles().find(p => p.name === name), created: false };
+  }
+
+  const used = new Set([8888, 8889]);
+  listProfiles().forEach(p => used.add(p.proxyPort).add(p.apiPort));
+  let offset = 10;
+  while (!proxyPort && (used.has(8888 + offset) || used.has(8889 + offset))) offset += 10;
+
+  const settings = {
+    proxyPort: proxyPort || 8888 + offset,
+    apiPort: apiPort || (proxyPort ? proxyPort + 1 : 8889 + offset)
+  };
+  fs.mkdirSync(paths.home, { recursive: true });
+  fs.writeFileSync(paths.settingsPath, JSON.stringify(settings, null, 2));
+  if (sharedCA) {
+    fs.writeFileSync(path.join(paths.home, 'profile.json'), JSON.stringify({ sharedCA: true }, null, 2));
+  }
+
+  return { name, home: paths.home, ...settings, sharedCA, created: true };
+}
+
+module.exports = {
+  currentProfile,
+  validateProfileName,
+  profilePaths,
+  listProfiles,
+  listInstances,
+  runningPid,
+  createProfile
+};
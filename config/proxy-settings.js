@@ -0,0 +1,349 @@
+/**
+ * Proxy Settings - Runtime options for the MITM proxy
+ *
+ * Settings are read from config/proxy-settings.json (or the path in
+ * LOGGY_PROXY_SETTINGS) and merged over the defaults below. Everything
+ * here is optional; a missing file means "use defaults".
+ *
+ * With LOGGY_PROFILE set, the profile's own proxy-settings.json and data
+ * directory are used instead (see profile.cjs). LOGGY_PROXY_PORT,
+ * LOGGY_API_PORT, LOGGY_LOG_LEVEL, LOGGY_LOG_FORMAT, LOGGY_LOG_FILE and
+ * LOGGY_RECORD_FIXTURES override the file for one run (the CLI's --ports,
+ * --log-level, --quiet, --log-format and --record-fixtures set them; the
+ * native host and --inline set LOGGY_LOG_FILE).
+ */
+
+import fs from 'fs';
+import os from 'os';
+import path from 'path';
+import profiles from './profile.cjs';
+
+export const PROFILE_PATHS = profiles.profilePaths();
+
+// Data directory for logs, captures and other proxy-owned files
+export const LOGGY_HOME = PROFILE_PATHS.home;
+
+export const DEFAULT_SETTINGS_PATH = PROFILE_PATHS.settingsPath;
+
+export const DEFAULT_PROXY_SETTINGS = {
+  proxyPort: 8888,
+  apiPort: 8889,
+  listenHosts: ['0.0.0.0', '::1'], // Addresses the proxy and API listen on ("::1" for browsers that resolve localhost to IPv6)
+  maxEvents: 1000,       // Size of the in-memory event buffer served by the API
+  maxPinnedEvents: 500,  // Events kept by POST /events/<id>/pin, apart from the buffer (GET /events/pinned)
+  dropPolicy: 'oldest',  // When the buffer or a sink's queue is full: drop the 'oldest' or the 'newest' event
+  timestamps: {
+    precision: 'ms',     // _metadata.capturedAt fraction: 'ms' or 'us' (microseconds)
+    timeZone: 'utc'      // 'utc' (Z) or 'local' (local time with its UTC offset)
+  },
+  logLevel: 'info',      // error | warn | info | debug (see proxy/logger.cjs)
+  logFormat: 'text',     // text | json (one JSON object per line)
+  logFile: null,         // Also log here; then the console only when it is a terminal
+  logRotation: {
+    maxBytes: 5 * 1024 * 1024, // Rotate logFile past this size
+    maxFiles: 3                // logFile, logFile.1 and logFile.2
+  },
+  enabledSources: null,  // Array of source IDs to capture (null = every enabled source)
+  bypassHosts: [],       // Tunnel these hosts without interception ("example.com", "*.example.com")
+  tunnelUnmatchedHosts: true, // Tunnel hosts no source can match (except analytics-looking ones) without interception
+  captureHeaders: false, // Keep each intercepted request's headers in _metadata.headers (credentials scrubbed unless redaction.credentials is false)
+  redaction: {
+    emails: false,       // Mask email addresses in properties/context
+    userIds: false,      // Replace userId/anonymousId with a stable hash
+    credentials: true    // Scrub Authorization/Cookie-style headers and token/password/apiKey fields (false keeps them)
+  },
+  certificates: {
+    keyType: 'rsa',      // 'rsa' (http-mitm-proxy's RSA-2048 CA) or 'ecdsa' (P-256 CA in ~/.loggy-proxy/ca)
+    expiryWarningDays: 30, // Warn when the CA expires within this many days
+    autoRenew: true,     // Regenerate an expired CA at startup (it then needs re-trusting)
+    dir: null,           // Keep CAs in <dir>/rsa and <dir>/ecdsa (null = defaults; LOGGY_CERT_DIR overrides)
+    keyStorage: 'file',  // 'file', 'encrypted' (passphrase) or 'keychain' (macOS); ECDSA CA key only
+    trustWatchdog: {
+      intervalMinutes: 10, // Re-check that the CA is still trusted (0 = only at startup and via /healthz?refresh=1)
+      autoRetrust: false   // Re-trust once when trust goes missing (macOS asks for a password)
+    },
+    leafCache: {
+      maxEntries: 500,   // Per-host certificates kept in memory
+      disk: true         // Also keep them in ~/.loggy-proxy/leaf-cache so restarts don't re-sign
+    }
+  },
+  browser: {
+    id: null,            // chrome | chrome-beta | chrome-canary | chromium | brave | edge (null = OS default)
+    path: null           // Executable to launch instead of auto-detecting
+  },
+  sinks: {
+    // Append every captured event to a JSONL file
+    file: {
+      enabled: false,
+      path: path.join(LOGGY_HOME, 'events', 'events.jsonl'),
+      maxBytes: 10 * 1024 * 1024, // Rotate when the file reaches this size (0 = never)
+      maxAgeHours: 24,            // Rotate when the file is older than this (0 = never)
+      maxFiles: 10                // Rotated files to keep (0 = keep all)
+    },
+    // Replay events into a Segment/RudderStack source via the HTTP Tracking API
+    segment: {
+      enabled: false,
+      writeKey: '',
+      endpoint: 'https://api.segment.io/v1/batch',
+      maxBatchSize: 100,
+      flushIntervalMs: 5000
+    },
+    // Translate events into Amplitude's HTTP V2 API (use a test project key)
+    amplitude: {
+      enabled: false,
+      apiKey: '',
+      endpoint: 'https://api2.amplitude.com/2/httpapi'
+    },
+    // Translate events into Mixpanel's /track API (use a test project token)
+    mixpanel: {
+      enabled: false,
+      token: '',
+      endpoint: 'https://api.mixpanel.com/track'
+    },
+    // Batch inserts into ClickHouse over its HTTP interface (JSONEachRow)
+    clickhouse: {
+      enabled: false,
+      url: 'http://localhost:8123',
+      table: 'loggy_events',
+      user: '',
+      password: '',
+      columns: null, // Column -> event path mapping (null = default schema)
+      maxBatchSize: 500,
+      flushIntervalMs: 10000
+    },
+    // Streaming inserts into BigQuery (tabledata.insertAll)
+    bigquery: {
+      enabled: false,
+      projectId: '',
+      dataset: '',
+      table: 'loggy_events',
+      accessToken: '', // Falls back to GOOGLE_OAUTH_ACCESS_TOKEN
+      columns: null,
+      maxBatchSize: 500,
+      flushIntervalMs: 10000
+    },
+    // Bulk-index into Elasticsearch/OpenSearch
+    elasticsearch: {
+      enabled: false,
+      url: 'http://localhost:9200',
+      index: 'loggy-events-{date}', // {date} = YYYY.MM.DD
+      apiKey: '',
+      username: '',
+      password: '',
+      template: null, // Index template body installed on startup
+      templateName: 'loggy-events',
+      maxBatchSize: 500,
+      flushIntervalMs: 5000
+    },
+    // Publish each event to <topicPrefix>/<source>/<event>
+    mqtt: {
+      enabled: false,
+      url: 'mqtt://localhost:1883', // mqtts:// for TLS
+      username: '',
+      password: '',
+      topicPrefix: 'loggy',
+      retain: false
+    }
+  },
+  // Request bodies are parsed by worker threads, off the request path (see proxy/parse-pool.js)
+  parsing: {
+    workers: 2,          // 0 = parse on the main thread
+    maxQueue: 1000,      // Bodies waiting for a worker; more are dropped (GET /status counts them)
+    maxDecompressedBytes: 4 * 1024 * 1024, // Compressed bodies that inflate past this become one "(body too large)" event
+    errorEvents: true    // Capture a "__parse_error" event (error, Content-Type, start of the body) for bodies that don't parse
+  },
+  // Raw copies of matched requests, replayed by "loggy-proxy replay" to test parser changes
+  fixtures: {
+    record: false,       // Write one fixture per matched request (LOGGY_RECORD_FIXTURES=<dir> turns it on for one run)
+    dir: path.join(LOGGY_HOME, 'fixtures'),
+    maxPerSource: 100    // Stop recording a source after this many fixtures in dir (0 = no limit)
+  },
+  // Analytics-looking requests to hosts no source matches (GET /unmatched)
+  unmatched: {
+    skipDomains: [],           // Never tracked: "cdn.example.com" also covers its subdomains, "*" matches any characters
+    defaultSkipDomains: true   // Also skip the built-in CDN, font and ad-tech list (config/default-sources.js)
+  },
+  // Label stamped on every captured event as _environment, e.g. "staging", to
+  // compare environments (GET /environments/compare; LOGGY_ENVIRONMENT or --environment)
+  environment: null,
+  // Where requests are captured: "mitm" (this proxy, which browsers are pointed
+  // at), "cdp" (read from a Chrome started with --remote-debugging-port; no CA
+  // or proxy flags, that browser only) or "pcap" (passively from network
+  // traffic, Linux; see pcap). LOGGY_CDP=<endpoint> / --cdp and LOGGY_PCAP=<interface> / --pcap select one for a run
+  captureBackend: 'mitm',
+  cdp: {
+    endpoint: 'http://127.0.0.1:9222', // Chrome's --remote-debugging-port, or its browser ws:// URL
+    reconnectSeconds: 5                // Wait between attempts while Chrome isn't reachable
+  },
+  pcap: {
+    interface: 'any',    // tcpdump/tshark -i (needs root or CAP_NET_RAW)
+    ports: [80],         // Server ports of plaintext HTTP, reassembled from packets
+    file: null,          // Read this pcap file (tcpdump -w) instead of capturing live
+    keylogFile: null,    // A client's SSLKEYLOGFILE: decrypt its HTTPS (HTTP/1.1) with tshark
+    tlsPorts: [443]      // Server ports of HTTPS, with keylogFile
+  },
+  // Keys for API clients beyond loopback, e.g. a teammate's dashboard in LAN
+  // mode: [{ name, key, scopes: ["read", "clear", "configure"] }] ("loggy-proxy
+  // api-key create"). Empty = the API is open to anyone who can reach it.
+  apiKeys: [],
+  // Per-source token buckets, so a runaway SDK can't flood the buffer and
+  // sinks (GET /rate-limits; see proxy/rate-limiter.js)
+  rateLimits: {
+    eventsPerSecond: 0,      // Events a source may capture per second; more are throttled (0 = no limit)
+    burst: 200,              // Events a source may capture at once before the limit applies
+    sources: {}              // Per-source overrides: { "<source id>": { eventsPerSecond, burst } }
+  },
+  // Raw requests kept behind the buffered events, which POST /reprocess parses
+  // again with the current sources (see proxy/raw-requests.js)
+  reprocess: {
+    maxRequests: 1000,             // Requests kept, oldest dropped first (0 = none, no reprocessing)
+    maxBytes: 16 * 1024 * 1024     // Total body bytes kept
+  },
+  // Event-frequency anomalies: spikes, names that stop firing, duplicate
+  // bursts (GET /anomalies, GET /anomalies/stream; see proxy/anomaly-detector.js)
+  anomalies: {
+    enabled: true,
+    windowSeconds: 10,       // Events are counted per source and name in windows this long
+    spikeFactor: 5,          // A window with this many times the usual count is a spike...
+    minSpikeCount: 10,       // ...if it has at least this many events
+    silenceWindows: 6,       // A steadily firing event missing for this many windows has disappeared
+    duplicateWindowMs: 1000, // Identical events this close together are duplicates (0 = don't check)
+    maxAnomalies: 200        // Anomalies kept for GET /anomalies
+  },
+  // Funnels checked against the event buffer by GET /funnels/<name> (see
+  // proxy/funnels.js); usually added with PUT /funnels/<name>
+  funnels: [],
+  // GET hits to a source whose query string is the payload: GA4 /g/collect,
+  // tracking pixels (1x1 GIFs). Script and asset loads are skipped
+  getRequests: {
+    enabled: true,
+    skipExtensions: ['js', 'mjs', 'css', 'map', 'html', 'htm', 'woff', 'woff2', 'ttf', 'svg', 'ico'],
+    skipPaths: ['/gtag/js', '/gtag/destination'] // Path globs (* within a segment, ** across)
+  },
+  // Analytics sent over WebSockets: on connections to a source with its
+  // `websocket` flag on, JSON frames the page sends are parsed like bodies
+  websockets: {
+    enabled: true,
+    serverFrames: false,          // Also frames the server sends
+    maxFrameBytes: 1024 * 1024    // Larger frames are skipped
+  },
+  // Audits: capture every POST/PUT body that no source captures, as events of
+  // the "uncategorized" source (LOGGY_PROMISCUOUS=1 or --promiscuous turns it on for one run)
+  promiscuous: {
+    enabled: false,      // Also intercepts hosts tunnelUnmatchedHosts would tunnel
+    maxBodyBytes: 256 * 1024 // Larger bodies keep only their first bytes, undecoded
+  },
+  // Rules that classify each captured event as ok, warn or error: missing
+  // required properties, names outside the tracking plan, PII (GET /severity;
+  // see proxy/severity.js for the rule format)
+  severity: {
+    rules: []
+  },
+  // Slack/Discord webhook notifications (see proxy/alerts.js for the rule format)
+  alerts: {
+    cooldownSeconds: 60, // Minimum time between notifications for the same rule
+    rules: []
+  }
+};
+
+/**
+ * Expand a leading ~ in a configured path
+ * @param {string} filePath - Path from settings
+ * @returns {string} - Absolute path
+ */
+export function resolvePath(filePath) {
+  if (!filePath) return filePath;
+  if (filePath === '~' || filePath.startsWith('~/')) {
+    return path.join(os.homedir(), filePath.slice(1));
+  }
+  return path.resolve(filePath);
+}
+
+/**
+ * Deep-merge plain objects (arrays and scalars from `override` win)
+ */
+function mergeSettings(base, override) {
+  if (!override || typeof override !== 'object' || Array.isArray(override)) {
+    return override === undefined ? base : override;
+  }
+
+  const result = { ...base };
+  for (const [key, value] of Object.entries(override)) {
+    const baseValue = base ? base[key] : undefined;
+    if (baseValue && typeof baseValue === 'object' && !Array.isArray(baseValue)) {
+      result[key] = mergeSettings(baseValue, value);
+    } else {
+      result[key] = value;
+    }
+  }
+  return result;
+}
+
+/**
+ * Load proxy settings from disk, falling back to defaults
+ * @param {string} settingsPath - Optional path to settings JSON
+ * @param {object} options
+ * @param {boolean} options.quiet - Don't log which file was loaded (keeps stdout clean for CLI output)
+ * @returns {object} - Merged settings
+ */
+export function loadProxySettings(settingsPath = null, { quiet = false } = {}) {
+  const filePath = settingsPath || process.env.LOGGY_PROXY_SETTINGS || DEFAULT_SETTINGS_PATH;
+  let userSettings = {};
+
+  try {
+    if (fs.existsSync(filePath)) {
+      userSettings = JSON.parse(fs.readFileSync(filePath, 'utf8'));
+      if (!quiet) console.log('[ProxySettings] Loaded settings from', filePath);
+    }
+  } catch (err) {
+    console.error('[ProxySettings] Error loading settings:', err.message);
+  }
+
+  return applyEnvironment(mergeSettings(DEFAULT_PROXY_SETTINGS, userSettings));
+}
+
+/**
+ * Write top-level keys to the user's settings file, keeping everything else
+ * in it (used by API endpoints that change settings)
+ * @param {object} changes - Top-level keys to replace
+ * @param {string} settingsPath - Optional path to settings JSON
+ */
+export function saveProxySettings(changes, settingsPath = null) {
+  const filePath = settingsPath || process.env.LOGGY_PROXY_SETTINGS || DEFAULT_SETTINGS_PATH;
+  let userSettings = {};
+  if (fs.existsSync(filePath)) {
+    userSettings = JSON.parse(fs.readFileSync(filePath, 'utf8'));
+  }
+
+  fs.mkdirSync(path.dirname(filePath), { recursive: true });
+  fs.writeFileSync(filePath, JSON.stringify({ ...userSettings, ...changes }, null, 2));
+}
+
+/**
+ * Per-run overrides from the environment
+ */
+function applyEnvironment(settings) {
+  const port = name => parseInt(process.env[name], 10) || null;
+  if (port('LOGGY_PROXY_PORT')) settings.proxyPort = port('LOGGY_PROXY_PORT');
+  if (port('LOGGY_API_PORT')) settings.apiPort = port('LOGGY_API_PORT');
+  if (process.env.LOGGY_LOG_LEVEL) settings.logLevel = process.env.LOGGY_LOG_LEVEL;
+  if (process.env.LOGGY_LOG_FORMAT) settings.logFormat = process.env.LOGGY_LOG_FORMAT;
+  if (process.env.LOGGY_LOG_FILE) settings.logFile = process.env.LOGGY_LOG_FILE;
+  if (process.env.LOGGY_ENVIRONMENT) settings.environment = process.env.LOGGY_ENVIRONMENT;
+  if (process.env.LOGGY_CDP) {
+    settings.captureBackend = 'cdp';
+    if (process.env.LOGGY_CDP !== '1') settings.cdp = { ...settings.cdp, endpoint: process.env.LOGGY_CDP };
+  }
+  if (process.env.LOGGY_PCAP) {
+    settings.captureBackend = 'pcap';
+    if (process.env.LOGGY_PCAP !== '1') settings.pcap = { ...settings.pcap, interface: process.env.LOGGY_PCAP };
+  }
+  if (process.env.LOGGY_PCAP_FILE) settings.pcap = { ...settings.pcap, file: process.env.LOGGY_PCAP_FILE };
+  if (process.env.LOGGY_PROMISCUOUS === '1') {
+    settings.promiscuous = { ...settings.promiscuous, enabled: true };
+  }
+  if (process.env.LOGGY_RECORD_FIXTURES) {
+    settings.fixtures = { ...settings.fixtures, record: true, dir: process.env.LOGGY_RECORD_FIXTURES };
+  }
+  return settings;
+}
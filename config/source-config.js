@@ -3,12 +3,38 @@
  *
  * Each source (e.g., Reddit, Segment, Honey) has:
  * - A domain to match (e.g., "joinhoney.com" matches all subdomains)
+ * - Optional aliases: other hosts or endpoints of the same source (regional
+ *   endpoints, a customer's CNAME collector), as "host" or "host/path-glob"
+ * - Or a request signature, for collectors on any host (see SIGNATURES)
+ * - Optionally a websocket flag, to capture JSON frames sent over
+ *   WebSocket connections to it as well
  * - Optional field mappings to override auto-detection
  * - Visual identity (icon, color)
  * - Statistics tracking
+ * - A version, raised by each change to its definition, so the extension and
+ *   the proxy can tell whose copy changed when they sync (see source-sync.js)
  */
 
+// Google's own GA4 collectors; hits anywhere else went through a tagging server
+const GOOGLE_COLLECTOR_HOST = /(^|\.)(google-analytics\.com|analytics\.google\.com|googletagmanager\.com)$/;
+
 export class SourceConfig {
+  /**
+   * Request signatures a source can match on any host, by path and query,
+   * for collectors that live on first-party domains
+   */
+  static SIGNATURES = {
+    // Server-side Google Tag Manager: GA4 web hits (gtag.js /g/collect,
+    // protocol v=2) and Measurement Protocol hits (/mp/collect) sent to a
+    // tagging server on the site's own domain rather than to Google
+    'ga4-first-party': urlObj => !GOOGLE_COLLECTOR_HOST.test(urlObj.hostname.toLowerCase()) &&
+      ((/\/g\/collect$/.test(urlObj.pathname) && urlObj.searchParams.get('v') === '2') || /\/mp\/collect$/.test(urlObj.pathname))
+  };
+
+  // Fields that define what a source captures and how it looks; stats and
+  // timestamps are not part of it
+  static DEFINITION_FIELDS = ['name', 'enabled', 'color', 'icon', 'domain', 'urlPattern', 'aliases', 'signature', 'fieldMappings', 'websocket'];
+
   constructor(id, config = {}) {
     this.id = id;
     this.name = config.name || id;
@@ -17,9 +43,15 @@ export class SourceConfig {
     this.icon = config.icon || '📊';
     this.domain = config.domain || ''; // Base domain to match (e.g., "joinhoney.com")
     this.urlPattern = config.urlPattern || null; // Optional glob pattern for URL path (e.g., "/tracking/*")
+    this.aliases = Array.isArray(config.aliases) ? config.aliases : []; // e.g. ["events.eu1.segmentapis.com", "t.example.com/v1/*"]
+    this.signature = config.signature || null; // A SIGNATURES name, matched on any host
     this.fieldMappings = config.fieldMappings || {}; // Optional overrides only
+    this.websocket = config.websocket === true; // Capture JSON frames sent over WebSockets too
     this.createdBy = config.createdBy || 'system';
     this.createdAt = config.createdAt || new Date().toISOString();
+    this.version = config.version || 1;
+    this.updatedAt = config.updatedAt || this.createdAt;
+    this.syncedVersion = config.syncedVersion ?? null; // Version both sides had at the last sync
     this.stats = config.stats || {
       eventsCapture: 0,
       lastCaptured: null
@@ -115,30 +147,44 @@ export class SourceConfig {
     }
   }
 
+  /**
+   * Split an alias into its host and optional path glob
+   * e.g., "t.example.com/v1/*" -> { host: "t.example.com", pathPattern: "/v1/*" }
+   * @param {string} alias - "host" or "host/path-glob" (a scheme is ignored)
+   * @returns {object} - { host, pathPattern }
+   */
+  static parseAlias(alias) {
+    const value = String(alias).trim().replace(/^\w+:\/\//, '');
+    const slash = value.indexOf('/');
+    const host = (slash === -1 ? value : value.slice(0, slash)).split(':')[0].toLowerCase();
+    const pathPattern = slash === -1 || slash === value.length - 1 ? null : value.slice(slash);
+    return { host, pathPattern };
+  }
+
+  /**
+   * Whether a hostname is an alias host or one of its subdomains
+   */
+  static matchesAliasHost(hostname, host) {
+    return hostname === host || hostname.endsWith(`.${host}`);
+  }
+
+  /**
+   * Whether any request to a host could match this source (ignoring paths)
+   * @param {string} hostname - Hostname without port
+   */
+  matchesHost(hostname) {
+    const host = hostname.toLowerCase();
+    if (this.domain && SourceConfig.extractBaseDomain(host) === this.domain.toLowerCase()) return true;
+    return this.aliases.some(alias => SourceConfig.matchesAliasHost(host, SourceConfig.parseAlias(alias).host));
+  }
+
   /**
    * Check if this source matches a URL
    * @param {string} url - URL to test
    * @returns {boolean} - True if URL matches this source's domain and optional path pattern
    */
   matches(url) {
-    if (!this.enabled || !this.domain) return false;
-
-    try {
-      const urlObj = new URL(url);
-      const urlDomain = SourceConfig.extractBaseDomain(urlObj.hostname);
-
-      // Domain must match
-      if (urlDomain !== this.domain.toLowerCase()) return false;
-
-      // If urlPattern is specified, path must also match
-      if (this.urlPattern) {
-        return this.matchesPattern(urlObj.pathname, this.urlPattern);
-      }
-
-      return true;
-    } catch {
-      return false;
-    }
+    return this.getMatchScore(url) > 0;
   }
 
   /**
@@ -156,11 +202,42 @@ export class SourceConfig {
    * Get match score for priority matching
    * Higher score = more specific match
    * @param {string} url - URL to test
-   * @returns {number} - 0 = no match, 1 = domain only, 2 = domain + pattern
+   * @returns {number} - 0 = no match, 0.5 = signature only (below any domain
+   *   match), 1 = domain only, 2 = domain + pattern or alias host, 3 = alias
+   *   host + pattern
    */
   getMatchScore(url) {
-    if (!this.matches(url)) return 0;
-    return this.urlPattern ? 2 : 1;
+    if (!this.enabled || (!this.domain && this.aliases.length === 0 && !this.signature)) return 0;
+
+    let urlObj;
+    try {
+      urlObj = new URL(url);
+    } catch {
+      return 0;
+    }
+    const hostname = urlObj.hostname.toLowerCase();
+
+    // Aliases name specific hosts, so they win over a base-domain match; an
+    // alias without its own path uses the source's urlPattern
+    let best = 0;
+    for (const alias of this.aliases) {
+      const { host, pathPattern } = SourceConfig.parseAlias(alias);
+      if (!host || !SourceConfig.matchesAliasHost(hostname, host)) continue;
+      const pattern = pathPattern || this.urlPattern;
+      if (pattern && !this.matchesPattern(urlObj.pathname, pattern)) continue;
+      best = Math.max(best, pattern ? 3 : 2);
+    }
+    if (best > 0) return best;
+
+    // Domain must match
+    if (this.domain && SourceConfig.extractBaseDomain(hostname) === this.domain.toLowerCase()) {
+      // If urlPattern is specified, path must also match
+      if (!this.urlPattern) return 1;
+      if (this.matchesPattern(urlObj.pathname, this.urlPattern)) return 2;
+    }
+
+    const signature = this.signature && SourceConfig.SIGNATURES[this.signature];
+    return signature && signature(urlObj) ? 0.5 : 0;
   }
 
   /**
@@ -221,15 +298,53 @@ export class SourceConfig {
       fieldMappings: this.fieldMappings,
       createdBy: this.createdBy,
       createdAt: this.createdAt,
+      version: this.version,
+      updatedAt: this.updatedAt,
       stats: this.stats
     };
     // Only include urlPattern if set (keep JSON clean)
     if (this.urlPattern) {
       json.urlPattern = this.urlPattern;
     }
+    if (this.aliases.length > 0) {
+      json.aliases = this.aliases;
+    }
+    if (this.signature) {
+      json.signature = this.signature;
+    }
+    if (this.websocket) {
+      json.websocket = true;
+    }
+    if (this.syncedVersion !== null) {
+      json.syncedVersion = this.syncedVersion;
+    }
     return json;
   }
 
+  /**
+   * A source's definition (DEFINITION_FIELDS) as a string, for comparing two
+   * copies of it
+   * @param {object} json - A source in toJSON form
+   * @returns {string}
+   */
+  static definitionKey(json) {
+    const defaults = new SourceConfig(json.id, json);
+    const values = SourceConfig.DEFINITION_FIELDS.map(field => defaults[field] ?? null);
+    // websocket came after sources files kept checksums; it counts only when
+    // on, so the checksums of sources without it still match
+    if (!defaults.websocket) values.pop();
+    return JSON.stringify(values);
+  }
+
+  /**
+   * Fields whose values differ between two copies of a source
+   * @returns {Array<string>}
+   */
+  static changedFields(a, b) {
+    const [left, right] = [JSON.parse(SourceConfig.definitionKey(a)), JSON.parse(SourceConfig.definitionKey(b))];
+    return SourceConfig.DEFINITION_FIELDS.filter((field, i) => JSON.stringify(left[i] ?? null) !== JSON.stringify(right[i] ?? null));
+  }
+
   /**
    * Create a SourceConfig from JSON
    * @param {object} json - JSON representation
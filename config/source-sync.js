@@ -0,0 +1,99 @@
+/**
+ * Source sync - Merges the extension's sources with the proxy's
+ *
+ * Both sides edit sources: the extension in its source manager, the proxy
+ * through `loggy-proxy sources`, the sources file or the API. Each copy of a
+ * source carries a version, raised by every change to its definition, and
+ * the extension's copies carry syncedVersion, the version both sides had at
+ * their last sync (1 for a source never synced: every copy starts from the
+ * same version 1). A pushed source is then merged rather than written over
+ * the proxy's:
+ * - only the extension changed it: its copy is taken;
+ * - only the proxy changed it: the proxy's copy is kept (the extension is
+ *   behind, and picks it up from the answer);
+ * - both changed it: a conflict, resolved
+ *   by onConflict ("keep" the proxy's copy, "replace" it with the pushed
+ *   one, or take the "newest" by updatedAt) and reported. "replace" also
+ *   replaces copies only the proxy changed, as POST /sources used to.
+ * Sources only the proxy has are kept; a sync never removes one.
+ */
+
+import { SourceConfig } from './source-config.js';
+
+export const CONFLICT_STRATEGIES = ['keep', 'replace', 'newest'];
+
+/**
+ * A changed copy of a source, with its version raised past the previous one
+ * (unchanged copies keep the previous version)
+ * @param {object} previous - The source before the change (toJSON form), or null for a new one
+ * @param {object} next - The source after it
+ * @param {string} now - ISO time of the change
+ * @returns {object} - next with version and updatedAt set
+ */
+export function reviseSource(previous, next, now = new Date().toISOString()) {
+  if (!previous) return { ...next, version: next.version || 1, updatedAt: next.updatedAt || now };
+  if (SourceConfig.definitionKey(previous) === SourceConfig.definitionKey(next)) {
+    return { ...next, version: previous.version || 1, updatedAt: previous.updatedAt || next.updatedAt };
+  }
+  return { ...next, version: Math.max(previous.version || 1, next.version || 1) + 1, updatedAt: now };
+}
+
+/**
+ * Merge pushed sources into the proxy's
+ * @param {object} local - Source ID -> the proxy's copy (toJSON form)
+ * @param {Array<object>} incoming - The pushed sources
+ * @param {object} options
+ * @param {string} options.onConflict - "keep" (default), "replace" or "newest"
+ * @param {string} options.now - ISO time, for sources the merge changes
+ * @returns {object} - { sources: ID -> merged copy (for the pushed IDs), added, updated,
+ *   unchanged, behind (IDs), conflicts: [{ id, fields, proxyVersion, pushedVersion, kept }] }
+ */
+export function mergeSources(local, incoming, { onConflict = 'keep', now = new Date().toISOString() } = {}) {
+  const result = { sources: {}, added: [], updated: [], unchanged: [], behind: [], conflicts: [] };
+  for (const pushed of incoming) {
+    const { syncedVersion, ...copy } = pushed;
+    const base = syncedVersion || 1;
+    const current = local[pushed.id];
+    const version = copy.version || 1;
+
+    if (!current) {
+      result.sources[pushed.id] = { ...copy, version };
+      result.added.push(pushed.id);
+      continue;
+    }
+    const currentVersion = current.version || 1;
+    if (SourceConfig.definitionKey(current) === SourceConfig.definitionKey(copy)) {
+      result.sources[pushed.id] = { ...current, version: Math.max(currentVersion, version) };
+      result.unchanged.push(pushed.id);
+      continue;
+    }
+
+    if (currentVersion <= base) {
+      // The proxy's copy is the one the extension last synced
+      result.sources[pushed.id] = { ...copy, stats: current.stats || copy.stats, version: Math.max(version, currentVersion + 1), updatedAt: copy.updatedAt || now };
+      result.updated.push(pushed.id);
+      continue;
+    }
+    if (version <= base && onConflict !== 'replace') {
+      // The pushed copy is the one the extension last synced
+      result.sources[pushed.id] = current;
+      result.behind.push(pushed.id);
+      continue;
+    }
+
+    const replace = onConflict === 'replace' ||
+      (onConflict === 'newest' && (Date.parse(copy.updatedAt) || 0) > (Date.parse(current.updatedAt) || 0));
+    result.sources[pushed.id] = replace
+      ? { ...copy, stats: current.stats || copy.stats, version: Math.max(currentVersion, version) + 1, updatedAt: now }
+      : current;
+    if (replace) result.updated.push(pushed.id);
+    result.conflicts.push({
+      id: pushed.id,
+      fields: SourceConfig.changedFields(current, copy),
+      proxyVersion: currentVersion,
+      pushedVersion: version,
+      kept: replace ? 'pushed' : 'proxy'
+    });
+  }
+  return result;
+}
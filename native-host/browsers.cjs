@@ -0,0 +1,208 @@
+/**
+ * Chromium browser detection for launching the proxy window
+ *
+ * Finds the user's default browser (when it's Chromium-based) or the first
+ * installed one, so the proxy doesn't assume stable Google Chrome on macOS.
+ * A configured path always wins.
+ */
+
+const { execFile } = require('child_process');
+const fs = require('fs');
+const os = require('os');
+const path = require('path');
+
+const LOCAL_APP_DATA = process.env.LOCALAPPDATA || path.join(os.homedir(), 'AppData', 'Local');
+const PROGRAM_FILES = [process.env.PROGRAMFILES, process.env['PROGRAMFILES(X86)'], LOCAL_APP_DATA].filter(Boolean);
+
+function windowsPaths(relative) {
+  return PROGRAM_FILES.map(dir => path.join(dir, relative));
+}
+
+// Known browsers in preference order. `bundleId` (macOS), `desktop` (Linux
+// .desktop file) and `progId` (Windows) identify the OS default browser.
+const BROWSERS = [
+  {
+    id: 'chrome',
+    name: 'Google Chrome',
+    bundleId: 'com.google.chrome',
+    desktop: ['google-chrome'],
+    progId: 'ChromeHTML',
+    paths: {
+      darwin: ['/Applications/Google Chrome.app/Contents/MacOS/Google Chrome'],
+      linux: ['google-chrome', 'google-chrome-stable'],
+      win32: windowsPaths('Google\\Chrome\\Application\\chrome.exe')
+    }
+  },
+  {
+    id: 'chrome-beta',
+    name: 'Google Chrome Beta',
+    bundleId: 'com.google.chrome.beta',
+    desktop: ['google-chrome-beta'],
+    progId: 'ChromeBHTML',
+    paths: {
+      darwin: ['/Applications/Google Chrome Beta.app/Contents/MacOS/Google Chrome Beta'],
+      linux: ['google-chrome-beta'],
+      win32: windowsPaths('Google\\Chrome Beta\\Application\\chrome.exe')
+    }
+  },
+  {
+    id: 'chrome-canary',
+    name: 'Google Chrome Canary',
+    bundleId: 'com.google.chrome.canary',
+    desktop: ['google-chrome-unstable'],
+    progId: 'ChromeSSHTM',
+    paths: {
+      darwin: ['/Applications/Google Chrome Canary.app/Contents/MacOS/Google Chrome Canary'],
+      linux: ['google-chrome-unstable'],
+      win32: windowsPaths('Google\\Chrome SxS\\Application\\chrome.exe')
+    }
+  },
+  {
+    id: 'chromium',
+    name: 'Chromium',
+    bundleId: 'org.chromium.chromium',
+    desktop: ['chromium', 'chromium-browser'],
+    progId: 'ChromiumHTM',
+    paths: {
+      darwin: ['/Applications/Chromium.app/Contents/MacOS/Chromium'],
+      linux: ['chromium', 'chromium-browser'],
+      win32: windowsPaths('Chromium\\Application\\chrome.exe')
+    }
+  },
+  {
+    id: 'brave',
+    name: 'Brave',
+    bundleId: 'com.brave.browser',
+    desktop: ['brave-browser', 'brave'],
+    progId: 'BraveHTML',
+    paths: {
+      darwin: ['/Applications/Brave Browser.app/Contents/MacOS/Brave Browser'],
+      linux: ['brave-browser', 'brave'],
+      win32: windowsPaths('BraveSoftware\\Brave-Browser\\Application\\brave.exe')
+    }
+  },
+  {
+    id: 'edge',
+    name: 'Microsoft Edge',
+    bundleId: 'com.microsoft.edgemac',
+    desktop: ['microsoft-edge'],
+    progId: 'MSEdgeHTM',
+    paths: {
+      darwin: ['/Applications/Microsoft Edge.app/Contents/MacOS/Microsoft Edge'],
+      linux: ['microsoft-edge', 'microsoft-edge-stable'],
+      win32: windowsPaths('Microsoft\\Edge\\Application\\msedge.exe')
+    }
+  }
+];
+
+function run(file, args) {
+  return new Promise(resolve => {
+    execFile(file, args, { timeout: 5000 }, (err, stdout) => resolve(err ? '' : String(stdout)));
+  });
+}
+
+/**
+ * Resolve a bare command name against PATH
+ */
+function findOnPath(command) {
+  for (const dir of (process.env.PATH || '').split(path.delimiter)) {
+    const candidate = path.join(dir, command);
+    if (fs.existsSync(candidate)) return candidate;
+  }
+  return null;
+}
+
+/**
+ * Installed executable for a known browser, or null
+ */
+function findExecutable(browser) {
+  const candidates = browser.paths[process.platform] || [];
+  for (const candidate of candidates) {
+    if (path.isAbsolute(candidate)) {
+      if (fs.existsSync(candidate)) return candidate;
+    } else {
+      const resolved = findOnPath(candidate);
+      if (resolved) return resolved;
+    }
+  }
+  return null;
+}
+
+/**
+ * Identify the OS default browser among the known Chromium browsers
+ * @returns {Promise<object|null>} - Entry from BROWSERS, or null if unknown/non-Chromium
+ */
+async function getDefaultBrowser() {
+  if (process.platform === 'darwin') {
+    const plist = path.join(os.homedir(), 'Library', 'Preferences', 'com.apple.LaunchServices',
+      'com.apple.launchservices.secure.plist');
+    const json = await run('plutil', ['-convert', 'json', '-o', '-', plist]);
+    try {
+      const handler = (JSON.parse(json).LSHandlers || []).find(h => h.LSHandlerURLScheme === 'https');
+      const bundleId = (handler && handler.LSHandlerRoleAll || '').toLowerCase();
+      return BROWSERS.find(b => b.bundleId === bundleId) || null;
+    } catch (err) {
+      return null;
+    }
+  }
+
+  if (process.platform === 'linux') {
+    const desktop = (await run('xdg-settings', ['get', 'default-web-browser'])).trim().replace(/\.desktop$/, '');
+    return BROWSERS.find(b => b.desktop.includes(desktop)) || null;
+  }
+
+  if (process.platform === 'win32') {
+    const output = await run('reg', ['query',
+      'HKCU\\Software\\Microsoft\\Windows\\Shell\\Associations\\UrlAssociations\\https\\UserChoice', '/v', 'ProgId']);
+    const match = output.match(/ProgId\s+REG_SZ\s+(\S+)/);
+    return match ? BROWSERS.find(b => match[1].startsWith(b.progId)) || null : null;
+  }
+
+  return null;
+}
+
+/**
+ * Pick the browser to launch
+ * @param {object} config - `browser` section of proxy settings ({ path, id })
+ * @returns {Promise<object>} - { id, name, path, source } with path null when nothing was found
+ */
+async function resolveBrowser(config = {}) {
+  if (config.path) {
+    return { id: 'custom', name: path.basename(config.path), path: config.path, source: 'configured' };
+  }
+
+  if (config.id) {
+    const selected = BROWSERS.find(b => b.id === config.id);
+    const executable = selected && findExecutable(selected);
+    if (executable) return { id: selected.id, name: selected.name, path: executable, source: 'selected' };
+  }
+
+  const defaultBrowser = await getDefaultBrowser();
+  const defaultPath = defaultBrowser && findExecutable(defaultBrowser);
+  if (defaultPath) {
+    return { id: defaultBrowser.id, name: defaultBrowser.name, path: defaultPath, source: 'default' };
+  }
+
+  for (const browser of BROWSERS) {
+    const executable = findExecutable(browser);
+    if (executable) return { id: browser.id, name: browser.name, path: executable, source: 'installed' };
+  }
+
+  return { id: null, name: null, path: null, source: null };
+}
+
+/**
+ * Every known browser that is installed on this machine
+ */
+function listInstalledBrowsers() {
+  return BROWSERS
+    .map(browser => ({ id: browser.id, name: browser.name, path: findExecutable(browser) }))
+    .filter(browser => browser.path);
+}
+
+module.exports = {
+  BROWSERS,
+  resolveBrowser,
+  getDefaultBrowser,
+  listInstalledBrowsers
+};
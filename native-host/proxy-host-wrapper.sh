@@ -1,13 +1,16 @@
 #!/bin/bash
 
+LOG_FILE="${LOGGY_HOME:-$HOME/.loggy-proxy}/logs/host.log"
+mkdir -p "$(dirname "$LOG_FILE")"
+
 # Log that we were called
-echo "[$(date)] Wrapper called with args: $@" >> /Users/jonahnakagawa/vibes/extension-analytics-logger/native-host/wrapper-debug.log
-echo "[$(date)] PATH: $PATH" >> /Users/jonahnakagawa/vibes/extension-analytics-logger/native-host/wrapper-debug.log
-echo "[$(date)] PWD: $(pwd)" >> /Users/jonahnakagawa/vibes/extension-analytics-logger/native-host/wrapper-debug.log
+echo "[$(date)] Wrapper called with args: $@" >> "$LOG_FILE"
+echo "[$(date)] PATH: $PATH" >> "$LOG_FILE"
+echo "[$(date)] PWD: $(pwd)" >> "$LOG_FILE"
 
 export PATH="/opt/homebrew/bin:/usr/local/bin:/usr/bin:/bin"
 
 # Log execution
-echo "[$(date)] Executing node script" >> /Users/jonahnakagawa/vibes/extension-analytics-logger/native-host/wrapper-debug.log
+echo "[$(date)] Executing node script" >> "$LOG_FILE"
 
-exec /opt/homebrew/bin/node "$(dirname "$0")/proxy-host.cjs" 2>> /Users/jonahnakagawa/vibes/extension-analytics-logger/native-host/wrapper-debug.log
+exec /opt/homebrew/bin/node "$(dirname "$0")/proxy-host.cjs" 2>> "$LOG_FILE"
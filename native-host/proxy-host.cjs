@@ -5,41 +5,235 @@
  * Allows the extension to start/stop the proxy server
  */
 
-const { spawn, exec } = require('child_process');
+const { spawn } = require('child_process');
+const http = require('http');
+const net = require('net');
+const os = require('os');
 const path = require('path');
 const fs = require('fs');
+const { getVersionInfo, checkForUpdate } = require('../proxy/version.cjs');
+const { resolveBrowser, listInstalledBrowsers } = require('./browsers.cjs');
+const trustStore = require('../proxy/trust-store.cjs');
+const profiles = require('../config/profile.cjs');
+const logging = require('../proxy/logger.cjs');
 
 let proxyProcess = null;
-const PID_FILE = path.join(__dirname, '.proxy.pid');
 
-// Native messaging uses stdin/stdout for communication
+// Files owned by the selected profile (see config/profile.cjs). Messages may
+// carry a `profile`; each one runs in a fresh host process, so selecting it
+// once in handleMessage covers the whole operation.
+let PID_FILE;
+let SETTINGS_PATH; // Shared with proxy-server-mitm.js (see config/proxy-settings.js)
+let SOURCES_PATH;  // Persistent source list read by ConfigManagerNode
+let API_SOCKET;    // Proxy API endpoint (see proxy-server-mitm.js)
+let PROXY_LOG_FILE;
+let BROWSER_PROFILE_DIR; // Separate profile for the proxied browser window
+
+const CONFIGURABLE_KEYS = ['proxyPort', 'apiPort', 'maxEvents', 'enabledSources', 'bypassHosts', 'tunnelUnmatchedHosts', 'captureHeaders', 'redaction', 'browser', 'certificates', 'unmatched'];
+
+/**
+ * Point the path constants at a profile (null = the default profile). The
+ * profile is also exported as LOGGY_PROFILE for trustStore and the spawned proxy.
+ */
+function selectProfile(profile) {
+  if (profile) {
+    process.env.LOGGY_PROFILE = profile;
+  } else {
+    delete process.env.LOGGY_PROFILE;
+  }
+  const paths = profiles.profilePaths(profile || null);
+  PID_FILE = paths.pidFile;
+  SETTINGS_PATH = process.env.LOGGY_PROXY_SETTINGS || paths.settingsPath;
+  SOURCES_PATH = paths.sourcesPath;
+  API_SOCKET = paths.apiSocket;
+  PROXY_LOG_FILE = path.join(paths.home, 'logs', 'proxy.log');
+  BROWSER_PROFILE_DIR = path.join(os.tmpdir(), profile ? `chrome-proxy-profile-${profile}` : 'chrome-proxy-profile');
+}
+
+selectProfile(profiles.currentProfile());
+
+// Base data directory (host diagnostics are shared by all profiles)
+const LOGGY_HOME = process.env.LOGGY_HOME || path.join(os.homedir(), '.loggy-proxy');
+
+// Host diagnostics (stdout is reserved for the messaging protocol)
+const HOST_LOG_FILE = path.join(LOGGY_HOME, 'logs', 'host.log');
+const MAX_HOST_LOG_BYTES = 1024 * 1024;
+const MAX_HOST_LOG_FILES = 3;
+
+// Chrome rejects host -> extension messages over 1MB; leave room for the envelope
+const MAX_MESSAGE_BYTES = 900 * 1024;
+
+// Machine-readable failure reasons, so the extension can show targeted
+// remediation steps instead of raw error strings
+const ERROR_CODES = {
+  PORT_IN_USE: 'PORT_IN_USE',               // details: { port }
+  CERT_NOT_GENERATED: 'CERT_NOT_GENERATED', // details: { certPath }
+  CERT_NOT_TRUSTED: 'CERT_NOT_TRUSTED',     // details: { certPath, output }
+  CERT_EXPIRING: 'CERT_EXPIRING',           // details: { certPath, validTo, daysRemaining }
+  CERT_EXPIRED: 'CERT_EXPIRED',             // details: { certPath, validTo }
+  PROXY_CRASHED: 'PROXY_CRASHED',           // details: { pid, logTail }
+  PROXY_START_FAILED: 'PROXY_START_FAILED', // details: { pid, logTail }
+  PROXY_STOP_FAILED: 'PROXY_STOP_FAILED',
+  PROXY_NOT_RUNNING: 'PROXY_NOT_RUNNING',
+  PROXY_UNREACHABLE: 'PROXY_UNREACHABLE',
+  CHROME_NOT_FOUND: 'CHROME_NOT_FOUND',     // details: { browser }
+  DEPS_NOT_INSTALLED: 'DEPS_NOT_INSTALLED',
+  INVALID_SETTINGS: 'INVALID_SETTINGS',
+  INVALID_SOURCES: 'INVALID_SOURCES',
+  FILE_WRITE_FAILED: 'FILE_WRITE_FAILED',   // details: { path }
+  UNSUPPORTED_PLATFORM: 'UNSUPPORTED_PLATFORM',
+  INVALID_MESSAGE: 'INVALID_MESSAGE',
+  UNKNOWN_ACTION: 'UNKNOWN_ACTION',
+  INVALID_CHUNK: 'INVALID_CHUNK',           // details: { transferId, seq, total }
+  INVALID_PROFILE: 'INVALID_PROFILE',       // details: { profile }
+  HOST_ERROR: 'HOST_ERROR'
+};
+
+/**
+ * Build a failure response carrying an error code and remediation details
+ */
+function errorResponse(code, error, details = {}, extra = {}) {
+  return { success: false, ...extra, code, error, details };
+}
+
+const log = logging.getLogger('nativehost');
+
+// Anything printed to stdout would corrupt the protocol, so all logging goes
+// to the host log (rotated host.log -> host.log.1 -> ...)
+logging.configureLogging({ console: false, file: HOST_LOG_FILE, maxBytes: MAX_HOST_LOG_BYTES, maxFiles: MAX_HOST_LOG_FILES });
+logging.captureConsole('nativehost');
+
+process.on('uncaughtException', err => {
+  log.error('Uncaught exception:', err);
+  sendMessage(errorResponse(ERROR_CODES.HOST_ERROR, 'Native host error: ' + err.message));
+});
+
+log.info(`Native host started (node ${process.version}, ${process.platform})`);
+
+// Cleanup to run when Chrome disconnects (e.g. stop the proxy)
+const disconnectHandlers = [];
+let exitOnDisconnectRegistered = false;
+
+/**
+ * Exit once the extension disconnects the port (Chrome closes stdin), after
+ * running any registered cleanup. Long-running actions call this so the host
+ * doesn't outlive the connection.
+ */
+function exitOnDisconnect(handler = null) {
+  if (handler) disconnectHandlers.push(handler);
+  if (exitOnDisconnectRegistered) return;
+  exitOnDisconnectRegistered = true;
+
+  process.stdin.on('end', async () => {
+    log.info('Extension disconnected');
+    for (const cleanup of disconnectHandlers) {
+      try {
+        await cleanup();
+      } catch (err) {
+        log.error('Disconnect cleanup failed:', err);
+      }
+    }
+    process.exit(0);
+  });
+}
+
+// Native messaging uses stdin/stdout for communication. Each message is a
+// 4-byte little-endian length followed by JSON; one read may hold several
+// messages or only part of one, so buffer until a full frame has arrived.
+let pendingInput = Buffer.alloc(0);
+
 process.stdin.on('readable', () => {
-  let input = [];
   let chunk;
-
   while ((chunk = process.stdin.read()) !== null) {
-    input.push(chunk);
+    pendingInput = Buffer.concat([pendingInput, chunk]);
   }
 
-  if (input.length > 0) {
-    const buffer = Buffer.concat(input);
-
-    // First 4 bytes are message length
-    if (buffer.length < 4) return;
+  while (pendingInput.length >= 4) {
+    const msgLength = pendingInput.readUInt32LE(0);
+    if (pendingInput.length < 4 + msgLength) return;
 
-    const msgLength = buffer.readUInt32LE(0);
-    const msgContent = buffer.slice(4, 4 + msgLength).toString();
+    const msgContent = pendingInput.slice(4, 4 + msgLength).toString();
+    pendingInput = pendingInput.slice(4 + msgLength);
 
     try {
       const message = JSON.parse(msgContent);
-      handleMessage(message);
+      if (message.chunked) {
+        receiveChunk(message);
+      } else {
+        handleMessage(message);
+      }
     } catch (err) {
-      sendMessage({ error: 'Invalid message format' });
+      log.error('Invalid message:', err.message);
+      sendMessage(errorResponse(ERROR_CODES.INVALID_MESSAGE, 'Invalid message format', { reason: err.message }));
     }
   }
 });
 
+// Large payloads are split into `{ chunked, transferId, seq, total, data }`
+// frames in both directions; `data` is a slice of the message's JSON text
+const CHUNK_CHARS = Math.floor(MAX_MESSAGE_BYTES / 3); // Worst case after UTF-8 + re-escaping
+const CHUNK_TRANSFER_TIMEOUT_MS = 30000;
+const incomingTransfers = new Map();
+let nextTransferId = 1;
+
+/**
+ * Collect one frame of a chunked request, handling the message once every
+ * sequence number has arrived
+ */
+function receiveChunk({ transferId, seq, total, data }) {
+  const invalid = !transferId || !Number.isInteger(total) || total < 1 ||
+    !Number.isInteger(seq) || seq < 0 || seq >= total || typeof data !== 'string';
+  if (invalid) {
+    sendMessage(errorResponse(ERROR_CODES.INVALID_CHUNK, 'Malformed chunk frame', { transferId, seq, total }));
+    return;
+  }
+
+  let transfer = incomingTransfers.get(transferId);
+  if (!transfer) {
+    transfer = { total, parts: new Array(total), received: 0 };
+    // Abandon transfers whose remaining frames never arrive
+    transfer.timer = setTimeout(() => {
+      incomingTransfers.delete(transferId);
+      sendMessage(errorResponse(ERROR_CODES.INVALID_CHUNK, 'Chunked transfer timed out', {
+        transferId,
+        received: transfer.received,
+        total
+      }));
+    }, CHUNK_TRANSFER_TIMEOUT_MS);
+    incomingTransfers.set(transferId, transfer);
+  }
+
+  if (transfer.total !== total) {
+    clearTimeout(transfer.timer);
+    incomingTransfers.delete(transferId);
+    sendMessage(errorResponse(ERROR_CODES.INVALID_CHUNK, 'Chunk total changed mid-transfer', { transferId, seq, total }));
+    return;
+  }
+
+  if (transfer.parts[seq] === undefined) {
+    transfer.parts[seq] = data;
+    transfer.received++;
+  }
+  if (transfer.received < transfer.total) return;
+
+  clearTimeout(transfer.timer);
+  incomingTransfers.delete(transferId);
+  log.info(`Reassembled chunked transfer ${transferId} (${total} frames)`);
+  handleMessage(JSON.parse(transfer.parts.join('')));
+}
+
 function handleMessage(message) {
+  log.info('Received action:', message.action + (message.profile ? ` (profile ${message.profile})` : ''));
+
+  if (message.profile !== undefined && message.profile !== null) {
+    const profileError = profiles.validateProfileName(message.profile);
+    if (profileError) {
+      sendMessage(errorResponse(ERROR_CODES.INVALID_PROFILE, profileError, { profile: message.profile }, { action: message.action }));
+      return;
+    }
+    selectProfile(message.profile);
+  }
+
   switch (message.action) {
     case 'startProxy':
       startProxy();
@@ -49,11 +243,27 @@ function handleMessage(message) {
       stopProxy();
       break;
 
+    case 'restartProxy':
+      restartProxy();
+      break;
+
     case 'getStatus':
-      sendMessage({
-        running: proxyProcess !== null,
-        pid: proxyProcess?.pid
-      });
+      getProxyHealth().then(withCertTrust).then(health => sendMessage({
+        running: health.state === 'running',
+        ...health
+      }));
+      break;
+
+    case 'heartbeat':
+      getProxyHealth().then(health => sendMessage(heartbeatMessage(health)));
+      break;
+
+    case 'keepalive':
+      keepalive(message);
+      break;
+
+    case 'watchProxy':
+      watchProxy(message);
       break;
 
     case 'ping':
@@ -67,182 +277,1042 @@ function handleMessage(message) {
       });
       break;
 
+    case 'getEvents':
+      getEvents(message.cursor, message.limit);
+      break;
+
+    case 'configure':
+      configure(message.settings || {});
+      break;
+
+    case 'streamLogs':
+      streamLogs(message.lines, message.follow);
+      break;
+
+    case 'getVersion':
+      getVersion(message.checkForUpdate !== false);
+      break;
+
+    case 'trustCert':
+      trustCert({ system: !!message.system }).then(result => sendMessage({ action: 'trustCert', ...result }));
+      break;
+
+    case 'retrustCert':
+      retrustCert().then(result => sendMessage({ action: 'retrustCert', ...result }));
+      break;
+
+    case 'getCertStatus':
+      getCertTrustStatus().then(status => sendMessage({
+        success: true,
+        action: 'getCertStatus',
+        ...status,
+        certificate: trustStore.describeCert(status.certPath)
+      }));
+      break;
+
+    case 'getLogs': {
+      const logFile = message.file === 'proxy' ? PROXY_LOG_FILE : HOST_LOG_FILE;
+      const tail = readLogTail(message.lines || 200, logFile);
+      sendMessage({
+        success: true,
+        action: 'getLogs',
+        logFile,
+        lines: tail.lines,
+        errors: tail.lines.filter(isErrorLine)
+      });
+      break;
+    }
+
+    case 'syncSources':
+      syncSources(message.sources, message.onConflict);
+      break;
+
+    case 'getBrowsers':
+      resolveBrowser(readSettings().browser || {}).then(selected => sendMessage({
+        success: true,
+        action: 'getBrowsers',
+        selected,
+        installed: listInstalledBrowsers()
+      }));
+      break;
+
+    case 'clearEvents':
+      proxyApiRequest('POST', '/clear')
+        .then(result => sendMessage({ success: true, ...result }))
+        .catch(err => sendMessage(errorResponse(ERROR_CODES.PROXY_UNREACHABLE, 'Could not reach proxy: ' + err.message)));
+      break;
+
+    case 'listProfiles':
+      sendMessage({
+        success: true,
+        action: 'listProfiles',
+        current: profiles.currentProfile(),
+        profiles: profiles.listProfiles()
+      });
+      break;
+
+    case 'listInstances':
+      // The default instance and every profile, running or not
+      sendMessage({
+        success: true,
+        action: 'listInstances',
+        current: profiles.currentProfile(),
+        instances: profiles.listInstances()
+      });
+      break;
+
     default:
-      sendMessage({ error: 'Unknown action' });
+      sendMessage(errorResponse(ERROR_CODES.UNKNOWN_ACTION, 'Unknown action', { action: message.action }));
   }
 }
 
-function startProxy() {
-  // Check if proxy is already running
-  exec('/usr/sbin/lsof -i :8888 -i :8889 2>/dev/null | grep LISTEN', (error, stdout) => {
-    if (stdout) {
-      // Proxy already running - stop it first, then restart
-      // Extract PID from lsof output
-      const lines = stdout.trim().split('\n');
-      const pids = new Set();
-
-      lines.forEach(line => {
-        const parts = line.trim().split(/\s+/);
-        if (parts.length > 1) {
-          pids.add(parseInt(parts[1]));
-        }
-      });
+/**
+ * Read the user's proxy settings file (only keys that were set)
+ */
+function readSettings() {
+  try {
+    return JSON.parse(fs.readFileSync(SETTINGS_PATH, 'utf8'));
+  } catch (err) {
+    return {};
+  }
+}
+
+function getPorts() {
+  const settings = readSettings();
+  return {
+    proxyPort: settings.proxyPort || 8888,
+    apiPort: settings.apiPort || 8889
+  };
+}
+
+/**
+ * Validate settings from the extension, returning an error string or null
+ */
+function validateSettings(changes) {
+  for (const key of ['proxyPort', 'apiPort']) {
+    if (key in changes && !(Number.isInteger(changes[key]) && changes[key] > 0 && changes[key] < 65536)) {
+      return `${key} must be a port number`;
+    }
+  }
+  if ('maxEvents' in changes && !(Number.isInteger(changes.maxEvents) && changes.maxEvents > 0)) {
+    return 'maxEvents must be a positive integer';
+  }
+  if ('enabledSources' in changes && changes.enabledSources !== null &&
+      !(Array.isArray(changes.enabledSources) && changes.enabledSources.every(id => typeof id === 'string'))) {
+    return 'enabledSources must be an array of source IDs or null';
+  }
+  if ('bypassHosts' in changes &&
+      !(Array.isArray(changes.bypassHosts) && changes.bypassHosts.every(host => typeof host === 'string'))) {
+    return 'bypassHosts must be an array of hostnames';
+  }
+  if ('tunnelUnmatchedHosts' in changes && typeof changes.tunnelUnmatchedHosts !== 'boolean') {
+    return 'tunnelUnmatchedHosts must be true or false';
+  }
+  if ('captureHeaders' in changes && typeof changes.captureHeaders !== 'boolean') {
+    return 'captureHeaders must be true or false';
+  }
+  if ('redaction' in changes && (typeof changes.redaction !== 'object' || changes.redaction === null)) {
+    return 'redaction must be an object of toggles';
+  }
+  if ('unmatched' in changes && 'skipDomains' in (changes.unmatched || {}) &&
+      !(Array.isArray(changes.unmatched.skipDomains) && changes.unmatched.skipDomains.every(domain => typeof domain === 'string'))) {
+    return 'unmatched.skipDomains must be an array of domain patterns';
+  }
+  if ('browser' in changes && (typeof changes.browser !== 'object' || changes.browser === null)) {
+    return 'browser must be an object with id and/or path';
+  }
+  if ('certificates' in changes && !['rsa', 'ecdsa', undefined].includes((changes.certificates || {}).keyType)) {
+    return 'certificates.keyType must be "rsa" or "ecdsa"';
+  }
+  if ('certificates' in changes && !['file', 'encrypted', 'keychain', undefined].includes((changes.certificates || {}).keyStorage)) {
+    return 'certificates.keyStorage must be "file", "encrypted" or "keychain"';
+  }
+  if ('certificates' in changes && (changes.certificates || {}).keyStorage === 'keychain' && process.platform !== 'darwin') {
+    return 'certificates.keyStorage "keychain" is only supported on macOS';
+  }
+  return null;
+}
+
+/**
+ * Apply settings from the extension: write the settings file, then have a
+ * running proxy reload it (or restart it when ports changed)
+ */
+function configure(changes) {
+  const error = validateSettings(changes);
+  if (error) {
+    sendMessage(errorResponse(ERROR_CODES.INVALID_SETTINGS, error));
+    return;
+  }
+
+  const current = readSettings();
+  const updated = { ...current };
+  CONFIGURABLE_KEYS.forEach(key => {
+    if (!(key in changes)) return;
+    const merge = ['redaction', 'browser', 'certificates', 'unmatched'].includes(key);
+    updated[key] = merge ? { ...current[key], ...changes[key] } : changes[key];
+  });
+
+  try {
+    fs.mkdirSync(path.dirname(SETTINGS_PATH), { recursive: true });
+    fs.writeFileSync(SETTINGS_PATH, JSON.stringify(updated, null, 2));
+  } catch (err) {
+    sendMessage(errorResponse(ERROR_CODES.FILE_WRITE_FAILED, 'Could not write settings: ' + err.message, { path: SETTINGS_PATH }));
+    return;
+  }
+
+  const pid = getProxyPid();
+  if (!pid || !isProcessAlive(pid)) {
+    sendMessage({ success: true, applied: 'saved', settings: updated });
+    return;
+  }
+
+  // Ports and the CA (key type, directory, key storage) are fixed at startup
+  const portsChanged = ['proxyPort', 'apiPort'].some(key => (updated[key] || null) !== (current[key] || null));
+  const caChanged = ['keyType', 'dir', 'keyStorage']
+    .some(key => (updated.certificates || {})[key] !== (current.certificates || {})[key]);
+  if (!portsChanged && !caChanged && process.platform !== 'win32') {
+    process.kill(pid, 'SIGHUP');
+    sendMessage({ success: true, applied: 'reloaded', settings: updated, pid });
+    return;
+  }
+
+  // Restart to re-bind ports or switch CA
+  restartProxyProcess()
+    .then(result => sendMessage({ success: true, applied: 'restarted', settings: updated, pid: result.pid }))
+    .catch(err => sendMessage(errorResponse(err.code || ERROR_CODES.PROXY_START_FAILED, err.message, err.details, { settings: updated })));
+}
+
+/**
+ * Resolve once `check` returns true, or reject after `timeoutMs`
+ */
+function waitFor(check, timeoutMs, intervalMs = 100) {
+  const deadline = Date.now() + timeoutMs;
+  return new Promise((resolve, reject) => {
+    const poll = async () => {
+      if (await check()) {
+        resolve();
+      } else if (Date.now() >= deadline) {
+        reject(new Error('Timed out'));
+      } else {
+        setTimeout(poll, intervalMs);
+      }
+    };
+    poll();
+  });
+}
+
+/**
+ * Check whether something accepts connections on a local port
+ */
+function isPortListening(port) {
+  return new Promise(resolve => {
+    const socket = net.connect({ port, host: '127.0.0.1' });
+    socket.once('connect', () => {
+      socket.destroy();
+      resolve(true);
+    });
+    socket.once('error', () => resolve(false));
+  });
+}
+
+/**
+ * Stop the running proxy (if any), wait for it to exit, start a new one and
+ * wait until both ports accept connections
+ */
+async function restartProxyProcess() {
+  const previousPid = await stopTrackedProxy();
+
+  spawnProxy();
+
+  const { proxyPort, apiPort } = getPorts();
+  try {
+    await waitFor(async () => await isPortListening(proxyPort) && await isPortListening(apiPort), 10000, 200);
+  } catch (err) {
+    const failure = startFailure(proxyProcess.pid);
+    const error = new Error(`Proxy restarted (pid ${proxyProcess.pid}) but ports ${proxyPort}/${apiPort} are not listening`);
+    error.code = failure.code;
+    error.details = failure.details;
+    throw error;
+  }
+
+  return { previousPid, pid: proxyProcess.pid };
+}
+
+/**
+ * Check the proxy process and its ports rather than trusting the PID file
+ * @returns {Promise<object>} - state is running | starting | degraded | stopped
+ */
+async function getProxyHealth() {
+  const pid = getProxyPid();
+  const processAlive = pid !== null && isProcessAlive(pid);
+  const { proxyPort, apiPort } = getPorts();
+  const proxyPortListening = await isPortListening(proxyPort);
+  const apiPortListening = await isPortListening(apiPort);
+
+  let state;
+  if (processAlive && proxyPortListening && apiPortListening) {
+    state = 'running';
+  } else if (processAlive) {
+    state = proxyPortListening || apiPortListening ? 'degraded' : 'starting';
+  } else {
+    state = 'stopped';
+  }
+
+  return { state, pid: processAlive ? pid : null, processAlive, proxyPortListening, apiPortListening };
+}
+
+/**
+ * Add the running proxy's trust watchdog result (GET /healthz) to a health
+ * report, with a CERT_NOT_TRUSTED warning once trust has been lost
+ */
+async function withCertTrust(health) {
+  if (health.state !== 'running') return health;
+
+  let trust;
+  try {
+    ({ trust } = await proxyApiRequest('GET', '/healthz'));
+  } catch (err) {
+    return health;
+  }
+  if (!trust || trust.trusted !== false) return { ...health, certTrust: trust || null };
+
+  return {
+    ...health,
+    certTrust: trust,
+    warnings: [{
+      code: ERROR_CODES.CERT_NOT_TRUSTED,
+      message: `The proxy CA ${trust.present === false ? 'was removed from the trust store' : 'is no longer trusted'}, so HTTPS interception fails. Send "retrustCert" to trust it again.`,
+      details: { certPath: trust.certPath, output: trust.details, lostAt: trust.lostAt, present: trust.present }
+    }]
+  };
+}
+
+/**
+ * Connection-state reply: the host is alive, plus the proxy's own state
+ */
+function heartbeatMessage(health) {
+  return {
+    success: true,
+    action: 'heartbeat',
+    hostAlive: true,
+    hostPid: process.pid,
+    proxyState: health.state,
+    proxy: health,
+    timestamp: new Date().toISOString()
+  };
+}
+
+/**
+ * Send a heartbeat every `intervalMs` while the extension holds the port open.
+ * Missing heartbeats tell the extension the host is gone; the payload tells it
+ * whether the proxy is running. With `stopProxyOnDisconnect`, the proxy is
+ * shut down when Chrome disconnects.
+ */
+function keepalive({ intervalMs = 10000, stopProxyOnDisconnect = false } = {}) {
+  const beat = () => getProxyHealth().then(health => sendMessage(heartbeatMessage(health)));
+
+  beat();
+  setInterval(beat, Math.max(1000, intervalMs));
+
+  exitOnDisconnect(stopProxyOnDisconnect ? async () => {
+    const pid = await stopTrackedProxy();
+    if (pid) log.info(`Stopped proxy ${pid} after extension disconnected`);
+  } : null);
+}
+
+// Auto-restart budget: at most MAX_RESTARTS within RESTART_WINDOW_MS
+const MAX_RESTARTS = 5;
+const RESTART_WINDOW_MS = 60000;
+
+/**
+ * Poll proxy health while the extension keeps the port open, reporting
+ * state transitions and optionally restarting a crashed proxy
+ */
+function watchProxy({ autoRestart = true, intervalMs = 2000 } = {}) {
+  let lastState = null;
+  let restarting = false;
+  const restarts = [];
+
+  exitOnDisconnect();
+
+  const check = async () => {
+    if (restarting) return;
+
+    const health = await getProxyHealth();
+
+    // A proxy we saw running that is now gone has crashed; one that stopped
+    // listening without exiting is hung rather than starting
+    const crashed = lastState && lastState !== 'stopped' && health.state === 'stopped';
+    let state = crashed ? 'crashed' : health.state;
+    if (state === 'starting' && (lastState === 'running' || lastState === 'degraded')) {
+      state = 'degraded';
+    }
+
+    if (state !== lastState) {
+      sendMessage({ action: 'proxyState', ...health, state, previousState: lastState, timestamp: new Date().toISOString() });
+      lastState = state;
+    }
+
+    if (crashed && autoRestart) {
+      const now = Date.now();
+      while (restarts.length > 0 && now - restarts[0] > RESTART_WINDOW_MS) restarts.shift();
+
+      if (restarts.length >= MAX_RESTARTS) {
+        sendMessage({
+          action: 'proxyState',
+          state: 'crashed',
+          autoRestart: false,
+          code: ERROR_CODES.PROXY_CRASHED,
+          error: `Proxy crashed ${MAX_RESTARTS} times in a minute; not restarting`,
+          details: { logTail: readLogTail(20).lines }
+        });
+        lastState = 'stopped';
+        return;
+      }
+
+      restarts.push(now);
+      restarting = true;
+      sendMessage({ action: 'proxyState', state: 'restarting', previousState: 'crashed' });
+      try {
+        const result = await restartProxyProcess();
+        sendMessage({ action: 'proxyState', state: 'running', previousState: 'restarting', pid: result.pid, restarts: restarts.length });
+        lastState = 'running';
+      } catch (err) {
+        sendMessage({ action: 'proxyState', state: 'stopped', previousState: 'restarting', code: err.code, error: err.message, details: err.details });
+        lastState = 'stopped';
+      }
+      restarting = false;
+    }
+  };
+
+  check();
+  setInterval(check, intervalMs);
+}
+
+function restartProxy() {
+  restartProxyProcess()
+    .then(({ previousPid, pid }) => sendMessage({
+      success: true,
+      action: 'restartProxy',
+      message: previousPid ? 'Proxy restarted' : 'Proxy was not running; started it',
+      previousPid,
+      pid
+    }))
+    .catch(err => sendMessage(errorResponse(err.code || ERROR_CODES.PROXY_START_FAILED, err.message, err.details, { action: 'restartProxy' })));
+}
+
+/**
+ * Merge the extension's sources into the proxy's persistent sources file (as
+ * POST /sources does; see config/source-sync.js) and have a running proxy
+ * reload them, without going through the API server
+ */
+async function syncSources(sources, onConflict = 'keep') {
+  const { mergeSources, CONFLICT_STRATEGIES } = await import('../config/source-sync.js');
+  if (!Array.isArray(sources) || !sources.every(src => src && typeof src.id === 'string') ||
+      !CONFLICT_STRATEGIES.includes(onConflict)) {
+    sendMessage(errorResponse(ERROR_CODES.INVALID_SOURCES, `sources must be an array of source configs with an id, and onConflict one of ${CONFLICT_STRATEGIES.join(', ')}`, {}, { action: 'syncSources' }));
+    return;
+  }
+
+  let existing = {};
+  try {
+    existing = JSON.parse(fs.readFileSync(SOURCES_PATH, 'utf8'));
+  } catch (err) {
+    // No sources file yet
+  }
 
-      // Kill existing proxy processes
-      pids.forEach(pid => {
+  // Sources only in the file are kept, and pushed ones keep the capture
+  // stats the proxy has accumulated
+  const merged = mergeSources(existing, sources, { onConflict });
+  const updated = { ...existing, ...merged.sources };
+
+  try {
+    fs.mkdirSync(path.dirname(SOURCES_PATH), { recursive: true });
+    fs.writeFileSync(SOURCES_PATH, JSON.stringify(updated, null, 2));
+  } catch (err) {
+    sendMessage(errorResponse(ERROR_CODES.FILE_WRITE_FAILED, 'Could not write sources: ' + err.message, { path: SOURCES_PATH }, { action: 'syncSources' }));
+    return;
+  }
+
+  const pid = getProxyPid();
+  const reloaded = !!pid && isProcessAlive(pid) && process.platform !== 'win32';
+  if (reloaded) {
+    process.kill(pid, 'SIGHUP');
+  }
+
+  log.info(`Synced ${sources.length} sources, ${merged.conflicts.length} conflicts (proxy ${reloaded ? 'reloaded' : 'not running'})`);
+  sendMessage({
+    success: true,
+    action: 'syncSources',
+    synced: sources.length,
+    added: merged.added,
+    updated: merged.updated,
+    behind: merged.behind,
+    conflicts: merged.conflicts,
+    total: Object.keys(updated).length,
+    // The file's sources, as the extension should now have them
+    sources: Object.entries(updated).map(([id, { checksum, ...source }]) => ({ ...source, id, syncedVersion: source.version || 1 })),
+    reloaded
+  });
+}
+
+/**
+ * PID of the running proxy, from memory or the PID file
+ */
+function getProxyPid() {
+  if (proxyProcess) return proxyProcess.pid;
+
+  if (fs.existsSync(PID_FILE)) {
+    try {
+      return parseInt(fs.readFileSync(PID_FILE, 'utf8')) || null;
+    } catch (err) {
+      // Ignore
+    }
+  }
+  return null;
+}
+
+function isProcessAlive(pid) {
+  try {
+    process.kill(pid, 0);
+    return true;
+  } catch (err) {
+    return err.code === 'EPERM';
+  }
+}
+
+/**
+ * Call the proxy API over its local socket, falling back to the TCP port
+ */
+function proxyApiRequest(method, apiPath, body = null) {
+  const attempt = (target) => new Promise((resolve, reject) => {
+    const req = http.request({ ...target, path: apiPath, method, timeout: 5000 }, (res) => {
+      let data = '';
+      res.on('data', chunk => data += chunk);
+      res.on('end', () => {
         try {
-          process.kill(pid, 'SIGTERM');
+          resolve(JSON.parse(data || '{}'));
         } catch (err) {
-          // Ignore errors
+          reject(new Error('Invalid response from proxy'));
         }
       });
+    });
+    req.on('timeout', () => req.destroy(new Error('Request timed out')));
+    req.on('error', reject);
+    if (body !== null) {
+      req.setHeader('Content-Type', 'application/json');
+      req.write(typeof body === 'string' ? body : JSON.stringify(body));
+    }
+    req.end();
+  });
 
-      // Wait a bit for processes to die, then start fresh
-      setTimeout(() => {
-        actuallyStartProxy();
-      }, 1000);
-    } else {
-      actuallyStartProxy();
+  return attempt({ socketPath: API_SOCKET })
+    .catch(() => attempt({ host: '127.0.0.1', port: getPorts().apiPort }));
+}
+
+/**
+ * Fetch a page of events and send it back in chunks under Chrome's size limit
+ */
+function getEvents(cursor, limit = 500) {
+  const query = new URLSearchParams({ limit: String(limit) });
+  if (cursor) query.set('cursor', cursor);
+
+  proxyApiRequest('GET', `/events?${query}`).then(page => {
+    const chunks = [];
+    let current = [];
+    let currentSize = 0;
+
+    page.events.forEach(event => {
+      const size = Buffer.byteLength(JSON.stringify(event));
+      if (current.length > 0 && currentSize + size > MAX_MESSAGE_BYTES) {
+        chunks.push(current);
+        current = [];
+        currentSize = 0;
+      }
+      current.push(event);
+      currentSize += size;
+    });
+    chunks.push(current);
+
+    chunks.forEach((events, index) => {
+      sendMessage({
+        success: true,
+        action: 'getEvents',
+        events,
+        chunk: index,
+        totalChunks: chunks.length,
+        done: index === chunks.length - 1,
+        nextCursor: page.nextCursor,
+        cursorExpired: page.cursorExpired || false,
+        count: page.count
+      });
+    });
+  }).catch(err => {
+    sendMessage(errorResponse(ERROR_CODES.PROXY_UNREACHABLE, 'Could not reach proxy: ' + err.message));
+  });
+}
+
+function startProxy() {
+  // Restart our own proxy if it's already running; never touch other apps
+  stopTrackedProxy().then(async () => {
+    const { proxyPort, apiPort } = getPorts();
+    for (const port of [proxyPort, apiPort]) {
+      if (await isPortInUse(port)) {
+        sendMessage(errorResponse(
+          ERROR_CODES.PORT_IN_USE,
+          `Port ${port} is in use by another application. Quit it or change the port in proxy settings.`,
+          { port },
+          { port }
+        ));
+        return;
+      }
     }
+    actuallyStartProxy();
+  }).catch(err => {
+    sendMessage(errorResponse(ERROR_CODES.PROXY_STOP_FAILED, 'Could not stop the running proxy: ' + err.message));
   });
 }
 
+/**
+ * Check whether a port can be bound, the same way the proxy will bind it
+ */
+function isPortInUse(port) {
+  return new Promise(resolve => {
+    const probe = net.createServer();
+    probe.once('error', err => resolve(err.code === 'EADDRINUSE' || err.code === 'EACCES'));
+    probe.once('listening', () => probe.close(() => resolve(false)));
+    probe.listen(port);
+  });
+}
+
+/**
+ * Stop the proxy this host started (tracked handle or PID file) and wait for
+ * it to exit. Resolves with the stopped PID, or null if none was running.
+ */
+async function stopTrackedProxy() {
+  const pid = getProxyPid();
+
+  if (pid && isProcessAlive(pid)) {
+    process.kill(pid, 'SIGTERM');
+    try {
+      await waitFor(() => !isProcessAlive(pid), 5000);
+    } catch (err) {
+      process.kill(pid, 'SIGKILL');
+      await waitFor(() => !isProcessAlive(pid), 2000);
+    }
+  }
+
+  proxyProcess = null;
+  if (fs.existsSync(PID_FILE)) {
+    fs.unlinkSync(PID_FILE);
+  }
+
+  return pid && !isProcessAlive(pid) ? pid : null;
+}
+
 function actuallyStartProxy() {
   const projectRoot = path.join(__dirname, '..');
   const depsPath = path.join(projectRoot, 'node_modules', 'http-mitm-proxy');
 
   // Check if dependencies are installed
   if (!fs.existsSync(depsPath)) {
-    sendMessage({
-      success: false,
-      error: 'Dependencies not installed. Please run the setup command again (it includes npm install).',
-      needsSetup: true
-    });
+    sendMessage(errorResponse(
+      ERROR_CODES.DEPS_NOT_INSTALLED,
+      'Dependencies not installed. Please run the setup command again (it includes npm install).',
+      { depsPath },
+      { needsSetup: true }
+    ));
     return;
   }
 
   doStartProxy();
 }
 
-function doStartProxy() {
-  // Clean up stale PID file
-  if (fs.existsSync(PID_FILE)) {
-    try {
-      fs.unlinkSync(PID_FILE);
-    } catch (err) {
-      // Ignore
-    }
-  }
-
-  // Start the MITM proxy (can decrypt HTTPS like Charles)
+/**
+ * Launch the MITM proxy (can decrypt HTTPS like Charles) and record its PID
+ */
+function spawnProxy() {
   const proxyPath = path.join(__dirname, '..', 'proxy-server-mitm.js');
 
+  const logFd = openProxyLog();
+
   proxyProcess = spawn('node', [proxyPath], {
     detached: true,
-    stdio: 'ignore'
+    stdio: ['ignore', 'ignore', logFd],
+    env: { ...process.env, LOGGY_LOG_FILE: PROXY_LOG_FILE }
   });
 
+  fs.closeSync(logFd);
+
   // Save PID for later tracking
+  fs.mkdirSync(path.dirname(PID_FILE), { recursive: true });
   fs.writeFileSync(PID_FILE, proxyProcess.pid.toString());
 
   proxyProcess.unref();
+}
 
-  // Give it a moment to start
-  setTimeout(() => {
-    exec('/usr/sbin/lsof -i :8888 2>/dev/null | grep LISTEN', (err, stdout) => {
-      if (stdout) {
-        // Proxy started - install CA cert and launch Chrome
-        const certPath = path.join(require('os').homedir(), '.http-mitm-proxy', 'certs', 'ca.pem');
-
-        // Wait for cert generation, then install it
-        setTimeout(() => {
-          exec(`security add-trusted-cert -d -r trustRoot -k ~/Library/Keychains/login.keychain-db "${certPath}" 2>&1 | grep -v "already present" || true`, () => {
-            // Get the extension path (parent directory of native-host)
-            const extensionPath = path.join(__dirname, '..');
-
-            // Launch Chrome with extension loaded
-            const chromeCommand = `/Applications/Google\\ Chrome.app/Contents/MacOS/Google\\ Chrome --proxy-server="http://127.0.0.1:8888" --user-data-dir="/tmp/chrome-proxy-profile" --load-extension="${extensionPath}" --ignore-certificate-errors > /dev/null 2>&1 &`;
-
-            exec(chromeCommand, (launchErr) => {
-              if (launchErr) {
-                sendMessage({
-                  success: true,
-                  message: 'MITM Proxy started, but could not auto-launch Chrome.',
-                  pid: proxyProcess.pid
-                });
-              } else {
-                sendMessage({
-                  success: true,
-                  message: 'MITM Proxy started! Extension loaded. Can now intercept HTTPS.',
-                  pid: proxyProcess.pid,
-                  autoLaunched: true
-                });
-              }
-            });
-          });
-        }, 1500); // Wait for cert generation
-      } else {
-        sendMessage({
-          success: false,
-          error: 'Proxy failed to start.'
-        });
-      }
-    });
-  }, 500);
+/**
+ * Report the installed version and whether a newer release exists
+ */
+function getVersion(checkUpdate) {
+  const info = getVersionInfo();
+
+  if (!checkUpdate) {
+    sendMessage({ success: true, ...info });
+    return;
+  }
+
+  checkForUpdate(info.version)
+    .then(update => sendMessage({ success: true, ...info, ...update }))
+    .catch(err => sendMessage({ success: true, ...info, updateAvailable: null, updateCheckError: err.message }));
 }
 
-function stopProxy() {
-  // Try to read PID from file if we don't have it in memory
-  let pid = proxyProcess ? proxyProcess.pid : null;
+/**
+ * Whether the configured CA exists and is trusted by the OS store Chrome uses
+ */
+function getCertTrustStatus() {
+  return trustStore.getTrustStatus(trustStore.getCaCert(readSettings()));
+}
 
-  if (!pid && fs.existsSync(PID_FILE)) {
-    try {
-      pid = parseInt(fs.readFileSync(PID_FILE, 'utf8'));
-    } catch (err) {
-      // Ignore
+/**
+ * Warning for a CA that has expired (with autoRenew off) or expires soon
+ * @returns {object|null} - { code, message, details }
+ */
+function getCertExpiryWarning() {
+  const settings = readSettings();
+  const { certPath } = trustStore.getCaCert(settings);
+  const info = trustStore.describeCert(certPath);
+  if (!info) return null;
+
+  const warningDays = (settings.certificates || {}).expiryWarningDays || 30;
+  if (info.expired) {
+    return {
+      code: ERROR_CODES.CERT_EXPIRED,
+      message: `CA certificate expired on ${info.validTo}. Run "loggy-proxy cert rotate".`,
+      details: { certPath, validTo: info.validTo }
+    };
+  }
+  if (info.daysRemaining < warningDays) {
+    return {
+      code: ERROR_CODES.CERT_EXPIRING,
+      message: `CA certificate expires in ${info.daysRemaining} days. Run "loggy-proxy cert rotate".`,
+      details: { certPath, validTo: info.validTo, daysRemaining: info.daysRemaining }
+    };
+  }
+  return null;
+}
+
+/**
+ * One-shot re-trust: through the running proxy, so its watchdog (and
+ * /healthz) see the result straight away, or directly when it isn't running
+ */
+async function retrustCert() {
+  const health = await getProxyHealth();
+  if (health.state !== 'running') {
+    return trustCert();
+  }
+
+  try {
+    const result = await proxyApiRequest('POST', '/certificates/trust');
+    if (result.trusted === false) {
+      return errorResponse(
+        ERROR_CODES.CERT_NOT_TRUSTED,
+        'Could not trust the CA certificate' + (result.retrustOutput ? `: ${result.retrustOutput}` : ''),
+        { certPath: result.certPath, output: result.retrustOutput },
+        { trust: result }
+      );
     }
+    return { success: true, trust: result };
+  } catch (err) {
+    return errorResponse(ERROR_CODES.PROXY_UNREACHABLE, 'Could not reach proxy: ' + err.message);
+  }
+}
+
+/**
+ * Install the CA as a trusted root and report exactly what happened
+ * (`system`: macOS System keychain, after an administrator password dialog)
+ */
+async function trustCert({ system = false } = {}) {
+  const ca = trustStore.getCaCert(readSettings());
+  const { certPath } = ca;
+  const before = await getCertTrustStatus();
+  if (!before.generated) {
+    return errorResponse(
+      ERROR_CODES.CERT_NOT_GENERATED,
+      'CA certificate not generated yet. Start the proxy once to create it.',
+      { certPath },
+      before
+    );
+  }
+  // Trusted for this user doesn't mean trusted for everyone, so always
+  // install when the System keychain was asked for
+  if (before.trusted && !system) {
+    return { success: true, ...before, alreadyTrusted: true };
   }
 
-  if (!pid) {
-    sendMessage({ success: false, error: 'No proxy PID found. Proxy may not be running.' });
-    return;
+  const result = await trustStore.addTrust(ca, { system });
+  if (!result.supported) {
+    return errorResponse(
+      ERROR_CODES.UNSUPPORTED_PLATFORM,
+      result.output,
+      { platform: process.platform, certPath },
+      before
+    );
+  }
+
+  const output = result.output;
+  const after = await getCertTrustStatus();
+  if (!after.trusted) {
+    return errorResponse(
+      ERROR_CODES.CERT_NOT_TRUSTED,
+      output || `Trust command exited with code ${result.code}`,
+      { certPath, output },
+      { ...after, output }
+    );
   }
+  return { success: true, ...after, output };
+}
+
+/**
+ * Describe why a freshly spawned proxy isn't serving: it exited (crashed)
+ * or is still alive but never started listening
+ */
+function startFailure(pid) {
+  const details = { pid, logTail: readLogTail(20).lines };
+  if (pid && !isProcessAlive(pid)) {
+    return errorResponse(ERROR_CODES.PROXY_CRASHED, 'Proxy exited during startup. See the log tail for the cause.', details);
+  }
+  return errorResponse(ERROR_CODES.PROXY_START_FAILED, 'Proxy failed to start.', details);
+}
 
+/**
+ * Open the proxy log for the proxy's stderr (crashes), which the proxy also
+ * writes and rotates itself (logFile)
+ */
+function openProxyLog() {
+  fs.mkdirSync(path.dirname(PROXY_LOG_FILE), { recursive: true });
+  return fs.openSync(PROXY_LOG_FILE, 'a');
+}
+
+/**
+ * Read the last `count` lines of a log file
+ */
+function readLogTail(count, logFile = PROXY_LOG_FILE) {
   try {
-    process.kill(pid, 'SIGTERM');
-    proxyProcess = null;
+    const stat = fs.statSync(logFile);
+    // Lines are short; reading the last 256 bytes per line is plenty
+    const length = Math.min(stat.size, count * 256);
+    const buffer = Buffer.alloc(length);
+    const fd = fs.openSync(logFile, 'r');
+    fs.readSync(fd, buffer, 0, length, stat.size - length);
+    fs.closeSync(fd);
+
+    const lines = buffer.toString('utf8').split('\n').filter(Boolean);
+    if (length < stat.size) lines.shift(); // First line may be partial
+    return { lines: lines.slice(-count), offset: stat.size };
+  } catch (err) {
+    return { lines: [], offset: 0 };
+  }
+}
+
+function isErrorLine(line) {
+  return /error|failed|EADDRINUSE|EACCES/i.test(line);
+}
+
+/**
+ * Send recent proxy log lines (and the errors among them); with `follow`,
+ * keep sending new lines until the extension disconnects
+ */
+function streamLogs(count = 200, follow = false) {
+  const tail = readLogTail(count);
+  let offset = tail.offset;
+
+  sendMessage({
+    success: true,
+    action: 'streamLogs',
+    logFile: PROXY_LOG_FILE,
+    lines: tail.lines,
+    errors: tail.lines.filter(isErrorLine),
+    done: !follow
+  });
+
+  if (!follow) return;
+
+  exitOnDisconnect();
+
+  setInterval(() => {
+    let size;
+    try {
+      size = fs.statSync(PROXY_LOG_FILE).size;
+    } catch (err) {
+      return;
+    }
+    if (size < offset) offset = 0; // Rotated
+    if (size === offset) return;
+
+    const length = Math.min(size - offset, MAX_MESSAGE_BYTES);
+    const buffer = Buffer.alloc(length);
+    const fd = fs.openSync(PROXY_LOG_FILE, 'r');
+    fs.readSync(fd, buffer, 0, length, offset);
+    fs.closeSync(fd);
+
+    // Only send complete lines; the rest is picked up next time
+    const text = buffer.toString('utf8');
+    const lastNewline = text.lastIndexOf('\n');
+    if (lastNewline === -1) return;
+    offset += Buffer.byteLength(text.slice(0, lastNewline + 1));
+
+    const lines = text.slice(0, lastNewline).split('\n').filter(Boolean);
+    sendMessage({
+      success: true,
+      action: 'streamLogs',
+      lines,
+      errors: lines.filter(isErrorLine),
+      done: false
+    });
+  }, 1000);
+}
+
+/**
+ * Open the configured, default or first installed Chromium browser with the
+ * proxy flags and this extension loaded
+ * @returns {Promise<object>} - The launched browser ({ id, name, path, source })
+ */
+async function launchBrowser(proxyPort) {
+  const browser = await resolveBrowser(readSettings().browser || {});
+  const fail = message => Object.assign(new Error(message), { browser });
+
+  if (!browser.path) {
+    throw fail('No Chromium-based browser was found. Set browser.path in proxy settings or open one manually with the proxy.');
+  }
+  if (path.isAbsolute(browser.path) && !fs.existsSync(browser.path)) {
+    throw fail(`Browser not found at ${browser.path}`);
+  }
+
+  // Get the extension path (parent directory of native-host)
+  const extensionPath = path.join(__dirname, '..');
+
+  const child = spawn(browser.path, [
+    `--proxy-server=http://127.0.0.1:${proxyPort}`,
+    `--user-data-dir=${BROWSER_PROFILE_DIR}`,
+    `--load-extension=${extensionPath}`,
+    '--ignore-certificate-errors'
+  ], { detached: true, stdio: 'ignore' });
+
+  await new Promise((resolve, reject) => {
+    child.once('error', err => reject(fail(`Could not launch ${browser.name}: ${err.message}`)));
+    child.once('spawn', resolve);
+  });
+  child.unref();
+
+  log.info(`Launched ${browser.name} (${browser.source}) from ${browser.path}`);
+  return browser;
+}
 
-    // Clean up PID file
-    if (fs.existsSync(PID_FILE)) {
+function doStartProxy() {
+  // Clean up stale PID file
+  if (fs.existsSync(PID_FILE)) {
+    try {
       fs.unlinkSync(PID_FILE);
+    } catch (err) {
+      // Ignore
     }
+  }
 
-    // Verify it stopped
+  spawnProxy();
+  const { proxyPort } = getPorts();
+
+  // Wait for the proxy to accept connections
+  waitFor(() => isPortListening(proxyPort), 5000, 200).then(() => {
+    // Proxy started - install CA cert and launch the browser
+    // Wait for cert generation, then install it
     setTimeout(() => {
-      exec('/usr/sbin/lsof -i :8888 2>/dev/null | grep LISTEN', (err, stdout) => {
-        if (!stdout) {
-          sendMessage({ success: true, message: 'Proxy server stopped successfully' });
-        } else {
-          sendMessage({ success: false, error: 'Proxy may still be running' });
+      trustCert().then(certResult => {
+        // Partial successes the extension can offer a fix for
+        const warnings = [];
+        if (!certResult.trusted) {
+          warnings.push({ code: certResult.code || ERROR_CODES.CERT_NOT_TRUSTED, message: certResult.error, details: certResult.details });
+        }
+        const expiryWarning = getCertExpiryWarning();
+        if (expiryWarning) {
+          warnings.push(expiryWarning);
         }
+
+        const started = { success: true, pid: proxyProcess.pid, certTrusted: certResult.trusted, warnings };
+
+        launchBrowser(proxyPort).then(browser => {
+          sendMessage({
+            ...started,
+            message: `MITM Proxy started! Extension loaded in ${browser.name}. Can now intercept HTTPS.`,
+            autoLaunched: true,
+            browser
+          });
+        }).catch(err => {
+          warnings.push({ code: ERROR_CODES.CHROME_NOT_FOUND, message: err.message, details: { browser: err.browser } });
+          sendMessage({ ...started, message: 'MITM Proxy started, but could not auto-launch a browser.' });
+        });
       });
-    }, 300);
-  } catch (err) {
-    // Process doesn't exist - that's fine, it's already stopped
-    if (err.code === 'ESRCH') {
-      // Clean up PID file
-      if (fs.existsSync(PID_FILE)) {
-        fs.unlinkSync(PID_FILE);
-      }
-      sendMessage({ success: true, message: 'Proxy was already stopped' });
+    }, 1500); // Wait for cert generation
+  }).catch(() => {
+    sendMessage(startFailure(proxyProcess && proxyProcess.pid));
+  });
+}
+
+function stopProxy() {
+  if (!getProxyPid()) {
+    sendMessage(errorResponse(ERROR_CODES.PROXY_NOT_RUNNING, 'No proxy PID found. Proxy may not be running.'));
+    return;
+  }
+
+  stopTrackedProxy().then(pid => {
+    if (pid) {
+      sendMessage({ success: true, message: 'Proxy server stopped successfully' });
     } else {
-      sendMessage({ success: false, error: 'Failed to stop proxy: ' + err.message });
+      sendMessage({ success: true, message: 'Proxy was already stopped' });
     }
-  }
+  }).catch(err => {
+    sendMessage(errorResponse(ERROR_CODES.PROXY_STOP_FAILED, 'Failed to stop proxy: ' + err.message));
+  });
 }
 
 function sendMessage(message) {
-  const buffer = Buffer.from(JSON.stringify(message));
+  if (message.success === false || (message.error && message.success === undefined)) {
+    log.error(`${message.action || 'response'} failed${message.code ? ` [${message.code}]` : ''}:`, message.error);
+  }
+
+  const json = JSON.stringify(message);
+  if (Buffer.byteLength(json) <= MAX_MESSAGE_BYTES) {
+    writeFrame(json);
+    return;
+  }
+
+  // Too big for one message: send it as numbered slices of the JSON text
+  const parts = [];
+  for (let start = 0; start < json.length;) {
+    let end = Math.min(start + CHUNK_CHARS, json.length);
+    // Don't split a surrogate pair across frames
+    const code = json.charCodeAt(end - 1);
+    if (end < json.length && code >= 0xd800 && code <= 0xdbff) end--;
+    parts.push(json.slice(start, end));
+    start = end;
+  }
+
+  const transferId = `h${process.pid}-${nextTransferId++}`;
+  log.info(`Sending ${message.action || 'response'} as ${parts.length} chunks (transfer ${transferId})`);
+  parts.forEach((data, seq) => {
+    writeFrame(JSON.stringify({
+      chunked: true,
+      transferId,
+      action: message.action,
+      seq,
+      total: parts.length,
+      data
+    }));
+  });
+}
+
+function writeFrame(json) {
+  const buffer = Buffer.from(json);
   const header = Buffer.alloc(4);
   header.writeUInt32LE(buffer.length, 0);
 
@@ -252,15 +1322,7 @@ function sendMessage(message) {
 
 // Handle process termination
 process.on('SIGTERM', () => {
-  let pid = proxyProcess ? proxyProcess.pid : null;
-
-  if (!pid && fs.existsSync(PID_FILE)) {
-    try {
-      pid = parseInt(fs.readFileSync(PID_FILE, 'utf8'));
-    } catch (err) {
-      // Ignore
-    }
-  }
+  const pid = getProxyPid();
 
   if (pid) {
     try {
@@ -0,0 +1,85 @@
+// Native Messaging Helpers
+// Chunked transfers to and from the proxy native host (native-host/proxy-host.cjs)
+
+// Chrome rejects host -> extension messages over 1MB; the host splits anything
+// larger into { chunked, transferId, seq, total, data } frames, where `data`
+// is a slice of the original message's JSON text. The same framing works in
+// the other direction for large requests (e.g. syncSources).
+export const MAX_MESSAGE_BYTES = 900 * 1024;
+const CHUNK_CHARS = Math.floor(MAX_MESSAGE_BYTES / 3);
+
+let nextTransferId = 1;
+
+/**
+ * Reassembles chunked frames into the original messages
+ */
+export class ChunkAssembler {
+  constructor() {
+    this.transfers = new Map();
+  }
+
+  /**
+   * Accept a message from the host
+   * @param {object} frame - Message received on the port
+   * @returns {object|null} - The complete message, or null while chunks are outstanding
+   */
+  accept(frame) {
+    if (!frame || !frame.chunked) return frame;
+
+    const { transferId, seq, total, data } = frame;
+    let transfer = this.transfers.get(transferId);
+    if (!transfer) {
+      transfer = { total, parts: new Array(total), received: 0 };
+      this.transfers.set(transferId, transfer);
+    }
+
+    if (transfer.parts[seq] === undefined) {
+      transfer.parts[seq] = data;
+      transfer.received++;
+    }
+    if (transfer.received < transfer.total) return null;
+
+    this.transfers.delete(transferId);
+    return JSON.parse(transfer.parts.join(''));
+  }
+}
+
+/**
+ * Listen on a native port, receiving chunked responses as whole messages
+ * @param {chrome.runtime.Port} port - Port from chrome.runtime.connectNative
+ * @param {Function} callback - Called with each complete message
+ */
+export function addChunkedListener(port, callback) {
+  const assembler = new ChunkAssembler();
+  port.onMessage.addListener(frame => {
+    const message = assembler.accept(frame);
+    if (message) callback(message);
+  });
+}
+
+/**
+ * Post a message, splitting it into chunk frames if it's too large
+ * @param {chrome.runtime.Port} port - Port from chrome.runtime.connectNative
+ * @param {object} message - Request for the host
+ */
+export function postChunked(port, message) {
+  const json = JSON.stringify(message);
+  if (new TextEncoder().encode(json).length <= MAX_MESSAGE_BYTES) {
+    port.postMessage(message);
+    return;
+  }
+
+  const parts = [];
+  for (let start = 0; start < json.length;) {
+    let end = Math.min(start + CHUNK_CHARS, json.length);
+    const code = json.charCodeAt(end - 1);
+    if (end < json.length && code >= 0xd800 && code <= 0xdbff) end--;
+    parts.push(json.slice(start, end));
+    start = end;
+  }
+
+  const transferId = `x${Date.now()}-${nextTransferId++}`;
+  parts.forEach((data, seq) => {
+    port.postMessage({ chunked: true, transferId, action: message.action, seq, total: parts.length, data });
+  });
+}
@@ -597,6 +597,7 @@ class AnalyticsLoggerUI {
       if (syncResponse.ok) {
         const result = await syncResponse.json();
         console.log(`[Panel] ✅ Synced ${result.synced} sources to proxy`);
+        await this.applySyncResult(result);
       } else {
         console.error('[Panel] Failed to sync sources to proxy:', syncResponse.status);
       }
@@ -605,6 +606,22 @@ class AnalyticsLoggerUI {
     }
   }
 
+  /**
+   * Adopt the proxy's merged sources (which include edits made with
+   * "loggy-proxy sources" or in its sources file) and report conflicts:
+   * sources changed on both sides, with the copy that was kept
+   */
+  async applySyncResult(result) {
+    for (const conflict of result.conflicts || []) {
+      console.warn(`[Panel] Source "${conflict.id}" was changed here and in the proxy (${conflict.fields.join(', ')}); kept the ${conflict.kept === 'proxy' ? 'proxy\'s' : 'extension\'s'} copy`);
+    }
+    if (!Array.isArray(result.sources)) return;
+    const response = await chrome.runtime.sendMessage({ action: 'applySyncedSources', sources: result.sources });
+    if (response && response.adopted > 0) {
+      console.log(`[Panel] Adopted ${response.adopted} sources from the proxy`);
+    }
+  }
+
   connectToBackground() {
     // Create long-lived connection for real-time updates
     this.port = chrome.runtime.connect({ name: 'analytics-logger-panel' });
@@ -1249,11 +1266,17 @@ class AnalyticsLoggerUI {
     const structuredDisplay = savedViewMode === 'structured' ? '' : 'display: none;';
     const rawDisplay = savedViewMode === 'raw' ? '' : 'display: none;';
     const toggleBtnText = savedViewMode === 'raw' ? 'Show Structured View' : 'Show Raw JSON';
+    // Events the proxy's severity rules flagged stand out (see proxy/severity.js)
+    const severity = ['warn', 'error'].includes(event._severity) ? event._severity : null;
+    const issues = event._issues || [];
 
     return `
-      <div class="event-card" data-id="${eventId}">
+      <div class="event-card${severity ? ` severity-${severity}` : ''}" data-id="${eventId}">
         <div class="event-header">
           <div class="event-name">${this.escapeHtml(event.event || 'Unknown Event')}</div>
+          ${severity ? `
+            <span class="severity-badge severity-${severity}" title="${this.escapeHtml(issues.map(issue => issue.message).join('\n')).replace(/"/g, '&quot;')}">${severity}</span>
+          ` : ''}
           <span class="event-badge" style="background: ${sourceColor}20; color: ${sourceColor}; border: 1px solid ${sourceColor}40;">
             ${this.escapeHtml(sourceName)}
           </span>
@@ -1282,6 +1305,14 @@ class AnalyticsLoggerUI {
 
           <!-- Structured View -->
           <div class="structured-view" data-event-id="${eventId}" style="${structuredDisplay}">
+            ${issues.length > 0 ? `
+              <div class="event-section">
+                <div class="event-section-title">Issues</div>
+                <ul class="event-issues">
+                  ${issues.map(issue => `<li class="severity-${issue.level}">${this.escapeHtml(issue.message)} <span class="event-issue-rule">${this.escapeHtml(issue.rule)}</span></li>`).join('')}
+                </ul>
+              </div>
+            ` : ''}
             ${event.properties && Object.keys(event.properties).length > 0 ? `
               <div class="event-section">
                 <div class="event-section-title">Properties</div>
@@ -45,6 +45,7 @@ export class SourceManager {
       sourceName: document.getElementById('sourceName'),
       sourceDomain: document.getElementById('sourceDomain'),
       sourceUrlPattern: document.getElementById('sourceUrlPattern'),
+      sourceAliases: document.getElementById('sourceAliases'),
       sourceColor: document.getElementById('sourceColor'),
       sourceEnabled: document.getElementById('sourceEnabled'),
       fieldEventName: document.getElementById('fieldEventName'),
@@ -237,7 +238,7 @@ export class SourceManager {
         <div class="pending-color-dot"></div>
         <div class="pending-info">
           <div class="pending-domain">${pending.domain}</div>
-          <div class="pending-meta">${pending.count} event${pending.count !== 1 ? 's' : ''} • ${timeAgo}</div>
+          <div class="pending-meta">${pending.count} event${pending.count !== 1 ? 's' : ''} • ${timeAgo}${pending.suggestedSource ? ` • looks like ${pending.suggestedSource.name.split(' (')[0]}` : ''}</div>
         </div>
         <button class="btn btn-primary btn-small pending-add-btn">Add</button>
         <button class="pending-dismiss-btn" title="Dismiss">×</button>
@@ -386,7 +387,7 @@ export class SourceManager {
           <div class="source-name">${source.name}</div>
           <div class="source-meta">
             <span class="source-badge ${source.createdBy}">${source.createdBy}</span>
-            <span class="source-domain">${source.domain || 'No domain'}</span>
+            <span class="source-domain">${source.domain || (source.signature ? 'Any host (by request signature)' : 'No domain')}</span>
           </div>
           <div class="source-stats">
             ${eventsCount.toLocaleString()} events captured
@@ -407,6 +408,7 @@ export class SourceManager {
       this.elements.sourceName.value = source.name;
       this.elements.sourceDomain.value = source.domain || '';
       this.elements.sourceUrlPattern.value = source.urlPattern || '';
+      this.elements.sourceAliases.value = (source.aliases || []).join(', ');
       this.elements.sourceColor.value = source.color;
       this.elements.sourceEnabled.checked = source.enabled;
 
@@ -451,6 +453,7 @@ export class SourceManager {
       this.elements.sourceName.value = '';
       this.elements.sourceDomain.value = '';
       this.elements.sourceUrlPattern.value = '';
+      this.elements.sourceAliases.value = '';
       this.elements.sourceColor.value = '#6366F1';
       this.elements.sourceEnabled.checked = true;
       this.resetFieldPickers();
@@ -507,6 +510,7 @@ export class SourceManager {
     this.elements.sourceName.value = this.humanizeDomain(domain);
     this.elements.sourceDomain.value = domain;
     this.elements.sourceUrlPattern.value = '';
+    this.elements.sourceAliases.value = '';
     this.elements.sourceColor.value = this.generateColor(domain);
     this.elements.sourceEnabled.checked = true;
     this.elements.sourceStats.style.display = 'none';
@@ -526,6 +530,19 @@ export class SourceManager {
           console.log('[SourceManager] No payload found');
           this.resetFieldPickers();
         }
+        // A first-party proxy of a known vendor: prefill its name, look and mappings
+        const suggested = pending?.suggestedSource;
+        if (suggested) {
+          this.currentSource = { icon: suggested.icon };
+          this.elements.sourceName.value = suggested.name;
+          this.elements.sourceColor.value = suggested.color;
+          for (const [field, path] of Object.entries(suggested.fieldMappings || {})) {
+            const input = { eventName: 'fieldEventName', timestamp: 'fieldTimestamp', userId: 'fieldUserId' }[field];
+            if (!input) continue;
+            this.elements[input].value = path;
+            this.updateFieldPickerDisplay(field, path);
+          }
+        }
       } else {
         this.resetFieldPickers();
       }
@@ -561,7 +578,8 @@ export class SourceManager {
   async saveSource() {
     const domain = this.elements.sourceDomain.value.trim().toLowerCase();
 
-    if (!domain) {
+    // Signature sources (e.g. server-side GTM) match on any host
+    if (!domain && !this.currentSource?.signature) {
       alert('Please enter a domain');
       return;
     }
@@ -581,6 +599,11 @@ export class SourceManager {
     // Get URL pattern (optional)
     const urlPattern = this.elements.sourceUrlPattern.value.trim();
 
+    // Other hosts or endpoints of the same source (optional)
+    const aliases = this.elements.sourceAliases.value.split(',')
+      .map(alias => alias.trim().toLowerCase())
+      .filter(Boolean);
+
     // Generate unique ID for endpoint configurations to avoid collisions
     let sourceId;
     if (this.editingSourceId) {
@@ -610,6 +633,12 @@ export class SourceManager {
     if (urlPattern) {
       sourceData.urlPattern = urlPattern;
     }
+    if (aliases.length > 0) {
+      sourceData.aliases = aliases;
+    }
+    if (this.currentSource?.signature) {
+      sourceData.signature = this.currentSource.signature;
+    }
 
     try {
       const action = this.editingSourceId ? 'updateSource' : 'addSource';
@@ -742,6 +771,7 @@ export class SourceManager {
       if (syncResponse.ok) {
         const result = await syncResponse.json();
         console.log(`[SourceManager] Synced ${result.synced} sources to proxy`);
+        await this.applySyncResult(result);
       }
     } catch (err) {
       // Silently fail if proxy not running
@@ -749,6 +779,23 @@ export class SourceManager {
     }
   }
 
+  /**
+   * Adopt the proxy's merged sources (which include edits made with
+   * "loggy-proxy sources" or in its sources file) and report conflicts:
+   * sources changed on both sides, with the copy that was kept
+   */
+  async applySyncResult(result) {
+    for (const conflict of result.conflicts || []) {
+      console.warn(`[SourceManager] Source "${conflict.id}" was changed here and in the proxy (${conflict.fields.join(', ')}); kept the ${conflict.kept === 'proxy' ? 'proxy\'s' : 'extension\'s'} copy`);
+    }
+    if (!Array.isArray(result.sources)) return;
+    const response = await chrome.runtime.sendMessage({ action: 'applySyncedSources', sources: result.sources });
+    if (response && response.adopted > 0) {
+      console.log(`[SourceManager] Adopted ${response.adopted} sources from the proxy`);
+      await this.loadSources();
+    }
+  }
+
   // Helper functions for auto-generating source info
   humanizeDomain(domain) {
     let name = domain.replace(/\.(com|org|io|co|net)$/, '');
@@ -2,7 +2,14 @@
 // Auto-detects event structure with user-configurable field mappings
 // Supports nested paths and propertyContainer for envelope-style payloads
 
+import { decodeAppMeasurement, decodeMsgpack, decodeProtobuf } from './body-decoders.js';
+
 export class AnalyticsParser {
+  // Current time (epoch ms) and event ID source; proxy/clock.js replaces them
+  // so tests and replays get deterministic events
+  static clock = () => Date.now();
+  static idGenerator = null;
+
   // Field paths to search for auto-detection (supports nested paths)
   // Ordered by priority: most common/standard patterns first
   static FIELD_PATHS = {
@@ -57,6 +64,18 @@ export class AnalyticsParser {
       'event_data.context.user_id',
       'event_data.user_id',
       'data.user_id'
+    ],
+    // The vendor's own event ID, used as the event's id so it can be
+    // looked up in the vendor's debugger (and retries share one id)
+    eventId: [
+      'messageId',        // Segment, RudderStack
+      'message_id',
+      'insert_id',        // Amplitude
+      '$insert_id',       // Mixpanel
+      'properties.$insert_id',
+      'event_id',
+      'eventId',
+      'uuid'              // PostHog
     ]
   };
 
@@ -64,7 +83,51 @@ export class AnalyticsParser {
   static PROPERTY_CONTAINERS = ['properties', 'props', 'event_data', 'data', 'payload', 'params', 'attributes'];
 
   // Array field detection - where batched events might be stored
-  static EVENT_ARRAY_FIELDS = ['batch', 'events', 'data', 'items', 'records', 'messages'];
+  // ("e": Amplitude's legacy browser SDK form upload)
+  static EVENT_ARRAY_FIELDS = ['batch', 'events', 'data', 'items', 'records', 'messages', 'e'];
+
+  // Compressed bodies that decompress past this are not parsed (decompression bombs)
+  static MAX_DECOMPRESSED_BYTES = 4 * 1024 * 1024;
+
+  // Body format by media type (Content-Type without parameters); "+json"
+  // types are JSON too, and anything else is sniffed (see decodeBody)
+  static BODY_FORMATS = {
+    'application/json': 'json',
+    'text/json': 'json',
+    'application/x-ndjson': 'ndjson',
+    'application/ndjson': 'ndjson',
+    'application/jsonl': 'ndjson',
+    'application/jsonlines': 'ndjson',
+    'application/x-jsonlines': 'ndjson',
+    'application/x-www-form-urlencoded': 'form',
+    'application/x-protobuf': 'protobuf',
+    'application/protobuf': 'protobuf',
+    'application/vnd.google.protobuf': 'protobuf',
+    'application/msgpack': 'msgpack',
+    'application/x-msgpack': 'msgpack',
+    'application/vnd.msgpack': 'msgpack'
+  };
+
+  // GA4 web hits (gtag.js): shared parameters in the query string, one event per body line
+  static GA4_COLLECT_PATH = /\/g\/collect$/;
+
+  // Firebase Analytics app uploads (app-measurement.com/a): protobuf batches
+  static APP_MEASUREMENT_URL = /^app-measurement\.com\/a$/;
+
+  // Firebase Installations API: installations/{fid} and its auth tokens
+  static FIREBASE_INSTALLATIONS_HOST = 'firebaseinstallations.googleapis.com';
+
+  // GA4 hit parameters copied into an event's context
+  static GA4_CONTEXT_PARAMS = {
+    tid: 'measurementId',
+    sid: 'sessionId',
+    sct: 'sessionCount',
+    dl: 'pageLocation',
+    dt: 'pageTitle',
+    dr: 'pageReferrer',
+    ul: 'language',
+    sr: 'screenResolution'
+  };
 
   /**
    * Main parsing function - smart auto-detection (async for decompression)
@@ -75,13 +138,19 @@ export class AnalyticsParser {
    */
   static async parseRequest(url, requestBody, initiator, source = null) {
     try {
-      const data = await this.decodeRequestBodyAsync(requestBody);
+      let data = await this.decodeRequestBodyAsync(requestBody, url);
+      if (!data && this.isGa4Collect(url)) {
+        // A hit without a body is one event, described by the query string
+        data = this.decodeGa4Collect(url, '');
+      }
 
       if (!data || typeof data !== 'object') {
         return [];
       }
 
-      const events = this.parsePayload(data, source?.fieldMappings || {});
+      const events = data._truncatedBody
+        ? [this.truncatedEvent(data._truncatedBody)]
+        : this.parsePayload(data, source?.fieldMappings || {});
 
       // Add metadata, source info, and raw payload to all events
       return events.map(event => ({
@@ -92,7 +161,7 @@ export class AnalyticsParser {
         _sourceColor: source?.color || '#6366F1',
         _rawPayload: data,  // Store original payload for "show raw" and field pickers
         _metadata: {
-          capturedAt: new Date().toISOString(),
+          capturedAt: AnalyticsParser.now(),
           url: url,
           initiator: initiator
         }
@@ -165,7 +234,10 @@ export class AnalyticsParser {
     const eventName = this.extractField(item, 'eventName', fieldMappings);
 
     // Extract timestamp using configured path or auto-detect
-    const timestamp = this.extractField(item, 'timestamp', fieldMappings) || new Date().toISOString();
+    const timestamp = this.extractField(item, 'timestamp', fieldMappings) || AnalyticsParser.now();
+
+    // Vendor event ID (random when the payload has none)
+    const eventId = this.extractField(item, 'eventId', fieldMappings);
 
     // Extract userId using configured path or auto-detect
     const userId = this.extractField(item, 'userId', fieldMappings) ||
@@ -209,7 +281,7 @@ export class AnalyticsParser {
     }
 
     return {
-      id: this.generateId(),
+      id: (typeof eventId === 'string' && eventId) || typeof eventId === 'number' ? String(eventId) : this.generateId(),
       timestamp: this.normalizeTimestamp(timestamp),
       event: eventName || 'unknown',
       properties: properties,
@@ -301,17 +373,24 @@ export class AnalyticsParser {
    * Normalize timestamp to ISO string
    */
   static normalizeTimestamp(timestamp) {
-    if (!timestamp) return new Date().toISOString();
+    if (!timestamp) return AnalyticsParser.now();
 
     // Already ISO string
     if (typeof timestamp === 'string' && timestamp.includes('T')) {
       return timestamp;
     }
 
-    // Unix timestamp (seconds or milliseconds)
+    // Numeric strings ("1767268800000000", e.g. GA4's timestamp_micros)
+    if (typeof timestamp === 'string' && /^\d+(\.\d+)?$/.test(timestamp)) {
+      timestamp = Number(timestamp);
+    }
+
+    // Unix timestamp (seconds, milliseconds or microseconds)
     if (typeof timestamp === 'number') {
-      // If less than a reasonable year (2000), assume it's in seconds
-      const ms = timestamp < 10000000000 ? timestamp * 1000 : timestamp;
+      // If less than a reasonable year (2000), assume it's in seconds;
+      // past year 5000 in milliseconds, assume microseconds
+      let ms = timestamp < 10000000000 ? timestamp * 1000 : timestamp;
+      if (ms > 100000000000000) ms = ms / 1000;
       return new Date(ms).toISOString();
     }
 
@@ -319,7 +398,7 @@ export class AnalyticsParser {
     try {
       return new Date(timestamp).toISOString();
     } catch {
-      return new Date().toISOString();
+      return AnalyticsParser.now();
     }
   }
 
@@ -366,22 +445,59 @@ export class AnalyticsParser {
 
   /**
    * Decompress bytes using DecompressionStream API
+   * Stops reading past maxBytes and returns { tooLarge: true } instead
    */
-  static async decompressBytes(bytes, format) {
+  static async decompressBytes(bytes, format, maxBytes = this.MAX_DECOMPRESSED_BYTES) {
     try {
-      const stream = new Response(bytes).body.pipeThrough(new DecompressionStream(format));
-      const decompressed = await new Response(stream).arrayBuffer();
-      return new Uint8Array(decompressed);
+      const reader = new Response(bytes).body.pipeThrough(new DecompressionStream(format)).getReader();
+      const chunks = [];
+      let total = 0;
+      for (;;) {
+        const { done, value } = await reader.read();
+        if (done) break;
+        total += value.length;
+        if (total > maxBytes) {
+          await reader.cancel();
+          return { tooLarge: true };
+        }
+        chunks.push(value);
+      }
+
+      const decompressed = new Uint8Array(total);
+      let offset = 0;
+      for (const chunk of chunks) {
+        decompressed.set(chunk, offset);
+        offset += chunk.length;
+      }
+      return decompressed;
     } catch (e) {
-      console.log('[AnalyticsParser] [DEBUG] Decompression failed:', e.message);
+      console.debug('[AnalyticsParser] Decompression failed:', e.message);
       return null;
     }
   }
 
+  /**
+   * Stand-in event for a body that decompressed past MAX_DECOMPRESSED_BYTES,
+   * so the request still shows up (marked _truncated) without being parsed
+   * @param {object} details - { encoding, compressedBytes, maxDecompressedBytes }
+   */
+  static truncatedEvent(details) {
+    return {
+      id: this.generateId(),
+      timestamp: AnalyticsParser.now(),
+      event: '(body too large)',
+      properties: { ...details },
+      context: {},
+      userId: null,
+      type: 'track',
+      _truncated: true
+    };
+  }
+
   /**
    * Decode request body from various formats (async for decompression support)
    */
-  static async decodeRequestBodyAsync(requestBody) {
+  static async decodeRequestBodyAsync(requestBody, url = null) {
     if (!requestBody) return null;
 
     // If already an object, return it
@@ -422,29 +538,160 @@ export class AnalyticsParser {
       // Check for compression and decompress if needed
       const compression = this.detectCompression(allBytes);
       if (compression) {
-        console.log('[AnalyticsParser] [DEBUG] Detected compression:', compression);
+        console.debug('[AnalyticsParser] Detected compression:', compression);
         const decompressed = await this.decompressBytes(allBytes, compression);
+        if (decompressed && decompressed.tooLarge) {
+          console.debug('[AnalyticsParser] Decompressed body is larger than', this.MAX_DECOMPRESSED_BYTES, 'bytes; not parsing it');
+          return {
+            _truncatedBody: { encoding: compression, compressedBytes: totalLength, maxDecompressedBytes: this.MAX_DECOMPRESSED_BYTES }
+          };
+        }
         if (decompressed) {
           allBytes = decompressed;
-          console.log('[AnalyticsParser] [DEBUG] Decompressed:', totalLength, '->', allBytes.length, 'bytes');
+          console.debug('[AnalyticsParser] Decompressed:', totalLength, '->', allBytes.length, 'bytes');
         }
       }
 
-      const decoder = new TextDecoder('utf-8');
-      const text = decoder.decode(allBytes);
-
-      // Try to parse as JSON
+      // webRequest doesn't expose request headers, so the format is sniffed
       try {
-        return JSON.parse(text);
+        return this.decodeBody(allBytes, null, url).data;
       } catch {
-        // Return as string if not JSON
-        return text;
+        // Return as string if it doesn't decode
+        return new TextDecoder('utf-8').decode(allBytes);
       }
     }
 
     return null;
   }
 
+  /**
+   * Media type of a Content-Type header, without parameters such as charset
+   * ("application/json; charset=utf-8" -> "application/json")
+   */
+  static mediaType(contentType) {
+    return String(contentType || '').split(';')[0].trim().toLowerCase();
+  }
+
+  /**
+   * Decode a (decompressed) request body by its Content-Type
+   *
+   * The media type picks the format: JSON, NDJSON (one event per line), form
+   * (URL-encoded; JSON-looking values are parsed), protobuf or MessagePack.
+   * Without a known type (none, text/plain as sendBeacon sends, octet-stream)
+   * the text is sniffed for JSON, NDJSON or form data, and is returned as
+   * "text" if it is none of them. GA4 /g/collect hits, Firebase Analytics
+   * uploads and Firebase Installations calls are recognised by URL.
+   * @param {Uint8Array} bytes - Body bytes
+   * @param {string} contentType - Content-Type header (optional)
+   * @param {string} url - Request URL (optional)
+   * @returns {object} - { format, data }; throws if the body is not valid for the declared type
+   */
+  static decodeBody(bytes, contentType = null, url = null) {
+    if (url && this.isGa4Collect(url)) {
+      return { format: 'ga4-collect', data: this.decodeGa4Collect(url, new TextDecoder('utf-8').decode(bytes)) };
+    }
+    if (url && this.isAppMeasurement(url)) {
+      return { format: 'app-measurement', data: decodeAppMeasurement(bytes) };
+    }
+    if (url && this.isFirebaseInstallations(url)) {
+      const text = new TextDecoder('utf-8').decode(bytes);
+      return { format: 'firebase-installations', data: this.decodeFirebaseInstallations(url, this.sniffJson(text) || {}) };
+    }
+
+    const type = this.mediaType(contentType);
+    const format = this.BODY_FORMATS[type] || (type.endsWith('+json') ? 'json' : null);
+    if (format === 'protobuf') return { format, data: decodeProtobuf(bytes) };
+    if (format === 'msgpack') return { format, data: decodeMsgpack(bytes) };
+
+    const text = new TextDecoder('utf-8').decode(bytes);
+    if (format === 'json') return { format, data: JSON.parse(text) };
+    if (format === 'ndjson') return { format, data: this.decodeNdjson(text) };
+    if (format === 'form') {
+      // jQuery and others label JSON bodies as form data by default
+      const json = this.sniffJson(text);
+      return json !== undefined ? { format: 'json', data: json } : { format, data: this.decodeForm(text) };
+    }
+    return this.sniffBody(text);
+  }
+
+  /**
+   * Format of a body without a known media type
+   * @returns {object} - { format, data }
+   */
+  static sniffBody(text) {
+    const json = this.sniffJson(text);
+    if (json !== undefined) return { format: 'json', data: json };
+
+    const lines = text.split(/\r?\n/).filter(line => line.trim());
+    if (lines.length > 1 && lines.every(line => line.trim().startsWith('{'))) {
+      try {
+        return { format: 'ndjson', data: this.decodeNdjson(text) };
+      } catch {
+        // Not NDJSON after all
+      }
+    }
+
+    if (/^[^\s=&]+=[^\s&]*(&[^\s=&]+=[^\s&]*)*$/.test(text.trim())) {
+      return { format: 'form', data: this.decodeForm(text.trim()) };
+    }
+    return { format: 'text', data: text };
+  }
+
+  /**
+   * The JSON value of a body that looks like an object or array, else undefined
+   */
+  static sniffJson(text) {
+    const trimmed = text.trim();
+    if (!trimmed.startsWith('{') && !trimmed.startsWith('[')) return undefined;
+    try {
+      return JSON.parse(trimmed);
+    } catch {
+      return undefined;
+    }
+  }
+
+  /**
+   * One JSON value per line -> array
+   */
+  static decodeNdjson(text) {
+    return text.split(/\r?\n/).filter(line => line.trim()).map(line => JSON.parse(line));
+  }
+
+  /**
+   * URL-encoded fields -> object; repeated fields become arrays, and values
+   * that are JSON objects or arrays (e=[{...}]) are parsed
+   */
+  static decodeForm(text) {
+    const params = new URLSearchParams(text);
+    const data = {};
+    for (const key of new Set(params.keys())) {
+      const values = params.getAll(key).map(raw => {
+        const json = this.sniffJson(raw);
+        return json !== undefined ? json : raw;
+      });
+      data[key] = values.length === 1 ? values[0] : values;
+    }
+    return data;
+  }
+
+  /**
+   * Stand-in event for a matched request whose body could not be parsed, so
+   * unreadable payloads show up (marked _parseError) instead of vanishing
+   * @param {object} details - { contentType, contentEncoding, error, body (a preview), bodyBytes }
+   */
+  static parseErrorEvent(details) {
+    return {
+      id: this.generateId(),
+      timestamp: AnalyticsParser.now(),
+      event: '__parse_error',
+      properties: { ...details },
+      context: {},
+      userId: null,
+      type: 'track',
+      _parseError: true
+    };
+  }
+
   /**
    * Decode request body from various formats (sync version, no decompression)
    */
@@ -501,11 +748,124 @@ export class AnalyticsParser {
     return null;
   }
 
+  /**
+   * Whether a URL is a GA4 web collect request (…/g/collect)
+   */
+  static isGa4Collect(url) {
+    try {
+      return this.GA4_COLLECT_PATH.test(new URL(url).pathname);
+    } catch {
+      return false;
+    }
+  }
+
+  /**
+   * Turn a GA4 /g/collect request into a payload parsePayload understands
+   *
+   * gtag.js batches events: parameters shared by every event (measurement
+   * ID, client ID, page, session) go in the query string, and each line of
+   * the body holds one event's own parameters (en=scroll&epn.percent_scrolled=90).
+   * A hit with no body is a single event described by the query string.
+   * Event parameters (ep.* as text, epn.* as numbers) become the event's
+   * params; user properties (up.*, upn.*) go in context.userProperties.
+   * @param {string} url - Request URL
+   * @param {string} text - Request body
+   * @returns {object} - { events: [{ name, params, user_id, anonymousId, context }] }
+   */
+  static decodeGa4Collect(url, text) {
+    const shared = new URL(url).searchParams;
+    const lines = text.split(/\r?\n/).filter(line => line.trim());
+
+    const events = (lines.length > 0 ? lines : ['']).map(line => {
+      const hit = new Map(shared);
+      for (const [key, value] of new URLSearchParams(line)) {
+        hit.set(key, value);
+      }
+
+      const params = {};
+      const userProperties = {};
+      const context = {};
+      for (const [key, value] of hit) {
+        if (key.startsWith('ep.')) params[key.slice(3)] = value;
+        else if (key.startsWith('epn.')) params[key.slice(4)] = Number(value);
+        else if (key.startsWith('up.')) userProperties[key.slice(3)] = value;
+        else if (key.startsWith('upn.')) userProperties[key.slice(4)] = Number(value);
+        else if (key === '_et') params.engagement_time_msec = Number(value);
+        else if (this.GA4_CONTEXT_PARAMS[key]) context[this.GA4_CONTEXT_PARAMS[key]] = value;
+      }
+      if (Object.keys(userProperties).length > 0) context.userProperties = userProperties;
+
+      return {
+        name: hit.get('en') || 'unknown',
+        params,
+        user_id: hit.get('uid') || null,
+        anonymousId: hit.get('cid') || null,
+        context
+      };
+    });
+
+    return { events };
+  }
+
+  /**
+   * Whether a URL is a Firebase Analytics upload (app-measurement.com/a)
+   */
+  static isAppMeasurement(url) {
+    try {
+      const urlObj = new URL(url);
+      return this.APP_MEASUREMENT_URL.test(urlObj.hostname + urlObj.pathname);
+    } catch {
+      return false;
+    }
+  }
+
+  /**
+   * Whether a URL is a Firebase Installations API call
+   */
+  static isFirebaseInstallations(url) {
+    try {
+      return new URL(url).hostname === this.FIREBASE_INSTALLATIONS_HOST;
+    } catch {
+      return false;
+    }
+  }
+
+  /**
+   * Turn a Firebase Installations API call into a payload parsePayload
+   * understands: one event, "firebase_installation" when an app registers
+   * (…/installations) or "firebase_auth_token" when it refreshes its token
+   * (…/installations/{fid}/authTokens:generate), with the request body as
+   * params and the installation ID (fid) as anonymousId
+   * @param {string} url - Request URL
+   * @param {object} body - Request body (JSON)
+   * @returns {object} - { events: [{ name, params, anonymousId, context }] }
+   */
+  static decodeFirebaseInstallations(url, body) {
+    const match = new URL(url).pathname.match(/\/projects\/([^/]+)\/installations(?:\/([^/]+))?/);
+    const [, projectId = null, fid = null] = match || [];
+    return {
+      events: [{
+        name: /authTokens:generate$/.test(new URL(url).pathname) ? 'firebase_auth_token' : 'firebase_installation',
+        params: body,
+        anonymousId: body.fid || fid,
+        context: { projectId, appId: body.appId || null }
+      }]
+    };
+  }
+
+  /**
+   * Current time as an ISO string (from AnalyticsParser.clock)
+   */
+  static now() {
+    return new Date(AnalyticsParser.clock()).toISOString();
+  }
+
   /**
    * Generate unique ID
    */
   static generateId() {
-    return `${Date.now()}-${Math.random().toString(36).substring(2, 9)}`;
+    if (AnalyticsParser.idGenerator) return AnalyticsParser.idGenerator();
+    return `${AnalyticsParser.clock()}-${Math.random().toString(36).substring(2, 9)}`;
   }
 
   // ============================================
@@ -7,178 +7,1007 @@
 
 import { Proxy as MitmProxy } from 'http-mitm-proxy';
 import http from 'http';
-import zlib from 'zlib';
+import net from 'net';
+import fs from 'fs';
+import path from 'path';
 import { AnalyticsParser } from './parsers.js';
-import { ConfigManagerNode, SourceConfig, looksLikeAnalyticsEndpoint } from './config/config-manager-node.js';
+import { ConfigManagerNode, SourceConfig, isSkippedDomain, looksLikeAnalyticsHost } from './config/config-manager-node.js';
+import { DEFAULT_UNMATCHED_SKIP_DOMAINS } from './config/default-sources.js';
+import { loadProxySettings, saveProxySettings, resolvePath, PROFILE_PATHS } from './config/proxy-settings.js';
+import { SinkManager } from './proxy/sinks/index.js';
+import { AlertManager } from './proxy/alerts.js';
+import { redactEvent, redactHeaders } from './proxy/redaction.js';
+import { CertificateAuthority, caDirs, resolvePassphrase } from './proxy/certificate-authority.js';
+import { LeafCertificateCache, leafCacheDir } from './proxy/leaf-cache.js';
+import { checkCAExpiry, renewCA } from './proxy/cert-tools.js';
+import { TrustWatchdog } from './proxy/trust-watchdog.js';
+import { PinningDetector, matchesHost } from './proxy/pinning-detector.js';
+import versionInfo from './proxy/version.cjs';
+import profiles from './config/profile.cjs';
+import logging from './proxy/logger.cjs';
+import { GENERATED_HEADER } from './proxy/event-generator.js';
+import { bodyPayload, inflateBody, queryRequest, uncategorizedEvent } from './proxy/request-body.js';
+import { ParsePool } from './proxy/parse-pool.js';
+import { FixtureRecorder } from './proxy/fixtures.js';
+import { EventStore } from './proxy/event-store.js';
+import { BufferPool } from './proxy/buffer-pool.js';
+import { HostMatchCache } from './proxy/host-match-cache.js';
+import { PayloadStats } from './proxy/payload-stats.js';
+import { DropCounter } from './proxy/drop-counter.js';
+import { StageTimings } from './proxy/stage-timings.js';
+import { formatTimestamp } from './proxy/timestamps.js';
+import { applyClockEnvironment, newRequestId, now } from './proxy/clock.js';
+import { formatAddress, listenOnMore } from './proxy/listeners.js';
+import { checkFunnel, validateFunnel } from './proxy/funnels.js';
+import { AnomalyDetector } from './proxy/anomaly-detector.js';
+import { RateLimiter } from './proxy/rate-limiter.js';
+import { RawRequestStore } from './proxy/raw-requests.js';
+import { PinnedEvents } from './proxy/pinned-events.js';
+import { SeverityRules, severityCounts } from './proxy/severity.js';
+import { CONFLICT_STRATEGIES } from './config/source-sync.js';
+import { EventWaiters, parseWaitRequest } from './proxy/event-waiters.js';
+import { propertyStats } from './proxy/property-stats.js';
+import { compareEnvironments } from './proxy/environment-compare.js';
+import { pageHeaders, pageTimeline } from './proxy/page-timeline.js';
+import { CdpCapture } from './proxy/cdp-capture.js';
+import { PcapCapture } from './proxy/pcap-capture.js';
+import { WebSocketCapture } from './proxy/websocket-capture.js';
+import { authorizeApiRequest, reachesThisMachine, validateApiKeys } from './proxy/api-keys.js';
+
+const log = logging.getLogger('proxy');
+const apiLog = logging.getLogger('api');
+
+// Most recent error, reported by GET /status
+let lastError = null;
 
 /**
- * Decompress body if needed based on Content-Encoding
+ * Log an error and remember it as the last one
+ * @param {string} message - What failed (logged as "[MITM Proxy] <message>: <error>")
+ * @param {Error} err
  */
-function decompressBody(bodyBuffer, encoding) {
-  if (!encoding) return bodyBuffer.toString('utf-8');
+function recordError(message, err) {
+  log.error(`${message}:`, err.message);
+  lastError = { message: `${message}: ${err.message}`, at: new Date().toISOString() };
+}
 
-  try {
-    if (encoding === 'gzip') {
-      return zlib.gunzipSync(bodyBuffer).toString('utf-8');
-    } else if (encoding === 'deflate') {
-      return zlib.inflateSync(bodyBuffer).toString('utf-8');
-    } else if (encoding === 'br') {
-      return zlib.brotliDecompressSync(bodyBuffer).toString('utf-8');
-    }
-  } catch (err) {
-    console.error('[MITM Proxy] Decompression failed:', err.message);
-  }
-  return bodyBuffer.toString('utf-8');
+/**
+ * Recorder for raw-request fixtures (fixtures.record), or null
+ */
+function createFixtureRecorder(settings) {
+  if (!settings.fixtures.record) return null;
+  const dir = resolvePath(settings.fixtures.dir);
+  log.info(`Recording matched requests as fixtures in ${dir}`);
+  return new FixtureRecorder({ dir, maxPerSource: settings.fixtures.maxPerSource });
+}
+
+/**
+ * Hosts never tracked as unmatched: the built-in list (unless turned off)
+ * plus unmatched.skipDomains
+ */
+function unmatchedSkipDomains(settings) {
+  const { skipDomains = [], defaultSkipDomains = true } = settings.unmatched;
+  return defaultSkipDomains ? [...DEFAULT_UNMATCHED_SKIP_DOMAINS, ...skipDomains] : [...skipDomains];
 }
 
-const PROXY_PORT = 8888;
-const API_PORT = 8889;
+// Events from requests no source captured, in promiscuous mode (not a
+// configured source: it can't be edited, disabled or matched)
+const UNCATEGORIZED_SOURCE = new SourceConfig('uncategorized', {
+  name: 'Uncategorized',
+  icon: '❔',
+  color: '#9E9E9E',
+  createdBy: 'proxy'
+});
+const PROMISCUOUS_METHODS = ['POST', 'PUT'];
+
+// Per profile, so several profiles can run side by side
+const API_SOCKET = PROFILE_PATHS.apiSocket;
+
+/**
+ * Apply the logging settings (logLevel, logFormat, logFile, logRotation)
+ */
+function applyLogging(settings) {
+  logging.configureLogging({
+    level: settings.logLevel,
+    format: settings.logFormat,
+    file: settings.logFile ? resolvePath(settings.logFile) : null,
+    maxBytes: settings.logRotation.maxBytes,
+    maxFiles: settings.logRotation.maxFiles,
+    // Parents that log to a file (the native host, --inline) discard stdout
+    console: !settings.logFile || process.stdout.isTTY === true
+  });
+}
+
+// Shared modules log with console calls ("[Sinks] ...")
+logging.captureConsole('proxy');
+
+// The environment's logging applies while the settings load, so --quiet,
+// --log-format json and the log file cover that message too
+applyLogging({
+  logLevel: process.env.LOGGY_LOG_LEVEL,
+  logFormat: process.env.LOGGY_LOG_FORMAT,
+  logFile: process.env.LOGGY_LOG_FILE,
+  logRotation: {}
+});
+
+// Events lost to a full buffer, parse queue or sink, per source (GET /stats, /metrics)
+const drops = new DropCounter();
+// Time spent per stage of intercepted requests (GET /stats, /metrics)
+const timings = new StageTimings();
+// Matched request bodies that did not parse, per source (GET /stats, /metrics)
+const parseErrors = new Map(); // source ID -> { id, name, count, lastError }
+const recordSinkDrop = (sinkName, event) => drops.record(`sink:${sinkName}`, event._source);
+
+// Proxy settings and event sinks (file, forwarders, ...)
+let settings = loadProxySettings();
+applyLogging(settings);
+let sinks = SinkManager.fromSettings(settings, recordSinkDrop);
+let alerts = new AlertManager(settings.alerts);
+let fixtureRecorder = createFixtureRecorder(settings);
+let parsePool = new ParsePool(settings.parsing);
+// Clients of GET /anomalies/stream (server-sent events)
+const anomalyStreams = new Set();
+const anomalies = new AnomalyDetector(settings.anomalies, anomaly => {
+  log.warn(`Anomaly (${anomaly.type}): ${anomaly.message} [${anomaly.source}]`);
+  for (const stream of anomalyStreams) {
+    stream.write(`event: anomaly\ndata: ${JSON.stringify(anomaly)}\n\n`);
+  }
+});
+const eventWaiters = new EventWaiters(); // Pending POST /events/wait requests
+const rateLimiter = new RateLimiter(settings.rateLimits, now); // Per-source capture limits
+const rawRequests = new RawRequestStore(settings.reprocess); // Requests behind buffered events, for POST /reprocess
+const pinnedEvents = new PinnedEvents(settings.maxPinnedEvents, now); // Survive /clear and eviction (GET /events/pinned)
+// Stamps each captured event's _severity (GET /severity)
+const severityRules = new SeverityRules(settings.severity, problem => log.warn(`Skipping ${problem}`));
+const bodyPool = new BufferPool(); // Request bodies being collected or parsed
+const payloadStats = new PayloadStats(); // Request body sizes per source, for GET /stats
+
+const PROXY_PORT = settings.proxyPort;
+const API_PORT = settings.apiPort;
+const LISTEN_HOSTS = settings.listenHosts.length > 0 ? settings.listenHosts : ['0.0.0.0'];
+// "mitm", "cdp" or "pcap" (captureBackend; changing it needs a restart)
+const CAPTURE_BACKEND = ['cdp', 'pcap'].includes(settings.captureBackend) ? settings.captureBackend : 'mitm';
 
 // Store captured events
-const capturedEvents = [];
-const MAX_EVENTS = 1000;
+const capturedEvents = new EventStore(settings.maxEvents, {
+  dropPolicy: settings.dropPolicy,
+  onDrop: event => drops.record('buffer', event._source)
+});
+let capturedTotal = 0; // Since startup, including events dropped from the buffer; also the last _sequence
+
+// Reported by GET /status, so the CLI and extension can tell what is running
+const VERSION = versionInfo.getVersionInfo();
+
+// LOGGY_TEST_CLOCK / LOGGY_TEST_IDS: deterministic times and IDs for tests
+const TEST_CLOCK = applyClockEnvironment();
+
+// Tags every event captured by this run, so exports can pick one session
+const SESSION_ID = AnalyticsParser.now().replace(/[:.]/g, '-');
 
 // Initialize configuration manager
-const configManager = new ConfigManagerNode();
+const configManager = new ConfigManagerNode(PROFILE_PATHS.sourcesPath);
 configManager.load();
+configManager.setEnabledSourceIds(settings.enabledSources);
+configManager.setUnmatchedSkipDomains(unmatchedSkipDomains(settings));
 
-console.log('[MITM Proxy] Loaded', configManager.getAllSources().length, 'analytics sources');
+log.info('Loaded', configManager.getAllSources().length, 'analytics sources');
+if (settings.promiscuous.enabled) {
+  log.warn('Promiscuous mode: capturing every POST/PUT body as "uncategorized" events');
+}
+checkApiKeys(settings);
+if (TEST_CLOCK) {
+  log.warn('LOGGY_TEST_CLOCK/LOGGY_TEST_IDS set: capture times and generated IDs are fixed, for tests');
+}
+
+/**
+ * Log a broken apiKeys setting (remote API clients are refused until it's fixed)
+ */
+function checkApiKeys(settings) {
+  const problem = validateApiKeys(settings.apiKeys);
+  if (problem) log.error(`${problem}; refusing API requests from beyond loopback`);
+}
+
+/**
+ * Re-read proxy settings and sources (SIGHUP from the native host after
+ * "configure" or "syncSources"). Ports only change on restart; everything
+ * else applies immediately.
+ */
+async function reloadSettings() {
+  const previousSinks = sinks;
+  settings = loadProxySettings();
+  applyLogging(settings);
+  checkApiKeys(settings);
+  configManager.reload();
+  configManager.setEnabledSourceIds(settings.enabledSources);
+  configManager.setUnmatchedSkipDomains(unmatchedSkipDomains(settings));
+  hostMatchCache.clear();
+  capturedEvents.dropPolicy = settings.dropPolicy;
+  capturedEvents.resize(settings.maxEvents);
+  pinnedEvents.resize(settings.maxPinnedEvents);
+  sinks = SinkManager.fromSettings(settings, recordSinkDrop);
+  alerts = new AlertManager(settings.alerts);
+  fixtureRecorder = createFixtureRecorder(settings);
+  const previousPool = parsePool;
+  parsePool = new ParsePool(settings.parsing);
+  trustWatchdog.configure(settings.certificates.trustWatchdog);
+  anomalies.configure(settings.anomalies);
+  rateLimiter.configure(settings.rateLimits);
+  rawRequests.configure(settings.reprocess);
+  severityRules.configure(settings.severity);
+  websocketCapture.configure(settings.websockets);
+  await previousSinks.close();
+  await previousPool.close();
+  log.info('Reloaded settings');
+}
 
 // Create MITM proxy
 const proxy = new MitmProxy();
 
 proxy.onError((ctx, err) => {
-  console.error('[MITM Proxy] Error:', err.message);
+  recordError('Error', err);
+});
+
+// Key type and certificate directory are fixed for the life of the process
+// (changing them needs a restart)
+const CA_DIRS = caDirs(settings);
+const usesEcdsaCA = settings.certificates.keyType === 'ecdsa';
+const caPassphrase = usesEcdsaCA && settings.certificates.keyStorage === 'encrypted'
+  ? await resolvePassphrase(CA_DIRS.ecdsa)
+  : null;
+
+// An expired CA fails every TLS handshake; replace it before anything signs with it
+let caRenewedAt = null;
+const caExpiry = checkCAExpiry(settings);
+if (caExpiry.status === 'expired' && settings.certificates.autoRenew) {
+  log.warn(`CA expired on ${caExpiry.validTo}; generating a new one`);
+  renewCA(settings, { passphrase: caPassphrase });
+  caRenewedAt = new Date().toISOString();
+  log.warn('Trust the new CA (native host "trustCert") before browsing');
+} else if (caExpiry.status === 'expired') {
+  log.warn(`CA expired on ${caExpiry.validTo}; HTTPS interception will fail. Run "loggy-proxy cert rotate".`);
+} else if (caExpiry.status === 'expiring') {
+  log.warn(`CA expires in ${caExpiry.daysRemaining} days (${caExpiry.validTo}). Run "loggy-proxy cert rotate" to replace it.`);
+}
+
+const certificateAuthority = usesEcdsaCA
+  ? CertificateAuthority.load(CA_DIRS.ecdsa, { keyStorage: settings.certificates.keyStorage, passphrase: caPassphrase })
+  : null;
+if (!certificateAuthority && settings.certificates.keyStorage !== 'file') {
+  log.warn(`certificates.keyStorage "${settings.certificates.keyStorage}" only applies to the ECDSA CA; http-mitm-proxy reads the RSA key unencrypted from disk`);
+}
+if (certificateAuthority) {
+  certificateAuthority.install(proxy);
+}
+const CA_CERT_PATH = certificateAuthority ? certificateAuthority.certPath : path.join(CA_DIRS.rsa, 'certs', 'ca.pem');
+
+// Reuse signed per-host certificates instead of re-signing on every CONNECT
+const leafCacheSettings = settings.certificates.leafCache;
+const leafCache = new LeafCertificateCache({
+  maxEntries: leafCacheSettings.maxEntries,
+  dir: leafCacheSettings.disk ? leafCacheDir(settings.certificates.keyType, CA_DIRS.base) : null,
+  timings
 });
+leafCache.install(proxy);
+timings.installHandshakeTiming(proxy);
+
+// Count clients that reject our certificates (pinning) per host
+const pinningDetector = new PinningDetector();
+pinningDetector.install(proxy);
+
+// Notice when the CA in use stops being trusted (e.g. removed by MDM)
+const trustWatchdog = new TrustWatchdog(
+  { certificates: { keyType: certificateAuthority ? 'ecdsa' : 'rsa', dir: CA_DIRS.base } },
+  settings.certificates.trustWatchdog
+);
+// Nothing is signed with the CA unless intercepting
+if (CAPTURE_BACKEND === 'mitm') {
+  trustWatchdog.start();
+}
+
+// Hosts worth intercepting: a source could match them, or they look like an
+// analytics collector whose endpoints can be suggested as a source
+const hostMatchCache = new HostMatchCache(hostname =>
+  configManager.canMatchHost(hostname) || looksLikeAnalyticsHost(hostname));
 
 /**
- * Parse events using shared AnalyticsParser and enrich with source metadata
+ * Whether a proxied request or CONNECT is for this proxy's API. Relayed, it
+ * would arrive from loopback and skip the API key check, so it is refused.
+ * @returns {Promise<boolean>}
  */
-function parseEventFromSource(source, data, fullUrl) {
-  // Use shared AnalyticsParser for parsing
-  const events = AnalyticsParser.parsePayload(data, source.fieldMappings || {});
+async function targetsApi(hostname, port) {
+  if (Number(port) !== API_PORT || !(await reachesThisMachine(hostname))) return false;
+  log.warn(`Refused to relay a request to the proxy API (${hostname}:${port})`);
+  return true;
+}
 
-  // Enrich events with source metadata
-  return events.map(event => ({
+proxy.onConnect((req, socket, head, callback) => {
+  const separator = req.url.lastIndexOf(':');
+  const port = req.url.slice(separator + 1);
+  if (Number(port) !== API_PORT) {
+    return routeConnect(req, socket, head, callback);
+  }
+  targetsApi(req.url.slice(0, separator), port).then(refused => {
+    if (refused) {
+      socket.end('HTTP/1.1 403 Forbidden\r\n\r\n');
+    } else {
+      routeConnect(req, socket, head, callback);
+    }
+  });
+});
+
+// Tunnel bypassed hosts, and hosts no source can match (unless in
+// promiscuous mode), straight through, without a MITM certificate
+function routeConnect(req, socket, head, callback) {
+  const [hostname, port] = req.url.split(':');
+  const tunnel = settings.bypassHosts.some(pattern => matchesHost(hostname, pattern)) ||
+    (settings.tunnelUnmatchedHosts && !settings.promiscuous.enabled && !hostMatchCache.shouldIntercept(hostname));
+  if (!tunnel) {
+    return callback();
+  }
+
+  const upstream = net.connect({ host: hostname, port: parseInt(port, 10) || 443, allowHalfOpen: true }, () => {
+    socket.write('HTTP/1.1 200 OK\r\n\r\n', () => {
+      if (head && head.length) upstream.write(head);
+      upstream.pipe(socket);
+      socket.pipe(upstream);
+    });
+  });
+  upstream.on('error', err => {
+    recordError(`Tunnel to ${req.url} failed`, err);
+    socket.destroy();
+  });
+  socket.on('close', () => upstream.end());
+}
+
+/**
+ * Enrich a parsed event with source metadata
+ * @param {string} requestId - ID of the intercepted request that carried it
+ *   (shared by all events from that request; null for events added through the API)
+ * @param {object} headers - That request's headers, for the page it came from (GET /pages)
+ */
+function enrichEvent(source, event, fullUrl, requestId = null, headers = {}) {
+  return {
     ...event,
+    _requestId: requestId,
+    _environment: settings.environment,
     _source: source.id,
     _sourceName: source.name,
     _sourceIcon: source.icon,
     _sourceColor: source.color,
     _metadata: {
       url: fullUrl,
-      capturedAt: new Date().toISOString()
+      ...pageHeaders(headers),
+      // Credential headers are scrubbed by redactEvent (redaction.credentials)
+      ...(settings.captureHeaders ? { headers: { ...headers } } : {}),
+      capturedAt: formatTimestamp(now(), settings.timestamps),
+      session: SESSION_ID
     }
-  }));
+  };
+}
+
+/**
+ * An event's severity ({ _severity, _issues }), from the severity rules
+ */
+function classifyEvent(event) {
+  const { severity, issues } = severityRules.classify(event);
+  return issues.length > 0 ? { _severity: severity, _issues: issues } : { _severity: severity };
+}
+
+/**
+ * Classify, redact, buffer, store and alert on a source's events
+ */
+function captureEvents(source, events) {
+  events.forEach(event => {
+    if (!rateLimiter.take(source.id)) {
+      drops.record('rateLimit', source.id);
+      return;
+    }
+    // _sequence numbers events in capture order for the whole run (not reset
+    // by /clear), so a gap means events were dropped before a reader saw them
+    // Classified before redaction, so redacted PII still counts
+    const captured = { ...redactEvent(event, settings.redaction), ...classifyEvent(event), _sequence: ++capturedTotal };
+    capturedEvents.push(captured);
+
+    sinks.write(captured);
+    alerts.checkEvent(captured);
+    anomalies.observe(captured);
+    eventWaiters.check(captured);
+
+    log.info(`Captured event: ${captured.event} from ${source.name}`);
+  });
+
+  // Update source statistics
+  if (events.length > 0) {
+    source.recordCapture();
+    configManager.save();
+  }
+}
+
+/**
+ * Add events from another capture (an export file, another proxy) to the
+ * buffer under an environment label, for GET /environments/compare. They
+ * keep their source and metadata, are classified by this proxy's severity
+ * rules and are not sent to sinks or alerts. Each gets a new ID (two
+ * sessions can share IDs, and some events have none); the one it came with
+ * is kept as _importedId.
+ * @returns {number} - Events added
+ */
+function importEvents(events, environment) {
+  events.forEach(event => {
+    const { _issues, ...imported } = event;
+    capturedEvents.push({
+      ...imported,
+      ...classifyEvent(imported),
+      id: AnalyticsParser.generateId(),
+      _importedId: imported.id ?? null,
+      _environment: environment,
+      _imported: true,
+      _sequence: ++capturedTotal
+    });
+  });
+  return events.length;
+}
+
+/**
+ * Parse the raw requests behind the buffered events again with the current
+ * sources and parser, replacing each request's events with new ones added as
+ * the newest (derived fields: name, properties, how a batch splits). Capture time, session, page
+ * and environment are kept. Requests that no longer match a source, fail to
+ * parse or were not kept (see reprocess.maxRequests) keep their events.
+ * Reprocessed events are classified by the current severity rules (so a
+ * changed rule applies too) and are not sent to sinks or alerts again.
+ * @param {object} options
+ * @param {string} options.source - Only requests whose events came from this source ID
+ * @returns {Promise<object>} - { requests, reprocessed, unchanged, unmatched, failed, notKept, eventsBefore, eventsAfter }
+ */
+async function reprocessRequests({ source: sourceId = null } = {}) {
+  const buffered = capturedEvents.toArray().reverse(); // Oldest first
+  const byRequest = new Map(); // request ID -> its buffered events
+  for (const event of buffered) {
+    if (!event._requestId || event._imported || (sourceId && event._source !== sourceId)) continue;
+    if (!byRequest.has(event._requestId)) byRequest.set(event._requestId, []);
+    byRequest.get(event._requestId).push(event);
+  }
+
+  const startedAt = now();
+  const result = { requests: byRequest.size, reprocessed: 0, unchanged: 0, unmatched: 0, failed: 0, notKept: 0, eventsBefore: 0, eventsAfter: 0 };
+  const replacements = new Map(); // request ID -> new events
+  for (const [requestId, events] of byRequest) {
+    result.eventsBefore += events.length;
+    const raw = rawRequests.get(requestId);
+    const source = raw && configManager.findSourceForUrl(raw.url);
+    let parsed = null;
+    if (source) {
+      try {
+        parsed = await parsePool.parse(source, raw.body, raw.headers['content-encoding'], { url: raw.url, contentType: raw.headers['content-type'] });
+      } catch (err) {
+        log.debug(`Could not reprocess request ${requestId}: ${err.message}`);
+      }
+    }
+    if (!raw) result.notKept++;
+    else if (!source) result.unmatched++;
+    else if (!parsed) result.failed++;
+    if (!parsed) {
+      result.eventsAfter += events.length;
+      continue;
+    }
+
+    // The parser stamps events without a timestamp with the time it ran;
+    // those keep the time of the event they replace
+    const [first] = events;
+    const reparsed = parsed.events.map((event, i) => {
+      const enriched = enrichEvent(source, event, raw.url, requestId, raw.headers);
+      return {
+        ...redactEvent(enriched, settings.redaction),
+        ...classifyEvent(enriched),
+        timestamp: Date.parse(event.timestamp) >= startedAt ? (events[i] || first).timestamp : event.timestamp,
+        _environment: first._environment,
+        _metadata: first._metadata
+      };
+    });
+    const derived = ({ id, _sequence, _reprocessed, ...rest }) => JSON.stringify(rest);
+    result.eventsAfter += reparsed.length;
+    if (reparsed.length === events.length && reparsed.every((event, i) => derived(event) === derived(events[i]))) {
+      result.unchanged++;
+      continue;
+    }
+    result.reprocessed++;
+    replacements.set(requestId, reparsed);
+  }
+
+  if (replacements.size > 0) {
+    // Rebuild the buffer (as it is now: events kept arriving while parsing)
+    // without the replaced events, and add the new ones as the newest, so
+    // _sequence keeps rising through the buffer (tail and /events/wait?since=
+    // see them as new events)
+    const current = capturedEvents.toArray().reverse();
+    capturedEvents.clear();
+    for (const event of current) {
+      if (event._imported || !replacements.has(event._requestId)) capturedEvents.push(event);
+    }
+    for (const reparsed of replacements.values()) {
+      reparsed.forEach(event => capturedEvents.push({ ...event, _reprocessed: true, _sequence: ++capturedTotal }));
+    }
+  }
+  return result;
+}
+
+/**
+ * Decode (on the parse pool) and capture an analytics request body
+ * @returns {Promise<object>} - { status: captured|failed|dropped, events },
+ *   where events are the parsed events before enrichment
+ */
+async function captureRequestBody(source, body, headers, fullUrl, requestId) {
+  const encoding = headers['content-encoding'];
+  payloadStats.record(source, body.length, encoding);
+  rawRequests.record(requestId, { url: fullUrl, headers: storedHeaders(headers), body });
+  let parsed;
+  try {
+    parsed = await parsePool.parse(source, body, encoding, { url: fullUrl, contentType: headers['content-type'] });
+  } catch (err) {
+    if (err.decompressionError) recordError('Decompression failed', new Error(err.decompressionError));
+    recordError('Error parsing body', err);
+    const entry = parseErrors.get(source.id) || { id: source.id, name: source.name, count: 0, lastError: null };
+    entry.count++;
+    entry.lastError = err.message;
+    parseErrors.set(source.id, entry);
+    if (settings.parsing.errorEvents) {
+      captureEvents(source, [enrichEvent(source, AnalyticsParser.parseErrorEvent({
+        contentType: headers['content-type'] || null,
+        contentEncoding: encoding || null,
+        error: err.message,
+        body: err.bodyPreview === undefined ? null : err.bodyPreview,
+        bodyBytes: body.length
+      }), fullUrl, requestId, headers)]);
+    }
+    return { status: 'failed', events: null };
+  }
+  if (!parsed) {
+    log.warn(`Parse queue full (parsing.maxQueue ${parsePool.maxQueue}); dropped a request from ${source.name}`);
+    drops.record('parseQueue', source.id);
+    return { status: 'dropped', events: null };
+  }
+
+  if (parsed.decompressionError) recordError('Decompression failed', new Error(parsed.decompressionError));
+  if (parsed.events.some(event => event._truncated)) {
+    log.warn(`Body from ${source.name} decompresses past parsing.maxDecompressedBytes (${parsePool.maxDecompressedBytes}); captured it as truncated`);
+  }
+  timings.observe('parse_queue_wait', parsed.timings.queueMs);
+  timings.observe('decompress', parsed.timings.decompressMs);
+  timings.observe('parse', parsed.timings.parseMs);
+  captureEvents(source, parsed.events.map(event => enrichEvent(source, event, fullUrl, requestId, headers)));
+  return { status: 'captured', events: parsed.events };
+}
+
+/**
+ * Whether a GET to a source is a hit to capture from its query string (GA4
+ * /g/collect, a tracking pixel) rather than a script or asset load
+ */
+function capturesQuery(method, fullUrl) {
+  if (method !== 'GET' || !settings.getRequests.enabled) return false;
+  const { pathname, search } = new URL(fullUrl);
+  if (search.length <= 1) return false;
+  const extension = /\.([a-z0-9]+)$/i.exec(pathname);
+  if (extension && settings.getRequests.skipExtensions.includes(extension[1].toLowerCase())) return false;
+  return !settings.getRequests.skipPaths.some(pattern => SourceConfig.globToRegex(pattern).test(pathname));
+}
+
+/**
+ * Capture a GET hit: its query string is parsed as a form body
+ * @returns {Promise<object>} - See captureRequestBody
+ */
+function captureQueryRequest(source, headers, fullUrl, requestId) {
+  const hit = queryRequest(fullUrl, headers);
+  return captureRequestBody(source, hit.body, hit.headers, fullUrl, requestId)
+    .then(result => ({ ...result, hit }));
+}
+
+/**
+ * Headers as kept with a raw request or its response: credentials scrubbed
+ * as they are in events (unless redaction.credentials is false)
+ */
+function storedHeaders(headers) {
+  return settings.redaction.credentials !== false ? redactHeaders(headers) : headers;
+}
+
+/**
+ * Keep the response to a captured request with its raw form
+ * (GET /requests/<id>)
+ */
+function recordResponse(ctx, requestId) {
+  ctx.onResponse((_, callback) => {
+    const response = ctx.serverToProxyResponse;
+    rawRequests.recordResponse(requestId, { status: response.statusCode, headers: storedHeaders(response.headers) });
+    return callback();
+  });
+}
+
+/**
+ * Save a matched request and its events as a fixture, when recording
+ */
+function recordFixture(request) {
+  if (!fixtureRecorder || !request.events) return;
+  try {
+    fixtureRecorder.record(request);
+  } catch (err) {
+    recordError('Could not record fixture', err);
+  }
+}
+
+/**
+ * Track unmatched analytics request for suggestions (skip-listed hosts aren't
+ * even buffered). Any path is buffered: a first-party proxy of Segment,
+ * RudderStack or Amplitude is recognised by its payload (see detectVendorPayload)
+ */
+function tracksUnmatched(method, fullUrl) {
+  return method === 'POST' && !isSkippedDomain(new URL(fullUrl).hostname, configManager.unmatchedSkipDomains);
+}
+
+/**
+ * Everything a source didn't capture (including PUTs to a source's domain)
+ */
+function capturesUncategorized(method) {
+  return settings.promiscuous.enabled && PROMISCUOUS_METHODS.includes(method);
+}
+
+/**
+ * A request no source captured: in promiscuous mode, capture it as an
+ * "uncategorized" event; if it looks like analytics, track it for GET /unmatched
+ */
+function captureUnsourcedRequest(method, fullUrl, headers, body, requestId) {
+  if (capturesUncategorized(method)) {
+    captureEvents(UNCATEGORIZED_SOURCE, [enrichEvent(UNCATEGORIZED_SOURCE, uncategorizedEvent({
+      method,
+      url: fullUrl,
+      headers,
+      body
+    }, settings.promiscuous.maxBodyBytes), fullUrl, requestId, headers)]);
+  }
+  if (tracksUnmatched(method, fullUrl)) {
+    try {
+      const inflated = inflateBody(body, headers['content-encoding'], undefined, settings.parsing.maxDecompressedBytes);
+      const data = bodyPayload(inflated, headers['content-type'], fullUrl);
+      const isNewDomain = configManager.trackUnmatchedRequest(fullUrl, data, headers);
+      const domain = SourceConfig.extractBaseDomainFromUrl(fullUrl);
+      const unmatched = configManager.unmatchedDomains.get(domain);
+      if (!unmatched) return;
+      if (isNewDomain) {
+        alerts.checkUnmatchedDomain(domain, fullUrl);
+      }
+      log.info(`Unmatched analytics from: ${domain}${unmatched.vendor ? ` (looks like ${unmatched.vendor})` : ''}`);
+    } catch {
+      // Not a payload (or too large once decompressed), ignore
+    }
+  }
 }
 
-// Intercept HTTPS requests
 proxy.onRequest((ctx, callback) => {
+  const { host, port } = ctx.proxyToServerRequestOptions;
+  if (Number(port) !== API_PORT) {
+    return interceptRequest(ctx, callback);
+  }
+  targetsApi(host, port).then(refused => {
+    if (refused) {
+      ctx.proxyToClientResponse.writeHead(403, { 'Content-Type': 'text/plain' });
+      ctx.proxyToClientResponse.end('The proxy does not relay requests to its own API\n');
+    } else {
+      interceptRequest(ctx, callback);
+    }
+  });
+});
+
+// Intercept HTTPS requests
+function interceptRequest(ctx, callback) {
   const url = ctx.clientToProxyRequest.url;
   const host = ctx.clientToProxyRequest.headers.host;
   const fullUrl = `${ctx.isSSL ? 'https' : 'http'}://${host}${url}`;
+  // Stamped on every event parsed from this request (GET /events?requestId=)
+  const requestId = newRequestId();
 
   // Find matching source using domain matching
   const source = configManager.findSourceForUrl(fullUrl);
+  const method = ctx.clientToProxyRequest.method;
 
   // Debug: Log all POST requests to see what's coming through
   if (ctx.clientToProxyRequest.method === 'POST') {
     const domain = SourceConfig.extractBaseDomainFromUrl(fullUrl);
-    console.log(`[MITM Proxy] POST to ${domain}: ${fullUrl.slice(0, 80)}...`);
+    log.info(`POST to ${domain}: ${fullUrl.slice(0, 80)}...`);
     if (source) {
-      console.log(`[MITM Proxy]   → Matched source: ${source.name} (enabled: ${source.enabled})`);
+      log.info(`  → Matched source: ${source.name} (enabled: ${source.enabled})`);
     } else {
-      console.log(`[MITM Proxy]   → No source match. Sources: ${configManager.getAllSources().map(s => `${s.domain}(${s.enabled})`).join(', ')}`);
+      log.info(`  → No source match. Sources: ${configManager.getAllSources().map(s => `${s.domain}(${s.enabled})`).join(', ')}`);
     }
   }
 
+  if (source && ctx.clientToProxyRequest.method === 'POST' && ctx.clientToProxyRequest.headers[GENERATED_HEADER]) {
+    // From "loggy-proxy generate": capture it and answer here, so fabricated
+    // events never reach the real endpoint (the request is not forwarded)
+    const body = bodyPool.body();
+    const bodyRead = timings.start('body_read');
+    ctx.clientToProxyRequest.on('data', chunk => body.append(chunk));
+    ctx.clientToProxyRequest.on('end', async () => {
+      bodyRead();
+      const { status } = await captureRequestBody(source, body.bytes(), ctx.clientToProxyRequest.headers, fullUrl, requestId);
+      body.release();
+      // "dropped" tells generate that it outran the parse workers
+      ctx.proxyToClientResponse.writeHead(status === 'dropped' ? 503 : 204, { [GENERATED_HEADER]: status === 'dropped' ? 'dropped' : 'captured' });
+      ctx.proxyToClientResponse.end();
+    });
+    ctx.clientToProxyRequest.resume();
+    return;
+  }
+
+  if (source && capturesQuery(method, fullUrl)) {
+    // No body to wait for: the query string is the payload
+    log.info(`Capturing event from "${source.name}" for: ${fullUrl}`);
+    const parsedAt = new Date(now()).toISOString();
+    captureQueryRequest(source, ctx.clientToProxyRequest.headers, fullUrl, requestId).then(({ events, hit }) => {
+      recordFixture({ method: 'GET', url: fullUrl, headers: hit.headers, body: hit.body, source, events, parsedAt });
+    });
+    recordResponse(ctx, requestId);
+    return callback();
+  }
+
   if (source && ctx.clientToProxyRequest.method === 'POST') {
-    console.log(`[MITM Proxy] Capturing event from "${source.name}" for: ${fullUrl}`);
+    log.info(`Capturing event from "${source.name}" for: ${fullUrl}`);
 
-    // Collect request body as buffer (to handle compression)
-    const chunks = [];
+    // Collect request body as buffer (to handle compression); the chunks
+    // themselves are forwarded, so they are copied into a pooled buffer
+    const body = bodyPool.body();
+    const bodyRead = timings.start('body_read');
     ctx.onRequestData((_, chunk, callback) => {
-      chunks.push(chunk);
+      body.append(chunk);
       return callback(null, chunk);
     });
 
     ctx.onRequestEnd((_, callback) => {
-      // Parse and store the analytics event
-      try {
-        const bodyBuffer = Buffer.concat(chunks);
-        const encoding = ctx.clientToProxyRequest.headers['content-encoding'];
-        const body = decompressBody(bodyBuffer, encoding);
-        const data = JSON.parse(body);
-        const events = parseEventFromSource(source, data, fullUrl);
-
-        events.forEach(captured => {
-          capturedEvents.unshift(captured);
-
-          // Maintain max size
-          if (capturedEvents.length > MAX_EVENTS) {
-            capturedEvents.length = MAX_EVENTS;
-          }
-
-          console.log(`[MITM Proxy] Captured event: ${captured.event} from ${source.name}`);
+      bodyRead();
+      // Forward first; the body is parsed and stored off the request path
+      const parsedAt = new Date(now()).toISOString();
+      captureRequestBody(source, body.bytes(), ctx.clientToProxyRequest.headers, fullUrl, requestId).then(({ events }) => {
+        recordFixture({
+          method: ctx.clientToProxyRequest.method,
+          url: fullUrl,
+          headers: ctx.clientToProxyRequest.headers,
+          body: body.bytes(),
+          source,
+          events,
+          parsedAt
         });
-
-        // Update source statistics
-        if (events.length > 0) {
-          source.recordCapture();
-          configManager.save();
-        }
-      } catch (err) {
-        console.error('[MITM Proxy] Error parsing body:', err.message);
-      }
-
+      }).finally(() => body.release());
       return callback();
     });
-  } else if (ctx.clientToProxyRequest.method === 'POST' && looksLikeAnalyticsEndpoint(fullUrl)) {
-    // Track unmatched analytics request for suggestions
-    const chunks = [];
+    recordResponse(ctx, requestId);
+    return callback();
+  }
+
+  if (tracksUnmatched(method, fullUrl) || capturesUncategorized(method)) {
+    const bodyBuffer = bodyPool.body();
     ctx.onRequestData((_, chunk, callback) => {
-      chunks.push(chunk);
+      bodyBuffer.append(chunk);
       return callback(null, chunk);
     });
 
     ctx.onRequestEnd((_, callback) => {
-      try {
-        const bodyBuffer = Buffer.concat(chunks);
-        const encoding = ctx.clientToProxyRequest.headers['content-encoding'];
-        const body = decompressBody(bodyBuffer, encoding);
-        const data = JSON.parse(body);
-        configManager.trackUnmatchedRequest(fullUrl, data);
-        const domain = SourceConfig.extractBaseDomainFromUrl(fullUrl);
-        console.log(`[MITM Proxy] Unmatched analytics from: ${domain}`);
-      } catch {
-        // Not JSON, ignore
-      }
+      captureUnsourcedRequest(method, fullUrl, ctx.clientToProxyRequest.headers, bodyBuffer.bytes(), requestId);
+      bodyBuffer.release();
       return callback();
     });
   }
 
   return callback();
-});
+}
 
-// Start MITM proxy
-proxy.listen({
-  port: PROXY_PORT,
-  host: '0.0.0.0'
-}, () => {
-  console.log(`\n MITM Proxy running on 0.0.0.0:${PROXY_PORT}`);
-  console.log(` API server running on port ${API_PORT}`);
-  console.log(`\n Certificate location: ~/.http-mitm-proxy/certs/ca.pem`);
-  console.log(`\n IMPORTANT: You must trust the CA certificate for HTTPS interception to work.`);
-  console.log(`   Run: security add-trusted-cert -d -r trustRoot -k ~/Library/Keychains/login.keychain-db ~/.http-mitm-proxy/certs/ca.pem`);
-  console.log(`\n Ready to intercept analytics events!\n`);
+// JSON frames sent over WebSockets to sources that opt in, each captured as
+// a request of its own
+const websocketCapture = new WebSocketCapture(settings.websockets, url => {
+  const source = configManager.findSourceForUrl(url);
+  return source && source.websocket ? source : null;
+}, (source, body, headers, url) => {
+  log.debug(`Capturing WebSocket frame from "${source.name}" for: ${url}`);
+  captureRequestBody(source, body, headers, url, newRequestId());
 });
+websocketCapture.install(proxy);
+
+const listenError = (err, address) => log.warn(`Could not listen on ${address}: ${err.code || err.message}`);
+
+/**
+ * A request Chrome sent (captureBackend "cdp"), seen on the network ("pcap")
+ * or posted to POST /requests, captured as an intercepted one would be
+ * @returns {object|null} - The source that captured it
+ */
+function captureObservedRequest({ method, url, headers, body }) {
+  const requestId = newRequestId();
+  const source = configManager.findSourceForUrl(url);
+  if (source && method === 'POST') {
+    log.info(`Capturing event from "${source.name}" for: ${url}`);
+    captureRequestBody(source, body, headers, url, requestId);
+    return source;
+  }
+  if (source && capturesQuery(method, url)) {
+    log.info(`Capturing event from "${source.name}" for: ${url}`);
+    captureQueryRequest(source, headers, url, requestId);
+    return source;
+  }
+  captureUnsourcedRequest(method, url, headers, body, requestId);
+  return null;
+}
+
+// Requests something would be captured from (the CDP backend fetches only their bodies)
+const wantsRequest = ({ method, url }) => ((method === 'POST' || capturesQuery(method, url)) && !!configManager.findSourceForUrl(url)) ||
+  tracksUnmatched(method, url) || capturesUncategorized(method);
+
+const cdpCapture = CAPTURE_BACKEND === 'cdp'
+  ? new CdpCapture({
+    endpoint: settings.cdp.endpoint,
+    reconnectSeconds: settings.cdp.reconnectSeconds,
+    wants: wantsRequest,
+    onRequest: captureObservedRequest
+  })
+  : null;
+
+const pcapCapture = CAPTURE_BACKEND === 'pcap'
+  ? new PcapCapture({
+    ...settings.pcap,
+    file: settings.pcap.file ? resolvePath(settings.pcap.file) : null,
+    keylogFile: settings.pcap.keylogFile ? resolvePath(settings.pcap.keylogFile) : null,
+    wants: wantsRequest,
+    onRequest: captureObservedRequest
+  })
+  : null;
+
+const addresses = port => LISTEN_HOSTS.map(host => formatAddress(host, port)).join(', ');
+const versionLabel = `${VERSION.version}${VERSION.commit ? ` (${VERSION.commit})` : ''}`;
+const profileLabel = PROFILE_PATHS.profile ? ` (profile "${PROFILE_PATHS.profile}")` : '';
+
+if (cdpCapture) {
+  // No proxy port: requests come from Chrome's DevTools Protocol
+  cdpCapture.start();
+  writePidFile();
+  console.log(`\n Loggy ${versionLabel} capturing from Chrome over CDP at ${settings.cdp.endpoint}${profileLabel}`);
+  console.log(` API server running on ${addresses(API_PORT)}`);
+  console.log(`\n No CA or proxy flags needed: start Chrome with --remote-debugging-port (and the port of cdp.endpoint)\n`);
+} else if (pcapCapture) {
+  // No proxy port: requests are read from network traffic
+  pcapCapture.start();
+  writePidFile();
+  const { pcap } = settings;
+  const from = pcap.file ? `the capture file ${pcapCapture.file}` : `interface ${pcap.interface}, HTTP ports ${pcap.ports.join(', ')}`;
+  console.log(`\n Loggy ${versionLabel} capturing passively from ${from}${pcap.keylogFile ? ` and HTTPS ports ${pcap.tlsPorts.join(', ')} (decrypted with ${pcapCapture.keylogFile})` : ''}${profileLabel}`);
+  console.log(` API server running on ${addresses(API_PORT)}\n`);
+} else {
+  // Start MITM proxy
+  proxy.listen({
+    port: PROXY_PORT,
+    host: LISTEN_HOSTS[0],
+    sslCaDir: CA_DIRS.rsa
+  }, () => {
+    listenOnMore(proxy.httpServer, LISTEN_HOSTS.slice(1), PROXY_PORT, listenError);
+    writePidFile();
+    console.log(`\n MITM Proxy ${versionLabel} running on ${addresses(PROXY_PORT)}${profileLabel}`);
+    console.log(` API server running on ${addresses(API_PORT)}`);
+    console.log(`\n Certificate location: ${CA_CERT_PATH} (${certificateAuthority ? 'ECDSA P-256' : 'RSA-2048'})`);
+    console.log(`\n IMPORTANT: You must trust the CA certificate for HTTPS interception to work.`);
+    console.log(`   Run: security add-trusted-cert -d -r trustRoot -k ~/Library/Keychains/login.keychain-db ${CA_CERT_PATH}`);
+    console.log(`\n Ready to intercept analytics events!\n`);
+  });
+}
+
+/**
+ * Process, buffer and per-source counts for GET /status
+ */
+function getStatus() {
+  return {
+    pid: process.pid,
+    version: VERSION,
+    profile: PROFILE_PATHS.profile,
+    session: SESSION_ID,
+    environment: settings.environment,
+    startedAt: new Date(Date.now() - process.uptime() * 1000).toISOString(),
+    uptimeSeconds: Math.round(process.uptime()),
+    captureBackend: CAPTURE_BACKEND,
+    proxyPort: CAPTURE_BACKEND === 'mitm' ? PROXY_PORT : null,
+    apiPort: API_PORT,
+    listenHosts: LISTEN_HOSTS,
+    apiSocket: API_SOCKET,
+    buffer: { events: capturedEvents.length, maxEvents: settings.maxEvents },
+    pinned: pinnedEvents.size,
+    rawRequests: rawRequests.getStatus(),
+    severity: capturedEvents.severityCounts(),
+    capturedTotal,
+    parsing: parsePool.stats(),
+    bodyBuffers: bodyPool.stats(),
+    tunnel: hostMatchCache.stats(),
+    websockets: websocketCapture.getStatus(),
+    sources: capturedEvents.sourceCounts().sort((a, b) => b.events - a.events),
+    trust: trustWatchdog.getStatus(),
+    cdp: cdpCapture ? cdpCapture.getStatus() : null,
+    pcap: pcapCapture ? pcapCapture.getStatus() : null,
+    lastError
+  };
+}
+
+/**
+ * Memory, buffer occupancy and payload sizes for GET /stats
+ */
+function getStats() {
+  const memory = process.memoryUsage();
+  const bufferBytes = capturedEvents.byteSize();
+  return {
+    memory: {
+      rss: memory.rss,
+      heapUsed: memory.heapUsed,
+      heapTotal: memory.heapTotal,
+      external: memory.external,
+      arrayBuffers: memory.arrayBuffers
+    },
+    buffer: {
+      events: capturedEvents.length,
+      maxEvents: settings.maxEvents,
+      occupancy: Math.round(capturedEvents.length / settings.maxEvents * 1000) / 10,
+      bytes: bufferBytes,
+      avgEventBytes: capturedEvents.length > 0 ? Math.round(bufferBytes / capturedEvents.length) : 0,
+      sources: capturedEvents.sourceCounts().sort((a, b) => b.events - a.events)
+    },
+    parsing: parsePool.stats(),
+    bodyBuffers: bodyPool.stats(),
+    payloads: payloadStats.summary(),
+    drops: drops.summary(),
+    parseErrors: [...parseErrors.values()].sort((a, b) => b.count - a.count),
+    timings: timings.summary()
+  };
+}
+
+/**
+ * Counters and gauges for GET /metrics (Prometheus text format)
+ */
+function getMetrics() {
+  const label = value => String(value).replace(/["\\\n]/g, char => (char === '\n' ? '\\n' : `\\${char}`));
+  const parsing = parsePool.stats();
+  const lines = [
+    '# HELP loggy_events_captured_total Events captured since the proxy started',
+    '# TYPE loggy_events_captured_total counter',
+    `loggy_events_captured_total ${capturedTotal}`,
+    '# HELP loggy_buffer_events Events in the in-memory buffer',
+    '# TYPE loggy_buffer_events gauge',
+    `loggy_buffer_events ${capturedEvents.length}`,
+    '# HELP loggy_buffer_capacity Size of the in-memory buffer (maxEvents)',
+    '# TYPE loggy_buffer_capacity gauge',
+    `loggy_buffer_capacity ${settings.maxEvents}`,
+    '# HELP loggy_parse_queue_depth Request bodies waiting to be parsed',
+    '# TYPE loggy_parse_queue_depth gauge',
+    `loggy_parse_queue_depth ${parsing.queued}`,
+    '# HELP loggy_dropped_total Events (requests, for parseQueue) dropped, by reason and source',
+    '# TYPE loggy_dropped_total counter',
+    ...drops.entries().map(({ reason, source, count }) =>
+      `loggy_dropped_total{reason="${label(reason)}",source="${label(source)}"} ${count}`),
+    '# HELP loggy_parse_errors_total Matched request bodies that could not be parsed, by source',
+    '# TYPE loggy_parse_errors_total counter',
+    ...[...parseErrors.values()].map(({ id, count }) => `loggy_parse_errors_total{source="${label(id)}"} ${count}`),
+    ...timings.prometheusLines(),
+    ...anomalies.prometheusLines()
+  ];
+  return lines.join('\n') + '\n';
+}
+
+// GET /events query parameters that filter (through the buffer's indexes)
+const EVENT_FILTERS = ['source', 'event', 'userId', 'requestId', 'environment', 'severity'];
+
+/**
+ * Page through the event buffer (newest first)
+ * @param {string} cursor - ID of the last event from the previous page
+ * @param {number} limit - Maximum events to return
+ * @param {object} filters - { source, event, userId, requestId, environment, severity }; only matching events
+ */
+function getEventPage(cursor, limit, filters = {}) {
+  return capturedEvents.find(filters, { cursor, limit });
+}
 
 // API server for Analytics Logger to fetch events
-const apiServer = http.createServer((req, res) => {
+function handleApiRequest(req, res) {
   // CORS headers
   res.setHeader('Access-Control-Allow-Origin', '*');
-  res.setHeader('Access-Control-Allow-Methods', 'GET, POST, OPTIONS');
-  res.setHeader('Access-Control-Allow-Headers', 'Content-Type');
+  res.setHeader('Access-Control-Allow-Methods', 'GET, POST, PUT, DELETE, OPTIONS');
+  res.setHeader('Access-Control-Allow-Headers', 'Content-Type, Authorization, X-Loggy-Key');
 
   if (req.method === 'OPTIONS') {
     res.writeHead(200);
@@ -186,61 +1015,615 @@ const apiServer = http.createServer((req, res) => {
     return;
   }
 
-  if (req.url === '/events' && req.method === 'GET') {
+  const { pathname, searchParams } = new URL(req.url, 'http://localhost');
+
+  // Clients beyond loopback need an API key with the route's scope, once any are configured
+  const denied = authorizeApiRequest(req, pathname, searchParams, settings.apiKeys);
+  if (denied) {
+    res.writeHead(denied.status, { 'Content-Type': 'application/json' });
+    res.end(JSON.stringify({ success: false, error: denied.error }));
+    return;
+  }
+
+  const funnelName = pathname.startsWith('/funnels/') && pathname !== '/funnels/check'
+    ? decodeURIComponent(pathname.slice('/funnels/'.length))
+    : null;
+  const pinMatch = /^\/events\/([^/]+)\/pin$/.exec(pathname);
+  const pinId = pinMatch ? decodeURIComponent(pinMatch[1]) : null;
+  const rawRequestId = /^\/requests\/[^/]+$/.test(pathname) ? decodeURIComponent(pathname.slice('/requests/'.length)) : null;
+
+  if (pathname === '/events' && req.method === 'GET' && ['limit', 'cursor', ...EVENT_FILTERS].some(name => searchParams.has(name))) {
+    const limit = Math.max(1, parseInt(searchParams.get('limit'), 10) || 100);
+    const filters = Object.fromEntries(EVENT_FILTERS.filter(name => searchParams.has(name)).map(name => [name, searchParams.get(name)]));
     res.writeHead(200, { 'Content-Type': 'application/json' });
     res.end(JSON.stringify({
-      events: capturedEvents,
+      ...getEventPage(searchParams.get('cursor'), limit, filters),
       count: capturedEvents.length,
+      sequence: capturedTotal
+    }));
+  } else if (pathname === '/events' && req.method === 'GET') {
+    res.writeHead(200, { 'Content-Type': 'application/json' });
+    res.end(JSON.stringify({
+      events: capturedEvents.toArray(),
+      count: capturedEvents.length,
+      sequence: capturedTotal,
       unmatchedDomains: configManager.getUnmatchedDomains()
     }));
-  } else if (req.url === '/clear' && req.method === 'POST') {
-    capturedEvents.length = 0;
+  } else if (pathname === '/events' && req.method === 'POST') {
+    // Add events without an analytics request ("loggy-proxy generate --via api")
+    let body = '';
+    req.on('data', chunk => body += chunk);
+    req.on('end', () => {
+      try {
+        const { source: sourceId, events = [], url = null, environment = settings.environment } = JSON.parse(body);
+        const source = configManager.sources.get(sourceId);
+        if (!source) {
+          res.writeHead(404, { 'Content-Type': 'application/json' });
+          res.end(JSON.stringify({ success: false, error: `Unknown source "${sourceId}"` }));
+          return;
+        }
+        if (!Array.isArray(events)) {
+          throw new Error('"events" must be an array');
+        }
+        if (environment !== null && typeof environment !== 'string') {
+          throw new Error('"environment" must be a label such as "staging"');
+        }
+
+        captureEvents(source, events.map(event => enrichEvent(source, {
+          // Replayed events keep their IDs
+          id: event.id || AnalyticsParser.generateId(),
+          timestamp: AnalyticsParser.normalizeTimestamp(event.timestamp),
+          event: event.event || 'unknown',
+          properties: event.properties || {},
+          context: event.context || {},
+          userId: event.userId || null,
+          anonymousId: event.anonymousId,
+          type: event.type || 'track'
+        }, url)).map(event => ({ ...event, _environment: environment })));
+
+        res.writeHead(200, { 'Content-Type': 'application/json' });
+        res.end(JSON.stringify({ success: true, captured: events.length }));
+      } catch (err) {
+        res.writeHead(400, { 'Content-Type': 'application/json' });
+        res.end(JSON.stringify({ success: false, error: err.message }));
+      }
+    });
+  } else if (pathname === '/events/pinned' && req.method === 'GET') {
+    // The pinned working set, newest pin first
+    res.writeHead(200, { 'Content-Type': 'application/json' });
+    res.end(JSON.stringify({ events: pinnedEvents.list(), count: pinnedEvents.size, max: pinnedEvents.max }));
+  } else if (pathname === '/events/pinned' && req.method === 'DELETE') {
+    const unpinned = pinnedEvents.clear();
+    res.writeHead(200, { 'Content-Type': 'application/json' });
+    res.end(JSON.stringify({ success: true, unpinned }));
+  } else if (pinId && req.method === 'POST') {
+    // Copy a buffered event into the pinned set, with an optional { note }
+    let body = '';
+    req.on('data', chunk => body += chunk);
+    req.on('end', () => {
+      let note = null;
+      try {
+        ({ note = null } = body.trim() ? JSON.parse(body) : {});
+        if (note !== null && typeof note !== 'string') throw new Error('"note" must be a string');
+      } catch (err) {
+        res.writeHead(400, { 'Content-Type': 'application/json' });
+        res.end(JSON.stringify({ success: false, error: err.message }));
+        return;
+      }
+      const index = capturedEvents.indexOf(pinId);
+      const event = index === -1 ? null : capturedEvents.get(index);
+      if (!event) {
+        // Already pinned but evicted: only the note changes
+        if (pinnedEvents.has(pinId)) {
+          const pinned = pinnedEvents.pin(pinnedEvents.get(pinId), note);
+          res.writeHead(200, { 'Content-Type': 'application/json' });
+          res.end(JSON.stringify({ success: true, event: pinned }));
+          return;
+        }
+        res.writeHead(404, { 'Content-Type': 'application/json' });
+        res.end(JSON.stringify({ success: false, error: `No buffered event with ID "${pinId}"` }));
+        return;
+      }
+      const pinned = pinnedEvents.pin(event, note);
+      apiLog.info(`Pinned event ${pinId} (${event.event})`);
+      res.writeHead(200, { 'Content-Type': 'application/json' });
+      res.end(JSON.stringify({ success: true, event: pinned }));
+    });
+  } else if (pinId && req.method === 'DELETE') {
+    const unpinned = pinnedEvents.unpin(pinId);
+    res.writeHead(unpinned ? 200 : 404, { 'Content-Type': 'application/json' });
+    res.end(JSON.stringify(unpinned ? { success: true } : { success: false, error: `Event "${pinId}" is not pinned` }));
+  } else if (pathname === '/events/import' && req.method === 'POST') {
+    // Add captured events from elsewhere under an environment label
+    let body = '';
+    req.on('data', chunk => body += chunk);
+    req.on('end', () => {
+      try {
+        const { environment, events } = JSON.parse(body);
+        if (typeof environment !== 'string' || !environment) {
+          throw new Error('"environment" (a label such as "staging") is required');
+        }
+        if (!Array.isArray(events) || !events.every(event => event && typeof event === 'object' && typeof event.event === 'string')) {
+          throw new Error('"events" must be an array of captured events (as written by export or capture)');
+        }
+        const imported = importEvents(events, environment);
+        apiLog.info(`Imported ${imported} events as environment "${environment}"`);
+        res.writeHead(200, { 'Content-Type': 'application/json' });
+        res.end(JSON.stringify({ success: true, imported }));
+      } catch (err) {
+        res.writeHead(400, { 'Content-Type': 'application/json' });
+        res.end(JSON.stringify({ success: false, error: err.message }));
+      }
+    });
+  } else if (pathname === '/reprocess' && req.method === 'POST') {
+    // Parse the requests behind the buffered events again, e.g. after fixing a source's fieldMappings
+    let body = '';
+    req.on('data', chunk => body += chunk);
+    req.on('end', async () => {
+      let options;
+      try {
+        options = body.trim() ? JSON.parse(body) : {};
+        if (options.source !== undefined && (typeof options.source !== 'string' || !options.source)) {
+          throw new Error('"source" must be a source ID');
+        }
+      } catch (err) {
+        res.writeHead(400, { 'Content-Type': 'application/json' });
+        res.end(JSON.stringify({ success: false, error: err.message }));
+        return;
+      }
+      const result = await reprocessRequests(options);
+      apiLog.info(`Reprocessed ${result.reprocessed} of ${result.requests} requests (${result.eventsBefore} -> ${result.eventsAfter} events)`);
+      res.writeHead(200, { 'Content-Type': 'application/json' });
+      res.end(JSON.stringify({ success: true, ...result }));
+    });
+  } else if (rawRequestId && req.method === 'GET') {
+    // A kept raw request (a buffered event's _requestId) and its response
+    const raw = rawRequests.get(rawRequestId);
+    if (!raw) {
+      res.writeHead(404, { 'Content-Type': 'application/json' });
+      res.end(JSON.stringify({ success: false, error: `No request "${rawRequestId}" is kept` }));
+    } else {
+      res.writeHead(200, { 'Content-Type': 'application/json' });
+      res.end(JSON.stringify({
+        success: true,
+        request: {
+          requestId: rawRequestId,
+          url: raw.url,
+          headers: raw.headers,
+          body: raw.body.toString('base64'),
+          recordedAt: new Date(raw.recordedAt).toISOString(),
+          response: raw.response && { ...raw.response, receivedAt: new Date(raw.response.receivedAt).toISOString() }
+        }
+      }));
+    }
+  } else if (pathname === '/requests' && req.method === 'POST') {
+    // Raw requests recorded elsewhere (e.g. "loggy-proxy flows import"), run
+    // through source matching and the parser like intercepted ones
+    let body = '';
+    req.on('data', chunk => body += chunk);
+    req.on('end', () => {
+      try {
+        const { requests } = JSON.parse(body);
+        if (!Array.isArray(requests) || !requests.every(request => request && typeof request.method === 'string' && typeof request.url === 'string')) {
+          throw new Error('"requests" must be an array of { method, url, headers, body (base64) }');
+        }
+        let captured = 0;
+        for (const request of requests) {
+          const headers = Object.fromEntries(Object.entries(request.headers || {}).map(([name, value]) => [name.toLowerCase(), String(value)]));
+          if (captureObservedRequest({
+            method: request.method.toUpperCase(),
+            url: request.url,
+            headers,
+            body: Buffer.from(request.body || '', 'base64')
+          })) {
+            captured++;
+          }
+        }
+        apiLog.info(`Received ${requests.length} requests; ${captured} matched a source`);
+        res.writeHead(200, { 'Content-Type': 'application/json' });
+        res.end(JSON.stringify({ success: true, requests: requests.length, captured }));
+      } catch (err) {
+        res.writeHead(400, { 'Content-Type': 'application/json' });
+        res.end(JSON.stringify({ success: false, error: err.message }));
+      }
+    });
+  } else if (pathname === '/clear' && req.method === 'POST') {
+    capturedEvents.clear();
+    rawRequests.clear();
     res.writeHead(200, { 'Content-Type': 'application/json' });
     res.end(JSON.stringify({ success: true }));
-  } else if (req.url === '/sources' && req.method === 'POST') {
-    // Receive sources from the extension
+  } else if (pathname === '/sources' && req.method === 'POST') {
+    // Merge sources from the extension with ours (see config/source-sync.js);
+    // the answer carries the merged sources for the extension to adopt
+    const onConflict = searchParams.get('onConflict') || 'keep';
+    if (!CONFLICT_STRATEGIES.includes(onConflict)) {
+      res.writeHead(400, { 'Content-Type': 'application/json' });
+      res.end(JSON.stringify({ success: false, error: `onConflict must be one of ${CONFLICT_STRATEGIES.join(', ')}` }));
+      return;
+    }
     let body = '';
     req.on('data', chunk => body += chunk);
     req.on('end', () => {
       try {
         const sources = JSON.parse(body);
-        let added = 0;
-
-        // Add/update sources from extension
-        sources.forEach(sourceData => {
-          const source = new SourceConfig(sourceData.id, sourceData);
-          configManager.sources.set(sourceData.id, source);
-          added++;
-        });
+        if (!Array.isArray(sources) || !sources.every(source => source && typeof source.id === 'string')) {
+          throw new Error('Expected an array of sources with an id');
+        }
+        const merged = configManager.mergeSources(sources, { onConflict });
+        hostMatchCache.clear();
 
-        console.log(`[MITM Proxy] Synced ${added} sources from extension`);
-        console.log(`[MITM Proxy] Total sources: ${configManager.getAllSources().length}`);
+        apiLog.info(`Synced ${sources.length} sources from extension: ${merged.added.length} added, ${merged.updated.length} updated, ${merged.conflicts.length} conflicts`);
+        for (const conflict of merged.conflicts) {
+          apiLog.warn(`Source "${conflict.id}" changed on both sides (${conflict.fields.join(', ')}); kept the ${conflict.kept === 'proxy' ? 'proxy\'s' : 'pushed'} copy`);
+        }
+        apiLog.info(`Total sources: ${configManager.getAllSources().length}`);
 
         res.writeHead(200, { 'Content-Type': 'application/json' });
-        res.end(JSON.stringify({ success: true, synced: added }));
+        res.end(JSON.stringify({
+          success: true,
+          synced: sources.length,
+          added: merged.added,
+          updated: merged.updated,
+          unchanged: merged.unchanged,
+          behind: merged.behind,
+          conflicts: merged.conflicts,
+          // Every source, as the extension should now have it
+          sources: configManager.getAllSources().map(source => ({ ...source.toJSON(), syncedVersion: source.version }))
+        }));
       } catch (err) {
-        console.error('[MITM Proxy] Error syncing sources:', err.message);
+        recordError('Error syncing sources', err);
         res.writeHead(400, { 'Content-Type': 'application/json' });
         res.end(JSON.stringify({ success: false, error: err.message }));
       }
     });
-  } else if (req.url === '/sources' && req.method === 'GET') {
+  } else if (pathname === '/sources/reload' && req.method === 'POST') {
+    // Pick up changes to the sources file (loggy-proxy sources add/edit/remove)
+    configManager.reload();
+    configManager.setEnabledSourceIds(settings.enabledSources);
+    // and to the unmatched skip list in the settings file
+    settings.unmatched = loadProxySettings(null, { quiet: true }).unmatched;
+    configManager.setUnmatchedSkipDomains(unmatchedSkipDomains(settings));
+    hostMatchCache.clear();
+    apiLog.info('Reloaded', configManager.getAllSources().length, 'analytics sources');
+    res.writeHead(200, { 'Content-Type': 'application/json' });
+    res.end(JSON.stringify({ success: true, count: configManager.getAllSources().length }));
+  } else if (pathname === '/sources' && req.method === 'GET') {
     // Return current sources
     res.writeHead(200, { 'Content-Type': 'application/json' });
     res.end(JSON.stringify({
       sources: configManager.getAllSources().map(s => s.toJSON()),
       count: configManager.getAllSources().length
     }));
-  } else if (req.url === '/unmatched' && req.method === 'GET') {
+  } else if (pathname === '/certificates' && req.method === 'GET') {
+    res.writeHead(200, { 'Content-Type': 'application/json' });
+    res.end(JSON.stringify({
+      keyType: certificateAuthority ? 'ecdsa' : 'rsa',
+      caCertPath: CA_CERT_PATH,
+      // CA from startup: a reload can change the settings but not the CA in use
+      expiry: {
+        ...checkCAExpiry({
+          certificates: { ...settings.certificates, keyType: certificateAuthority ? 'ecdsa' : 'rsa', dir: CA_DIRS.base }
+        }),
+        renewedAt: caRenewedAt
+      },
+      trust: trustWatchdog.getStatus(),
+      leafCache: leafCache.getStats()
+    }));
+  } else if (pathname === '/certificates/trust' && req.method === 'POST') {
+    // One-shot re-trust, e.g. after /healthz reports trust was lost
+    trustWatchdog.retrust().then(result => {
+      res.writeHead(result.trusted === false ? 500 : 200, { 'Content-Type': 'application/json' });
+      res.end(JSON.stringify({ success: result.trusted !== false, ...result }));
+    });
+  } else if (pathname === '/status' && req.method === 'GET') {
+    res.writeHead(200, { 'Content-Type': 'application/json' });
+    res.end(JSON.stringify(getStatus()));
+  } else if (pathname === '/instances' && req.method === 'GET') {
+    // Every proxy instance on this machine (the default and each profile)
+    res.writeHead(200, { 'Content-Type': 'application/json' });
+    res.end(JSON.stringify({
+      current: PROFILE_PATHS.profile,
+      instances: profiles.listInstances().map(instance => ({ ...instance, current: instance.name === PROFILE_PATHS.profile }))
+    }));
+  } else if (pathname === '/stats' && req.method === 'GET') {
+    res.writeHead(200, { 'Content-Type': 'application/json' });
+    res.end(JSON.stringify(getStats()));
+  } else if (pathname === '/metrics' && req.method === 'GET') {
+    res.writeHead(200, { 'Content-Type': 'text/plain; version=0.0.4' });
+    res.end(getMetrics());
+  } else if (pathname === '/healthz' && req.method === 'GET') {
+    const trustCheck = searchParams.has('refresh') ? trustWatchdog.check() : Promise.resolve(trustWatchdog.getStatus());
+    trustCheck.then(trust => {
+      const expiry = checkCAExpiry({
+        certificates: { ...settings.certificates, keyType: certificateAuthority ? 'ecdsa' : 'rsa', dir: CA_DIRS.base }
+      });
+      const problems = [];
+      if (trust.trusted === false) problems.push('CA_NOT_TRUSTED');
+      if (expiry.status === 'expired') problems.push('CA_EXPIRED');
+
+      res.writeHead(problems.length > 0 ? 503 : 200, { 'Content-Type': 'application/json' });
+      res.end(JSON.stringify({
+        status: problems.length > 0 ? 'degraded' : 'ok',
+        problems,
+        pid: process.pid,
+        uptimeSeconds: Math.round(process.uptime()),
+        events: capturedEvents.length,
+        trust,
+        expiry
+      }));
+    });
+  } else if (pathname === '/pinned-domains' && req.method === 'GET') {
+    res.writeHead(200, { 'Content-Type': 'application/json' });
+    res.end(JSON.stringify(pinningDetector.getReport(settings.bypassHosts)));
+  } else if (pathname === '/pinned-domains' && req.method === 'DELETE') {
+    pinningDetector.clear();
+    res.writeHead(200, { 'Content-Type': 'application/json' });
+    res.end(JSON.stringify({ success: true }));
+  } else if (pathname === '/unmatched' && req.method === 'GET') {
     // Return unmatched domains (for suggestions)
     res.writeHead(200, { 'Content-Type': 'application/json' });
     res.end(JSON.stringify({
       domains: configManager.getUnmatchedDomains()
     }));
+  } else if (pathname.startsWith('/unmatched/') && pathname.endsWith('/source') && req.method === 'POST') {
+    // Add the source suggested for an unmatched domain (a first-party proxy
+    // of a known vendor), saved to the sources file
+    const domain = decodeURIComponent(pathname.slice('/unmatched/'.length, -'/source'.length)).toLowerCase();
+    const unmatched = configManager.unmatchedDomains.get(domain);
+    if (!unmatched || !unmatched.suggestedSource) {
+      res.writeHead(404, { 'Content-Type': 'application/json' });
+      res.end(JSON.stringify({ success: false, error: `No source suggested for ${domain}` }));
+      return;
+    }
+    const id = `${unmatched.vendor}-${domain.replace(/[^a-z0-9]+/g, '-')}`;
+    const source = new SourceConfig(id, { ...unmatched.suggestedSource, createdBy: 'user' });
+    configManager.addSource(source);
+    hostMatchCache.clear();
+    apiLog.info(`Added source "${source.name}" for ${domain}`);
+    res.writeHead(200, { 'Content-Type': 'application/json' });
+    res.end(JSON.stringify({ success: true, source: source.toJSON() }));
+  } else if (pathname === '/events/wait' && req.method === 'POST') {
+    // Long-poll for an event (see proxy/event-waiters.js)
+    let body = '';
+    req.on('data', chunk => body += chunk);
+    req.on('end', () => {
+      let wait;
+      try {
+        wait = parseWaitRequest(JSON.parse(body), capturedTotal);
+      } catch (err) {
+        res.writeHead(400, { 'Content-Type': 'application/json' });
+        res.end(JSON.stringify({ success: false, error: err.message }));
+        return;
+      }
+      const cancel = eventWaiters.add(wait, capturedEvents.toArray().reverse(), event => {
+        res.writeHead(200, { 'Content-Type': 'application/json' });
+        res.end(JSON.stringify(event
+          ? { matched: true, event, sequence: capturedTotal }
+          : { matched: false, timeoutMs: wait.timeoutMs, sequence: capturedTotal }));
+      });
+      // The client gave up first
+      res.on('close', cancel);
+    });
+  } else if (pathname === '/properties' && req.method === 'GET') {
+    // Per-property statistics for the buffered events, optionally of one source or event name
+    const filters = Object.fromEntries(['source', 'event'].filter(name => searchParams.has(name)).map(name => [name, searchParams.get(name)]));
+    const examples = searchParams.has('examples') ? Math.max(0, parseInt(searchParams.get('examples'), 10) || 0) : 5;
+    const { events } = capturedEvents.find(filters, { limit: Infinity });
+    res.writeHead(200, { 'Content-Type': 'application/json' });
+    res.end(JSON.stringify({ events: propertyStats(events, { examples }), count: events.length }));
+  } else if (pathname === '/pages' && req.method === 'GET') {
+    // Buffered events grouped by the page that sent them, optionally of one source or session
+    const filters = searchParams.has('source') ? { source: searchParams.get('source') } : {};
+    let { events } = capturedEvents.find(filters, { limit: Infinity });
+    if (searchParams.has('session')) {
+      events = events.filter(event => event._metadata && event._metadata.session === searchParams.get('session'));
+    }
+    const limit = Math.max(1, parseInt(searchParams.get('limit'), 10) || 100);
+    res.writeHead(200, { 'Content-Type': 'application/json' });
+    res.end(JSON.stringify(pageTimeline(events.reverse(), { limit })));
+  } else if (pathname === '/severity' && req.method === 'GET') {
+    // Severity counts of the buffered events, overall, per source and per rule
+    const filters = searchParams.has('source') ? { source: searchParams.get('source') } : {};
+    const { events } = capturedEvents.find(filters, { limit: Infinity });
+    res.writeHead(200, { 'Content-Type': 'application/json' });
+    res.end(JSON.stringify({ ...severityCounts(events), count: events.length, ruleCount: severityRules.rules.length }));
+  } else if (pathname === '/environments' && req.method === 'GET') {
+    res.writeHead(200, { 'Content-Type': 'application/json' });
+    res.end(JSON.stringify({ environment: settings.environment, environments: capturedEvents.environmentCounts() }));
+  } else if (pathname === '/environments/compare' && req.method === 'GET') {
+    const a = searchParams.get('a');
+    const b = searchParams.get('b');
+    if (!a || !b) {
+      res.writeHead(400, { 'Content-Type': 'application/json' });
+      res.end(JSON.stringify({ success: false, error: 'Give the two environments to compare as ?a=<label>&b=<label>' }));
+      return;
+    }
+    const eventsOf = environment => capturedEvents.find({ environment }, { limit: Infinity }).events;
+    res.writeHead(200, { 'Content-Type': 'application/json' });
+    res.end(JSON.stringify(compareEnvironments(eventsOf(a), eventsOf(b), { a, b })));
+  } else if (pathname === '/rate-limits' && req.method === 'GET') {
+    res.writeHead(200, { 'Content-Type': 'application/json' });
+    res.end(JSON.stringify(rateLimiter.getStatus()));
+  } else if (pathname === '/anomalies' && req.method === 'GET') {
+    res.writeHead(200, { 'Content-Type': 'application/json' });
+    res.end(JSON.stringify({ anomalies: anomalies.list(), enabled: anomalies.enabled }));
+  } else if (pathname === '/anomalies' && req.method === 'DELETE') {
+    anomalies.clear();
+    res.writeHead(200, { 'Content-Type': 'application/json' });
+    res.end(JSON.stringify({ success: true }));
+  } else if (pathname === '/anomalies/stream' && req.method === 'GET') {
+    // Server-sent events: one "anomaly" event per anomaly, as it is found
+    res.writeHead(200, { 'Content-Type': 'text/event-stream', 'Cache-Control': 'no-cache', Connection: 'keep-alive' });
+    res.write(': connected\n\n');
+    const heartbeat = setInterval(() => res.write(': heartbeat\n\n'), 15000);
+    anomalyStreams.add(res);
+    res.on('close', () => {
+      clearInterval(heartbeat);
+      anomalyStreams.delete(res);
+    });
+  } else if (pathname === '/funnels' && req.method === 'GET') {
+    res.writeHead(200, { 'Content-Type': 'application/json' });
+    res.end(JSON.stringify({ funnels: settings.funnels }));
+  } else if (pathname === '/funnels/check' && req.method === 'POST') {
+    // Check a funnel without saving it
+    let body = '';
+    req.on('data', chunk => body += chunk);
+    req.on('end', () => {
+      try {
+        const funnel = validateFunnel({ name: 'check', ...JSON.parse(body) });
+        res.writeHead(200, { 'Content-Type': 'application/json' });
+        res.end(JSON.stringify(checkFunnel(funnel, capturedEvents.toArray().reverse())));
+      } catch (err) {
+        res.writeHead(400, { 'Content-Type': 'application/json' });
+        res.end(JSON.stringify({ success: false, error: err.message }));
+      }
+    });
+  } else if (funnelName && req.method === 'GET') {
+    const funnel = settings.funnels.find(candidate => candidate.name === funnelName);
+    if (!funnel) {
+      res.writeHead(404, { 'Content-Type': 'application/json' });
+      res.end(JSON.stringify({ success: false, error: `No funnel named "${funnelName}"` }));
+      return;
+    }
+    try {
+      const result = checkFunnel(validateFunnel(funnel), capturedEvents.toArray().reverse());
+      res.writeHead(200, { 'Content-Type': 'application/json' });
+      res.end(JSON.stringify(result));
+    } catch (err) {
+      // Hand-edited in the settings file
+      res.writeHead(500, { 'Content-Type': 'application/json' });
+      res.end(JSON.stringify({ success: false, error: `Funnel "${funnelName}" in the settings file is invalid: ${err.message}` }));
+    }
+  } else if (funnelName && req.method === 'PUT') {
+    // Add or replace a funnel, saved to the settings file
+    let body = '';
+    req.on('data', chunk => body += chunk);
+    req.on('end', () => {
+      try {
+        const funnel = validateFunnel({ ...JSON.parse(body), name: funnelName });
+        settings.funnels = [...settings.funnels.filter(candidate => candidate.name !== funnelName), funnel];
+        saveProxySettings({ funnels: settings.funnels });
+        apiLog.info(`Saved funnel "${funnelName}" (${funnel.steps.length} steps)`);
+        res.writeHead(200, { 'Content-Type': 'application/json' });
+        res.end(JSON.stringify({ success: true, funnel }));
+      } catch (err) {
+        res.writeHead(400, { 'Content-Type': 'application/json' });
+        res.end(JSON.stringify({ success: false, error: err.message }));
+      }
+    });
+  } else if (funnelName && req.method === 'DELETE') {
+    const remaining = settings.funnels.filter(candidate => candidate.name !== funnelName);
+    if (remaining.length === settings.funnels.length) {
+      res.writeHead(404, { 'Content-Type': 'application/json' });
+      res.end(JSON.stringify({ success: false, error: `No funnel named "${funnelName}"` }));
+      return;
+    }
+    settings.funnels = remaining;
+    saveProxySettings({ funnels: settings.funnels });
+    res.writeHead(200, { 'Content-Type': 'application/json' });
+    res.end(JSON.stringify({ success: true }));
+  } else if (pathname === '/unmatched/skip-domains' && req.method === 'GET') {
+    res.writeHead(200, { 'Content-Type': 'application/json' });
+    res.end(JSON.stringify({
+      ...settings.unmatched,
+      defaults: DEFAULT_UNMATCHED_SKIP_DOMAINS,
+      effective: configManager.unmatchedSkipDomains
+    }));
+  } else if (pathname === '/unmatched/skip-domains' && req.method === 'PUT') {
+    // Replace unmatched.skipDomains (and optionally defaultSkipDomains), saved to the settings file
+    let body = '';
+    req.on('data', chunk => body += chunk);
+    req.on('end', () => {
+      try {
+        const changes = JSON.parse(body);
+        if ('skipDomains' in changes &&
+            !(Array.isArray(changes.skipDomains) && changes.skipDomains.every(domain => typeof domain === 'string' && domain))) {
+          throw new Error('skipDomains must be an array of domain patterns');
+        }
+        if ('defaultSkipDomains' in changes && typeof changes.defaultSkipDomains !== 'boolean') {
+          throw new Error('defaultSkipDomains must be true or false');
+        }
+
+        settings.unmatched = {
+          skipDomains: (changes.skipDomains ?? settings.unmatched.skipDomains).map(domain => domain.trim().toLowerCase()),
+          defaultSkipDomains: changes.defaultSkipDomains ?? settings.unmatched.defaultSkipDomains
+        };
+        saveProxySettings({ unmatched: settings.unmatched });
+        configManager.setUnmatchedSkipDomains(unmatchedSkipDomains(settings));
+        apiLog.info(`Unmatched skip list: ${configManager.unmatchedSkipDomains.length} patterns`);
+
+        res.writeHead(200, { 'Content-Type': 'application/json' });
+        res.end(JSON.stringify({ success: true, ...settings.unmatched, effective: configManager.unmatchedSkipDomains }));
+      } catch (err) {
+        res.writeHead(400, { 'Content-Type': 'application/json' });
+        res.end(JSON.stringify({ success: false, error: err.message }));
+      }
+    });
   } else {
     res.writeHead(404);
     res.end();
   }
+}
+
+const apiServer = http.createServer(handleApiRequest);
+apiServer.listen(API_PORT, LISTEN_HOSTS[0], () => {
+  listenOnMore(apiServer, LISTEN_HOSTS.slice(1), API_PORT, listenError);
+});
+
+// Same API over a local socket, used by the native host when the TCP port is
+// blocked by a local firewall
+const ipcServer = http.createServer(handleApiRequest);
+if (process.platform !== 'win32') {
+  fs.mkdirSync(path.dirname(API_SOCKET), { recursive: true });
+  try {
+    fs.unlinkSync(API_SOCKET);
+  } catch {
+    // No stale socket
+  }
+}
+ipcServer.listen(API_SOCKET);
+ipcServer.on('error', err => {
+  recordError('API socket error', err);
 });
 
-apiServer.listen(API_PORT);
+if (process.platform !== 'win32') {
+  process.on('SIGHUP', () => {
+    reloadSettings().catch(err => {
+      recordError('Error reloading settings', err);
+    });
+  });
+}
+
+/**
+ * Record this process in the profile's PID file, so "loggy-proxy instances"
+ * and the native host see proxies started outside the native host too. A
+ * PID file of another live proxy is left alone.
+ */
+function writePidFile() {
+  if (profiles.runningPid(PROFILE_PATHS.pidFile)) return;
+  try {
+    fs.mkdirSync(path.dirname(PROFILE_PATHS.pidFile), { recursive: true });
+    fs.writeFileSync(PROFILE_PATHS.pidFile, String(process.pid));
+  } catch (err) {
+    log.warn(`Could not write ${PROFILE_PATHS.pidFile}: ${err.message}`);
+  }
+}
+
+function removePidFile() {
+  try {
+    if (fs.readFileSync(PROFILE_PATHS.pidFile, 'utf8').trim() === String(process.pid)) {
+      fs.unlinkSync(PROFILE_PATHS.pidFile);
+    }
+  } catch {
+    // Already gone
+  }
+}
+
+// Flush sinks on shutdown
+['SIGINT', 'SIGTERM'].forEach(signal => {
+  process.on(signal, async () => {
+    await sinks.close();
+    removePidFile();
+    process.exit(0);
+  });
+});
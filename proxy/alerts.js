@@ -0,0 +1,110 @@
+/**
+ * AlertManager - Sends Slack/Discord webhook notifications for matching captures
+ *
+ * Rules come from the `alerts` proxy setting:
+ *   {
+ *     name: 'Checkout fired',
+ *     when: 'event',                 // 'event' (default) or 'unmatchedDomain'
+ *     event: 'Checkout*',            // Event name glob (* = anything)
+ *     properties: { plan: 'pro' },   // Nested path -> expected value ('*' = present)
+ *     webhook: 'https://hooks.slack.com/services/...',
+ *     channel: 'slack'               // 'slack' or 'discord'
+ *   }
+ */
+
+import { AnalyticsParser } from '../parsers.js';
+
+export class AlertManager {
+  constructor(config = {}) {
+    this.rules = (config.rules || []).filter(rule => rule.webhook);
+    this.cooldownMs = (config.cooldownSeconds ?? 60) * 1000;
+    this.lastFired = new Map(); // rule name -> timestamp
+
+    if (this.rules.length > 0) {
+      console.log('[Alerts] Loaded', this.rules.length, 'alert rule(s)');
+    }
+  }
+
+  /**
+   * Check a captured event against all event rules
+   * @param {object} event - Captured event
+   */
+  checkEvent(event) {
+    for (const rule of this.rules) {
+      if ((rule.when || 'event') !== 'event') continue;
+      if (!this.matchesEvent(rule, event)) continue;
+
+      this.fire(rule, `\`${event.event}\` from ${event._sourceName || event._source}`,
+        this.summarize(event.properties));
+    }
+  }
+
+  /**
+   * Notify rules watching for analytics traffic from unknown domains
+   * @param {string} domain - Newly seen unmatched domain
+   * @param {string} url - Request URL
+   */
+  checkUnmatchedDomain(domain, url) {
+    for (const rule of this.rules) {
+      if (rule.when !== 'unmatchedDomain') continue;
+      this.fire(rule, `new unmatched analytics domain \`${domain}\``, url);
+    }
+  }
+
+  matchesEvent(rule, event) {
+    if (rule.event && !AlertManager.globToRegex(rule.event).test(event.event || '')) {
+      return false;
+    }
+
+    for (const [path, expected] of Object.entries(rule.properties || {})) {
+      const value = AnalyticsParser.getNestedValue(event.properties, path);
+      if (value === undefined) return false;
+      if (expected !== '*' && String(value) !== String(expected)) return false;
+    }
+
+    return true;
+  }
+
+  /**
+   * Render a compact one-line property summary
+   */
+  summarize(properties = {}) {
+    const entries = Object.entries(properties || {}).slice(0, 8)
+      .map(([key, value]) => `${key}=${typeof value === 'object' ? JSON.stringify(value) : value}`);
+    return entries.join(', ').slice(0, 500);
+  }
+
+  fire(rule, summary, detail) {
+    const key = rule.name || rule.webhook;
+    const now = Date.now();
+    if (now - (this.lastFired.get(key) || 0) < this.cooldownMs) return;
+    this.lastFired.set(key, now);
+
+    // Slack mrkdwn bolds with *, Discord markdown with **
+    const bold = rule.channel === 'discord' ? '**' : '*';
+    const text = `${bold}${rule.name || 'Loggy alert'}${bold}: ${summary}` + (detail ? `\n${detail}` : '');
+    const body = rule.channel === 'discord' ? { content: text } : { text };
+
+    fetch(rule.webhook, {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify(body)
+    }).then(response => {
+      if (!response.ok) {
+        console.error(`[Alerts] Webhook for "${key}" returned HTTP ${response.status}`);
+      }
+    }).catch(err => {
+      console.error(`[Alerts] Webhook for "${key}" failed:`, err.message);
+    });
+  }
+
+  /**
+   * Convert an event-name glob into a case-insensitive regex
+   */
+  static globToRegex(pattern) {
+    const escaped = pattern
+      .replace(/[.+^${}()|[\]\\?]/g, '\\$&')
+      .replace(/\*/g, '.*');
+    return new RegExp(`^${escaped}$`, 'i');
+  }
+}
@@ -0,0 +1,189 @@
+/**
+ * AnomalyDetector - Sudden changes in how often events fire
+ *
+ * Captured events are counted per source and event name in fixed windows
+ * (anomalies.windowSeconds). Each name's baseline is a moving average of its
+ * per-window count, and once it has a few windows of history:
+ * - "spike": a window has spikeFactor times the baseline (and at least
+ *   minSpikeCount events), e.g. a page view firing in a render loop;
+ * - "disappeared": a name with a steady rate fires nothing for
+ *   silenceWindows windows in a row, e.g. a heartbeat that stopped;
+ * - "duplicate": identical events (same source, name and properties) fire
+ *   within duplicateWindowMs of each other, e.g. one click sending three
+ *   identical events. Reported once the burst is over, with its size.
+ * Anomalies are served by GET /anomalies and streamed by GET /anomalies/stream.
+ */
+
+export const ANOMALY_TYPES = ['spike', 'disappeared', 'duplicate'];
+
+// Weight of the newest window in the moving average
+const SMOOTHING = 0.3;
+// Windows a name needs before spikes count, and windows it must have fired
+// in before its silence counts (so a one-off burst never "disappears")
+const WARMUP_WINDOWS = 3;
+// Smallest baseline (events per window) whose silence is worth reporting
+const MIN_STEADY_RATE = 1;
+
+export class AnomalyDetector {
+  /**
+   * @param {object} options - The anomalies settings
+   * @param {function} onAnomaly - Called with each anomaly as it is found
+   */
+  constructor(options = {}, onAnomaly = () => {}) {
+    this.onAnomaly = onAnomaly;
+    this.rates = new Map();      // "<source>\n<event>" -> { source, event, count, baseline, windows, active, silent, reported }
+    this.bursts = new Map();     // "<source>\n<event>\n<properties>" -> { source, event, count, firstAt, lastAt }
+    this.anomalies = [];         // Newest last
+    this.totals = Object.fromEntries(ANOMALY_TYPES.map(type => [type, 0]));
+    this.nextId = 1;
+    this.timer = null;
+    this.configure(options);
+  }
+
+  /**
+   * Apply new settings (e.g. after a reload) and restart the window timer
+   */
+  configure({
+    enabled = true,
+    windowSeconds = 10,
+    spikeFactor = 5,
+    minSpikeCount = 10,
+    silenceWindows = 6,
+    duplicateWindowMs = 1000,
+    maxAnomalies = 200
+  } = {}) {
+    Object.assign(this, { enabled, windowSeconds, spikeFactor, minSpikeCount, silenceWindows, duplicateWindowMs, maxAnomalies });
+    this.stop();
+    if (enabled && windowSeconds > 0) {
+      this.timer = setInterval(() => this.tick(), windowSeconds * 1000);
+      this.timer.unref();
+    }
+  }
+
+  stop() {
+    clearInterval(this.timer);
+    this.timer = null;
+  }
+
+  /**
+   * Count a captured event
+   */
+  observe(event, now = Date.now()) {
+    if (!this.enabled || event._parseError || event._truncated) return;
+
+    const key = `${event._source}\n${event.event}`;
+    let rate = this.rates.get(key);
+    if (!rate) {
+      rate = { source: event._source, event: event.event, count: 0, baseline: null, windows: 0, active: 0, silent: 0, reported: false };
+      this.rates.set(key, rate);
+    }
+    rate.count++;
+
+    if (this.duplicateWindowMs <= 0) return;
+    let properties;
+    try {
+      properties = JSON.stringify(event.properties || {});
+    } catch {
+      return;
+    }
+    const burstKey = `${key}\n${properties}`;
+    const burst = this.bursts.get(burstKey);
+    if (burst && now - burst.lastAt <= this.duplicateWindowMs) {
+      burst.count++;
+      burst.lastAt = now;
+    } else {
+      if (burst) this.endBurst(burst, now);
+      this.bursts.set(burstKey, { source: event._source, event: event.event, count: 1, firstAt: now, lastAt: now });
+    }
+  }
+
+  /**
+   * Close the current window: check each name against its baseline, then
+   * fold the window into it. Runs on the window timer.
+   */
+  tick(now = Date.now()) {
+    for (const [key, rate] of this.rates) {
+      const { count, baseline } = rate;
+      if (rate.windows >= WARMUP_WINDOWS) {
+        if (count >= this.minSpikeCount && count > baseline * this.spikeFactor) {
+          this.report('spike', rate, now, {
+            count,
+            baseline: Math.round(baseline * 100) / 100,
+            windowSeconds: this.windowSeconds
+          }, `${rate.event} fired ${count} times in ${this.windowSeconds}s (usually ${Math.round(baseline * 10) / 10})`);
+        }
+        rate.silent = count === 0 ? rate.silent + 1 : 0;
+        if (rate.silent === 0) rate.reported = false;
+        const steady = rate.active >= WARMUP_WINDOWS && baseline >= MIN_STEADY_RATE;
+        if (rate.silent >= this.silenceWindows && steady && !rate.reported) {
+          rate.reported = true;
+          this.report('disappeared', rate, now, {
+            baseline: Math.round(baseline * 100) / 100,
+            silentSeconds: rate.silent * this.windowSeconds
+          }, `${rate.event} has not fired for ${rate.silent * this.windowSeconds}s (usually ${Math.round(baseline * 10) / 10} per ${this.windowSeconds}s)`);
+        }
+      }
+
+      // Spikes count toward the baseline too, so a new steady rate stops being reported
+      rate.baseline = baseline === null ? count : SMOOTHING * count + (1 - SMOOTHING) * baseline;
+      rate.windows++;
+      if (count > 0) rate.active++;
+      rate.count = 0;
+      // Forget names that have faded out
+      if (rate.baseline < 0.01 && rate.windows > WARMUP_WINDOWS) this.rates.delete(key);
+    }
+
+    for (const [key, burst] of this.bursts) {
+      if (now - burst.lastAt > this.duplicateWindowMs) {
+        this.endBurst(burst, now);
+        this.bursts.delete(key);
+      }
+    }
+  }
+
+  endBurst(burst, now = Date.now()) {
+    if (burst.count < 2) return;
+    this.report('duplicate', burst, now, {
+      count: burst.count,
+      spanMs: burst.lastAt - burst.firstAt
+    }, `${burst.event} fired ${burst.count} identical events within ${burst.lastAt - burst.firstAt}ms`);
+  }
+
+  report(type, { source, event }, now, details, message) {
+    const anomaly = {
+      id: this.nextId++,
+      type,
+      source,
+      event,
+      detectedAt: new Date(now).toISOString(),
+      message,
+      details
+    };
+    this.anomalies.push(anomaly);
+    if (this.anomalies.length > this.maxAnomalies) this.anomalies.shift();
+    this.totals[type]++;
+    this.onAnomaly(anomaly);
+  }
+
+  /**
+   * @returns {Array<object>} - Anomalies, newest first
+   */
+  list() {
+    return [...this.anomalies].reverse();
+  }
+
+  clear() {
+    this.anomalies = [];
+  }
+
+  /**
+   * The loggy_anomalies_total counter, as Prometheus text lines
+   */
+  prometheusLines() {
+    return [
+      '# HELP loggy_anomalies_total Event-frequency anomalies detected, by type',
+      '# TYPE loggy_anomalies_total counter',
+      ...ANOMALY_TYPES.map(type => `loggy_anomalies_total{type="${type}"} ${this.totals[type]}`)
+    ];
+  }
+}
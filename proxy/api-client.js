@@ -0,0 +1,76 @@
+/**
+ * Client for the running proxy's API, used by the loggy-proxy CLI
+ *
+ * Tries the profile's local socket first and falls back to the TCP API port,
+ * the same way the native host does (see proxyApiRequest in proxy-host.cjs).
+ */
+
+import http from 'http';
+import { PROFILE_PATHS } from '../config/proxy-settings.js';
+
+export class ProxyApiClient {
+  /**
+   * @param {object} options
+   * @param {number} options.apiPort - TCP API port (settings.apiPort)
+   * @param {string} options.socketPath - Local socket (default: the profile's)
+   * @param {number} options.timeoutMs - Per-request timeout
+   */
+  constructor({ apiPort = 8889, socketPath = PROFILE_PATHS.apiSocket, timeoutMs = 5000 } = {}) {
+    this.apiPort = apiPort;
+    this.socketPath = socketPath;
+    this.timeoutMs = timeoutMs;
+  }
+
+  /**
+   * @returns {Promise<object>} - Parsed JSON body (also for non-2xx responses)
+   */
+  request(method, apiPath, body = null) {
+    const attempt = target => new Promise((resolve, reject) => {
+      const req = http.request({ ...target, path: apiPath, method, timeout: this.timeoutMs }, res => {
+        let data = '';
+        res.on('data', chunk => data += chunk);
+        res.on('end', () => {
+          try {
+            resolve(JSON.parse(data || '{}'));
+          } catch (err) {
+            reject(new Error(`Invalid response from proxy (HTTP ${res.statusCode})`));
+          }
+        });
+      });
+      req.on('timeout', () => req.destroy(new Error('Request timed out')));
+      req.on('error', reject);
+      if (body !== null) {
+        req.setHeader('Content-Type', 'application/json');
+        req.write(typeof body === 'string' ? body : JSON.stringify(body));
+      }
+      req.end();
+    });
+
+    return attempt({ socketPath: this.socketPath })
+      .catch(() => attempt({ host: '127.0.0.1', port: this.apiPort }));
+  }
+
+  get(apiPath) {
+    return this.request('GET', apiPath);
+  }
+
+  /**
+   * Whether a proxy is answering on the socket or port
+   */
+  async isReachable() {
+    try {
+      await this.get('/healthz');
+      return true;
+    } catch (err) {
+      return false;
+    }
+  }
+
+  /**
+   * Most recent events, newest first
+   */
+  async getRecentEvents(limit = 100) {
+    const { events = [] } = await this.get(`/events?limit=${limit}`);
+    return events;
+  }
+}
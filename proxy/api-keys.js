@@ -0,0 +1,128 @@
+/**
+ * API keys for the proxy API when it is reachable beyond loopback
+ *
+ * Requests from loopback and the API socket are always allowed: that's the
+ * extension, the native host and the CLI. Once apiKeys lists any keys, other
+ * requests must present one, as "Authorization: Bearer <key>", an
+ * X-Loggy-Key header, or ?key=<key> (for EventSource, which can't set
+ * headers), and the key needs the scope of what the request does:
+ * - read: every GET, plus POST /events/wait and /funnels/check
+ * - clear: POST /clear, DELETE /anomalies, DELETE /pinned-domains and
+ *   DELETE /events/pinned
+ * - configure: everything else (sources, funnels, settings, adding events)
+ * With no keys configured the API stays open, as before.
+ *
+ * Since loopback is trusted, the proxy must not relay requests to the API:
+ * they would reach it from the proxy itself. Requests and CONNECTs whose
+ * target is the API port on this machine are refused (see reachesThisMachine).
+ */
+
+import crypto from 'crypto';
+import dns from 'dns/promises';
+import os from 'os';
+
+export const API_SCOPES = ['read', 'clear', 'configure'];
+
+// POSTs that only read
+const READ_ROUTES = ['POST /events/wait', 'POST /funnels/check'];
+const CLEAR_ROUTES = ['POST /clear', 'DELETE /anomalies', 'DELETE /pinned-domains', 'DELETE /events/pinned'];
+
+/**
+ * @returns {string} - The scope a request needs
+ */
+export function requiredScope(method, pathname) {
+  const route = `${method} ${pathname}`;
+  if (method === 'GET' || READ_ROUTES.includes(route)) return 'read';
+  if (CLEAR_ROUTES.includes(route)) return 'clear';
+  return 'configure';
+}
+
+export function generateApiKey() {
+  return 'loggy_' + crypto.randomBytes(24).toString('base64url');
+}
+
+/**
+ * Check the apiKeys setting's shape
+ * @returns {string|null} - What's wrong, or null
+ */
+export function validateApiKeys(keys) {
+  if (!Array.isArray(keys)) return 'apiKeys must be an array of { name, key, scopes }';
+  const names = new Set();
+  for (const [i, entry] of keys.entries()) {
+    const where = `apiKeys[${i}]`;
+    if (!entry || typeof entry !== 'object') return `${where} must be an object with name, key and scopes`;
+    if (typeof entry.name !== 'string' || !entry.name) return `${where}: "name" is required`;
+    if (names.has(entry.name)) return `${where}: another key is named "${entry.name}"`;
+    names.add(entry.name);
+    if (typeof entry.key !== 'string' || entry.key.length < 16) return `${where}: "key" must be at least 16 characters`;
+    if (!Array.isArray(entry.scopes) || entry.scopes.length === 0 || !entry.scopes.every(scope => API_SCOPES.includes(scope))) {
+      return `${where}: "scopes" must list one or more of ${API_SCOPES.join(', ')}`;
+    }
+  }
+  return null;
+}
+
+function isLoopback(address) {
+  // No address: the API socket (a local Unix socket or named pipe)
+  if (!address) return true;
+  const ipv4 = address.startsWith('::ffff:') ? address.slice('::ffff:'.length) : address;
+  return ipv4.startsWith('127.') || address === '::1';
+}
+
+/**
+ * Whether a host resolves to this machine: a loopback or unspecified
+ * address, or the address of one of its network interfaces
+ * @param {string} hostname - Name or address, IPv6 with or without brackets
+ * @returns {Promise<boolean>}
+ */
+export async function reachesThisMachine(hostname) {
+  let addresses;
+  try {
+    addresses = await dns.lookup(hostname.replace(/^\[|\]$/g, ''), { all: true });
+  } catch {
+    // Doesn't resolve, so the request can't go anywhere
+    return false;
+  }
+  const own = new Set(Object.values(os.networkInterfaces()).flat().map(({ address }) => address));
+  return addresses.some(({ address }) => {
+    const ipv4 = address.startsWith('::ffff:') ? address.slice('::ffff:'.length) : address;
+    return isLoopback(address) || ipv4 === '0.0.0.0' || address === '::' || own.has(ipv4) || own.has(address);
+  });
+}
+
+function presentedKey(req, searchParams) {
+  const authorization = req.headers.authorization || '';
+  if (authorization.toLowerCase().startsWith('bearer ')) return authorization.slice('bearer '.length).trim();
+  return req.headers['x-loggy-key'] || searchParams.get('key') || null;
+}
+
+function sameKey(a, b) {
+  // Compared as hashes, so the comparison takes the same time for any length
+  const digest = value => crypto.createHash('sha256').update(value).digest();
+  return crypto.timingSafeEqual(digest(a), digest(b));
+}
+
+/**
+ * Whether an API request may go ahead
+ * @param {http.IncomingMessage} req
+ * @param {URLSearchParams} searchParams
+ * @param {Array<object>} keys - The apiKeys setting
+ * @returns {object|null} - null if allowed, else { status, error }
+ */
+export function authorizeApiRequest(req, pathname, searchParams, keys) {
+  if (isLoopback(req.socket.remoteAddress)) return null;
+  // A broken apiKeys setting locks remote clients out rather than opening the API
+  if (validateApiKeys(keys)) return { status: 503, error: 'The proxy\'s apiKeys setting is invalid (see its log)' };
+  if (keys.length === 0) return null;
+
+  const presented = presentedKey(req, searchParams);
+  const entry = presented && keys.find(candidate => typeof candidate.key === 'string' && sameKey(candidate.key, presented));
+  if (!entry) {
+    return { status: 401, error: 'An API key is required (Authorization: Bearer <key>)' };
+  }
+  const scope = requiredScope(req.method, pathname);
+  if (!Array.isArray(entry.scopes) || !entry.scopes.includes(scope)) {
+    return { status: 403, error: `API key "${entry.name}" does not have the "${scope}" scope` };
+  }
+  return null;
+}
@@ -0,0 +1,130 @@
+/**
+ * JUnit XML and TAP reports of assertion results, for CI systems
+ *
+ * Each expectation (and each order constraint) becomes one test case. A
+ * failing case carries its details plus an excerpt of the event involved:
+ * the closest miss or the first extra event.
+ */
+
+import fs from 'fs';
+import path from 'path';
+import { capturedAt } from './event-export.js';
+
+// Report format implied by a file extension
+const FORMATS = { '.xml': 'junit', '.tap': 'tap' };
+
+// Longest payload excerpt attached to a test case
+const EXCERPT_LENGTH = 2000;
+
+/**
+ * @param {string} format - "junit" or "tap" (default: from the extension)
+ * @param {string} reportPath - Report file
+ * @returns {string}
+ */
+export function resolveReportFormat(format, reportPath) {
+  const resolved = format || FORMATS[path.extname(reportPath).toLowerCase()];
+  if (!['junit', 'tap'].includes(resolved)) {
+    throw new Error(format
+      ? `Unknown report format "${format}" (expected junit or tap)`
+      : `Cannot tell the report format from "${reportPath}" (use .xml or .tap, or --report-format junit|tap)`);
+  }
+  return resolved;
+}
+
+/**
+ * The parts of an event worth attaching, as indented JSON
+ */
+function excerpt(event) {
+  if (!event) return null;
+  const text = JSON.stringify({
+    event: event.event,
+    source: event._source,
+    capturedAt: capturedAt(event),
+    url: event._metadata && event._metadata.url,
+    properties: event.properties
+  }, null, 2);
+  return text.length > EXCERPT_LENGTH ? `${text.slice(0, EXCERPT_LENGTH)}\n... (truncated)` : text;
+}
+
+function escapeXml(value) {
+  return String(value)
+    .replace(/&/g, '&amp;')
+    .replace(/</g, '&lt;')
+    .replace(/>/g, '&gt;')
+    .replace(/"/g, '&quot;')
+    // Characters XML 1.0 does not allow
+    .replace(/[\u0000-\u0008\u000b\u000c\u000e-\u001f]/g, '');
+}
+
+/**
+ * @param {object} report - From checkExpectations
+ * @param {object} options
+ * @param {string} options.suite - Suite name (the spec file)
+ * @param {number} options.events - Number of events checked
+ * @returns {string}
+ */
+export function formatJUnit(report, { suite, events = 0 } = {}) {
+  const failures = report.results.filter(result => !result.passed).length;
+  const lines = [
+    '<?xml version="1.0" encoding="UTF-8"?>',
+    `<testsuites name="loggy" tests="${report.results.length}" failures="${failures}">`,
+    `  <testsuite name="${escapeXml(suite)}" tests="${report.results.length}" failures="${failures}" timestamp="${new Date().toISOString()}">`,
+    '    <properties>',
+    `      <property name="events" value="${events}"/>`,
+    '    </properties>'
+  ];
+
+  for (const result of report.results) {
+    const payload = excerpt(result.example);
+    lines.push(`    <testcase classname="${escapeXml(`loggy.${suite}`)}" name="${escapeXml(result.name)}">`);
+    if (!result.passed) {
+      const body = [...result.details, ...(payload ? ['', 'Event:', payload] : [])].join('\n');
+      lines.push(`      <failure message="${escapeXml(result.message)}" type="expectation">${escapeXml(body)}</failure>`);
+    } else if (payload) {
+      lines.push(`      <system-out>${escapeXml(payload)}</system-out>`);
+    }
+    lines.push('    </testcase>');
+  }
+
+  lines.push('  </testsuite>', '</testsuites>', '');
+  return lines.join('\n');
+}
+
+/**
+ * TAP version 13, with a YAML block of details on failures
+ * @param {object} report - From checkExpectations
+ * @returns {string}
+ */
+export function formatTAP(report) {
+  const lines = ['TAP version 13', `1..${report.results.length}`];
+
+  report.results.forEach((result, i) => {
+    lines.push(`${result.passed ? 'ok' : 'not ok'} ${i + 1} - ${result.message.replace(/#/g, '\\#')}`);
+    if (result.passed) return;
+
+    // JSON strings are valid YAML scalars
+    lines.push('  ---', `  message: ${JSON.stringify(result.message)}`);
+    if (result.details.length > 0) {
+      lines.push('  details:', ...result.details.map(detail => `    - ${JSON.stringify(detail.trim())}`));
+    }
+    const payload = excerpt(result.example);
+    if (payload) {
+      lines.push('  event: |', ...payload.split('\n').map(line => `    ${line}`));
+    }
+    lines.push('  ...');
+  });
+
+  lines.push('');
+  return lines.join('\n');
+}
+
+/**
+ * Write a report file
+ * @returns {string} - The format written
+ */
+export function writeReport(report, reportPath, { format = null, suite, events } = {}) {
+  const resolved = resolveReportFormat(format, reportPath);
+  fs.mkdirSync(path.dirname(path.resolve(reportPath)), { recursive: true });
+  fs.writeFileSync(reportPath, resolved === 'junit' ? formatJUnit(report, { suite, events }) : formatTAP(report));
+  return resolved;
+}
@@ -0,0 +1,150 @@
+/**
+ * Launch settings for running Playwright or Puppeteer browsers through the
+ * proxy, for `loggy-proxy browser-args`
+ *
+ * Chromium has no per-profile trust store: it trusts the CA only through the
+ * OS store (see trust-store.cjs), so an untrusted CA means ignoring
+ * certificate errors. Firefox keeps roots in its profile, so a provisioned
+ * Firefox profile gets the CA imported with NSS certutil.
+ */
+
+import fs from 'fs';
+import path from 'path';
+import { createRequire } from 'module';
+
+const require = createRequire(import.meta.url);
+const trustStore = require('./trust-store.cjs');
+
+export const BROWSERS = ['chromium', 'firefox'];
+
+/**
+ * Preferences pointing a Firefox profile at the proxy
+ */
+function firefoxProxyPrefs(port) {
+  return {
+    'network.proxy.type': 1,
+    'network.proxy.http': '127.0.0.1',
+    'network.proxy.http_port': port,
+    'network.proxy.ssl': '127.0.0.1',
+    'network.proxy.ssl_port': port,
+    'network.proxy.no_proxies_on': '',
+    // Send localhost traffic through the proxy too (dev servers)
+    'network.proxy.allow_hijacking_localhost': true,
+    // macOS and Windows: trust roots from the OS store as well
+    'security.enterprise_roots.enabled': true
+  };
+}
+
+/**
+ * Create an isolated browser profile: for Firefox, with the proxy set and
+ * the CA imported into its certificate database
+ * @returns {Promise<object>} - { caTrusted, notes }
+ */
+async function provisionProfile(browser, dir, ca, port) {
+  fs.mkdirSync(dir, { recursive: true });
+
+  if (browser === 'chromium') {
+    // Skip the first-run dialogs
+    fs.writeFileSync(path.join(dir, 'First Run'), '');
+    return { caTrusted: null, notes: ['Chromium has no per-profile trust store; the CA is trusted through the OS store or not at all'] };
+  }
+
+  const prefs = Object.entries(firefoxProxyPrefs(port))
+    .map(([name, value]) => `user_pref(${JSON.stringify(name)}, ${JSON.stringify(value)});`);
+  fs.writeFileSync(path.join(dir, 'user.js'), `// Written by loggy-proxy browser-args\n${prefs.join('\n')}\n`);
+
+  const db = `sql:${dir}`;
+  if (!fs.existsSync(path.join(dir, 'cert9.db'))) {
+    const created = await trustStore.runCommand('certutil', ['-N', '-d', db, '--empty-password']);
+    if (created.code !== 0) {
+      return {
+        caTrusted: false,
+        notes: [`Could not create the profile's certificate database with certutil (install libnss3-tools or nss): ${(created.stderr || created.stdout).trim()}`]
+      };
+    }
+  }
+  // Replace a CA imported before a rotation
+  await trustStore.runCommand('certutil', ['-D', '-d', db, '-n', ca.nickname]);
+  const added = await trustStore.runCommand('certutil', ['-A', '-d', db, '-n', ca.nickname, '-t', 'C,,', '-i', ca.certPath]);
+  if (added.code !== 0) {
+    return { caTrusted: false, notes: [`Could not import the CA into ${dir}: ${(added.stderr || added.stdout).trim()}`] };
+  }
+  return { caTrusted: true, notes: [] };
+}
+
+/**
+ * Proxy, CA trust and launch options for a browser
+ * @param {object} settings - Proxy settings
+ * @param {object} options
+ * @param {string} options.browser - "chromium" or "firefox"
+ * @param {string} options.userDataDir - Provision an isolated profile here
+ * @returns {Promise<object>} - { browser, proxyServer, caCertPath, caTrusted, ignoreCertificateErrors, userDataDir, args, notes, playwright, puppeteer }
+ */
+export async function browserLaunchConfig(settings, { browser = 'chromium', userDataDir = null } = {}) {
+  if (!BROWSERS.includes(browser)) {
+    throw new Error(`Unknown browser "${browser}" (expected ${BROWSERS.join(' or ')})`);
+  }
+  const ca = trustStore.getCaCert(settings);
+  if (!fs.existsSync(ca.certPath)) {
+    throw new Error(`No ${ca.keyType.toUpperCase()} CA at ${ca.certPath} (create it with "loggy-proxy cert generate", or start the proxy)`);
+  }
+
+  const proxyServer = `http://127.0.0.1:${settings.proxyPort}`;
+  const dir = userDataDir ? path.resolve(userDataDir) : null;
+  const notes = [];
+
+  // Trusted by the OS store: Chrome everywhere, Firefox through enterprise_roots (not on Linux)
+  const status = await trustStore.getTrustStatus(ca);
+  let caTrusted = status.trusted === true && (browser === 'chromium' || process.platform !== 'linux');
+  if (dir) {
+    const provisioned = await provisionProfile(browser, dir, ca, settings.proxyPort);
+    caTrusted = caTrusted || provisioned.caTrusted === true;
+    notes.push(...provisioned.notes);
+  }
+  if (!caTrusted) {
+    notes.push('The CA is not trusted, so certificate errors are ignored ("loggy-proxy cert trust" avoids that)');
+  }
+  const ignoreCertificateErrors = !caTrusted;
+
+  // Firefox has no proxy flag: a started-by-hand Firefox needs the provisioned profile
+  let args;
+  if (browser === 'chromium') {
+    args = [`--proxy-server=${proxyServer}`];
+    if (ignoreCertificateErrors) args.push('--ignore-certificate-errors');
+  } else {
+    args = [];
+    if (!dir) notes.push('Firefox started by hand needs --user-data-dir, which sets the proxy in its profile');
+  }
+
+  const playwright = {
+    // With a userDataDir, pass both to launchPersistentContext(userDataDir, { ...launchOptions, ...contextOptions })
+    launchOptions: {
+      proxy: { server: proxyServer },
+      ...(browser === 'firefox' ? { firefoxUserPrefs: { 'security.enterprise_roots.enabled': true } } : {})
+    },
+    contextOptions: ignoreCertificateErrors ? { ignoreHTTPSErrors: true } : {}
+  };
+  const puppeteer = browser === 'chromium'
+    ? {
+        launchOptions: {
+          args: [`--proxy-server=${proxyServer}`],
+          acceptInsecureCerts: ignoreCertificateErrors,
+          ...(dir ? { userDataDir: dir } : {})
+        }
+      }
+    : null;
+
+  return {
+    browser,
+    proxyServer,
+    caCertPath: ca.certPath,
+    caTrusted,
+    ignoreCertificateErrors,
+    userDataDir: dir,
+    // For a browser started by hand (Playwright rejects --user-data-dir in args)
+    args: dir ? [...args, ...(browser === 'firefox' ? ['-profile', dir] : [`--user-data-dir=${dir}`])] : args,
+    notes,
+    playwright,
+    puppeteer
+  };
+}
@@ -0,0 +1,103 @@
+/**
+ * BufferPool - Reused buffers for collecting analytics request bodies
+ *
+ * Busy pages send dozens of analytics requests a second. Rather than
+ * allocating a buffer per request (and another for Buffer.concat), a body is
+ * copied chunk by chunk into a buffer taken from the pool, which goes back to
+ * the pool once the body has been parsed. Bodies that outgrow a buffer move to
+ * one twice its size; buffers larger than maxBufferSize are left to the
+ * garbage collector so one huge upload does not stay pinned in memory.
+ *
+ * Decompression is not pooled: zlib's one-shot decompressors (used on the
+ * parse workers) can't be reset and reused, and their output is only held
+ * until it has been decoded to a string.
+ */
+
+export class BufferPool {
+  /**
+   * @param {object} options
+   * @param {number} options.bufferSize - Size of a new buffer
+   * @param {number} options.maxBuffers - Idle buffers kept for reuse
+   * @param {number} options.maxBufferSize - Larger buffers are not kept
+   */
+  constructor({ bufferSize = 16 * 1024, maxBuffers = 64, maxBufferSize = 1024 * 1024 } = {}) {
+    this.bufferSize = bufferSize;
+    this.maxBuffers = maxBuffers;
+    this.maxBufferSize = maxBufferSize;
+    this.free = [];
+    this.counts = { reused: 0, allocated: 0 };
+  }
+
+  /**
+   * A buffer of at least size bytes (its contents are not cleared)
+   */
+  acquire(size = this.bufferSize) {
+    const index = this.free.findIndex(buffer => buffer.length >= size);
+    if (index !== -1) {
+      this.counts.reused++;
+      return this.free.splice(index, 1)[0];
+    }
+    this.counts.allocated++;
+    return Buffer.allocUnsafeSlow(Math.max(size, this.bufferSize));
+  }
+
+  release(buffer) {
+    if (buffer.length <= this.maxBufferSize && this.free.length < this.maxBuffers) {
+      this.free.push(buffer);
+    }
+  }
+
+  /**
+   * Start collecting a body
+   * @returns {PooledBody}
+   */
+  body() {
+    return new PooledBody(this);
+  }
+
+  /**
+   * @returns {object} - { idle, reused, allocated }
+   */
+  stats() {
+    return { idle: this.free.length, ...this.counts };
+  }
+}
+
+/**
+ * A request body being collected into a pooled buffer
+ */
+export class PooledBody {
+  constructor(pool) {
+    this.pool = pool;
+    this.buffer = null;
+    this.length = 0;
+  }
+
+  append(chunk) {
+    if (!this.buffer || this.length + chunk.length > this.buffer.length) {
+      const grown = this.pool.acquire(Math.max(this.length + chunk.length, this.buffer ? this.buffer.length * 2 : 0));
+      if (this.buffer) {
+        this.buffer.copy(grown, 0, 0, this.length);
+        this.pool.release(this.buffer);
+      }
+      this.buffer = grown;
+    }
+    chunk.copy(this.buffer, this.length);
+    this.length += chunk.length;
+  }
+
+  /**
+   * The collected bytes. A view of the pooled buffer: don't keep it past
+   * release().
+   * @returns {Buffer}
+   */
+  bytes() {
+    return this.buffer ? this.buffer.subarray(0, this.length) : Buffer.alloc(0);
+  }
+
+  release() {
+    if (this.buffer) this.pool.release(this.buffer);
+    this.buffer = null;
+    this.length = 0;
+  }
+}
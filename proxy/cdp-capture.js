@@ -0,0 +1,238 @@
+/**
+ * CdpCapture - Capture request bodies from Chrome over the DevTools Protocol
+ *
+ * An alternative to intercepting traffic: attach to a Chrome started with
+ * --remote-debugging-port and read each request's body from its Network
+ * domain (Network.requestWillBeSent, and Network.getRequestPostData when the
+ * body isn't inlined). No CA has to be trusted and the browser needs no proxy
+ * flags, but only that browser's traffic is seen. Pages, their out-of-process
+ * frames and workers, service workers and extension background pages are all
+ * attached (Target.setAutoAttach), so extension traffic is covered too.
+ *
+ * Bodies are handed to onRequest as the page sent them: one the page
+ * compressed arrives compressed, with its Content-Encoding header, as it
+ * would through the proxy. GETs that wants() accepts (hits whose payload is
+ * the query string) arrive with an empty body. Reconnects every
+ * reconnectSeconds while the browser is not reachable.
+ */
+
+import WebSocket from 'ws';
+
+// Target types whose network traffic is captured
+const CAPTURED_TARGETS = ['page', 'iframe', 'worker', 'shared_worker', 'service_worker', 'background_page'];
+const METHODS_WITH_BODIES = ['POST', 'PUT', 'PATCH'];
+
+export class CdpCapture {
+  /**
+   * @param {object} options
+   * @param {string} options.endpoint - http://host:port of --remote-debugging-port, or a browser ws:// URL
+   * @param {number} options.reconnectSeconds - Wait between connection attempts
+   * @param {function} options.wants - ({ method, url }) => whether to fetch and capture the body
+   * @param {function} options.onRequest - Called with { method, url, headers, body, target }
+   */
+  constructor({ endpoint, reconnectSeconds = 5, wants = () => true, onRequest }) {
+    this.endpoint = endpoint;
+    this.reconnectSeconds = reconnectSeconds;
+    this.wants = wants;
+    this.onRequest = onRequest;
+    this.socket = null;
+    this.nextId = 1;
+    this.pending = new Map(); // command ID -> { resolve, reject }
+    this.sessions = new Map(); // session ID -> { type, url }
+    this.reconnectTimer = null;
+    this.closed = false;
+    this.state = {
+      connected: false,
+      browser: null,
+      connectedAt: null,
+      requests: 0,       // Bodies handed to onRequest
+      missingBodies: 0,  // Bodies Chrome no longer had (or never kept, e.g. blobs)
+      lastError: null
+    };
+  }
+
+  start() {
+    this.connect();
+  }
+
+  async connect() {
+    if (this.closed) return;
+    let url;
+    try {
+      url = await this.browserSocketUrl();
+    } catch (err) {
+      this.failed(err);
+      return;
+    }
+
+    const socket = new WebSocket(url, { perMessageDeflate: false, maxPayload: 256 * 1024 * 1024 });
+    this.socket = socket;
+    socket.on('open', () => this.attach().catch(err => this.failed(err)));
+    socket.on('message', data => this.receive(data));
+    socket.on('error', err => this.failed(err));
+    socket.on('close', () => {
+      if (this.socket !== socket) return;
+      if (this.state.connected) console.warn(`[CDP] Disconnected from ${this.endpoint}`);
+      this.disconnected();
+      this.scheduleReconnect();
+    });
+  }
+
+  /**
+   * The browser's WebSocket URL (from /json/version for an http:// endpoint)
+   */
+  async browserSocketUrl() {
+    if (/^wss?:\/\//.test(this.endpoint)) return this.endpoint;
+    const response = await fetch(new URL('/json/version', this.endpoint));
+    if (!response.ok) throw new Error(`${this.endpoint}/json/version answered ${response.status}`);
+    const version = await response.json();
+    if (!version.webSocketDebuggerUrl) throw new Error(`${this.endpoint} gave no webSocketDebuggerUrl`);
+    this.state.browser = version.Browser || null;
+    return version.webSocketDebuggerUrl;
+  }
+
+  async attach() {
+    // Top-level targets; each attached session then auto-attaches its own
+    // children (out-of-process frames, dedicated workers)
+    await this.send('Target.setAutoAttach', { autoAttach: true, waitForDebuggerOnStart: false, flatten: true });
+    this.state.connected = true;
+    this.state.connectedAt = new Date().toISOString();
+    this.state.lastError = null;
+    console.log(`[CDP] Attached to ${this.state.browser || 'the browser'} at ${this.endpoint}`);
+  }
+
+  async attachSession(sessionId, targetInfo) {
+    if (!CAPTURED_TARGETS.includes(targetInfo.type)) {
+      await this.send('Runtime.runIfWaitingForDebugger', {}, sessionId).catch(() => {});
+      return;
+    }
+    this.sessions.set(sessionId, { type: targetInfo.type, url: targetInfo.url });
+    try {
+      await this.send('Network.enable', {}, sessionId);
+      await this.send('Target.setAutoAttach', { autoAttach: true, waitForDebuggerOnStart: false, flatten: true }, sessionId).catch(() => {});
+    } catch {
+      // The target closed while attaching
+    }
+  }
+
+  receive(data) {
+    let message;
+    try {
+      message = JSON.parse(data);
+    } catch {
+      return;
+    }
+
+    if (message.id !== undefined) {
+      const pending = this.pending.get(message.id);
+      if (!pending) return;
+      this.pending.delete(message.id);
+      if (message.error) {
+        pending.reject(new Error(message.error.message));
+      } else {
+        pending.resolve(message.result);
+      }
+      return;
+    }
+
+    const { method, params, sessionId } = message;
+    if (method === 'Target.attachedToTarget') {
+      this.attachSession(params.sessionId, params.targetInfo);
+    } else if (method === 'Target.detachedFromTarget') {
+      this.sessions.delete(params.sessionId);
+    } else if (method === 'Network.requestWillBeSent') {
+      this.requestWillBeSent(params, sessionId).catch(err => {
+        this.state.lastError = err.message;
+      });
+    }
+  }
+
+  async requestWillBeSent({ requestId, request }, sessionId) {
+    const method = request.method;
+    const hasBody = METHODS_WITH_BODIES.includes(method) && request.hasPostData;
+    if (!hasBody && method !== 'GET') return;
+    if (!this.wants({ method, url: request.url })) return;
+
+    let body = hasBody
+      ? bodyFromEntries(request.postDataEntries) || (request.postData !== undefined ? Buffer.from(request.postData, 'utf8') : null)
+      : Buffer.alloc(0);
+    if (!body) {
+      // Not inlined (large or multi-part bodies): ask for it while Chrome still has it
+      try {
+        const result = await this.send('Network.getRequestPostData', { requestId }, sessionId);
+        body = Buffer.from(result.postData, result.base64Encoded ? 'base64' : 'utf8');
+      } catch {
+        this.state.missingBodies++;
+        return;
+      }
+    }
+
+    this.state.requests++;
+    const target = this.sessions.get(sessionId) || null;
+    this.onRequest({ method, url: request.url, headers: lowerCaseHeaders(request.headers), body, target });
+  }
+
+  send(method, params = {}, sessionId = undefined) {
+    if (!this.socket || this.socket.readyState !== WebSocket.OPEN) {
+      return Promise.reject(new Error('Not connected'));
+    }
+    const id = this.nextId++;
+    this.socket.send(JSON.stringify({ id, method, params, sessionId }));
+    return new Promise((resolve, reject) => this.pending.set(id, { resolve, reject }));
+  }
+
+  failed(err) {
+    if (this.state.lastError !== err.message) {
+      console.warn(`[CDP] Cannot reach Chrome at ${this.endpoint}: ${err.message} (start it with --remote-debugging-port; retrying every ${this.reconnectSeconds}s)`);
+    }
+    this.state.lastError = err.message;
+    if (!this.socket) this.scheduleReconnect();
+  }
+
+  disconnected() {
+    this.socket = null;
+    this.state.connected = false;
+    this.sessions.clear();
+    for (const pending of this.pending.values()) pending.reject(new Error('Disconnected'));
+    this.pending.clear();
+  }
+
+  scheduleReconnect() {
+    if (this.closed || this.reconnectTimer) return;
+    this.reconnectTimer = setTimeout(() => {
+      this.reconnectTimer = null;
+      this.connect();
+    }, this.reconnectSeconds * 1000);
+    this.reconnectTimer.unref();
+  }
+
+  /**
+   * Connection state and counts for GET /status
+   */
+  getStatus() {
+    return { endpoint: this.endpoint, ...this.state, targets: this.sessions.size };
+  }
+
+  close() {
+    this.closed = true;
+    clearTimeout(this.reconnectTimer);
+    if (this.socket) {
+      const socket = this.socket;
+      this.disconnected();
+      socket.close();
+    }
+  }
+}
+
+/**
+ * A body from postDataEntries (base64 bytes per part), or null if Chrome
+ * didn't include them
+ */
+function bodyFromEntries(entries) {
+  if (!Array.isArray(entries) || entries.length === 0 || entries.some(entry => entry.bytes === undefined)) return null;
+  return Buffer.concat(entries.map(entry => Buffer.from(entry.bytes, 'base64')));
+}
+
+function lowerCaseHeaders(headers = {}) {
+  return Object.fromEntries(Object.entries(headers).map(([name, value]) => [name.toLowerCase(), value]));
+}
@@ -0,0 +1,279 @@
+/**
+ * Certificate maintenance for the `loggy-proxy cert` commands and proxy startup
+ *
+ * Works on the CA selected by `certificates.keyType` and the OS trust store,
+ * without needing the proxy to be running.
+ */
+
+import crypto from 'crypto';
+import fs from 'fs';
+import path from 'path';
+import { createRequire } from 'module';
+import { CertificateAuthority, caDirs, generateRsaCA, storePassphrase } from './certificate-authority.js';
+import { LeafCertificateCache, leafCacheDir } from './leaf-cache.js';
+import { createTrustStore } from './pkcs12.js';
+
+const require = createRequire(import.meta.url);
+const trustStore = require('./trust-store.cjs');
+
+// Output format implied by a file extension
+const EXPORT_FORMATS = { '.pem': 'pem', '.der': 'der', '.crt': 'der', '.cer': 'der', '.p12': 'p12', '.pfx': 'p12' };
+
+function fingerprintOf(certPath) {
+  try {
+    return new crypto.X509Certificate(fs.readFileSync(certPath)).fingerprint256;
+  } catch (err) {
+    return null;
+  }
+}
+
+/**
+ * Remove per-host certificates http-mitm-proxy wrote next to its RSA CA
+ */
+function removeRsaLeaves(caDir) {
+  let removed = 0;
+  for (const sub of ['certs', 'keys']) {
+    const dir = path.join(caDir, sub);
+    if (!fs.existsSync(dir)) continue;
+    for (const file of fs.readdirSync(dir)) {
+      if (file.startsWith('ca.')) continue;
+      fs.rmSync(path.join(dir, file), { force: true });
+      removed++;
+    }
+  }
+  return removed;
+}
+
+/**
+ * The configured CA's identity, validity and OS trust status
+ * @param {object} settings - Proxy settings
+ * @returns {Promise<object>}
+ */
+export async function getCAInfo(settings) {
+  const ca = trustStore.getCaCert(settings);
+  const status = await trustStore.getTrustStatus(ca);
+  return {
+    ...status,
+    ...(trustStore.describeCert(ca.certPath) || {})
+  };
+}
+
+/**
+ * Write the CA certificate (never its key) in a format other tools import
+ * @param {object} settings - Proxy settings
+ * @param {string} outputPath - Destination file
+ * @param {object} options
+ * @param {string} options.format - "pem", "der" or "p12" (default: from the extension, else PEM)
+ * @param {string} options.password - PKCS#12 integrity password
+ * @returns {object} - { certPath, outputPath, format, fingerprint }
+ */
+export function exportCA(settings, outputPath, { format, password = '' } = {}) {
+  const ca = trustStore.getCaCert(settings);
+  if (!fs.existsSync(ca.certPath)) {
+    throw new Error(`No ${ca.keyType.toUpperCase()} CA at ${ca.certPath} (it is created when the proxy first starts)`);
+  }
+
+  const resolvedFormat = format || EXPORT_FORMATS[path.extname(outputPath).toLowerCase()] || 'pem';
+  if (!['pem', 'der', 'p12'].includes(resolvedFormat)) {
+    throw new Error(`Unknown format "${resolvedFormat}" (expected pem, der or p12)`);
+  }
+
+  const cert = new crypto.X509Certificate(fs.readFileSync(ca.certPath));
+  const contents = {
+    pem: () => cert.toString(),
+    der: () => cert.raw,
+    p12: () => createTrustStore(cert.raw, { password })
+  }[resolvedFormat]();
+
+  fs.mkdirSync(path.dirname(path.resolve(outputPath)), { recursive: true });
+  fs.writeFileSync(outputPath, contents);
+
+  return { certPath: ca.certPath, outputPath: path.resolve(outputPath), format: resolvedFormat, fingerprint: cert.fingerprint256 };
+}
+
+/**
+ * Whether the configured ECDSA CA's ca.key is passphrase-encrypted
+ */
+export function isCAKeyEncrypted(settings) {
+  const keyPath = path.join(caDirs(settings).ecdsa, 'ca.key');
+  return fs.existsSync(keyPath) && fs.readFileSync(keyPath, 'utf8').includes('ENCRYPTED PRIVATE KEY');
+}
+
+/**
+ * Encrypt the ECDSA CA's ca.key with a new passphrase (or change it)
+ * @param {object} settings - Proxy settings
+ * @param {object} options
+ * @param {string} options.passphrase - Current passphrase, if the key is already encrypted
+ * @param {string} options.newPassphrase - Passphrase to encrypt with
+ * @param {boolean} options.keychain - Also remember the new passphrase in the login keychain
+ * @returns {object} - { keyPath, keychain }
+ */
+export function encryptCAKey(settings, { passphrase = null, newPassphrase, keychain = false }) {
+  const { keyType, keyStorage } = settings.certificates;
+  if (keyType !== 'ecdsa') {
+    throw new Error('Only the ECDSA CA key can be encrypted (http-mitm-proxy needs the RSA key in plain text); set certificates.keyType to "ecdsa"');
+  }
+  if (keyStorage === 'keychain') {
+    throw new Error('The CA key is kept in the keychain (certificates.keyStorage "keychain"); there is no ca.key to encrypt');
+  }
+
+  const dir = caDirs(settings).ecdsa;
+  const ca = CertificateAuthority.load(dir, { keyStorage, passphrase: passphrase || newPassphrase });
+  ca.keyStorage = 'encrypted';
+  ca.passphrase = newPassphrase;
+  ca.writeKey(ca.privateKey);
+
+  if (keychain) {
+    storePassphrase(dir, newPassphrase);
+  }
+  return { keyPath: ca.keyPath, keychain };
+}
+
+/**
+ * How close the configured CA is to expiry
+ * @param {object} settings - Proxy settings
+ * @returns {object} - { certPath, keyType, status, validTo, daysRemaining };
+ *   status is "ok", "expiring", "expired" or "missing"
+ */
+export function checkCAExpiry(settings) {
+  const ca = trustStore.getCaCert(settings);
+  const info = trustStore.describeCert(ca.certPath);
+  if (!info) {
+    return { certPath: ca.certPath, keyType: ca.keyType, status: 'missing', validTo: null, daysRemaining: null };
+  }
+
+  let status = 'ok';
+  if (info.expired) {
+    status = 'expired';
+  } else if (info.daysRemaining < settings.certificates.expiryWarningDays) {
+    status = 'expiring';
+  }
+  return { certPath: ca.certPath, keyType: ca.keyType, status, validTo: info.validTo, daysRemaining: info.daysRemaining };
+}
+
+/**
+ * Generate a new CA of the configured key type and drop every leaf signed by
+ * the previous one (trust is left to the caller)
+ * @param {object} settings - Proxy settings
+ * @param {object} options
+ * @param {string} options.passphrase - Required when keyStorage is "encrypted"
+ */
+export function renewCA(settings, { passphrase = null } = {}) {
+  const { keyType, keyStorage } = settings.certificates;
+  const dirs = caDirs(settings);
+  if (keyType === 'ecdsa') {
+    new CertificateAuthority(dirs.ecdsa, { keyStorage, passphrase }).generate();
+  } else {
+    generateRsaCA(dirs.rsa);
+    removeRsaLeaves(dirs.rsa);
+  }
+  new LeafCertificateCache({ dir: leafCacheDir(keyType, dirs.base) }).clear();
+}
+
+/**
+ * Path of the configured CA certificate (which may not exist yet)
+ * @param {object} settings - Proxy settings
+ * @returns {string}
+ */
+export function caCertPath(settings) {
+  return trustStore.getCaCert(settings).certPath;
+}
+
+/**
+ * Create the configured CA ahead of the proxy's first start (trust is left
+ * to the caller)
+ * @param {object} settings - Proxy settings
+ * @param {object} options
+ * @param {string} options.passphrase - Required when keyStorage is "encrypted"
+ * @param {boolean} options.force - Replace an existing CA
+ * @returns {object} - { keyType, certPath, fingerprint, previousFingerprint }
+ */
+export function generateCA(settings, { passphrase = null, force = false } = {}) {
+  const ca = trustStore.getCaCert(settings);
+  const previousFingerprint = fingerprintOf(ca.certPath);
+  if (previousFingerprint && !force) {
+    throw new Error(`The ${ca.keyType.toUpperCase()} CA already exists at ${ca.certPath} ` +
+      '(--force replaces it; "cert rotate" also moves the trust over)');
+  }
+
+  renewCA(settings, { passphrase });
+  return { keyType: ca.keyType, certPath: ca.certPath, fingerprint: fingerprintOf(ca.certPath), previousFingerprint };
+}
+
+/**
+ * Replace the CA: untrust and delete the old one, generate a new one, trust
+ * it, and drop every leaf signed by the old key
+ * @param {object} settings - Proxy settings
+ * @param {object} options - renewCA options, plus `system` (macOS System keychain)
+ * @returns {Promise<object>} - Report of each step
+ */
+export async function rotateCA(settings, options = {}) {
+  const trustOptions = { system: !!options.system };
+  if (trustOptions.system && process.platform !== 'darwin') {
+    throw new Error(`System keychain trust is only supported on macOS, not ${process.platform}`);
+  }
+  const ca = trustStore.getCaCert(settings);
+  const report = {
+    keyType: ca.keyType,
+    certPath: ca.certPath,
+    previousFingerprint: fingerprintOf(ca.certPath),
+    untrusted: null,
+    fingerprint: null,
+    trusted: false,
+    trustOutput: ''
+  };
+
+  // Untrust while the old certificate is still on disk to identify it
+  if (report.previousFingerprint) {
+    const removal = await trustStore.removeTrust(ca, trustOptions);
+    report.untrusted = removal.supported ? removal.code === 0 : null;
+    report.untrustOutput = removal.output;
+  }
+
+  renewCA(settings, options);
+  report.fingerprint = fingerprintOf(ca.certPath);
+  report.leafCacheCleared = true;
+
+  const trust = await trustStore.addTrust(ca, trustOptions);
+  const status = await trustStore.getTrustStatus(ca);
+  report.trusted = status.trusted;
+  report.trustOutput = trust.output;
+
+  return report;
+}
+
+/**
+ * Install the configured CA as a trusted root
+ * @param {object} settings - Proxy settings
+ * @param {object} options
+ * @param {boolean} options.system - macOS: System keychain instead of the login keychain
+ * @returns {Promise<object>} - getTrustStatus() plus { supported, output }
+ */
+export async function trustCA(settings, { system = false } = {}) {
+  const ca = trustStore.getCaCert(settings);
+  if (!fs.existsSync(ca.certPath)) {
+    return { ...await trustStore.getTrustStatus(ca), supported: true, output: '' };
+  }
+
+  const result = await trustStore.addTrust(ca, { system });
+  const status = await trustStore.getTrustStatus(ca);
+  return { ...status, supported: result.supported, output: result.output };
+}
+
+/**
+ * Remove the configured CA from the trust store (the CA itself is kept)
+ * @param {object} settings - Proxy settings
+ * @param {object} options
+ * @param {boolean} options.system - macOS: System keychain instead of the login keychain
+ * @returns {Promise<object>} - getTrustStatus() plus { supported, removed, output }
+ */
+export async function untrustCA(settings, { system = false } = {}) {
+  const ca = trustStore.getCaCert(settings);
+  if (!fs.existsSync(ca.certPath)) {
+    return { ...await trustStore.getTrustStatus(ca), supported: true, removed: false, output: '' };
+  }
+
+  const result = await trustStore.removeTrust(ca, { system });
+  const status = await trustStore.getTrustStatus(ca);
+  return { ...status, supported: result.supported, removed: result.code === 0, output: result.output };
+}
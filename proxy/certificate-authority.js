@@ -0,0 +1,317 @@
+/**
+ * CertificateAuthority - ECDSA CA and leaf certificates for the MITM proxy
+ *
+ * http-mitm-proxy signs every intercepted host with RSA-2048 keys generated
+ * in pure JS, which is slow on busy pages. With `certificates.keyType` set to
+ * "ecdsa", leaves are signed with this P-256 CA (stored under
+ * ~/.loggy-proxy/ca) instead.
+ * The existing RSA CA in ~/.http-mitm-proxy is left alone, so switching back
+ * to "rsa" keeps working without re-trusting anything.
+ *
+ * `certificates.dir` (or LOGGY_CERT_DIR) moves both CAs under one directory.
+ * `certificates.keyStorage` controls how the ECDSA CA's private key is kept:
+ * plain ca.key ("file"), passphrase-encrypted PKCS#8 ("encrypted"), or the
+ * macOS login keychain ("keychain").
+ */
+
+import { spawnSync } from 'child_process';
+import crypto from 'crypto';
+import fs from 'fs';
+import os from 'os';
+import path from 'path';
+import { PROFILE_PATHS } from '../config/proxy-settings.js';
+import { promptHidden } from './prompt.js';
+import { createCertificate } from './x509.js';
+
+// CA generated by http-mitm-proxy (RSA)
+export const RSA_CA_DIR = path.join(os.homedir(), '.http-mitm-proxy');
+export const ECDSA_CA_DIR = path.join(PROFILE_PATHS.caHome, 'ca');
+
+const KEYCHAIN_SERVICE = 'Loggy Proxy CA key';
+const PASSPHRASE_SERVICE = 'Loggy Proxy CA passphrase';
+
+/**
+ * Read a base64 secret stored by writeKeychainSecret (macOS only)
+ * @returns {Buffer|null}
+ */
+function readKeychainSecret(account, service) {
+  if (process.platform !== 'darwin') return null;
+  const found = spawnSync('security', ['find-generic-password', '-a', account, '-s', service, '-w'], { encoding: 'utf8' });
+  return found.status === 0 ? Buffer.from(found.stdout.trim(), 'base64') : null;
+}
+
+/**
+ * Store a secret as a generic password in the login keychain
+ */
+function writeKeychainSecret(account, service, secret) {
+  // Pass the secret on stdin (security's interactive mode) so it never shows up in `ps`
+  const encoded = Buffer.from(secret).toString('base64');
+  const stored = spawnSync('security', ['-i'], {
+    input: `add-generic-password -U -a "${account}" -s "${service}" -l "${service}" -w "${encoded}"\n`,
+    encoding: 'utf8'
+  });
+  if (stored.status !== 0 || /error/i.test(stored.stderr || '')) {
+    throw new Error(`Could not store "${service}" in the keychain: ${(stored.stderr || stored.error || '').toString().trim()}`);
+  }
+}
+
+/**
+ * Passphrase for an encrypted CA key: LOGGY_CA_PASSPHRASE, then the login
+ * keychain, then (from a terminal) a prompt
+ * @param {string} dir - CA directory (keychain items are per directory)
+ * @returns {Promise<string|null>} - null when none is available
+ */
+export async function resolvePassphrase(dir) {
+  if (process.env.LOGGY_CA_PASSPHRASE) {
+    return process.env.LOGGY_CA_PASSPHRASE;
+  }
+  const stored = readKeychainSecret(dir, PASSPHRASE_SERVICE);
+  if (stored) {
+    return stored.toString('utf8');
+  }
+  if (process.stdin.isTTY) {
+    return promptHidden(`Passphrase for ${path.join(dir, 'ca.key')}: `);
+  }
+  return null;
+}
+
+/**
+ * Remember a CA key passphrase in the login keychain so the proxy can start
+ * without a terminal (macOS only)
+ */
+export function storePassphrase(dir, passphrase) {
+  if (process.platform !== 'darwin') {
+    throw new Error('Storing the passphrase in the keychain is only supported on macOS');
+  }
+  writeKeychainSecret(dir, PASSPHRASE_SERVICE, passphrase);
+}
+
+/**
+ * CA directories for the configured certificate directory
+ * @param {object} settings - Proxy settings
+ * @returns {{base: string|null, rsa: string, ecdsa: string}} - base is null
+ *   when neither certificates.dir nor LOGGY_CERT_DIR is set (and no profile
+ *   is selected; a profile keeps its CAs in <profile>/certs)
+ */
+export function caDirs(settings) {
+  const base = process.env.LOGGY_CERT_DIR || settings.certificates.dir || PROFILE_PATHS.certDir;
+  if (!base) {
+    return { base: null, rsa: RSA_CA_DIR, ecdsa: ECDSA_CA_DIR };
+  }
+  return { base, rsa: path.join(base, 'rsa'), ecdsa: path.join(base, 'ecdsa') };
+}
+
+// The profile name keeps each profile's root distinguishable in the trust store
+const CA_SUBJECT = {
+  commonName: PROFILE_PATHS.caProfile ? `Loggy Proxy CA (ECDSA) - ${PROFILE_PATHS.caProfile}` : 'Loggy Proxy CA (ECDSA)',
+  organizationName: 'Loggy'
+};
+const CA_VALIDITY_DAYS = 3650;
+const LEAF_VALIDITY_DAYS = 365; // Chrome rejects leaves valid for more than 398 days
+const DAY_MS = 24 * 60 * 60 * 1000;
+
+/**
+ * Write a new RSA-2048 CA in http-mitm-proxy's layout (certs/ca.pem,
+ * keys/ca.private.key, keys/ca.public.key), replacing the existing one
+ * @param {string} dir - http-mitm-proxy's sslCaDir
+ * @returns {string} - Path of the new CA certificate
+ */
+export function generateRsaCA(dir = RSA_CA_DIR) {
+  const { publicKey, privateKey } = crypto.generateKeyPairSync('rsa', { modulusLength: 2048 });
+  const subject = {
+    commonName: PROFILE_PATHS.caProfile ? `Loggy Proxy CA - ${PROFILE_PATHS.caProfile}` : 'Loggy Proxy CA',
+    organizationName: 'Loggy'
+  };
+  const now = Date.now();
+
+  const certPem = createCertificate({
+    publicKey,
+    signingKey: privateKey,
+    subject,
+    issuer: subject,
+    notBefore: new Date(now - DAY_MS),
+    notAfter: new Date(now + CA_VALIDITY_DAYS * DAY_MS),
+    isCA: true
+  });
+
+  const certPath = path.join(dir, 'certs', 'ca.pem');
+  fs.mkdirSync(path.join(dir, 'certs'), { recursive: true });
+  fs.mkdirSync(path.join(dir, 'keys'), { recursive: true });
+  fs.writeFileSync(certPath, certPem);
+  fs.writeFileSync(path.join(dir, 'keys', 'ca.private.key'), privateKey.export({ type: 'pkcs1', format: 'pem' }), { mode: 0o600 });
+  fs.writeFileSync(path.join(dir, 'keys', 'ca.public.key'), publicKey.export({ type: 'spki', format: 'pem' }));
+
+  console.log(`[CA] Generated RSA CA at ${certPath}`);
+  return certPath;
+}
+
+export class CertificateAuthority {
+  /**
+   * @param {string} dir - Directory holding ca.pem (and ca.key)
+   * @param {object} options
+   * @param {string} options.keyStorage - "file", "encrypted" or "keychain" (macOS)
+   * @param {string} options.passphrase - For reading or writing an encrypted ca.key
+   */
+  constructor(dir = ECDSA_CA_DIR, { keyStorage = 'file', passphrase = null } = {}) {
+    if (keyStorage === 'keychain' && process.platform !== 'darwin') {
+      throw new Error('certificates.keyStorage "keychain" is only supported on macOS');
+    }
+    this.dir = dir;
+    this.keyStorage = keyStorage;
+    this.passphrase = passphrase;
+    this.certPath = path.join(dir, 'ca.pem');
+    this.keyPath = path.join(dir, 'ca.key');
+    this.certPem = null;
+    this.privateKey = null;
+    this.publicKey = null;
+  }
+
+  /**
+   * Load the CA from disk, generating it on first use
+   * @returns {CertificateAuthority}
+   */
+  static load(dir = ECDSA_CA_DIR, options = {}) {
+    const ca = new CertificateAuthority(dir, options);
+    const privateKey = fs.existsSync(ca.certPath) ? ca.readKey() : null;
+
+    if (privateKey) {
+      ca.certPem = fs.readFileSync(ca.certPath, 'utf8');
+      ca.privateKey = privateKey;
+      ca.publicKey = crypto.createPublicKey(ca.privateKey);
+    } else {
+      ca.generate();
+      if (fs.existsSync(path.join(RSA_CA_DIR, 'certs', 'ca.pem')) && dir === ECDSA_CA_DIR) {
+        console.log('[CA] The RSA CA in ~/.http-mitm-proxy is no longer used while keyType is "ecdsa".');
+        console.log('[CA] Trust the new CA (native host "trustCert") before browsing; set keyType back to "rsa" to revert.');
+      }
+    }
+
+    return ca;
+  }
+
+  /**
+   * Create a new P-256 CA and write it to disk
+   */
+  generate() {
+    fs.mkdirSync(this.dir, { recursive: true });
+    const { publicKey, privateKey } = crypto.generateKeyPairSync('ec', { namedCurve: 'P-256' });
+    const now = Date.now();
+
+    this.certPem = createCertificate({
+      publicKey,
+      signingKey: privateKey,
+      subject: CA_SUBJECT,
+      issuer: CA_SUBJECT,
+      notBefore: new Date(now - DAY_MS),
+      notAfter: new Date(now + CA_VALIDITY_DAYS * DAY_MS),
+      isCA: true
+    });
+    this.privateKey = privateKey;
+    this.publicKey = publicKey;
+
+    // Key first, so a missing passphrase does not leave a CA without its key
+    this.writeKey(privateKey);
+    fs.writeFileSync(this.certPath, this.certPem);
+
+    console.log(`[CA] Generated ECDSA CA at ${this.certPath}`);
+  }
+
+  /**
+   * Read the CA private key from ca.key or the keychain, converting an
+   * existing ca.key to the configured keyStorage
+   * @returns {crypto.KeyObject|null} - null when no key is stored
+   */
+  readKey() {
+    if (this.keyStorage === 'keychain') {
+      const stored = readKeychainSecret(this.dir, KEYCHAIN_SERVICE);
+      if (stored) {
+        return crypto.createPrivateKey({ key: stored, format: 'der', type: 'pkcs8' });
+      }
+    }
+    if (!fs.existsSync(this.keyPath)) {
+      return null;
+    }
+
+    const pem = fs.readFileSync(this.keyPath, 'utf8');
+    const encrypted = pem.includes('ENCRYPTED PRIVATE KEY');
+    if (encrypted && !this.passphrase) {
+      throw new Error(`${this.keyPath} is encrypted: set LOGGY_CA_PASSPHRASE, store the passphrase with "loggy-proxy cert encrypt-key --keychain", or start the proxy from a terminal`);
+    }
+
+    let privateKey;
+    try {
+      privateKey = crypto.createPrivateKey(encrypted ? { key: pem, passphrase: this.passphrase } : pem);
+    } catch (err) {
+      throw new Error(encrypted ? `Wrong passphrase for ${this.keyPath}` : err.message);
+    }
+
+    if (this.keyStorage === 'keychain') {
+      this.writeKey(privateKey);
+      console.log(`[CA] Moved the CA private key from ${this.keyPath} to the login keychain`);
+    } else if (this.keyStorage === 'encrypted' && !encrypted) {
+      this.writeKey(privateKey);
+      console.log(`[CA] Encrypted the CA private key in ${this.keyPath}`);
+    }
+    return privateKey;
+  }
+
+  /**
+   * Store the CA private key according to keyStorage
+   */
+  writeKey(privateKey) {
+    if (this.keyStorage === 'keychain') {
+      writeKeychainSecret(this.dir, KEYCHAIN_SERVICE, privateKey.export({ type: 'pkcs8', format: 'der' }));
+      fs.rmSync(this.keyPath, { force: true });
+      return;
+    }
+
+    if (this.keyStorage === 'encrypted' && !this.passphrase) {
+      throw new Error('A passphrase is required to write an encrypted CA key');
+    }
+    const pem = this.keyStorage === 'encrypted'
+      ? privateKey.export({ type: 'pkcs8', format: 'pem', cipher: 'aes-256-cbc', passphrase: this.passphrase })
+      : privateKey.export({ type: 'pkcs8', format: 'pem' });
+    fs.writeFileSync(this.keyPath, pem, { mode: 0o600 });
+  }
+
+  /**
+   * Sign a P-256 leaf certificate for the given hosts
+   * @param {Array<string>} hosts - Hostnames/IPs (first is the common name)
+   * @returns {{certPem: string, keyPem: string}}
+   */
+  createServerCertificate(hosts) {
+    const { publicKey, privateKey } = crypto.generateKeyPairSync('ec', { namedCurve: 'P-256' });
+    const now = Date.now();
+
+    const certPem = createCertificate({
+      publicKey,
+      signingKey: this.privateKey,
+      issuerPublicKey: this.publicKey,
+      subject: { commonName: hosts[0], organizationName: 'Loggy' },
+      issuer: CA_SUBJECT,
+      notBefore: new Date(now - DAY_MS),
+      notAfter: new Date(now + LEAF_VALIDITY_DAYS * DAY_MS),
+      hosts
+    });
+
+    return { certPem, keyPem: privateKey.export({ type: 'pkcs8', format: 'pem' }) };
+  }
+
+  /**
+   * Have an http-mitm-proxy instance sign leaves with this CA instead of its
+   * own RSA CA (install a LeafCertificateCache afterwards to reuse them)
+   * @param {object} proxy - http-mitm-proxy Proxy
+   */
+  install(proxy) {
+    proxy.onCertificateMissing = (ctx, files, callback) => {
+      const hosts = files.hosts || [ctx.hostname];
+      let leaf;
+      try {
+        leaf = this.createServerCertificate(hosts);
+      } catch (err) {
+        return callback(err);
+      }
+      return callback(null, { certFileData: leaf.certPem, keyFileData: leaf.keyPem, hosts });
+    };
+  }
+}
@@ -0,0 +1,132 @@
+/**
+ * Clock and event IDs, replaceable for deterministic tests
+ *
+ * Capture timestamps (_metadata.capturedAt), timestamps the parser fills in
+ * for payloads without one, generated event IDs and request IDs (_requestId)
+ * all come from here (and AnalyticsParser.clock / idGenerator, which this
+ * sets). Tests of a capture pipeline can pin them:
+ *
+ *   useClock(fixedClock('2026-01-01T00:00:00Z', 1));
+ *   useIdGenerator(sequentialIds('evt'));
+ *   useRequestIdGenerator(sequentialIds('req'));
+ *   ... capture ...
+ *   resetClock();
+ *
+ * A whole proxy can be run the same way with LOGGY_TEST_CLOCK=<ISO time>
+ * (each reading advances 1 ms) and LOGGY_TEST_IDS=<prefix> (request IDs are
+ * then "<prefix>-req-1", ...); see
+ * applyClockEnvironment. Parse workers read the same variables and number
+ * their IDs separately ("<prefix>-w1-1"), but which worker parses a request
+ * is not fixed, so use parsing.workers 0 for reproducible IDs.
+ */
+
+import crypto from 'crypto';
+import { AnalyticsParser } from '../parsers.js';
+import { preciseNow } from './timestamps.js';
+
+let clock = null; // null = the real clock
+let requestIds = null; // null = random UUIDs
+
+/**
+ * Current time in epoch milliseconds, with a sub-millisecond fraction from
+ * the high-resolution clock unless a test clock is set
+ */
+export function now() {
+  return clock ? clock() : preciseNow();
+}
+
+/**
+ * Use this clock for capture times, parser-filled timestamps and IDs
+ * @param {function} nowMs - Returns epoch milliseconds (null restores the real clock)
+ */
+export function useClock(nowMs) {
+  clock = nowMs;
+  AnalyticsParser.clock = nowMs || (() => Date.now());
+}
+
+/**
+ * Use this generator for event IDs the parser and the proxy make up
+ * @param {function} generate - Returns a new ID (null restores the default)
+ */
+export function useIdGenerator(generate) {
+  AnalyticsParser.idGenerator = generate;
+}
+
+/**
+ * Use this generator for the IDs of intercepted requests (_requestId)
+ * @param {function} generate - Returns a new ID (null restores random UUIDs)
+ */
+export function useRequestIdGenerator(generate) {
+  requestIds = generate;
+}
+
+/**
+ * A new request ID
+ */
+export function newRequestId() {
+  return requestIds ? requestIds() : crypto.randomUUID();
+}
+
+/**
+ * Back to the real clock and random IDs
+ */
+export function resetClock() {
+  useClock(null);
+  useIdGenerator(null);
+  useRequestIdGenerator(null);
+}
+
+/**
+ * Run fn with the clock stopped at a time (e.g. replaying a recorded request
+ * at the time it was recorded), then restore the previous clock
+ * @param {string|number} time - ISO time or epoch milliseconds
+ */
+export function atTime(time, fn) {
+  const previous = clock;
+  useClock(fixedClock(time));
+  try {
+    return fn();
+  } finally {
+    useClock(previous);
+  }
+}
+
+/**
+ * A clock that starts at a time and advances stepMs on each reading
+ * @param {string|number} start - ISO time or epoch milliseconds
+ * @param {number} stepMs
+ */
+export function fixedClock(start, stepMs = 0) {
+  let current = typeof start === 'number' ? start : Date.parse(start);
+  if (Number.isNaN(current)) {
+    throw new Error(`Invalid clock start "${start}" (expected an ISO time)`);
+  }
+  return () => {
+    const reading = current;
+    current += stepMs;
+    return reading;
+  };
+}
+
+/**
+ * IDs "<prefix>-1", "<prefix>-2", ...
+ */
+export function sequentialIds(prefix = 'evt') {
+  let next = 1;
+  return () => `${prefix}-${next++}`;
+}
+
+/**
+ * Apply LOGGY_TEST_CLOCK and LOGGY_TEST_IDS, if set (proxy and parse workers)
+ * @param {string} scope - Added to the ID prefix, so parse workers' IDs don't collide
+ * @returns {boolean} - Whether either was set
+ */
+export function applyClockEnvironment(env = process.env, scope = null) {
+  if (env.LOGGY_TEST_CLOCK) useClock(fixedClock(env.LOGGY_TEST_CLOCK, 1));
+  if (env.LOGGY_TEST_IDS) {
+    const prefix = scope ? `${env.LOGGY_TEST_IDS}-${scope}` : env.LOGGY_TEST_IDS;
+    useIdGenerator(sequentialIds(prefix));
+    useRequestIdGenerator(sequentialIds(`${prefix}-req`));
+  }
+  return !!(env.LOGGY_TEST_CLOCK || env.LOGGY_TEST_IDS);
+}
@@ -0,0 +1,241 @@
+/**
+ * Setup checks for `loggy-proxy doctor`
+ *
+ * Each check covers one of the usual reasons the extension shows no events:
+ * a port taken by something else, an untrusted CA, a missing or stale native
+ * host manifest, no Chromium browser, or a proxy whose API does not answer.
+ * A check returns { id, title, status: pass|warn|fail, message, fix }.
+ */
+
+import fs from 'fs';
+import net from 'net';
+import os from 'os';
+import path from 'path';
+import { fileURLToPath } from 'url';
+import browsers from '../native-host/browsers.cjs';
+import { PROFILE_PATHS, LOGGY_HOME } from '../config/proxy-settings.js';
+import { ProxyApiClient } from './api-client.js';
+import { getCAInfo } from './cert-tools.js';
+import { BROWSER_DATA_DIRS, NATIVE_HOST_NAME, manifestReport } from './native-host-manifest.js';
+
+const __dirname = path.dirname(fileURLToPath(import.meta.url));
+
+const NATIVE_HOST_PATH = path.join(__dirname, '..', 'native-host', 'proxy-host.cjs');
+
+function result(id, title, status, message, fix = null) {
+  return { id, title, status, message, fix };
+}
+
+function runningPid() {
+  try {
+    const pid = parseInt(fs.readFileSync(PROFILE_PATHS.pidFile, 'utf8'), 10);
+    process.kill(pid, 0);
+    return pid;
+  } catch (err) {
+    return null;
+  }
+}
+
+/**
+ * Whether something is listening on a port (tries to bind it briefly)
+ * @returns {Promise<string|null>} - Error code (EADDRINUSE, EACCES, ...) or null when free
+ */
+function portError(port) {
+  return new Promise(resolve => {
+    const server = net.createServer();
+    server.once('error', err => resolve(err.code || err.message));
+    server.listen(port, () => server.close(() => resolve(null)));
+  });
+}
+
+async function checkPorts(settings, proxy) {
+  const ports = [['proxy', settings.proxyPort], ['API', settings.apiPort]];
+  const problems = [];
+  const notes = [];
+  const busy = [];
+
+  for (const [label, port] of ports) {
+    const error = await portError(port);
+    if (!error) {
+      notes.push(`${label} port ${port} free`);
+    } else if (error === 'EADDRINUSE' && proxy.reachable) {
+      notes.push(`${label} port ${port} in use by the loggy proxy (pid ${proxy.pid})`);
+    } else if (error === 'EADDRINUSE') {
+      problems.push(`${label} port ${port} is in use by another program`);
+      busy.push(port);
+    } else {
+      problems.push(`${label} port ${port} cannot be opened (${error})`);
+    }
+  }
+
+  if (problems.length > 0) {
+    const port = busy[0] || settings.proxyPort;
+    const lookup = process.platform === 'win32' ? `netstat -ano | findstr :${port}` : `lsof -i :${port}`;
+    return result('ports', 'Ports', 'fail', problems.join('; '),
+      `Find the program with "${lookup}" and stop it, or set proxyPort/apiPort in ${PROFILE_PATHS.settingsPath}` +
+      ' (or use a profile: loggy-proxy profile create <name>)');
+  }
+  return result('ports', 'Ports', 'pass', notes.join(', '));
+}
+
+async function checkCA(settings) {
+  const title = 'CA certificate';
+  const info = await getCAInfo(settings);
+  const certCommand = `loggy-proxy${PROFILE_PATHS.profile ? ` --profile ${PROFILE_PATHS.profile}` : ''} cert`;
+  const trustCommand = `${certCommand} trust`;
+
+  if (!info.generated) {
+    return result('ca', title, 'warn', `No ${info.keyType.toUpperCase()} CA at ${info.certPath} yet`,
+      `Run "${certCommand} generate --trust" (or start the proxy once, then run "${trustCommand}")`);
+  }
+  if (info.expired) {
+    return result('ca', title, 'fail', `${info.certPath} expired on ${info.validTo}`,
+      `Run "${certCommand} rotate" (or set certificates.autoRenew)`);
+  }
+  if (info.trusted === null) {
+    return result('ca', title, 'warn', `${info.certPath} exists; ${info.details}`,
+      'Import it into the browser\'s trust store by hand ("loggy-proxy cert export ca.crt" writes a copy)');
+  }
+  if (!info.trusted) {
+    return result('ca', title, 'fail', `${info.certPath} is not trusted, so HTTPS interception fails`, `Run "${trustCommand}"`);
+  }
+  return result('ca', title, 'pass', `${info.certPath} trusted, expires in ${info.daysRemaining} days`);
+}
+
+/**
+ * Native host manifests installed for Chrome and the other installed browsers
+ * @returns {Array<object>|null} - { id, manifestPath }, or null where
+ *   manifests are registered in the registry instead (Windows)
+ */
+export function nativeHostManifests() {
+  const dataDirs = BROWSER_DATA_DIRS[process.platform];
+  if (!dataDirs) return null;
+
+  const installed = browsers.listInstalledBrowsers().map(browser => browser.id);
+  return Object.entries(dataDirs)
+    .filter(([id]) => installed.includes(id) || id === 'chrome')
+    .map(([id, dir]) => ({ id, manifestPath: path.join(os.homedir(), dir, 'NativeMessagingHosts', `${NATIVE_HOST_NAME}.json`) }))
+    .filter(({ manifestPath }) => fs.existsSync(manifestPath));
+}
+
+/**
+ * Problems with one native host manifest, or null when it is usable
+ */
+function manifestProblem(manifestPath) {
+  let manifest;
+  try {
+    manifest = JSON.parse(fs.readFileSync(manifestPath, 'utf8'));
+  } catch (err) {
+    return `${manifestPath} is not valid JSON (${err.message})`;
+  }
+
+  if (manifest.name !== NATIVE_HOST_NAME) {
+    return `${manifestPath} is for "${manifest.name}", not ${NATIVE_HOST_NAME}`;
+  }
+  if (!manifest.path || !fs.existsSync(manifest.path)) {
+    return `${manifestPath} points at ${manifest.path}, which does not exist`;
+  }
+  if (fs.realpathSync(manifest.path) !== fs.realpathSync(NATIVE_HOST_PATH)) {
+    return `${manifestPath} points at ${manifest.path}, not this install (${NATIVE_HOST_PATH})`;
+  }
+  if (process.platform !== 'win32') {
+    try {
+      fs.accessSync(manifest.path, fs.constants.X_OK);
+    } catch (err) {
+      return `${manifest.path} is not executable`;
+    }
+  }
+  const origins = manifest.allowed_origins || [];
+  if (origins.length === 0 || origins.some(origin => origin.includes('REPLACE_WITH'))) {
+    return `${manifestPath} does not name the extension ID in allowed_origins`;
+  }
+  return null;
+}
+
+function checkNativeHost() {
+  const title = 'Native host manifest';
+  const fix = 'Run "loggy-proxy native-host repair" (it finds the installed extension\'s ID)';
+
+  const manifests = nativeHostManifests();
+  if (!manifests) {
+    return result('native-host', title, 'warn', `Not checked on ${process.platform} (manifests are registered in the registry)`);
+  }
+  if (manifests.length === 0) {
+    return result('native-host', title, 'fail', 'No manifest installed, so the extension cannot start the proxy', fix);
+  }
+
+  const problems = manifests.map(({ manifestPath }) => manifestProblem(manifestPath)).filter(Boolean);
+  if (problems.length > 0) {
+    return result('native-host', title, 'fail', problems.join('; '), fix);
+  }
+
+  // The extension was re-loaded from another directory, so its ID changed
+  const mismatched = manifestReport().filter(report => report.manifest && report.missing.length > 0);
+  if (mismatched.length > 0) {
+    return result('native-host', title, 'fail',
+      mismatched.map(report => `${report.manifestPath} does not allow the installed extension ${report.missing.join(', ')}`).join('; '),
+      'Run "loggy-proxy native-host repair"');
+  }
+  return result('native-host', title, 'pass', manifests.map(({ manifestPath }) => manifestPath).join(', '));
+}
+
+async function checkBrowser(settings) {
+  const title = 'Browser';
+  const browser = await browsers.resolveBrowser(settings.browser);
+
+  if (!browser.path) {
+    return result('browser', title, 'fail', 'No Chrome, Chromium, Brave or Edge found',
+      'Install Google Chrome, or set browser.path in the proxy settings');
+  }
+  if (browser.source === 'configured' && !fs.existsSync(browser.path)) {
+    return result('browser', title, 'fail', `browser.path ${browser.path} does not exist`,
+      'Fix browser.path in the proxy settings, or set it to null to auto-detect');
+  }
+  return result('browser', title, 'pass', `${browser.name} (${browser.source}): ${browser.path}`);
+}
+
+async function checkApi(settings, proxy) {
+  const title = 'Proxy API';
+  const logFile = path.join(LOGGY_HOME, 'logs', 'proxy.log');
+
+  if (!proxy.pid && !proxy.reachable) {
+    return result('api', title, 'warn', 'The proxy is not running',
+      'Start it from the extension, or run "npm run proxy"');
+  }
+  if (!proxy.reachable) {
+    return result('api', title, 'fail', `The proxy (pid ${proxy.pid}) does not answer on port ${settings.apiPort} or ${PROFILE_PATHS.apiSocket}`,
+      `Check ${logFile}, then restart the proxy`);
+  }
+
+  const health = proxy.health;
+  if (health.status !== 'ok') {
+    return result('api', title, 'warn', `Answering on port ${settings.apiPort}, but degraded: ${(health.problems || []).join(', ')}`,
+      'See the CA certificate check above');
+  }
+  return result('api', title, 'pass', `Answering on port ${settings.apiPort}, ${health.events} events captured`);
+}
+
+/**
+ * Run every check
+ * @param {object} settings - Proxy settings
+ * @returns {Promise<Array<object>>}
+ */
+export async function runChecks(settings) {
+  const client = new ProxyApiClient({ apiPort: settings.apiPort, timeoutMs: 3000 });
+  const proxy = { pid: runningPid(), reachable: false, health: null };
+  try {
+    proxy.health = await client.get('/healthz');
+    proxy.reachable = true;
+    proxy.pid = proxy.pid || proxy.health.pid; // Started without the native host
+  } catch (err) {
+    // Not running, or not answering (reported by the API check)
+  }
+
+  return [
+    await checkPorts(settings, proxy),
+    await checkCA(settings),
+    checkNativeHost(),
+    await checkBrowser(settings),
+    await checkApi(settings, proxy)
+  ];
+}
@@ -0,0 +1,52 @@
+/**
+ * DropCounter - Events the proxy lost, per source and per reason
+ *
+ * Reasons are where the loss happened: "buffer" (an event pushed out of, or
+ * refused by, the full event buffer; see dropPolicy), "parseQueue" (a request
+ * body dropped because the parse queue was full; counts requests, as the body
+ * was never parsed), "rateLimit" (an event over its source's rate limit) and
+ * "sink:<name>" (an event a sink could not queue or deliver). Served by GET /stats and GET /metrics so losses are never silent.
+ */
+
+export const DROP_POLICIES = ['oldest', 'newest'];
+
+export class DropCounter {
+  constructor() {
+    this.counts = new Map(); // "<reason>\n<source>" -> count
+  }
+
+  /**
+   * @param {string} reason - "buffer", "parseQueue", "rateLimit" or "sink:<name>"
+   * @param {string} sourceId - Source of the dropped event (or request)
+   * @param {number} count
+   */
+  record(reason, sourceId, count = 1) {
+    const key = `${reason}\n${sourceId || 'unknown'}`;
+    this.counts.set(key, (this.counts.get(key) || 0) + count);
+  }
+
+  /**
+   * @returns {Array<object>} - { reason, source, count }, largest first
+   */
+  entries() {
+    return [...this.counts].map(([key, count]) => {
+      const [reason, source] = key.split('\n');
+      return { reason, source, count };
+    }).sort((a, b) => b.count - a.count);
+  }
+
+  /**
+   * @returns {object} - { total, byReason: { reason: count }, bySource: { id: { total, reason: count } } }
+   */
+  summary() {
+    const summary = { total: 0, byReason: {}, bySource: {} };
+    for (const { reason, source, count } of this.entries()) {
+      summary.total += count;
+      summary.byReason[reason] = (summary.byReason[reason] || 0) + count;
+      const entry = summary.bySource[source] || (summary.bySource[source] = { total: 0 });
+      entry.total += count;
+      entry[reason] = (entry[reason] || 0) + count;
+    }
+    return summary;
+  }
+}
@@ -0,0 +1,83 @@
+/**
+ * Environment comparison (GET /environments/compare)
+ *
+ * Events carry the environment they were captured in (_environment: the
+ * proxy's "environment" setting, or the label given when they were posted or
+ * imported). Comparing two environments, e.g. prod and staging, reports:
+ * - coverage: event names (per source) only one environment fired, and the
+ *   counts of those both fired;
+ * - schema: for names both fired, properties only one sent, and properties
+ *   whose types differ (from propertyStats, so nested paths count).
+ */
+
+import { propertyStats } from './property-stats.js';
+
+function nameKey(stats) {
+  return `${stats.source}\n${stats.event}`;
+}
+
+function typesOf(property) {
+  return Object.keys(property.types).filter(type => type !== 'null').sort();
+}
+
+/**
+ * Schema differences between one name's properties in two environments
+ */
+function compareProperties(a, b) {
+  const inA = new Map(a.properties.map(property => [property.path, property]));
+  const inB = new Map(b.properties.map(property => [property.path, property]));
+  const onlyInA = [...inA.keys()].filter(path => !inB.has(path));
+  const onlyInB = [...inB.keys()].filter(path => !inA.has(path));
+  const typeChanges = [];
+  for (const [path, propertyA] of inA) {
+    const propertyB = inB.get(path);
+    if (!propertyB) continue;
+    const typesA = typesOf(propertyA);
+    const typesB = typesOf(propertyB);
+    // A property that was only ever null in one environment has no type to compare
+    if (typesA.length === 0 || typesB.length === 0) continue;
+    if (typesA.join() !== typesB.join()) typeChanges.push({ path, a: typesA, b: typesB });
+  }
+  return { onlyInA, onlyInB, typeChanges };
+}
+
+/**
+ * Compare the events of two environments
+ * @param {Array<object>} eventsA - Events captured in environment a
+ * @param {Array<object>} eventsB - Events captured in environment b
+ * @param {object} labels - { a, b }: the environment names, echoed in the report
+ * @returns {object} - { a, b, counts, coverage: { onlyInA, onlyInB, both }, schema, summary }
+ */
+export function compareEnvironments(eventsA, eventsB, labels) {
+  const statsA = new Map(propertyStats(eventsA, { examples: 0 }).map(stats => [nameKey(stats), stats]));
+  const statsB = new Map(propertyStats(eventsB, { examples: 0 }).map(stats => [nameKey(stats), stats]));
+  const name = ({ source, event, count }) => ({ source, event, count });
+
+  const onlyInA = [...statsA.values()].filter(stats => !statsB.has(nameKey(stats))).map(name);
+  const onlyInB = [...statsB.values()].filter(stats => !statsA.has(nameKey(stats))).map(name);
+  const both = [];
+  const schema = [];
+  for (const [key, a] of statsA) {
+    const b = statsB.get(key);
+    if (!b) continue;
+    both.push({ source: a.source, event: a.event, counts: { a: a.count, b: b.count } });
+    const differences = compareProperties(a, b);
+    if (differences.onlyInA.length + differences.onlyInB.length + differences.typeChanges.length > 0) {
+      schema.push({ source: a.source, event: a.event, ...differences });
+    }
+  }
+
+  return {
+    a: labels.a,
+    b: labels.b,
+    counts: { a: eventsA.length, b: eventsB.length },
+    coverage: { onlyInA, onlyInB, both },
+    schema,
+    summary: {
+      onlyInA: onlyInA.length,
+      onlyInB: onlyInB.length,
+      both: both.length,
+      schemaDifferences: schema.length
+    }
+  };
+}
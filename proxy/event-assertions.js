@@ -0,0 +1,249 @@
+/**
+ * Expected-event checks for `loggy-proxy assert` and `capture --expect`
+ *
+ * A spec lists the events a test run must (or must not) produce, matched
+ * the way alert rules are:
+ *   {
+ *     "expect": [
+ *       { "name": "Landed", "event": "page_view", "properties": { "page": "home" } },
+ *       { "event": "Checkout*", "source": "segment", "properties": { "order_id": "*" }, "count": 1 },
+ *       { "event": "add_to_cart", "min": 1, "max": 3 },
+ *       { "event": "debug_*", "count": 0 }
+ *     ],
+ *     "order": ["Landed", "Checkout*"]
+ *   }
+ *
+ * `event` is a name glob, `source` a source ID or name, and `properties`
+ * maps nested paths to expected values ('*' = present). An expectation
+ * needs at least one matching event unless `count` or `min`/`max` say
+ * otherwise. `order` names expectations (by `name`, default their `event`)
+ * whose first matching events must arrive in that order.
+ */
+
+import fs from 'fs';
+import { AnalyticsParser } from '../parsers.js';
+import { AlertManager } from './alerts.js';
+import { capturedAt } from './event-export.js';
+
+const KEYS = ['name', 'event', 'source', 'properties', 'count', 'min', 'max'];
+
+function nameOf(expectation) {
+  return expectation.name || expectation.event;
+}
+
+function isCount(value) {
+  return Number.isInteger(value) && value >= 0;
+}
+
+/**
+ * Check a spec's shape
+ * @param {object} spec
+ * @returns {object} - The spec
+ */
+export function validateExpectations(spec) {
+  if (!spec || !Array.isArray(spec.expect) || spec.expect.length === 0) {
+    throw new Error('The spec needs an "expect" list of expected events');
+  }
+
+  spec.expect.forEach((expectation, i) => {
+    const where = `expect[${i}]`;
+    const unknown = Object.keys(expectation || {}).filter(key => !KEYS.includes(key));
+    if (unknown.length > 0) {
+      throw new Error(`${where}: unknown field(s) ${unknown.join(', ')} (expected ${KEYS.join(', ')})`);
+    }
+    if (typeof expectation.event !== 'string' || !expectation.event) {
+      throw new Error(`${where}: "event" (an event name or glob) is required`);
+    }
+    if (expectation.properties !== undefined && (typeof expectation.properties !== 'object' || Array.isArray(expectation.properties))) {
+      throw new Error(`${where}: "properties" must map property paths to values`);
+    }
+    for (const key of ['count', 'min', 'max']) {
+      if (expectation[key] !== undefined && !isCount(expectation[key])) {
+        throw new Error(`${where}: "${key}" must be a whole number`);
+      }
+    }
+    if (expectation.count !== undefined && (expectation.min !== undefined || expectation.max !== undefined)) {
+      throw new Error(`${where}: give "count" or "min"/"max", not both`);
+    }
+  });
+
+  const names = spec.expect.map(nameOf);
+  for (const name of spec.order || []) {
+    if (!names.includes(name)) {
+      throw new Error(`order: no expectation named "${name}" (names: ${names.join(', ')})`);
+    }
+  }
+  return spec;
+}
+
+/**
+ * Read and check a spec file
+ * @param {string} filePath - JSON spec
+ * @returns {object}
+ */
+export function loadExpectations(filePath) {
+  let spec;
+  try {
+    spec = JSON.parse(fs.readFileSync(filePath, 'utf8'));
+  } catch (err) {
+    throw new Error(`Could not read expectations from ${filePath}: ${err.message}`);
+  }
+  try {
+    return validateExpectations(spec);
+  } catch (err) {
+    throw new Error(`${filePath}: ${err.message}`);
+  }
+}
+
+function describeValue(value) {
+  return typeof value === 'string' ? `"${value}"` : JSON.stringify(value);
+}
+
+function matchesName(expectation, event) {
+  if (!AlertManager.globToRegex(expectation.event).test(event.event || '')) return false;
+  if (!expectation.source) return true;
+  const source = expectation.source.toLowerCase();
+  return String(event._source).toLowerCase() === source || String(event._sourceName).toLowerCase() === source;
+}
+
+/**
+ * Properties of an event that differ from an expectation's
+ * @returns {Array<string>} - One line per difference
+ */
+function propertyDifferences(expectation, event) {
+  const differences = [];
+  for (const [path, expected] of Object.entries(expectation.properties || {})) {
+    const value = AnalyticsParser.getNestedValue(event.properties, path);
+    if (value === undefined) {
+      differences.push(`${path}: missing${expected === '*' ? '' : ` (expected ${describeValue(expected)})`}`);
+    } else if (expected !== '*' && String(value) !== String(expected)) {
+      differences.push(`${path}: expected ${describeValue(expected)}, got ${describeValue(value)}`);
+    }
+  }
+  return differences;
+}
+
+/**
+ * Whether an event meets an expectation's name, source and properties (also
+ * used for funnel steps; see funnels.js)
+ */
+export function matchesExpectation(expectation, event) {
+  return matchesName(expectation, event) && propertyDifferences(expectation, event).length === 0;
+}
+
+function expectedRange(expectation) {
+  if (expectation.count !== undefined) return { min: expectation.count, max: expectation.count };
+  return { min: expectation.min ?? 1, max: expectation.max ?? Infinity };
+}
+
+function describeRange({ min, max }) {
+  if (min === max) return `exactly ${min}`;
+  if (max === Infinity) return `at least ${min}`;
+  if (min === 0) return `at most ${max}`;
+  return `${min} to ${max}`;
+}
+
+function timeOf(event) {
+  return String(capturedAt(event) || '').slice(11, 23);
+}
+
+/**
+ * Check events against a spec
+ * @param {object} spec - From loadExpectations / validateExpectations
+ * @param {Array<object>} events - Oldest first
+ * @returns {object} - { passed, results: [{ name, passed, message, details, example }], received }
+ */
+export function checkExpectations(spec, events) {
+  const results = [];
+  const firstMatch = new Map();
+
+  for (const expectation of spec.expect) {
+    const name = nameOf(expectation);
+    const range = expectedRange(expectation);
+    const named = events.filter(event => matchesName(expectation, event));
+    const matched = named.filter(event => propertyDifferences(expectation, event).length === 0);
+    if (matched.length > 0) firstMatch.set(name, matched[0]);
+
+    const passed = matched.length >= range.min && matched.length <= range.max;
+    const details = [];
+    // The event a report shows: the first match, the closest miss or the first extra
+    let example = matched[0] || null;
+    if (!passed && matched.length < range.min && named.length > matched.length) {
+      // Closest miss: the named event with the fewest property differences
+      const closest = named
+        .filter(event => !matched.includes(event))
+        .map(event => ({ event, differences: propertyDifferences(expectation, event) }))
+        .sort((a, b) => a.differences.length - b.differences.length)[0];
+      example = closest.event;
+      details.push(`closest: ${closest.event.event} at ${timeOf(closest.event)} (${closest.event._sourceName || closest.event._source})`);
+      details.push(...closest.differences.map(difference => `  ${difference}`));
+    } else if (!passed && matched.length > range.max) {
+      details.push(`at ${matched.map(timeOf).join(', ')}`);
+      example = matched[range.max];
+    }
+
+    results.push({
+      name,
+      passed,
+      message: `${name}: ${matched.length} event(s), expected ${describeRange(range)}`,
+      details,
+      example
+    });
+  }
+
+  const order = spec.order || [];
+  for (let i = 1; i < order.length; i++) {
+    const before = firstMatch.get(order[i - 1]);
+    const after = firstMatch.get(order[i]);
+    if (!before || !after) {
+      results.push({
+        name: `order ${order[i - 1]} → ${order[i]}`,
+        passed: false,
+        message: `order: ${order[i - 1]} before ${order[i]} not checked (${!before ? order[i - 1] : order[i]} never matched)`,
+        details: [],
+        example: null
+      });
+      continue;
+    }
+    const passed = events.indexOf(before) < events.indexOf(after);
+    results.push({
+      name: `order ${order[i - 1]} → ${order[i]}`,
+      passed,
+      message: passed
+        ? `order: ${order[i - 1]} before ${order[i]}`
+        : `order: ${order[i]} (${timeOf(after)}) came before ${order[i - 1]} (${timeOf(before)})`,
+      details: [],
+      example: passed ? null : after
+    });
+  }
+
+  const received = {};
+  for (const event of events) {
+    received[event.event] = (received[event.event] || 0) + 1;
+  }
+
+  return { passed: results.every(result => result.passed), results, received };
+}
+
+/**
+ * Human-readable report: one line per expectation, then what was received
+ * @returns {string}
+ */
+export function formatReport(report) {
+  const lines = [];
+  for (const result of report.results) {
+    lines.push(`${result.passed ? '✓' : '✗'} ${result.message}`);
+    lines.push(...result.details.map(detail => `    ${detail}`));
+  }
+
+  const received = Object.entries(report.received).sort((a, b) => b[1] - a[1]);
+  lines.push('', received.length > 0
+    ? `Received: ${received.map(([name, count]) => `${name} ×${count}`).join(', ')}`
+    : 'Received: no events');
+
+  const failed = report.results.filter(result => !result.passed).length;
+  lines.push(failed === 0
+    ? `All ${report.results.length} expectations met`
+    : `${failed} of ${report.results.length} expectations not met`);
+  return lines.join('\n');
+}
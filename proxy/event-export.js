@@ -0,0 +1,290 @@
+/**
+ * Event export for `loggy-proxy export`
+ *
+ * Events come from a running proxy's buffer or from the file sink's NDJSON
+ * files (the active file plus rotated ones), are filtered by source, name,
+ * time and session, and are written as JSON, NDJSON or CSV. A session is one
+ * proxy run (`_metadata.session`). Transforms shape the written events for
+ * their consumer: selected or excluded fields, flattened dot keys, and the
+ * JSON indentation.
+ */
+
+import fs from 'fs';
+import path from 'path';
+import { eventMatcher } from './event-format.js';
+
+// Output format implied by a file extension
+const FORMATS = { '.json': 'json', '.jsonl': 'ndjson', '.ndjson': 'ndjson', '.csv': 'csv' };
+
+const UNITS_MS = { s: 1000, m: 60 * 1000, h: 60 * 60 * 1000, d: 24 * 60 * 60 * 1000 };
+
+export function capturedAt(event) {
+  return (event._metadata && event._metadata.capturedAt) || event.timestamp;
+}
+
+function sessionOf(event) {
+  return (event._metadata && event._metadata.session) || 'unknown';
+}
+
+/**
+ * Parse an absolute time (anything Date understands) or a relative one
+ * ("30s", "15m", "2h", "7d" ago)
+ * @returns {Date}
+ */
+export function parseTime(value, now = Date.now()) {
+  const relative = /^(\d+)([smhd])$/.exec(String(value).trim());
+  if (relative) {
+    return new Date(now - parseInt(relative[1], 10) * UNITS_MS[relative[2]]);
+  }
+  const date = new Date(value);
+  if (Number.isNaN(date.getTime())) {
+    throw new Error(`Invalid time "${value}" (use an ISO date or e.g. 15m, 2h, 1d)`);
+  }
+  return date;
+}
+
+/**
+ * Parse a duration ("90" seconds, or "30s", "15m", "2h", "1d")
+ * @returns {number} - Milliseconds
+ */
+export function parseDuration(value) {
+  const match = /^(\d+)([smhd]?)$/.exec(String(value).trim());
+  if (!match || parseInt(match[1], 10) === 0) {
+    throw new Error(`Invalid duration "${value}" (use e.g. 90, 30s, 15m or 2h)`);
+  }
+  return parseInt(match[1], 10) * UNITS_MS[match[2] || 's'];
+}
+
+/**
+ * Events from the file sink: rotated files first, then the active one,
+ * oldest event first
+ * @param {string} filePath - sinks.file.path
+ * @returns {{events: Array<object>, files: Array<string>}}
+ */
+export function readStoredEvents(filePath) {
+  const { dir, name, ext } = path.parse(filePath);
+  if (!fs.existsSync(dir)) return { events: [], files: [] };
+
+  const rotated = fs.readdirSync(dir)
+    .filter(file => file.startsWith(`${name}-`) && file.endsWith(ext))
+    .sort() // ISO timestamps sort chronologically
+    .map(file => path.join(dir, file));
+  const files = fs.existsSync(filePath) ? [...rotated, filePath] : rotated;
+
+  const events = [];
+  for (const file of files) {
+    for (const line of fs.readFileSync(file, 'utf8').split('\n')) {
+      if (!line.trim()) continue;
+      try {
+        events.push(JSON.parse(line));
+      } catch (err) {
+        // Partial line from a crash mid-write
+      }
+    }
+  }
+  return { events, files };
+}
+
+/**
+ * Events from a file written by `export` or `capture`: a JSON array or
+ * NDJSON ("-" reads stdin)
+ * @returns {Array<object>}
+ */
+export function readEventsFile(filePath) {
+  const text = fs.readFileSync(filePath === '-' ? 0 : filePath, 'utf8');
+  try {
+    const events = JSON.parse(text);
+    if (Array.isArray(events)) return events;
+  } catch (err) {
+    // Not a single JSON document: NDJSON
+  }
+
+  return text.split('\n').filter(line => line.trim()).map((line, i) => {
+    try {
+      return JSON.parse(line);
+    } catch (err) {
+      throw new Error(`${filePath === '-' ? 'stdin' : filePath} line ${i + 1} is not JSON (expected a JSON array or NDJSON)`);
+    }
+  });
+}
+
+/**
+ * Sessions present in a list of events
+ * @returns {Array<object>} - { session, events, first, last }, oldest first
+ */
+export function listSessions(events) {
+  const sessions = new Map();
+  for (const event of events) {
+    const id = sessionOf(event);
+    const time = capturedAt(event);
+    const entry = sessions.get(id) || { session: id, events: 0, first: time, last: time };
+    entry.events++;
+    // Compared as dates: timestamps.timeZone "local" writes UTC offsets
+    if (Date.parse(time) < Date.parse(entry.first)) entry.first = time;
+    if (Date.parse(time) > Date.parse(entry.last)) entry.last = time;
+    sessions.set(id, entry);
+  }
+  return [...sessions.values()].sort((a, b) => Date.parse(a.first) - Date.parse(b.first));
+}
+
+/**
+ * @param {Array<object>} events - Oldest first
+ * @param {object} filters
+ * @param {string} filters.source - Comma-separated source IDs or names
+ * @param {string} filters.name - Event name glob (plain text matches anywhere)
+ * @param {string} filters.since - Start time (see parseTime)
+ * @param {string} filters.until - End time (see parseTime)
+ * @param {string} filters.session - Session ID, "latest" or "all" (default)
+ */
+export function filterEvents(events, { source = null, name = null, since = null, until = null, session = null } = {}) {
+  const matches = eventMatcher({ filter: name, source });
+  const sinceTime = since ? parseTime(since).getTime() : null;
+  const untilTime = until ? parseTime(until).getTime() : null;
+
+  let sessionId = session && session !== 'all' ? session : null;
+  if (sessionId === 'latest') {
+    const sessions = listSessions(events);
+    sessionId = sessions.length > 0 ? sessions[sessions.length - 1].session : null;
+  }
+
+  return events.filter(event => {
+    if (!matches(event)) return false;
+    if (sessionId && sessionOf(event) !== sessionId) return false;
+    const time = new Date(capturedAt(event)).getTime();
+    if (sinceTime !== null && time < sinceTime) return false;
+    if (untilTime !== null && time > untilTime) return false;
+    return true;
+  });
+}
+
+function csvCell(value) {
+  return `"${String(value).replace(/"/g, '""')}"`;
+}
+
+/**
+ * Comma-separated dot paths ("event,properties.plan") -> arrays of keys
+ */
+function parsePaths(list) {
+  return String(list || '').split(',').map(entry => entry.trim()).filter(Boolean).map(entry => entry.split('.'));
+}
+
+// Copy of an object without one dot path (objects along the path are copied too)
+function withoutPath(object, [key, ...rest]) {
+  if (!object || typeof object !== 'object' || !(key in object)) return object;
+  const copy = Array.isArray(object) ? [...object] : { ...object };
+  if (rest.length === 0) delete copy[key];
+  else copy[key] = withoutPath(copy[key], rest);
+  return copy;
+}
+
+// Set a dot path in a plain object, creating objects along the way
+function setPath(object, [key, ...rest], value) {
+  if (rest.length === 0) {
+    object[key] = value;
+    return;
+  }
+  if (!object[key] || typeof object[key] !== 'object') object[key] = {};
+  setPath(object[key], rest, value);
+}
+
+/**
+ * Nested objects and arrays as one level of dot keys
+ * ({ properties: { cart: { total: 5 } } } -> { "properties.cart.total": 5 });
+ * empty objects and arrays are kept as values
+ */
+export function flattenObject(object, prefix = '', flat = {}) {
+  for (const [key, value] of Object.entries(object)) {
+    const name = prefix ? `${prefix}.${key}` : key;
+    if (value && typeof value === 'object' && Object.keys(value).length > 0) flattenObject(value, name, flat);
+    else flat[name] = value;
+  }
+  return flat;
+}
+
+/**
+ * Shape events for export
+ * @param {Array<object>} events
+ * @param {object} transforms
+ * @param {string} transforms.fields - Comma-separated dot paths to keep, in this order (e.g. "event,properties.plan")
+ * @param {string} transforms.exclude - Comma-separated dot paths to drop (e.g. "_rawPayload,context")
+ * @param {boolean} transforms.flatten - Nested values as dot keys (see flattenObject)
+ * @returns {Array<object>}
+ */
+export function transformEvents(events, { fields = null, exclude = null, flatten = false } = {}) {
+  const keep = parsePaths(fields);
+  const drop = parsePaths(exclude);
+  return events.map(event => {
+    let shaped = event;
+    if (keep.length > 0) {
+      shaped = {};
+      for (const keys of keep) {
+        const value = keys.reduce((current, key) => (current && typeof current === 'object' ? current[key] : undefined), event);
+        if (value !== undefined) setPath(shaped, keys, value);
+      }
+    }
+    shaped = drop.reduce(withoutPath, shaped);
+    return flatten ? flattenObject(shaped) : shaped;
+  });
+}
+
+/**
+ * Serialize events; CSV has the extension panel's columns plus the source,
+ * or with columns "keys" one column per key (for transformed events)
+ * @param {Array<object>} events
+ * @param {string} format - "json", "ndjson" or "csv"
+ * @param {object} options
+ * @param {number} options.indent - JSON indentation (0 = minified)
+ * @param {string} options.columns - CSV columns: "panel" (default) or "keys"
+ * @returns {string}
+ */
+export function formatEvents(events, format, { indent = 2, columns = 'panel' } = {}) {
+  if (format === 'ndjson') {
+    return events.map(event => JSON.stringify(event)).join('\n') + (events.length > 0 ? '\n' : '');
+  }
+  if (format === 'csv' && columns === 'keys') {
+    const headers = [...new Set(events.flatMap(event => Object.keys(event)))];
+    const cell = value => (value === undefined || value === null ? '' : typeof value === 'object' ? JSON.stringify(value) : value);
+    const rows = events.map(event => headers.map(header => cell(event[header])));
+    return [headers.map(csvCell).join(','), ...rows.map(row => row.map(csvCell).join(','))].join('\n') + '\n';
+  }
+  if (format === 'csv') {
+    const headers = ['ID', 'Timestamp', 'Event', 'Source', 'Type', 'Parser', 'URL', 'Properties', 'User ID', 'Anonymous ID'];
+    const rows = events.map(event => [
+      event.id,
+      event.timestamp,
+      event.event || '',
+      event._sourceName || event._source || '',
+      event.type || '',
+      event._parser || '',
+      event._metadata?.url || '',
+      JSON.stringify(event.properties || {}),
+      event.userId || '',
+      event.anonymousId || ''
+    ]);
+    return [headers.join(','), ...rows.map(row => row.map(csvCell).join(','))].join('\n') + '\n';
+  }
+  return JSON.stringify(events, null, indent || undefined) + '\n';
+}
+
+/**
+ * Parse --indent: spaces per level, 0 to minify
+ */
+export function parseIndent(value) {
+  if (value === null || value === undefined) return 2;
+  const indent = Number(value);
+  if (!Number.isInteger(indent) || indent < 0 || indent > 10) {
+    throw new Error(`Invalid indent "${value}" (use 0 to minify, up to 10)`);
+  }
+  return indent;
+}
+
+/**
+ * Format for an output path: explicit, else from the extension, else JSON
+ */
+export function resolveFormat(format, outputPath) {
+  const resolved = format || (outputPath && FORMATS[path.extname(outputPath).toLowerCase()]) || 'json';
+  if (!['json', 'ndjson', 'csv'].includes(resolved)) {
+    throw new Error(`Unknown format "${resolved}" (expected json, ndjson or csv)`);
+  }
+  return resolved;
+}
@@ -0,0 +1,121 @@
+/**
+ * Terminal formatting for captured events (`loggy-proxy tail`)
+ *
+ * One line per event: time, a source badge in the source's colour, the event
+ * name and as many `key=value` properties as fit the terminal width. Events
+ * with severity issues (see proxy/severity.js) are marked WARN or ERROR, with
+ * their first issue.
+ */
+
+import { AlertManager } from './alerts.js';
+
+const RESET = '\x1b[0m';
+const DIM = '\x1b[2m';
+const BOLD = '\x1b[1m';
+const SEVERITY_STYLES = { warn: '\x1b[33m', error: '\x1b[31m' }; // Yellow, red
+
+/**
+ * Whether to colour output: a TTY, and NO_COLOR / --no-color not set
+ */
+export function useColor(stream = process.stdout, disabled = false) {
+  return !disabled && !!stream.isTTY && !('NO_COLOR' in process.env);
+}
+
+/**
+ * Background + contrasting foreground escape for a "#RRGGBB" source colour
+ */
+export function badgeStyle(hex) {
+  const match = /^#?([0-9a-f]{2})([0-9a-f]{2})([0-9a-f]{2})$/i.exec(hex || '');
+  if (!match) return '\x1b[7m'; // Reverse video when the source has no colour
+  const [r, g, b] = match.slice(1).map(part => parseInt(part, 16));
+  const foreground = 0.299 * r + 0.587 * g + 0.114 * b > 150 ? '30' : '97';
+  return `\x1b[48;2;${r};${g};${b}m\x1b[${foreground}m`;
+}
+
+function formatValue(value) {
+  if (value === null || value === undefined) return String(value);
+  if (typeof value === 'object') return JSON.stringify(value);
+  if (typeof value === 'string' && /[\s=]/.test(value)) return JSON.stringify(value);
+  return String(value);
+}
+
+/**
+ * Properties as "key=value" pairs, cut at `maxLength` characters
+ */
+export function summarizeProperties(properties, maxLength = 120) {
+  let summary = '';
+  for (const [key, value] of Object.entries(properties || {})) {
+    const pair = `${key}=${formatValue(value)}`;
+    const next = summary ? `${summary} ${pair}` : pair;
+    if (next.length > maxLength) {
+      return summary ? `${summary} …` : `${next.slice(0, Math.max(0, maxLength - 1))}…`;
+    }
+    summary = next;
+  }
+  return summary;
+}
+
+/**
+ * Local capture time as HH:MM:SS.mmm
+ */
+export function eventTime(event) {
+  const time = new Date((event._metadata && event._metadata.capturedAt) || event.timestamp);
+  return `${time.toTimeString().slice(0, 8)}.${String(time.getMilliseconds()).padStart(3, '0')}`;
+}
+
+/**
+ * @param {object} event - Captured event (see proxy-server-mitm.js)
+ * @param {object} options
+ * @param {boolean} options.color - Emit ANSI colours
+ * @param {number} options.width - Terminal width to fit the line into
+ * @returns {string}
+ */
+export function formatEvent(event, { color = false, width = 120 } = {}) {
+  const time = eventTime(event);
+  const source = event._sourceName || event._source || 'unknown';
+  const name = event.event || '(unnamed)';
+
+  const style = SEVERITY_STYLES[event._severity];
+  const issues = event._issues || [];
+  const marker = style
+    ? `${event._severity.toUpperCase()}: ${issues.length > 0 ? issues[0].message : 'see _issues'}${issues.length > 1 ? ` (+${issues.length - 1})` : ''}`
+    : '';
+
+  const prefixLength = time.length + source.length + name.length + 5 + (marker ? marker.length + 2 : 0);
+  const properties = summarizeProperties(event.properties, Math.max(20, width - prefixLength));
+
+  if (!color) {
+    return `${time} [${source}] ${name}${marker ? `  ${marker}` : ''}${properties ? `  ${properties}` : ''}`;
+  }
+  return `${DIM}${time}${RESET} ${badgeStyle(event._sourceColor)} ${source} ${RESET} ${BOLD}${name}${RESET}` +
+    (marker ? `  ${style}${marker}${RESET}` : '') +
+    (properties ? `  ${DIM}${properties}${RESET}` : '');
+}
+
+/**
+ * Build a predicate from tail's --filter / --source / --severity options
+ * @param {object} options
+ * @param {string} options.filter - Event name glob; without "*" it matches anywhere in the name
+ * @param {string} options.source - Comma-separated source IDs or names
+ * @param {string} options.severity - "warn" (warn and error events) or "error"
+ * @returns {function(object): boolean}
+ */
+export function eventMatcher({ filter = null, source = null, severity = null } = {}) {
+  const nameRegex = filter
+    ? AlertManager.globToRegex(filter.includes('*') ? filter : `*${filter}*`)
+    : null;
+  const sources = source
+    ? source.split(',').map(s => s.trim().toLowerCase()).filter(Boolean)
+    : null;
+
+  return event => {
+    if (nameRegex && !nameRegex.test(event.event || '')) return false;
+    if (sources && !sources.includes(String(event._source).toLowerCase()) &&
+        !sources.includes(String(event._sourceName).toLowerCase())) {
+      return false;
+    }
+    if (severity === 'error' && event._severity !== 'error') return false;
+    if (severity === 'warn' && !['warn', 'error'].includes(event._severity)) return false;
+    return true;
+  };
+}
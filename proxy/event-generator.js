@@ -0,0 +1,249 @@
+/**
+ * Synthetic analytics traffic for `loggy-proxy generate`
+ *
+ * Simulated visitors walk a small shop funnel (landing, search, product,
+ * cart, checkout, order), so the events have believable names, properties,
+ * users and ordering. Payloads follow a source's fieldMappings, so the
+ * proxy parses them the way it parses that source's real traffic.
+ */
+
+import crypto from 'crypto';
+import http from 'http';
+import { SourceConfig } from '../config/source-config.js';
+
+// Marks a request the proxy captures and answers itself instead of forwarding
+export const GENERATED_HEADER = 'x-loggy-generated';
+
+const PAGES = ['/', '/deals', '/new', '/about', '/help'];
+const PRODUCTS = [
+  { sku: 'MUG-001', name: 'Enamel Mug', category: 'Kitchen', price: 14 },
+  { sku: 'TEE-104', name: 'Organic Tee', category: 'Apparel', price: 28 },
+  { sku: 'LMP-220', name: 'Desk Lamp', category: 'Home', price: 64 },
+  { sku: 'BKP-031', name: 'Daypack', category: 'Outdoor', price: 89 },
+  { sku: 'HDP-550', name: 'Wireless Headphones', category: 'Audio', price: 149 },
+  { sku: 'NTB-012', name: 'Dot Grid Notebook', category: 'Stationery', price: 9 }
+];
+const QUERIES = ['mug', 'lamp', 'gift ideas', 'headphones', 'backpack', 'notebook'];
+const CURRENCIES = ['USD', 'USD', 'USD', 'EUR', 'GBP'];
+const USER_AGENTS = [
+  'Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0 Safari/537.36',
+  'Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0 Safari/537.36',
+  'Mozilla/5.0 (iPhone; CPU iPhone OS 17_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.5 Mobile/15E148 Safari/604.1'
+];
+
+// Visitors browsing at the same time
+const VISITORS = 12;
+
+function pick(list) {
+  return list[Math.floor(Math.random() * list.length)];
+}
+
+function id(prefix) {
+  return `${prefix}_${crypto.randomBytes(6).toString('hex')}`;
+}
+
+/**
+ * Events per second from "10/s", "30/m", "100/h" or a plain number (per second)
+ * @param {string} text
+ * @returns {number}
+ */
+export function parseRate(text) {
+  const match = /^(\d+(?:\.\d+)?)(?:\/(s|m|h))?$/.exec(String(text).trim());
+  if (!match || Number(match[1]) <= 0) {
+    throw new Error(`Invalid rate "${text}" (expected e.g. 10/s, 30/m or 100/h)`);
+  }
+  return Number(match[1]) / { s: 1, m: 60, h: 3600 }[match[2] || 's'];
+}
+
+/**
+ * Produces the next event of one of several simulated visitors
+ */
+export class EventGenerator {
+  constructor() {
+    this.visitors = [];
+  }
+
+  newVisitor() {
+    return {
+      anonymousId: id('anon'),
+      // Some visitors are signed in from the start, some sign up on the way
+      userId: Math.random() < 0.4 ? id('user') : null,
+      sessionId: id('sess'),
+      userAgent: pick(USER_AGENTS),
+      currency: pick(CURRENCIES),
+      step: 'landing',
+      cart: []
+    };
+  }
+
+  /**
+   * The visitor's next event and step; a visitor whose journey ends is
+   * replaced by a new one
+   */
+  advance(visitor) {
+    const product = pick(PRODUCTS);
+    const cartTotal = () => visitor.cart.reduce((sum, item) => sum + item.price, 0);
+
+    switch (visitor.step) {
+      case 'landing':
+        visitor.step = Math.random() < 0.5 ? 'search' : 'product';
+        return ['Page Viewed', { path: pick(PAGES), referrer: pick(['https://www.google.com/', 'https://news.ycombinator.com/', '']) }];
+      case 'search':
+        visitor.step = Math.random() < 0.8 ? 'product' : 'leave';
+        return ['Products Searched', { query: pick(QUERIES), results: 1 + Math.floor(Math.random() * 40) }];
+      case 'product':
+        visitor.current = product;
+        visitor.step = Math.random() < 0.55 ? 'cart' : pick(['product', 'search', 'leave']);
+        return ['Product Viewed', { product_id: product.sku, name: product.name, category: product.category, price: product.price, currency: visitor.currency }];
+      case 'cart': {
+        const item = visitor.current || product;
+        visitor.cart.push(item);
+        visitor.step = Math.random() < 0.5 ? 'checkout' : pick(['product', 'leave']);
+        return ['Product Added', { product_id: item.sku, name: item.name, price: item.price, quantity: 1, cart: { items: visitor.cart.length, total: cartTotal(), currency: visitor.currency } }];
+      }
+      case 'checkout':
+        visitor.step = visitor.userId ? (Math.random() < 0.7 ? 'order' : 'leave') : 'signup';
+        return ['Checkout Started', { order_id: (visitor.orderId = id('ord')), value: cartTotal(), currency: visitor.currency, products: visitor.cart.map(item => item.sku) }];
+      case 'signup':
+        visitor.userId = id('user');
+        visitor.step = Math.random() < 0.8 ? 'order' : 'leave';
+        return ['Signed Up', { method: pick(['email', 'google', 'apple']) }];
+      case 'order':
+        visitor.step = 'leave';
+        return ['Order Completed', {
+          order_id: visitor.orderId,
+          total: cartTotal(),
+          shipping: cartTotal() >= 50 ? 0 : 5,
+          currency: visitor.currency,
+          products: visitor.cart.map(item => ({ product_id: item.sku, price: item.price, quantity: 1 }))
+        }];
+      default:
+        return null;
+    }
+  }
+
+  /**
+   * @returns {object} - { event, properties, context, userId, anonymousId, timestamp }
+   */
+  next() {
+    while (this.visitors.length < VISITORS) {
+      this.visitors.push(this.newVisitor());
+    }
+    const index = Math.floor(Math.random() * this.visitors.length);
+    const visitor = this.visitors[index];
+    const step = this.advance(visitor);
+    if (visitor.step === 'leave') {
+      this.visitors.splice(index, 1);
+    }
+    if (!step) return this.next();
+
+    const [event, properties] = step;
+    return {
+      event,
+      properties,
+      context: { sessionId: visitor.sessionId, userAgent: visitor.userAgent, locale: 'en-US', library: { name: 'loggy-generate' } },
+      userId: visitor.userId,
+      anonymousId: visitor.anonymousId,
+      timestamp: new Date().toISOString()
+    };
+  }
+}
+
+function setPath(target, dottedPath, value) {
+  const keys = dottedPath.split('.');
+  let current = target;
+  for (const key of keys.slice(0, -1)) {
+    if (!current[key] || typeof current[key] !== 'object') current[key] = {};
+    current = current[key];
+  }
+  current[keys[keys.length - 1]] = value;
+}
+
+/**
+ * Request body for a source: a batch of items laid out by its fieldMappings
+ * (eventName, timestamp, userId, propertyContainer), else Segment-style
+ * @param {object} source - SourceConfig
+ * @param {Array<object>} events - From EventGenerator.next
+ * @returns {object}
+ */
+export function buildPayload(source, events) {
+  const mappings = source.fieldMappings || {};
+  const batch = events.map(event => {
+    const item = { type: 'track', anonymousId: event.anonymousId };
+    setPath(item, mappings.eventName || 'event', event.event);
+    setPath(item, mappings.timestamp || 'timestamp', event.timestamp);
+    if (event.userId) setPath(item, mappings.userId || 'userId', event.userId);
+    if (mappings.propertyContainer) {
+      // The parser reads context from inside a mapped container
+      setPath(item, mappings.propertyContainer, { ...event.properties, context: event.context });
+    } else {
+      item.properties = event.properties;
+      item.context = event.context;
+    }
+    return item;
+  });
+  return { batch, sentAt: new Date().toISOString() };
+}
+
+// Requests that match a source signature (SourceConfig.SIGNATURES)
+const SIGNATURE_TARGETS = {
+  'ga4-first-party': { host: 'sgtm.example.com', path: '/mp/collect' }
+};
+
+/**
+ * Host and path a source captures: its domain, and a path matching its
+ * urlPattern if it has one (a source without a domain: its first alias, or
+ * an example of its signature)
+ * @param {object} source - SourceConfig
+ * @returns {object} - { host, path }
+ */
+export function requestTarget(source) {
+  const toPath = pattern => (pattern ? pattern.replace(/\*+/g, 'generated') : '/v1/batch');
+  if (source.domain) return { host: source.domain, path: toPath(source.urlPattern) };
+  if (source.aliases && source.aliases.length > 0) {
+    const { host, pathPattern } = SourceConfig.parseAlias(source.aliases[0]);
+    return { host, path: toPath(pathPattern || source.urlPattern) };
+  }
+  return SIGNATURE_TARGETS[source.signature] || { host: '', path: toPath(source.urlPattern) };
+}
+
+/**
+ * Send events as one request through the proxy. The proxy answers generated
+ * requests itself (with GENERATED_HEADER set to "captured", or "dropped" when
+ * its parse queue is full); any other answer means it forwarded the request,
+ * which an older proxy does.
+ * @param {object} target - { proxyPort, agent }
+ * @param {object} source - SourceConfig
+ * @param {Array<object>} events - From EventGenerator.next
+ * @returns {Promise<void>}
+ */
+export function sendThroughProxy({ proxyPort, agent }, source, events) {
+  const { host, path } = requestTarget(source);
+  const body = JSON.stringify(buildPayload(source, events));
+
+  return new Promise((resolve, reject) => {
+    const req = http.request({
+      host: '127.0.0.1',
+      port: proxyPort,
+      method: 'POST',
+      path,
+      agent,
+      timeout: 10000,
+      headers: { Host: host, 'Content-Type': 'application/json', 'Content-Length': Buffer.byteLength(body), [GENERATED_HEADER]: '1' }
+    }, res => {
+      res.resume();
+      if (res.headers[GENERATED_HEADER] === 'dropped') {
+        return reject(Object.assign(new Error('The proxy\'s parse queue was full (parsing.maxQueue)'), { dropped: true }));
+      }
+      if (res.headers[GENERATED_HEADER] !== 'captured') {
+        const err = new Error(`The proxy forwarded a generated request to ${host} (HTTP ${res.statusCode}); restart it so it answers them itself`);
+        err.forwarded = true;
+        return reject(err);
+      }
+      resolve();
+    });
+    req.on('timeout', () => req.destroy(new Error('Request timed out')));
+    req.on('error', reject);
+    req.end(body);
+  });
+}
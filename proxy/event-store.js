@@ -0,0 +1,315 @@
+/**
+ * EventStore - The proxy's in-memory event buffer
+ *
+ * A fixed-size ring buffer: capturing an event overwrites the oldest one once
+ * the buffer is full, so the capture path costs the same at any buffer size
+ * (the array it replaces shifted every event along on each capture). Events
+ * are indexed by ID for the API's cursors, and counted per source for
+ * GET /status, so reads don't scan the buffer either. Index 0 is the newest
+ * event, as the API serves them. Filtered reads (find) use indexes by
+ * source, event name and userId, which list the sequence numbers of the
+ * matching events oldest first (and by request ID, for the events one request
+ * carried, and by severity).
+ *
+ * Vendor event IDs repeat when an SDK retries a request, so one ID can name
+ * several buffered events. A reference to an event (refOf: cursors, pins) is
+ * its ID, or "<id>@<_sequence>" while another buffered event has the same
+ * ID; a bare repeated ID names its newest copy.
+ *
+ * When the buffer is full, dropPolicy "oldest" (the default) drops the
+ * oldest event to make room; "newest" keeps what is buffered and refuses new
+ * events until the buffer is cleared. Either way onDrop hears about it.
+ */
+
+// Filter name -> the event field it indexes
+const INDEXED_FIELDS = {
+  source: event => event._source,
+  event: event => event.event,
+  userId: event => event.userId,
+  requestId: event => event._requestId,
+  environment: event => event._environment,
+  severity: event => event._severity
+};
+
+/**
+ * Sequence numbers of one index key, oldest first. The oldest entries are
+ * skipped rather than shifted off, and compacted once they are half the list.
+ */
+class SequenceList {
+  constructor() {
+    this.items = [];
+    this.start = 0;
+  }
+
+  get length() {
+    return this.items.length - this.start;
+  }
+
+  push(sequence) {
+    this.items.push(sequence);
+  }
+
+  removeOldest(sequence) {
+    if (this.items[this.start] !== sequence) return;
+    this.start++;
+    if (this.start > 32 && this.start * 2 > this.items.length) {
+      this.items = this.items.slice(this.start);
+      this.start = 0;
+    }
+  }
+
+  /**
+   * Sequence numbers below before, newest first
+   */
+  *newestFirst(before = Infinity) {
+    for (let i = this.items.length - 1; i >= this.start; i--) {
+      if (this.items[i] < before) yield this.items[i];
+    }
+  }
+}
+
+export class EventStore {
+  /**
+   * @param {number} capacity - Events kept (maxEvents)
+   * @param {object} options
+   * @param {string} options.dropPolicy - "oldest" or "newest" (see above)
+   * @param {function} options.onDrop - Called with each event dropped
+   */
+  constructor(capacity, { dropPolicy = 'oldest', onDrop = () => {} } = {}) {
+    this.capacity = capacity;
+    this.dropPolicy = dropPolicy;
+    this.onDrop = onDrop;
+    this.clear();
+  }
+
+  get length() {
+    return this.size;
+  }
+
+  clear() {
+    this.slots = new Array(this.capacity);
+    this.head = 0;       // Slot the next event goes in
+    this.size = 0;
+    this.sequence = 0;   // Events added since the last clear
+    this.ids = new Map(); // event ID -> SequenceList of the events with it
+    this.sources = new Map(); // source ID -> { id, name, events }
+    this.indexes = Object.fromEntries(Object.keys(INDEXED_FIELDS).map(name => [name, new Map()])); // String(value) -> SequenceList
+  }
+
+  /**
+   * Add an event as the newest; when the buffer is full, the dropPolicy
+   * decides which event goes
+   * @returns {boolean} - False if the event was refused
+   */
+  push(event) {
+    if (this.size === this.capacity) {
+      if (this.dropPolicy === 'newest') {
+        this.onDrop(event);
+        return false;
+      }
+      const oldest = this.slots[this.head];
+      this.forget(oldest, this.sequence - this.capacity);
+      this.onDrop(oldest);
+    } else {
+      this.size++;
+    }
+    this.slots[this.head] = event;
+    this.head = (this.head + 1) % this.capacity;
+    if (event.id) {
+      if (!this.ids.has(event.id)) this.ids.set(event.id, new SequenceList());
+      this.ids.get(event.id).push(this.sequence);
+    }
+    for (const [name, field] of Object.entries(INDEXED_FIELDS)) {
+      const value = field(event);
+      if (value === undefined || value === null) continue;
+      const index = this.indexes[name];
+      if (!index.has(String(value))) index.set(String(value), new SequenceList());
+      index.get(String(value)).push(this.sequence);
+    }
+    this.sequence++;
+
+    const entry = this.sources.get(event._source) || { id: event._source, name: event._sourceName, events: 0 };
+    entry.events++;
+    this.sources.set(event._source, entry);
+    return true;
+  }
+
+  forget(event, sequence) {
+    const copies = this.ids.get(event.id);
+    if (copies) {
+      copies.removeOldest(sequence);
+      if (copies.length === 0) this.ids.delete(event.id);
+    }
+    const entry = this.sources.get(event._source);
+    if (entry && --entry.events === 0) this.sources.delete(event._source);
+    for (const [name, field] of Object.entries(INDEXED_FIELDS)) {
+      const value = String(field(event));
+      const list = this.indexes[name].get(value);
+      if (!list) continue;
+      list.removeOldest(sequence);
+      if (list.length === 0) this.indexes[name].delete(value);
+    }
+  }
+
+  /**
+   * @param {number} index - 0 = newest
+   */
+  get(index) {
+    if (index < 0 || index >= this.size) return undefined;
+    return this.slots[(this.head - 1 - index + this.capacity) % this.capacity];
+  }
+
+  /**
+   * A reference that names exactly this buffered event
+   * @param {object} event - A buffered event
+   * @returns {string} - Its ID, or "<id>@<_sequence>" while the ID is repeated
+   */
+  refOf(event) {
+    const copies = this.ids.get(event.id);
+    if (!copies || copies.length < 2 || event._sequence === undefined) return event.id;
+    return `${event.id}@${event._sequence}`;
+  }
+
+  /**
+   * Sequence number of the event a reference names (see refOf)
+   * @returns {number|undefined} - undefined if it is no longer buffered
+   */
+  locate(ref) {
+    if (ref === null || ref === undefined) return undefined;
+    const copies = this.ids.get(ref);
+    if (copies) return copies.newestFirst().next().value;
+
+    const match = /^(.*)@(\d+)$/.exec(ref);
+    if (!match || !this.ids.has(match[1])) return undefined;
+    for (const sequence of this.ids.get(match[1]).newestFirst()) {
+      if (this.slots[sequence % this.capacity]._sequence === Number(match[2])) return sequence;
+    }
+    return undefined;
+  }
+
+  /**
+   * Position of an event, newest first
+   * @param {string} ref - Its ID, or a reference from refOf
+   * @returns {number} - -1 if it is no longer buffered
+   */
+  indexOf(ref) {
+    const sequence = this.locate(ref);
+    return sequence === undefined ? -1 : this.sequence - 1 - sequence;
+  }
+
+  /**
+   * Events from start up to (not including) end, newest first
+   */
+  slice(start = 0, end = this.size) {
+    const events = [];
+    for (let i = Math.max(start, 0); i < Math.min(end, this.size); i++) {
+      events.push(this.get(i));
+    }
+    return events;
+  }
+
+  toArray() {
+    return this.slice();
+  }
+
+  /**
+   * A page of the events matching every given filter, newest first
+   * @param {object} filters - { source, event, userId } (exact values; others are ignored)
+   * @param {object} options
+   * @param {string} options.cursor - nextCursor of the previous page (the reference of its last event)
+   * @param {number} options.limit - Maximum events to return
+   * @returns {object} - { events, nextCursor, cursorExpired }
+   */
+  find(filters, { cursor = null, limit = 100 } = {}) {
+    let before = Infinity;
+    if (cursor) {
+      before = this.locate(cursor);
+      if (before === undefined) {
+        return { events: [], nextCursor: null, cursorExpired: true };
+      }
+    }
+
+    // Walk the shortest index, checking the other filters on each event
+    const active = Object.keys(INDEXED_FIELDS).filter(name => filters[name] !== undefined && filters[name] !== null);
+    const lists = active.map(name => this.indexes[name].get(String(filters[name])) || new SequenceList());
+    const sequences = lists.length
+      ? lists.reduce((shortest, list) => (list.length < shortest.length ? list : shortest)).newestFirst(before)
+      : this.allSequences(before);
+
+    const events = [];
+    let hasMore = false;
+    for (const sequence of sequences) {
+      const event = this.slots[sequence % this.capacity];
+      if (!active.every(name => String(INDEXED_FIELDS[name](event)) === String(filters[name]))) continue;
+      if (events.length === limit) {
+        hasMore = true;
+        break;
+      }
+      events.push(event);
+    }
+    return { events, nextCursor: hasMore ? this.refOf(events[events.length - 1]) : null };
+  }
+
+  *allSequences(before) {
+    for (let sequence = Math.min(before, this.sequence) - 1; sequence >= this.sequence - this.size; sequence--) {
+      yield sequence;
+    }
+  }
+
+  toJSON() {
+    return this.toArray();
+  }
+
+  /**
+   * Per-source counts of the buffered events
+   * @returns {Array<object>} - { id, name, events }
+   */
+  sourceCounts() {
+    return [...this.sources.values()].map(entry => ({ ...entry }));
+  }
+
+  /**
+   * Per-environment counts of the buffered events (from the environment index)
+   * @returns {Array<object>} - { environment, events }, most events first
+   */
+  environmentCounts() {
+    return [...this.indexes.environment]
+      .map(([environment, list]) => ({ environment, events: list.length }))
+      .sort((a, b) => b.events - a.events);
+  }
+
+  /**
+   * Buffered events per severity (from the severity index)
+   * @returns {object} - { ok, warn, error }
+   */
+  severityCounts() {
+    const count = severity => (this.indexes.severity.get(severity) || { length: 0 }).length;
+    return { ok: count('ok'), warn: count('warn'), error: count('error') };
+  }
+
+  /**
+   * Approximate memory held by the buffered events: their size as JSON
+   * (computed on request, so capturing stays cheap)
+   * @returns {number} - Bytes
+   */
+  byteSize() {
+    let bytes = 0;
+    for (let i = 0; i < this.size; i++) {
+      bytes += Buffer.byteLength(JSON.stringify(this.get(i)));
+    }
+    return bytes;
+  }
+
+  /**
+   * Change the capacity, keeping the newest events that fit (those that don't
+   * are dropped)
+   */
+  resize(capacity) {
+    if (capacity === this.capacity) return;
+    const events = this.toArray();
+    this.capacity = capacity;
+    this.clear();
+    events.slice(capacity).forEach(event => this.onDrop(event));
+    events.slice(0, capacity).reverse().forEach(event => this.push(event));
+  }
+}
@@ -0,0 +1,105 @@
+/**
+ * EventWaiters - Requests waiting for an event (POST /events/wait)
+ *
+ * A UI test registers what it expects, matched like an assert expectation
+ * (see event-assertions.js):
+ *   { "event": "Checkout*", "source": "segment", "properties": { "order_id": "*" },
+ *     "timeout": 10000, "since": "now" }
+ * and gets an answer as soon as a matching event is captured, or when the
+ * timeout (milliseconds) passes. Events already buffered count too, unless
+ * they are no newer than "since": a _sequence from an earlier response, or
+ * "now" for only events captured after the request.
+ */
+
+import { matchesExpectation } from './event-assertions.js';
+
+export const DEFAULT_WAIT_MS = 30 * 1000;
+export const MAX_WAIT_MS = 5 * 60 * 1000;
+
+const KEYS = ['event', 'source', 'properties', 'timeout', 'since'];
+
+/**
+ * Check a wait request's shape
+ * @param {object} request - Body of POST /events/wait
+ * @param {number} currentSequence - Latest _sequence, for since "now"
+ * @returns {object} - { expectation, timeoutMs, since }
+ */
+export function parseWaitRequest(request, currentSequence) {
+  if (!request || typeof request !== 'object' || Array.isArray(request)) {
+    throw new Error('Expected an object with "event"');
+  }
+  const unknown = Object.keys(request).filter(key => !KEYS.includes(key));
+  if (unknown.length > 0) {
+    throw new Error(`Unknown field(s) ${unknown.join(', ')} (expected ${KEYS.join(', ')})`);
+  }
+  const { event, source, properties, timeout = DEFAULT_WAIT_MS, since = 0 } = request;
+  if (typeof event !== 'string' || !event) {
+    throw new Error('"event" (an event name or glob) is required');
+  }
+  if (source !== undefined && typeof source !== 'string') {
+    throw new Error('"source" must be a source ID or name');
+  }
+  if (properties !== undefined && (typeof properties !== 'object' || properties === null || Array.isArray(properties))) {
+    throw new Error('"properties" must map property paths to values');
+  }
+  if (!Number.isInteger(timeout) || timeout <= 0 || timeout > MAX_WAIT_MS) {
+    throw new Error(`"timeout" must be a number of milliseconds up to ${MAX_WAIT_MS}`);
+  }
+  if (since !== 'now' && !(Number.isInteger(since) && since >= 0)) {
+    throw new Error('"since" must be a _sequence number or "now"');
+  }
+
+  return {
+    expectation: { event, source, properties },
+    timeoutMs: timeout,
+    since: since === 'now' ? currentSequence : since
+  };
+}
+
+export class EventWaiters {
+  constructor() {
+    this.waiters = new Set();
+  }
+
+  get size() {
+    return this.waiters.size;
+  }
+
+  /**
+   * Wait for an event
+   * @param {object} wait - From parseWaitRequest
+   * @param {Array<object>} buffered - Buffered events, oldest first
+   * @param {function} done - Called once, with the matching event or null on timeout
+   * @returns {function} - Cancels the wait (e.g. when the client hangs up)
+   */
+  add({ expectation, timeoutMs, since }, buffered, done) {
+    const match = buffered.find(event => event._sequence > since && matchesExpectation(expectation, event));
+    if (match) {
+      done(match);
+      return () => {};
+    }
+
+    const waiter = { expectation, done: null };
+    const finish = event => {
+      clearTimeout(timer);
+      this.waiters.delete(waiter);
+      done(event);
+    };
+    const timer = setTimeout(() => finish(null), timeoutMs);
+    waiter.done = finish;
+    this.waiters.add(waiter);
+    return () => {
+      clearTimeout(timer);
+      this.waiters.delete(waiter);
+    };
+  }
+
+  /**
+   * Answer the waiters a newly captured event matches
+   */
+  check(event) {
+    for (const waiter of this.waiters) {
+      if (matchesExpectation(waiter.expectation, event)) waiter.done(event);
+    }
+  }
+}
@@ -0,0 +1,174 @@
+/**
+ * Raw-request fixtures: record matched analytics requests as the proxy saw
+ * them, and replay them through source matching and the parser
+ *
+ * A fixture is one JSON file holding the request (method, URL, headers, the
+ * body bytes as base64) and the events the proxy parsed from it when it was
+ * recorded. Replaying parses the body again with the current sources and
+ * parsers.js and reports any difference, so parser changes can be checked
+ * against real traffic (`loggy-proxy replay`).
+ */
+
+import fs from 'fs';
+import path from 'path';
+import { parseRequestBody } from './request-body.js';
+import { CREDENTIAL_HEADERS } from './redaction.js';
+
+export const FIXTURE_VERSION = 1;
+
+// Stands in for timestamps the parser filled in with the parse time
+const PARSE_TIME = '(parse time)';
+
+/**
+ * The stable part of parsed events, for comparing two parses
+ * @param {Array<object>} events - From parseRequestBody
+ * @param {string} parsedAt - ISO time taken just before parsing
+ * @returns {Array<object>}
+ */
+export function snapshotEvents(events, parsedAt) {
+  return events.map(({ id, timestamp, ...event }) => ({
+    ...event,
+    // A payload without a timestamp gets the parse time (see AnalyticsParser.extractEvent)
+    timestamp: timestamp >= parsedAt ? PARSE_TIME : timestamp
+  }));
+}
+
+/**
+ * Writes one fixture per matched request into <dir>/<source>/
+ */
+export class FixtureRecorder {
+  /**
+   * @param {object} options
+   * @param {string} options.dir - Fixture directory
+   * @param {number} options.maxPerSource - Stop recording a source after this many (0 = no limit)
+   */
+  constructor({ dir, maxPerSource = 100 }) {
+    this.dir = dir;
+    this.maxPerSource = maxPerSource;
+    this.counts = new Map();
+  }
+
+  /**
+   * @param {object} request - { method, url, headers, body (Buffer), source, events, parsedAt }
+   * @returns {string|null} - The fixture written, or null past the limit
+   */
+  record({ method, url, headers, body, source, events, parsedAt }) {
+    const sourceDir = path.join(this.dir, source.id);
+    if (!this.counts.has(source.id)) {
+      this.counts.set(source.id, fs.existsSync(sourceDir) ? fs.readdirSync(sourceDir).filter(f => f.endsWith('.json')).length : 0);
+    }
+    const count = this.counts.get(source.id);
+    if (this.maxPerSource && count >= this.maxPerSource) {
+      if (count === this.maxPerSource) {
+        console.log(`[Fixtures] ${source.id} has ${count} fixtures; not recording more (fixtures.maxPerSource)`);
+        this.counts.set(source.id, count + 1);
+      }
+      return null;
+    }
+
+    const recordedAt = new Date().toISOString();
+    const file = path.join(sourceDir, `${recordedAt.replace(/[:.]/g, '-')}-${count + 1}.json`);
+    const fixture = {
+      version: FIXTURE_VERSION,
+      recordedAt,
+      source: source.id,
+      method,
+      url,
+      // Credential headers are never written to a fixture
+      headers: Object.fromEntries(Object.entries(headers).filter(([name]) => !CREDENTIAL_HEADERS.includes(name.toLowerCase()))),
+      body: body.toString('base64'),
+      expected: snapshotEvents(events, parsedAt)
+    };
+
+    fs.mkdirSync(sourceDir, { recursive: true });
+    fs.writeFileSync(file, JSON.stringify(fixture, null, 2) + '\n');
+    this.counts.set(source.id, count + 1);
+    return file;
+  }
+}
+
+/**
+ * Fixture files among the given files and directories (searched recursively)
+ * @param {Array<string>} paths
+ * @returns {Array<string>} - Sorted paths
+ */
+export function findFixtures(paths) {
+  const files = [];
+  const visit = entry => {
+    if (fs.statSync(entry).isDirectory()) {
+      for (const name of fs.readdirSync(entry)) visit(path.join(entry, name));
+    } else if (entry.endsWith('.json')) {
+      files.push(entry);
+    }
+  };
+  for (const entry of paths) {
+    if (!fs.existsSync(entry)) throw new Error(`No such fixture file or directory: ${entry}`);
+    visit(entry);
+  }
+  return files.sort();
+}
+
+export function readFixture(file) {
+  let fixture;
+  try {
+    fixture = JSON.parse(fs.readFileSync(file, 'utf8'));
+  } catch (err) {
+    throw new Error(`${file} is not valid JSON: ${err.message}`);
+  }
+  if (fixture.version !== FIXTURE_VERSION || typeof fixture.url !== 'string' || typeof fixture.body !== 'string') {
+    throw new Error(`${file} is not a loggy fixture (version ${FIXTURE_VERSION} with url and body)`);
+  }
+  return fixture;
+}
+
+/**
+ * Differences between two values, as "path: expected X, got Y" lines
+ */
+function diffValues(expected, actual, at = '') {
+  if (JSON.stringify(expected) === JSON.stringify(actual)) return [];
+  const isObject = value => value && typeof value === 'object';
+  if (!isObject(expected) || !isObject(actual) || Array.isArray(expected) !== Array.isArray(actual)) {
+    return [`${at || '(root)'}: expected ${JSON.stringify(expected)}, got ${JSON.stringify(actual)}`];
+  }
+  const keys = [...new Set([...Object.keys(expected), ...Object.keys(actual)])];
+  return keys.flatMap(key => diffValues(expected[key], actual[key], at ? `${at}.${key}` : key));
+}
+
+/**
+ * Parse a fixture's request again and compare with what was recorded
+ * @param {object} fixture - From readFixture
+ * @param {object} configManager - Sources to match against (ConfigManagerNode)
+ * @returns {object} - { passed, source, events, differences }
+ */
+export function replayFixture(fixture, configManager) {
+  const source = configManager.findSourceForUrl(fixture.url);
+  if (!source) {
+    return { passed: false, source: null, events: [], differences: [`no source matches ${fixture.url} (recorded as ${fixture.source})`] };
+  }
+
+  const differences = [];
+  if (source.id !== fixture.source) {
+    differences.push(`matched source ${source.id}, recorded as ${fixture.source}`);
+  }
+
+  let events;
+  const parsedAt = new Date().toISOString();
+  try {
+    const header = name => Object.entries(fixture.headers || {}).find(([key]) => key.toLowerCase() === name)?.[1];
+    events = snapshotEvents(parseRequestBody(source, Buffer.from(fixture.body, 'base64'), header('content-encoding'), err => {
+      differences.push(`decompression failed: ${err.message}`);
+    }, { url: fixture.url, contentType: header('content-type') }), parsedAt);
+  } catch (err) {
+    return { passed: false, source: source.id, events: [], differences: [...differences, `body no longer parses: ${err.message}`] };
+  }
+
+  const expected = fixture.expected || [];
+  if (events.length !== expected.length) {
+    differences.push(`${events.length} event(s), recorded ${expected.length}`);
+  }
+  for (let i = 0; i < Math.min(events.length, expected.length); i++) {
+    differences.push(...diffValues(expected[i], events[i]).map(line => `event ${i}: ${line}`));
+  }
+
+  return { passed: differences.length === 0, source: source.id, events, differences };
+}
@@ -0,0 +1,174 @@
+/**
+ * Funnel checks (GET /funnels/<name>, POST /funnels/check)
+ *
+ * A funnel is an ordered list of steps, each matched like an expectation in
+ * an assert spec (see event-assertions.js):
+ *   {
+ *     "name": "checkout",
+ *     "steps": [
+ *       "Product Viewed",
+ *       { "name": "Added", "event": "Add*To Cart", "properties": { "sku": "*" } },
+ *       { "event": "Checkout Started", "source": "segment" }
+ *     ],
+ *     "by": "user"
+ *   }
+ *
+ * A string step is shorthand for { "event": <string> }. Events are grouped
+ * by "user" (userId, else anonymousId; the default), "anonymousId" or
+ * "session" (the proxy run, _metadata.session), and each group's events are
+ * walked oldest first. A group that reached every step in order completed;
+ * one that fired a step before the steps ahead of it is out of order; one
+ * that stopped partway dropped off at the first step it never reached.
+ */
+
+import { matchesExpectation } from './event-assertions.js';
+
+export const FUNNEL_GROUPINGS = {
+  user: event => event.userId ?? event.anonymousId ?? null,
+  anonymousId: event => event.anonymousId ?? null,
+  session: event => (event._metadata && event._metadata.session) || null
+};
+
+const KEYS = ['name', 'steps', 'by'];
+const STEP_KEYS = ['name', 'event', 'source', 'properties'];
+
+function toStep(step) {
+  return typeof step === 'string' ? { event: step } : step;
+}
+
+function stepName(step) {
+  return step.name || step.event;
+}
+
+/**
+ * Check a funnel definition's shape
+ * @param {object} funnel
+ * @returns {object} - The funnel, with string steps expanded
+ */
+export function validateFunnel(funnel) {
+  if (!funnel || typeof funnel !== 'object' || Array.isArray(funnel)) {
+    throw new Error('A funnel must be an object with "name" and "steps"');
+  }
+  const unknown = Object.keys(funnel).filter(key => !KEYS.includes(key));
+  if (unknown.length > 0) {
+    throw new Error(`Unknown field(s) ${unknown.join(', ')} (expected ${KEYS.join(', ')})`);
+  }
+  if (typeof funnel.name !== 'string' || !/^[\w.-]+$/.test(funnel.name)) {
+    throw new Error('"name" is required (letters, digits, ".", "_" and "-")');
+  }
+  if (!Array.isArray(funnel.steps) || funnel.steps.length < 2) {
+    throw new Error('"steps" must list at least two steps');
+  }
+  if (funnel.by !== undefined && !(funnel.by in FUNNEL_GROUPINGS)) {
+    throw new Error(`"by" must be one of ${Object.keys(FUNNEL_GROUPINGS).join(', ')}`);
+  }
+
+  const steps = funnel.steps.map(toStep);
+  steps.forEach((step, i) => {
+    const where = `steps[${i}]`;
+    if (!step || typeof step !== 'object') {
+      throw new Error(`${where}: a step is an event name or an object with "event"`);
+    }
+    const unknownStep = Object.keys(step).filter(key => !STEP_KEYS.includes(key));
+    if (unknownStep.length > 0) {
+      throw new Error(`${where}: unknown field(s) ${unknownStep.join(', ')} (expected ${STEP_KEYS.join(', ')})`);
+    }
+    if (typeof step.event !== 'string' || !step.event) {
+      throw new Error(`${where}: "event" (an event name or glob) is required`);
+    }
+    if (step.properties !== undefined && (typeof step.properties !== 'object' || Array.isArray(step.properties))) {
+      throw new Error(`${where}: "properties" must map property paths to values`);
+    }
+  });
+
+  const names = steps.map(stepName);
+  const repeated = names.find((name, i) => names.indexOf(name) !== i);
+  if (repeated) {
+    throw new Error(`Two steps are named "${repeated}"; give one a "name"`);
+  }
+  return { name: funnel.name, steps, by: funnel.by || 'user' };
+}
+
+/**
+ * One group's path through the funnel
+ */
+function walkGroup(steps, events) {
+  const reachedAt = [];
+  const firedAt = new Array(steps.length).fill(null);
+  for (const event of events) {
+    steps.forEach((step, i) => {
+      if (firedAt[i] === null && matchesExpectation(step, event)) firedAt[i] = event;
+    });
+    if (reachedAt.length < steps.length && matchesExpectation(steps[reachedAt.length], event)) {
+      reachedAt.push(event);
+    }
+  }
+
+  const reached = reachedAt.length;
+  const outOfOrder = steps.filter((step, i) => i >= reached && firedAt[i] !== null).map(stepName);
+  let status = 'completed';
+  if (outOfOrder.length > 0) {
+    status = 'out_of_order';
+  } else if (reached < steps.length) {
+    status = 'dropped';
+  }
+
+  return {
+    status,
+    reached,
+    droppedAt: status === 'completed' ? null : stepName(steps[reached]),
+    outOfOrder,
+    steps: steps.map((step, i) => {
+      const event = reachedAt[i] || firedAt[i];
+      return {
+        name: stepName(step),
+        event: event ? event.id : null,
+        at: event ? ((event._metadata && event._metadata.capturedAt) || event.timestamp) : null
+      };
+    })
+  };
+}
+
+/**
+ * Check events against a funnel
+ * @param {object} funnel - From validateFunnel
+ * @param {Array<object>} events - Oldest first
+ * @returns {object} - { name, by, steps: [{ name, reached }], summary, groups, ungrouped }
+ */
+export function checkFunnel(funnel, events) {
+  const keyOf = FUNNEL_GROUPINGS[funnel.by];
+  const groups = new Map(); // key -> events touching a step, oldest first
+  let ungrouped = 0;
+
+  for (const event of events) {
+    if (!funnel.steps.some(step => matchesExpectation(step, event))) continue;
+    const key = keyOf(event);
+    if (key === null || key === undefined || key === '') {
+      ungrouped++;
+      continue;
+    }
+    if (!groups.has(String(key))) groups.set(String(key), []);
+    groups.get(String(key)).push(event);
+  }
+
+  const results = [...groups].map(([key, groupEvents]) => ({ key, ...walkGroup(funnel.steps, groupEvents) }));
+  const count = status => results.filter(result => result.status === status).length;
+
+  return {
+    name: funnel.name,
+    by: funnel.by,
+    steps: funnel.steps.map((step, i) => ({
+      name: stepName(step),
+      reached: results.filter(result => result.reached > i).length
+    })),
+    summary: {
+      entered: results.length,
+      completed: count('completed'),
+      dropped: count('dropped'),
+      outOfOrder: count('out_of_order')
+    },
+    groups: results,
+    // Events matching a step but with no userId/anonymousId/session to group them by
+    ungrouped
+  };
+}
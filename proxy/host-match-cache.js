@@ -0,0 +1,62 @@
+/**
+ * HostMatchCache - Remember which CONNECT hosts are worth intercepting
+ *
+ * Most of a browser's HTTPS traffic goes to hosts that no source can ever
+ * match. Intercepting those costs a leaf certificate and a second TLS
+ * handshake per connection and breaks pinned apps, for nothing. The proxy asks
+ * this cache per CONNECT; hosts that can't match are tunnelled straight
+ * through (tunnelUnmatchedHosts). Decisions are kept in a bounded map and
+ * cleared whenever the sources change.
+ */
+
+export class HostMatchCache {
+  /**
+   * @param {function} decide - hostname -> true to intercept, false to tunnel
+   * @param {object} options
+   * @param {number} options.maxEntries - Hosts remembered (oldest forgotten first)
+   */
+  constructor(decide, { maxEntries = 5000 } = {}) {
+    this.decide = decide;
+    this.maxEntries = maxEntries;
+    this.hosts = new Map(); // hostname -> intercept?
+    this.counts = { hits: 0, misses: 0, tunnelled: 0 };
+  }
+
+  /**
+   * Whether a CONNECT to hostname should be intercepted
+   */
+  shouldIntercept(hostname) {
+    const host = hostname.toLowerCase();
+    let intercept = this.hosts.get(host);
+    if (intercept === undefined) {
+      this.counts.misses++;
+      intercept = this.decide(host);
+      if (this.hosts.size >= this.maxEntries) {
+        this.hosts.delete(this.hosts.keys().next().value);
+      }
+      this.hosts.set(host, intercept);
+    } else {
+      this.counts.hits++;
+    }
+    if (!intercept) this.counts.tunnelled++;
+    return intercept;
+  }
+
+  /**
+   * Forget every decision (the sources changed)
+   */
+  clear() {
+    this.hosts.clear();
+  }
+
+  /**
+   * @returns {object} - { hosts, unmatchedHosts, hits, misses, tunnelled }
+   */
+  stats() {
+    let unmatchedHosts = 0;
+    for (const intercept of this.hosts.values()) {
+      if (!intercept) unmatchedHosts++;
+    }
+    return { hosts: this.hosts.size, unmatchedHosts, ...this.counts };
+  }
+}
@@ -0,0 +1,188 @@
+/**
+ * LeafCertificateCache - Reuse per-host MITM certificates across CONNECTs
+ *
+ * Signing a leaf certificate (and generating its key) is the expensive part
+ * of intercepting a new host. Certificates are kept in an in-memory LRU keyed
+ * by hostname and, optionally, as `<host>.pem` / `<host>.key` files so they
+ * survive restarts. Entries close to expiry are treated as misses.
+ */
+
+import crypto from 'crypto';
+import fs from 'fs';
+import path from 'path';
+import { PROFILE_PATHS } from '../config/proxy-settings.js';
+
+// Re-sign leaves this close to expiry rather than serving them
+const EXPIRY_MARGIN_MS = 24 * 60 * 60 * 1000;
+
+/**
+ * Disk cache location for leaves signed by the CA of the given key type
+ * @param {string} keyType - "rsa" or "ecdsa"
+ * @param {string} certDir - Configured certificate directory (null = the home
+ *   holding the CA, so profiles sharing the default CA share its leaves too)
+ */
+export function leafCacheDir(keyType, certDir = null) {
+  return path.join(certDir || PROFILE_PATHS.caHome, 'leaf-cache', keyType);
+}
+
+export class LeafCertificateCache {
+  /**
+   * @param {object} options
+   * @param {number} options.maxEntries - In-memory LRU size
+   * @param {string} options.dir - Directory for the disk cache (null = memory only)
+   * @param {object} options.timings - StageTimings to record signing times in
+   */
+  constructor({ maxEntries = 500, dir = null, timings = null } = {}) {
+    this.maxEntries = maxEntries;
+    this.dir = dir;
+    this.timings = timings;
+    this.entries = new Map(); // hostname -> { certPem, keyPem, hosts, expiresAt }
+    this.stats = { hits: 0, diskHits: 0, misses: 0, evictions: 0 };
+
+    if (this.dir) {
+      fs.mkdirSync(this.dir, { recursive: true });
+    }
+  }
+
+  filesFor(hostname) {
+    const fileName = hostname.replace(/\*/g, '_');
+    return {
+      certFile: path.join(this.dir, `${fileName}.pem`),
+      keyFile: path.join(this.dir, `${fileName}.key`)
+    };
+  }
+
+  /**
+   * Cached certificate for a host, checking memory then disk
+   * @returns {object|null} - { certPem, keyPem, hosts }
+   */
+  get(hostname) {
+    const entry = this.entries.get(hostname);
+    if (entry && entry.expiresAt - EXPIRY_MARGIN_MS > Date.now()) {
+      // Move to the most-recently-used end
+      this.entries.delete(hostname);
+      this.entries.set(hostname, entry);
+      this.stats.hits++;
+      return entry;
+    }
+    if (entry) this.entries.delete(hostname);
+
+    const fromDisk = this.readFromDisk(hostname);
+    if (fromDisk) {
+      this.stats.diskHits++;
+      this.remember(hostname, fromDisk);
+      return fromDisk;
+    }
+
+    this.stats.misses++;
+    return null;
+  }
+
+  /**
+   * Store a freshly signed certificate
+   */
+  set(hostname, { certPem, keyPem, hosts }) {
+    const entry = { certPem, keyPem, hosts, expiresAt: expiryOf(certPem) };
+    this.remember(hostname, entry);
+
+    if (this.dir) {
+      const files = this.filesFor(hostname);
+      try {
+        fs.writeFileSync(files.certFile, certPem);
+        fs.writeFileSync(files.keyFile, keyPem, { mode: 0o600 });
+      } catch (err) {
+        console.error('[LeafCache] Could not save certificate:', err.message);
+      }
+    }
+  }
+
+  remember(hostname, entry) {
+    this.entries.set(hostname, entry);
+    while (this.entries.size > this.maxEntries) {
+      this.entries.delete(this.entries.keys().next().value);
+      this.stats.evictions++;
+    }
+  }
+
+  readFromDisk(hostname) {
+    if (!this.dir) return null;
+
+    const files = this.filesFor(hostname);
+    try {
+      const certPem = fs.readFileSync(files.certFile, 'utf8');
+      const keyPem = fs.readFileSync(files.keyFile, 'utf8');
+      const expiresAt = expiryOf(certPem);
+      if (expiresAt - EXPIRY_MARGIN_MS <= Date.now()) {
+        fs.rmSync(files.certFile, { force: true });
+        fs.rmSync(files.keyFile, { force: true });
+        return null;
+      }
+      return { certPem, keyPem, hosts: [hostname], expiresAt };
+    } catch (err) {
+      return null;
+    }
+  }
+
+  /**
+   * Drop every cached certificate (e.g. after the CA changes)
+   */
+  clear() {
+    this.entries.clear();
+    if (this.dir && fs.existsSync(this.dir)) {
+      for (const file of fs.readdirSync(this.dir)) {
+        if (file.endsWith('.pem') || file.endsWith('.key')) {
+          fs.rmSync(path.join(this.dir, file), { force: true });
+        }
+      }
+    }
+  }
+
+  getStats() {
+    return { ...this.stats, size: this.entries.size, maxEntries: this.maxEntries, disk: !!this.dir };
+  }
+
+  /**
+   * Put the cache in front of an http-mitm-proxy instance's certificate
+   * generation (its own RSA CA, or a CertificateAuthority installed first)
+   * @param {object} proxy - http-mitm-proxy Proxy
+   */
+  install(proxy) {
+    const generate = proxy.onCertificateMissing.bind(proxy);
+
+    // Always report a miss to the proxy's file check so every lookup comes
+    // through onCertificateMissing, where the cache is consulted
+    proxy.onCertificateRequired = (hostname, callback) => callback(null, {
+      keyFile: '',
+      certFile: '',
+      hosts: [hostname]
+    });
+
+    proxy.onCertificateMissing = (ctx, files, callback) => {
+      const hostname = ctx.hostname;
+      const cached = this.get(hostname);
+      if (cached) {
+        return callback(null, { certFileData: cached.certPem, keyFileData: cached.keyPem, hosts: cached.hosts });
+      }
+
+      const signed = this.timings ? this.timings.start('certificate') : () => {};
+      return generate(ctx, files, (err, generated) => {
+        signed();
+        if (err) return callback(err);
+        this.set(hostname, {
+          certPem: String(generated.certFileData),
+          keyPem: String(generated.keyFileData),
+          hosts: generated.hosts
+        });
+        return callback(null, generated);
+      });
+    };
+  }
+}
+
+function expiryOf(certPem) {
+  try {
+    return Date.parse(new crypto.X509Certificate(certPem).validTo);
+  } catch (err) {
+    return 0;
+  }
+}
@@ -0,0 +1,38 @@
+/**
+ * Listening on several addresses (listenHosts)
+ *
+ * http-mitm-proxy and http.Server listen on one host each. Chrome on some
+ * systems resolves the proxy host to ::1, which an IPv4-only listener
+ * refuses, so the proxy and API also listen on the other configured
+ * addresses: a plain TCP listener per address hands each connection to the
+ * server already handling the first one.
+ */
+
+import net from 'net';
+
+/**
+ * "host:port", with IPv6 hosts in brackets
+ */
+export function formatAddress(host, port) {
+  return net.isIPv6(host) ? `[${host}]:${port}` : `${host}:${port}`;
+}
+
+/**
+ * Also accept connections for `server` on more addresses. An address that
+ * can't be bound (e.g. IPv6 is disabled) is reported and skipped; the
+ * others still listen.
+ * @param {http.Server} server - Server already listening on its first address
+ * @param {Array<string>} hosts - The other addresses
+ * @param {number} port
+ * @param {function} onError - Called with (err, address) for each address that failed
+ * @returns {Array<net.Server>}
+ */
+export function listenOnMore(server, hosts, port, onError) {
+  return hosts.map(host => {
+    const listener = net.createServer(socket => server.emit('connection', socket));
+    listener.on('error', err => onError(err, formatAddress(host, port)));
+    // IPv6-only, so "::" and "0.0.0.0" can both be listed without clashing
+    listener.listen({ host, port, ipv6Only: net.isIPv6(host) });
+    return listener;
+  });
+}
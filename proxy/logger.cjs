@@ -0,0 +1,168 @@
+/**
+ * Logging for the proxy and the native host
+ *
+ * Each part logs through a subsystem logger (getLogger('proxy'), 'api',
+ * 'parser', 'nativehost'). A record is { time, level, pid, subsystem,
+ * component, message }; component is the label shown in text output
+ * ("[MITM Proxy] ..."). Modules that still log with plain console calls are
+ * routed here by captureConsole, which reads the component from their
+ * "[Component]" prefix.
+ *
+ * Levels: "error" keeps errors only, "warn" adds warnings, "info" (the
+ * default) adds the rest and "debug" adds debug output. Records go to the
+ * console (errors and warnings on stderr) as text or as one JSON object per
+ * line, and to a log file when one is configured. The file is rotated when it
+ * passes maxBytes: proxy.log -> proxy.log.1 -> ... up to maxFiles files.
+ *
+ * Shared with the CommonJS native host, hence .cjs.
+ */
+
+const fs = require('fs');
+const path = require('path');
+const util = require('util');
+
+const LOG_LEVELS = ['error', 'warn', 'info', 'debug'];
+const LOG_FORMATS = ['text', 'json'];
+
+// Subsystem -> component label
+const SUBSYSTEMS = {
+  proxy: 'MITM Proxy',
+  api: 'API',
+  parser: 'Parser',
+  nativehost: 'Native Host'
+};
+
+// Console prefixes that belong to another subsystem than the process's own
+const PREFIX_SUBSYSTEMS = {
+  'API': 'api',
+  'Parser': 'parser',
+  'AnalyticsParser': 'parser'
+};
+
+const original = {
+  error: console.error,
+  warn: console.warn,
+  log: console.log,
+  info: console.info,
+  debug: console.debug
+};
+
+// Console method that prints each level
+const LEVEL_METHODS = { error: 'error', warn: 'warn', info: 'log', debug: 'debug' };
+
+const config = {
+  level: 'info',
+  format: 'text',
+  console: true,
+  file: null,
+  maxBytes: 5 * 1024 * 1024,
+  maxFiles: 3
+};
+
+/**
+ * Move file -> file.1 -> file.2 ..., keeping maxFiles files in all
+ */
+function rotateLogFile(file, maxFiles) {
+  for (let i = maxFiles - 1; i >= 1; i--) {
+    const from = i === 1 ? file : `${file}.${i - 1}`;
+    if (fs.existsSync(from)) fs.renameSync(from, `${file}.${i}`);
+  }
+  if (maxFiles <= 1) fs.rmSync(file, { force: true });
+}
+
+function appendToFile(line) {
+  try {
+    fs.mkdirSync(path.dirname(config.file), { recursive: true });
+    // Stat on every write: several processes can share a log (native hosts)
+    if (config.maxBytes && fs.existsSync(config.file) && fs.statSync(config.file).size > config.maxBytes) {
+      rotateLogFile(config.file, config.maxFiles);
+    }
+    fs.appendFileSync(config.file, line + '\n');
+  } catch (err) {
+    // Nowhere left to report logging failures
+  }
+}
+
+function write(level, subsystem, component, args) {
+  if (LOG_LEVELS.indexOf(level) > LOG_LEVELS.indexOf(config.level)) return;
+
+  const text = util.format(...args);
+  const record = {
+    time: new Date().toISOString(),
+    level,
+    pid: process.pid,
+    subsystem,
+    component,
+    message: text.trim()
+  };
+
+  if (config.console) {
+    if (config.format === 'json') {
+      // Blank lines only space out the text output
+      if (record.message) original[LEVEL_METHODS[level]](JSON.stringify(record));
+    } else {
+      original[LEVEL_METHODS[level]](component ? `[${component}] ${text}` : text);
+    }
+  }
+  if (config.file && record.message) {
+    // The component is left out when it is just the subsystem's label
+    const label = component && component !== SUBSYSTEMS[subsystem] ? `[${component}] ` : '';
+    appendToFile(config.format === 'json'
+      ? JSON.stringify(record)
+      : `${record.time} [${level}] [pid ${record.pid}] ${subsystem}: ${label}${record.message}`);
+  }
+}
+
+/**
+ * Change the logging configuration; keys left out keep their value
+ * @param {object} options - { level, format, console, file, maxBytes, maxFiles }
+ * @returns {object} - The configuration applied
+ */
+function configureLogging(options = {}) {
+  const { level, format } = options;
+  for (const key of ['console', 'file', 'maxBytes', 'maxFiles']) {
+    if (options[key] !== undefined) config[key] = options[key];
+  }
+  if (level !== undefined) config.level = LOG_LEVELS.includes(level) ? level : 'info';
+  if (format !== undefined) config.format = LOG_FORMATS.includes(format) ? format : 'text';
+
+  if (level && !LOG_LEVELS.includes(level)) {
+    write('warn', 'proxy', 'Logging', [`Unknown log level "${level}"; using "info"`]);
+  }
+  if (format && !LOG_FORMATS.includes(format)) {
+    write('warn', 'proxy', 'Logging', [`Unknown log format "${format}"; using "text"`]);
+  }
+  return { ...config };
+}
+
+/**
+ * @param {string} subsystem - One of SUBSYSTEMS
+ * @returns {object} - { error, warn, info, debug }, each taking console-style arguments
+ */
+function getLogger(subsystem) {
+  const component = SUBSYSTEMS[subsystem] || subsystem;
+  return Object.fromEntries(LOG_LEVELS.map(level => [level, (...args) => write(level, subsystem, component, args)]));
+}
+
+/**
+ * Send console calls through the logger. A leading "[Component]" becomes the
+ * record's component; the subsystem is the one that prefix belongs to, else
+ * the given one.
+ */
+function captureConsole(subsystem) {
+  const capture = level => (...args) => {
+    const prefix = typeof args[0] === 'string' ? /^\[([^\]]+)\]\s*/.exec(args[0]) : null;
+    if (!prefix) {
+      return write(level, subsystem, null, args);
+    }
+    write(level, PREFIX_SUBSYSTEMS[prefix[1]] || subsystem, prefix[1], [args[0].slice(prefix[0].length), ...args.slice(1)]);
+  };
+
+  console.error = capture('error');
+  console.warn = capture('warn');
+  console.log = capture('info');
+  console.info = capture('info');
+  console.debug = capture('debug');
+}
+
+module.exports = { LOG_LEVELS, LOG_FORMATS, SUBSYSTEMS, configureLogging, getLogger, captureConsole, rotateLogFile };
@@ -0,0 +1,199 @@
+/**
+ * mitmproxy flow files (.flow, written by "mitmdump -w" or the mitmproxy UI)
+ *
+ * A flow file is a sequence of tnetstrings, one per flow: dictionaries whose
+ * byte values are tagged "," and text values ";". Only HTTP flows are read;
+ * each gives its request (method, URL, headers, body) for Loggy's parser.
+ * Writing goes the other way, from recorded fixtures, producing HTTP flows
+ * with the request and no response, in the flow format mitmproxy 10 writes
+ * (older and newer versions migrate it on load).
+ */
+
+import crypto from 'crypto';
+
+// mitmproxy 10's FLOW_FORMAT_VERSION
+export const FLOW_FORMAT_VERSION = 20;
+
+/**
+ * Decode the tnetstring at offset
+ * @returns {object} - { value, end }
+ */
+export function parseTnetstring(data, offset = 0) {
+  const colon = data.indexOf(0x3a, offset);
+  if (colon === -1 || colon - offset > 12) throw new Error(`Not a tnetstring at byte ${offset}`);
+  const length = parseInt(data.subarray(offset, colon).toString('latin1'), 10);
+  if (Number.isNaN(length)) throw new Error(`Not a tnetstring at byte ${offset}`);
+  const start = colon + 1;
+  const end = start + length;
+  if (end >= data.length) throw new Error(`Truncated tnetstring at byte ${offset}`);
+  const payload = data.subarray(start, end);
+  const type = String.fromCharCode(data[end]);
+
+  let value;
+  switch (type) {
+    case ',': value = Buffer.from(payload); break;
+    case ';': value = payload.toString('utf8'); break;
+    case '#': value = parseInt(payload.toString('latin1'), 10); break;
+    case '^': value = parseFloat(payload.toString('latin1')); break;
+    case '!': value = payload.toString('latin1') === 'true'; break;
+    case '~': value = null; break;
+    case ']': {
+      value = [];
+      for (let position = 0; position < payload.length;) {
+        const item = parseTnetstring(payload, position);
+        value.push(item.value);
+        position = item.end;
+      }
+      break;
+    }
+    case '}': {
+      value = {};
+      for (let position = 0; position < payload.length;) {
+        const key = parseTnetstring(payload, position);
+        const item = parseTnetstring(payload, key.end);
+        value[Buffer.isBuffer(key.value) ? key.value.toString('utf8') : key.value] = item.value;
+        position = item.end;
+      }
+      break;
+    }
+    default:
+      throw new Error(`Unknown tnetstring type "${type}" at byte ${end}`);
+  }
+  return { value, end: end + 1 };
+}
+
+// A number written as a float even when whole (mitmproxy's timestamps)
+class Float {
+  constructor(value) {
+    this.value = value;
+  }
+}
+
+function float(value) {
+  return new Float(value);
+}
+
+/**
+ * Encode a value as a tnetstring. Buffers are bytes, strings are text;
+ * whole numbers are integers unless wrapped with float()
+ */
+export function tnetstring(value) {
+  const encode = (payload, type) => {
+    const bytes = Buffer.isBuffer(payload) ? payload : Buffer.from(payload, 'utf8');
+    return Buffer.concat([Buffer.from(`${bytes.length}:`), bytes, Buffer.from(type)]);
+  };
+  if (value === null || value === undefined) return encode('', '~');
+  if (Buffer.isBuffer(value)) return encode(value, ',');
+  if (value instanceof Float) return encode(String(value.value), '^');
+  if (typeof value === 'string') return encode(value, ';');
+  if (typeof value === 'boolean') return encode(String(value), '!');
+  if (typeof value === 'number') return encode(String(value), Number.isInteger(value) ? '#' : '^');
+  if (Array.isArray(value)) return encode(Buffer.concat(value.map(tnetstring)), ']');
+  return encode(Buffer.concat(Object.entries(value).flatMap(([key, item]) => [tnetstring(key), tnetstring(item)])), '}');
+}
+
+const text = value => (Buffer.isBuffer(value) ? value.toString('utf8') : value == null ? '' : String(value));
+
+/**
+ * The HTTP requests in a flow file
+ * @param {Buffer} data - The file's contents
+ * @returns {Array<object>} - { method, url, headers, body, timestamp }; headers lower-cased
+ */
+export function readFlows(data) {
+  const requests = [];
+  for (let position = 0; position < data.length;) {
+    // Some writers separate flows with newlines
+    if (data[position] === 0x0a || data[position] === 0x0d) {
+      position++;
+      continue;
+    }
+    const { value: flow, end } = parseTnetstring(data, position);
+    position = end;
+    if (!flow || text(flow.type) !== 'http' || !flow.request) continue;
+
+    const request = flow.request;
+    const scheme = text(request.scheme) || 'http';
+    const host = text(request.host) || text(request.authority);
+    const defaultPort = scheme === 'https' ? 443 : 80;
+    const port = request.port && request.port !== defaultPort ? `:${request.port}` : '';
+    const requestPath = text(request.path);
+    const headers = {};
+    for (const [name, headerValue] of request.headers || []) {
+      const key = text(name).toLowerCase();
+      headers[key] = headers[key] ? `${headers[key]}, ${text(headerValue)}` : text(headerValue);
+    }
+    requests.push({
+      method: text(request.method).toUpperCase(),
+      url: /^https?:\/\//.test(requestPath) ? requestPath : `${scheme}://${host}${port}${requestPath}`,
+      headers,
+      body: Buffer.isBuffer(request.content) ? request.content : Buffer.from(text(request.content), 'utf8'),
+      timestamp: typeof request.timestamp_start === 'number' ? new Date(request.timestamp_start * 1000).toISOString() : null
+    });
+  }
+  return requests;
+}
+
+/**
+ * An HTTP flow (request only) for a request
+ * @param {object} request - { method, url, headers, body (Buffer), timestamp (ISO) }
+ */
+export function requestFlow({ method, url, headers = {}, body, timestamp = null }) {
+  const parsed = new URL(url);
+  const scheme = parsed.protocol.slice(0, -1);
+  const port = parsed.port ? parseInt(parsed.port, 10) : scheme === 'https' ? 443 : 80;
+  const startedAt = (timestamp ? Date.parse(timestamp) : Date.now()) / 1000;
+  const connection = (extra = {}) => ({
+    id: crypto.randomUUID(),
+    peername: null,
+    sockname: null,
+    timestamp_start: float(startedAt),
+    timestamp_end: null,
+    timestamp_tls_setup: null,
+    tls_established: scheme === 'https',
+    sni: scheme === 'https' ? parsed.hostname : null,
+    alpn: null,
+    tls_version: null,
+    cipher: null,
+    certificate_list: [],
+    error: null,
+    ...extra
+  });
+
+  return {
+    version: FLOW_FORMAT_VERSION,
+    type: 'http',
+    id: crypto.randomUUID(),
+    request: {
+      host: parsed.hostname,
+      port,
+      method: Buffer.from(method),
+      scheme: Buffer.from(scheme),
+      authority: Buffer.from(''),
+      path: Buffer.from(`${parsed.pathname}${parsed.search}`),
+      http_version: Buffer.from('HTTP/1.1'),
+      headers: Object.entries(headers).map(([name, value]) => [Buffer.from(name), Buffer.from(String(value))]),
+      content: body,
+      trailers: null,
+      timestamp_start: float(startedAt),
+      timestamp_end: float(startedAt)
+    },
+    response: null,
+    error: null,
+    client_conn: connection({ peername: ['127.0.0.1', 0], sockname: ['127.0.0.1', 8888], mitmcert: null, proxy_mode: 'regular' }),
+    server_conn: connection({ address: [parsed.hostname, port], source_address: null, via: null }),
+    intercepted: false,
+    is_replay: null,
+    marked: '',
+    metadata: {},
+    comment: '',
+    timestamp_created: float(startedAt),
+    websocket: null
+  };
+}
+
+/**
+ * A flow file's contents for these requests
+ */
+export function writeFlows(requests) {
+  return Buffer.concat(requests.map(request => tnetstring(requestFlow(request))));
+}
@@ -0,0 +1,226 @@
+/**
+ * Native host manifests and the extension IDs they must allow
+ *
+ * Chrome only lets the extensions listed in a manifest's allowed_origins
+ * talk to the native host. An unpacked extension's ID is derived from the
+ * directory it was loaded from (a hash of the path), so moving or re-loading
+ * the checkout from elsewhere changes it and the manifest written for the old
+ * ID stops working. The IDs a browser actually has installed are read from
+ * its profiles' Preferences and Secure Preferences, where Chrome lists each
+ * extension with the directory it loads it from.
+ */
+
+import crypto from 'crypto';
+import fs from 'fs';
+import os from 'os';
+import path from 'path';
+import { fileURLToPath } from 'url';
+
+const __dirname = path.dirname(fileURLToPath(import.meta.url));
+
+export const NATIVE_HOST_NAME = 'com.analytics_logger.proxy';
+export const EXTENSION_DIR = path.join(__dirname, '..');
+
+// The Chrome Web Store's update URL, for ExtensionInstallForcelist
+export const WEB_STORE_UPDATE_URL = 'https://clients2.google.com/service/update2/crx';
+
+// Per-user browser data directories, which hold NativeMessagingHosts/
+export const BROWSER_DATA_DIRS = {
+  darwin: {
+    'chrome': 'Library/Application Support/Google/Chrome',
+    'chrome-beta': 'Library/Application Support/Google/Chrome Beta',
+    'chrome-canary': 'Library/Application Support/Google/Chrome Canary',
+    'chromium': 'Library/Application Support/Chromium',
+    'brave': 'Library/Application Support/BraveSoftware/Brave-Browser',
+    'edge': 'Library/Application Support/Microsoft Edge'
+  },
+  linux: {
+    'chrome': '.config/google-chrome',
+    'chrome-beta': '.config/google-chrome-beta',
+    'chrome-canary': '.config/google-chrome-unstable',
+    'chromium': '.config/chromium',
+    'brave': '.config/BraveSoftware/Brave-Browser',
+    'edge': '.config/microsoft-edge'
+  }
+};
+
+const PREFERENCES_FILES = ['Secure Preferences', 'Preferences'];
+
+/**
+ * An extension ID from a SHA-256 digest: its first 16 bytes in hex, written
+ * with the letters a-p for 0-f
+ */
+function idFromDigest(digest) {
+  return digest.subarray(0, 16).toString('hex')
+    .replace(/[0-9a-f]/g, digit => String.fromCharCode(97 + parseInt(digit, 16)));
+}
+
+export function isExtensionId(id) {
+  return /^[a-p]{32}$/.test(id);
+}
+
+/**
+ * The ID Chrome gives the extension in a directory: from the manifest's
+ * "key" when it has one (packed and store builds keep their ID that way),
+ * otherwise from the directory's path, as for any unpacked extension
+ * @returns {object} - { id, from: 'key'|'path' }
+ */
+export function extensionIdFor(dir = EXTENSION_DIR) {
+  let key = null;
+  try {
+    key = JSON.parse(fs.readFileSync(path.join(dir, 'manifest.json'), 'utf8')).key || null;
+  } catch (err) {
+    // No manifest: fall back to the path
+  }
+  if (key) {
+    return { id: idFromDigest(crypto.createHash('sha256').update(Buffer.from(key, 'base64')).digest()), from: 'key' };
+  }
+
+  // Chrome hashes the resolved path's native characters: UTF-16 on Windows
+  // (with an upper-case drive letter), bytes elsewhere
+  let resolved = fs.realpathSync(dir);
+  let bytes = Buffer.from(resolved, 'utf8');
+  if (process.platform === 'win32') {
+    resolved = resolved.replace(/^[a-z]:/, drive => drive.toUpperCase());
+    bytes = Buffer.from(resolved, 'utf16le');
+  }
+  return { id: idFromDigest(crypto.createHash('sha256').update(bytes).digest()), from: 'path' };
+}
+
+/**
+ * Browsers whose data directory exists (they have been run), with the
+ * manifest path for each
+ * @returns {Array<object>|null} - { id, dataDir, manifestPath }, or null
+ *   where manifests are registered in the registry instead (Windows)
+ */
+export function browserDataDirs() {
+  const dataDirs = BROWSER_DATA_DIRS[process.platform];
+  if (!dataDirs) return null;
+  return Object.entries(dataDirs)
+    .map(([id, dir]) => {
+      const dataDir = path.join(os.homedir(), dir);
+      return { id, dataDir, manifestPath: path.join(dataDir, 'NativeMessagingHosts', `${NATIVE_HOST_NAME}.json`) };
+    })
+    .filter(({ dataDir }) => fs.existsSync(dataDir));
+}
+
+function samePath(a, b) {
+  try {
+    return fs.realpathSync(a) === fs.realpathSync(b);
+  } catch (err) {
+    return false;
+  }
+}
+
+/**
+ * This extension as installed in a browser's profiles: unpacked from this
+ * directory, or packed under the same name
+ * @returns {Array<object>} - { id, profile, unpacked }
+ */
+export function installedExtensions(dataDir, extensionDir = EXTENSION_DIR) {
+  let name = null;
+  try {
+    name = JSON.parse(fs.readFileSync(path.join(extensionDir, 'manifest.json'), 'utf8')).name;
+  } catch (err) {
+    // Only unpacked installs can be matched
+  }
+
+  let profiles;
+  try {
+    profiles = fs.readdirSync(dataDir).filter(entry => PREFERENCES_FILES.some(file => fs.existsSync(path.join(dataDir, entry, file))));
+  } catch (err) {
+    return [];
+  }
+
+  const found = new Map();
+  for (const profile of profiles) {
+    for (const file of PREFERENCES_FILES) {
+      let settings;
+      try {
+        settings = JSON.parse(fs.readFileSync(path.join(dataDir, profile, file), 'utf8')).extensions?.settings;
+      } catch (err) {
+        continue; // Missing, or being written by the browser
+      }
+      for (const [id, entry] of Object.entries(settings || {})) {
+        if (!isExtensionId(id) || !entry || found.has(`${profile}/${id}`)) continue;
+        // Unpacked extensions are listed by their absolute path; packed ones
+        // by a path inside the profile, with their manifest
+        const unpacked = typeof entry.path === 'string' && path.isAbsolute(entry.path) && samePath(entry.path, extensionDir);
+        const packed = Boolean(name) && entry.manifest?.name === name;
+        if (unpacked || packed) {
+          found.set(`${profile}/${id}`, { id, profile, unpacked });
+        }
+      }
+    }
+  }
+  return [...found.values()];
+}
+
+/**
+ * The extension IDs a manifest's allowed_origins names
+ */
+export function allowedIds(manifest) {
+  return (manifest.allowed_origins || [])
+    .map(origin => /^chrome-extension:\/\/([^/]+)\/?$/.exec(origin)?.[1])
+    .filter(id => id && isExtensionId(id));
+}
+
+/**
+ * Each browser's manifest next to the extension IDs it has installed
+ * @returns {Array<object>|null} - { browser, manifestPath, manifest (or null),
+ *   error, allowed, installed, missing } where missing are installed IDs the
+ *   manifest does not allow; null on Windows
+ */
+export function manifestReport(extensionDir = EXTENSION_DIR) {
+  const browsers = browserDataDirs();
+  if (!browsers) return null;
+
+  return browsers.map(({ id, dataDir, manifestPath }) => {
+    let manifest = null;
+    let error = null;
+    if (fs.existsSync(manifestPath)) {
+      try {
+        manifest = JSON.parse(fs.readFileSync(manifestPath, 'utf8'));
+      } catch (err) {
+        error = `not valid JSON (${err.message})`;
+      }
+    }
+    const allowed = manifest ? allowedIds(manifest) : [];
+    const installed = installedExtensions(dataDir, extensionDir);
+    const missing = [...new Set(installed.map(extension => extension.id))].filter(extensionId => !allowed.includes(extensionId));
+    return { browser: id, manifestPath, manifest, error, allowed, installed, missing };
+  });
+}
+
+/**
+ * Write a native host manifest as the installers do
+ * @param {Array<string>} extensionIds - The IDs to allow
+ */
+export function writeManifest(manifestPath, hostPath, extensionIds) {
+  const manifest = {
+    name: NATIVE_HOST_NAME,
+    description: 'Analytics Logger Proxy Control',
+    path: hostPath,
+    type: 'stdio',
+    allowed_origins: extensionIds.map(id => `chrome-extension://${id}/`)
+  };
+  fs.mkdirSync(path.dirname(manifestPath), { recursive: true });
+  fs.writeFileSync(manifestPath, JSON.stringify(manifest, null, 2) + '\n');
+  if (process.platform !== 'win32') {
+    fs.chmodSync(hostPath, 0o755);
+  }
+  return manifest;
+}
+
+/**
+ * A Chrome enterprise policy that force-installs the extension and allows
+ * its native host
+ * @param {string} extensionId - The packed extension's ID
+ * @param {string} updateUrl - Where Chrome fetches the update manifest (the Web Store by default)
+ */
+export function enterprisePolicy(extensionId, updateUrl = WEB_STORE_UPDATE_URL) {
+  return {
+    ExtensionInstallForcelist: [`${extensionId};${updateUrl}`],
+    NativeMessagingAllowlist: [NATIVE_HOST_NAME]
+  };
+}
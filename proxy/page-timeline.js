@@ -0,0 +1,74 @@
+/**
+ * Page timeline (GET /pages)
+ *
+ * Each intercepted request's Referer and Origin headers are kept in the
+ * event's _metadata (referer, origin). Grouping events by them shows which
+ * tracking fires on which page: the page is the Referer without its
+ * fragment, else the Origin (service workers and pages with a strict
+ * Referrer-Policy send only that). Pages are listed in the order they first
+ * fired an event, each with its events oldest first.
+ */
+
+/**
+ * The page an event was sent from, or null when the request had neither header
+ */
+export function pageOf(event) {
+  const metadata = event._metadata || {};
+  if (metadata.referer) {
+    const hash = metadata.referer.indexOf('#');
+    return hash === -1 ? metadata.referer : metadata.referer.slice(0, hash);
+  }
+  return metadata.origin || null;
+}
+
+/**
+ * Referer and Origin of an intercepted request, for _metadata
+ * @param {object} headers - Request headers (lower-case names)
+ */
+export function pageHeaders(headers = {}) {
+  return {
+    referer: headers.referer || null,
+    // "null" for sandboxed frames and some redirects
+    origin: headers.origin && headers.origin !== 'null' ? headers.origin : null
+  };
+}
+
+/**
+ * Group events by page
+ * @param {Array<object>} events - Oldest first
+ * @param {object} options
+ * @param {number} options.limit - Events listed per page (the counts cover all)
+ * @returns {object} - { pages: [{ page, firstAt, lastAt, count, sources, events }], noPage }
+ */
+export function pageTimeline(events, { limit = 100 } = {}) {
+  const pages = new Map();
+  let noPage = 0;
+
+  for (const event of events) {
+    const page = pageOf(event);
+    if (!page) {
+      noPage++;
+      continue;
+    }
+    const at = (event._metadata && event._metadata.capturedAt) || event.timestamp;
+    let entry = pages.get(page);
+    if (!entry) {
+      entry = { page, firstAt: at, lastAt: at, count: 0, sources: {}, events: [] };
+      pages.set(page, entry);
+    }
+    entry.lastAt = at;
+    entry.count++;
+    entry.sources[event._source] = (entry.sources[event._source] || 0) + 1;
+    if (entry.events.length < limit) {
+      entry.events.push({
+        id: event.id,
+        event: event.event,
+        source: event._source,
+        at,
+        sequence: event._sequence
+      });
+    }
+  }
+
+  return { pages: [...pages.values()], noPage };
+}
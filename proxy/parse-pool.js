@@ -0,0 +1,158 @@
+/**
+ * Request body parsing off the proxy's request path
+ *
+ * Matched requests are forwarded as soon as their body has arrived; the body
+ * is queued here and decompressed and parsed by a pool of worker threads, so
+ * a large batch or a brotli bundle does not delay the page that sent it. The
+ * queue is bounded: when it is full, new bodies are dropped and counted
+ * rather than letting memory grow. With 0 workers bodies are parsed on the
+ * main thread, as before.
+ */
+
+import { Worker } from 'worker_threads';
+import logging from './logger.cjs';
+import { parseRequestBody } from './request-body.js';
+
+const WORKER_URL = new URL('./parse-worker.js', import.meta.url);
+
+export class ParsePool {
+  /**
+   * @param {object} options
+   * @param {number} options.workers - Worker threads (0 = parse on the main thread)
+   * @param {number} options.maxQueue - Bodies waiting for a worker before new ones are dropped
+   * @param {number} options.maxDecompressedBytes - Decompressed size past which a body is not parsed
+   */
+  constructor({ workers = 2, maxQueue = 1000, maxDecompressedBytes } = {}) {
+    this.size = workers;
+    this.maxQueue = maxQueue;
+    this.maxDecompressedBytes = maxDecompressedBytes;
+    this.queue = [];
+    this.workers = [];
+    this.nextId = 1;
+    this.counts = { processed: 0, failed: 0, dropped: 0 };
+    this.idleWaiters = [];
+    this.closed = false;
+    for (let i = 0; i < workers; i++) {
+      this.workers.push(this.startWorker());
+    }
+  }
+
+  startWorker() {
+    const slot = { worker: null, job: null };
+    slot.worker = new Worker(WORKER_URL, { workerData: { logging: logging.configureLogging() } });
+    slot.worker.on('message', message => this.finish(slot, message));
+    slot.worker.on('error', err => this.finish(slot, { error: `Parse worker failed: ${err.message}` }));
+    slot.worker.on('exit', () => {
+      if (this.closed) return;
+      // Crashed: fail its job and start a replacement
+      if (slot.job) this.finish(slot, { error: 'Parse worker exited' });
+      this.workers[this.workers.indexOf(slot)] = this.startWorker();
+    });
+    return slot;
+  }
+
+  /**
+   * Decompress and parse a source's request body
+   * @param {object} source - SourceConfig
+   * @param {Buffer} body - Raw body bytes
+   * @param {string} encoding - Content-Encoding header
+   * @param {object} request - { url, contentType } (see parseRequestBody)
+   * @returns {Promise<object|null>} - { events, decompressionError, timings }, or
+   *   null if the queue was full; rejects if the body does not parse (the
+   *   error's bodyPreview is the start of the body). timings holds queueMs
+   *   (waiting for a worker), decompressMs and parseMs.
+   */
+  parse(source, body, encoding, { url = null, contentType = null } = {}) {
+    const fieldMappings = source.fieldMappings || {};
+    if (this.size === 0) {
+      return new Promise((resolve, reject) => {
+        let decompressionError = null;
+        const timings = { queueMs: 0 };
+        try {
+          const events = parseRequestBody({ fieldMappings }, body, encoding, err => {
+            decompressionError = err.message;
+          }, { timings, url, contentType, maxDecompressedBytes: this.maxDecompressedBytes });
+          this.counts.processed++;
+          resolve({ events, decompressionError, timings });
+        } catch (err) {
+          this.counts.failed++;
+          reject(err);
+        }
+      });
+    }
+
+    // Only bodies that have to wait for a busy worker count against maxQueue
+    if (this.queue.length >= this.maxQueue && this.workers.every(slot => slot.job)) {
+      this.counts.dropped++;
+      return Promise.resolve(null);
+    }
+    return new Promise((resolve, reject) => {
+      this.queue.push({ id: this.nextId++, fieldMappings, body, encoding, url, contentType, queuedAt: performance.now(), resolve, reject });
+      this.dispatch();
+    });
+  }
+
+  dispatch() {
+    for (const slot of this.workers) {
+      if (this.queue.length === 0) break;
+      if (slot.job) continue;
+      slot.job = this.queue.shift();
+      slot.job.queueMs = performance.now() - slot.job.queuedAt;
+      const { id, fieldMappings, body, encoding, url, contentType } = slot.job;
+      slot.worker.postMessage({ id, fieldMappings, body, encoding, url, contentType, maxDecompressedBytes: this.maxDecompressedBytes });
+    }
+  }
+
+  finish(slot, message) {
+    const job = slot.job;
+    slot.job = null;
+    if (job) {
+      if (message.error) {
+        this.counts.failed++;
+        job.reject(Object.assign(new Error(message.error), {
+          decompressionError: message.decompressionError,
+          bodyPreview: message.bodyPreview
+        }));
+      } else {
+        this.counts.processed++;
+        job.resolve({
+          events: message.events,
+          decompressionError: message.decompressionError,
+          timings: { queueMs: job.queueMs, ...message.timings }
+        });
+      }
+    }
+    this.dispatch();
+    if (this.isIdle()) {
+      this.idleWaiters.splice(0).forEach(resolve => resolve());
+    }
+  }
+
+  isIdle() {
+    return this.queue.length === 0 && this.workers.every(slot => !slot.job);
+  }
+
+  /**
+   * @returns {object} - { workers, queued, inFlight, maxQueue, processed, failed, dropped }
+   */
+  stats() {
+    return {
+      workers: this.size,
+      queued: this.queue.length,
+      inFlight: this.workers.filter(slot => slot.job).length,
+      maxQueue: this.maxQueue,
+      ...this.counts
+    };
+  }
+
+  /**
+   * Finish the queued bodies, then stop the workers
+   */
+  async close() {
+    if (!this.isIdle()) {
+      await new Promise(resolve => this.idleWaiters.push(resolve));
+    }
+    this.closed = true;
+    await Promise.all(this.workers.map(slot => slot.worker.terminate()));
+  }
+}
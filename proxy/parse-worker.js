@@ -0,0 +1,30 @@
+/**
+ * Parse worker for ParsePool (see parse-pool.js)
+ *
+ * Receives { id, fieldMappings, body, encoding, url, contentType, maxDecompressedBytes }
+ * and answers
+ * { id, events, decompressionError, timings } or { id, error, decompressionError, bodyPreview }.
+ * Logging follows the proxy's configuration, passed in workerData.
+ */
+
+import { parentPort, threadId, workerData } from 'worker_threads';
+import logging from './logger.cjs';
+import { parseRequestBody } from './request-body.js';
+import { applyClockEnvironment } from './clock.js';
+
+logging.configureLogging(workerData.logging);
+applyClockEnvironment(process.env, `w${threadId}`);
+logging.captureConsole('parser');
+
+parentPort.on('message', ({ id, fieldMappings, body, encoding, url, contentType, maxDecompressedBytes }) => {
+  let decompressionError = null;
+  const timings = {};
+  try {
+    const events = parseRequestBody({ fieldMappings }, Buffer.from(body.buffer, body.byteOffset, body.byteLength), encoding, err => {
+      decompressionError = err.message;
+    }, { timings, url, contentType, maxDecompressedBytes });
+    parentPort.postMessage({ id, events, decompressionError, timings });
+  } catch (err) {
+    parentPort.postMessage({ id, error: err.message, decompressionError, bodyPreview: err.bodyPreview });
+  }
+});
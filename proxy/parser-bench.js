@@ -0,0 +1,229 @@
+/**
+ * Parser benchmarks for `loggy-proxy bench`
+ *
+ * Every matched request goes through decompression, JSON.parse (or the GA4
+ * /g/collect decoder) and AnalyticsParser.parsePayload (extractEvent,
+ * getNestedValue and friends). This times each stage over a set of payloads:
+ * a built-in corpus shaped like common analytics traffic (GA4 JSON and
+ * /g/collect hits, Segment batches, Heap, brotli bodies, deep fieldMappings
+ * paths) and any recorded fixtures (see fixtures.js), so
+ * parser changes can be measured against real traffic. profileCpu writes a
+ * V8 CPU profile of a run for Chrome DevTools (Performance > Load profile).
+ */
+
+import fs from 'fs';
+import inspector from 'inspector';
+import zlib from 'zlib';
+import { AnalyticsParser } from '../parsers.js';
+import { bodyPayload, inflateBody } from './request-body.js';
+import { readFixture } from './fixtures.js';
+
+function segmentEvent(i) {
+  return {
+    type: 'track',
+    event: ['Page Viewed', 'Product Clicked', 'Added to Cart', 'Checkout Started'][i % 4],
+    messageId: `msg-${i}-5f2c9a1e`,
+    anonymousId: 'anon-7d2e41b0',
+    userId: i % 3 === 0 ? `user-${i}` : null,
+    timestamp: new Date(Date.UTC(2026, 0, 1, 12, 0, i)).toISOString(),
+    properties: {
+      product_id: `sku-${1000 + i}`,
+      name: 'Ceramic Mug',
+      price: 14.5 + i,
+      currency: 'USD',
+      category: 'kitchen',
+      position: i,
+      tags: ['gift', 'sale']
+    },
+    context: {
+      page: { path: '/deals', url: 'https://shop.example.com/deals', title: 'Deals', referrer: '' },
+      userAgent: 'Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0 Safari/537.36',
+      library: { name: 'analytics.js', version: '4.1.0' },
+      locale: 'en-US'
+    }
+  };
+}
+
+function segmentBatch(size) {
+  return { batch: Array.from({ length: size }, (_, i) => segmentEvent(i)), sentAt: '2026-01-01T12:00:00.000Z' };
+}
+
+/**
+ * Payloads shaped like what the proxy sees most, with the fieldMappings a
+ * source for them would use
+ */
+export function builtinCases() {
+  const ga4 = {
+    client_id: '1234567890.1700000000',
+    user_id: 'user-42',
+    timestamp_micros: '1767268800000000',
+    events: Array.from({ length: 10 }, (_, i) => ({
+      name: ['page_view', 'scroll', 'view_item', 'add_to_cart', 'begin_checkout'][i % 5],
+      params: {
+        page_location: 'https://shop.example.com/p/mug',
+        page_title: 'Ceramic Mug',
+        engagement_time_msec: 100 * i,
+        session_id: '1767268800',
+        items: [{ item_id: `sku-${i}`, item_name: 'Ceramic Mug', price: 14.5, quantity: 1 }]
+      }
+    }))
+  };
+  const heap = {
+    a: '3901234567',
+    u: 'heap-user-1',
+    h: 'heap-session-1',
+    events: Array.from({ length: 20 }, (_, i) => ({
+      t: ['click', 'pageview', 'change', 'submit'][i % 4],
+      ts: 1767268800000 + i * 250,
+      props: { target_text: 'Add to cart', target_tag: 'button', href: '/cart', hierarchy: 'div.product;button#add' }
+    }))
+  };
+  const nested = {
+    data: Array.from({ length: 25 }, (_, i) => ({
+      info: [{ action: { code: `evt_${i % 7}` }, client_ts: 1767268800000 + i }],
+      actor: { ids: { user: `u-${i % 5}` } },
+      meta: { props: { screen: 'home', index: i, experiment: { id: 'exp-1', variant: 'b' } } }
+    }))
+  };
+  const segment = segmentBatch(50);
+  const ga4Collect = Array.from({ length: 10 }, (_, i) =>
+    `en=${['page_view', 'scroll', 'view_item', 'user_engagement'][i % 4]}&_et=${100 * i}&ep.item_id=sku-${i}&epn.value=14.5`).join('\n');
+
+  return [
+    { name: 'ga4', fieldMappings: { eventName: 'name', propertyContainer: 'params' }, body: Buffer.from(JSON.stringify(ga4)), encoding: null },
+    {
+      name: 'ga4-collect',
+      fieldMappings: {},
+      body: Buffer.from(ga4Collect),
+      encoding: null,
+      contentType: 'text/plain;charset=UTF-8',
+      url: 'https://region1.google-analytics.com/g/collect?v=2&tid=G-ABC123&cid=1234567890.1700000000&sid=1767268800&dl=https%3A%2F%2Fshop.example.com%2Fp%2Fmug&dt=Ceramic%20Mug'
+    },
+    { name: 'segment-batch-50', fieldMappings: {}, body: Buffer.from(JSON.stringify(segment)), encoding: null },
+    { name: 'segment-batch-500', fieldMappings: {}, body: Buffer.from(JSON.stringify(segmentBatch(500))), encoding: null },
+    { name: 'segment-batch-50-gzip', fieldMappings: {}, body: zlib.gzipSync(JSON.stringify(segment)), encoding: 'gzip' },
+    { name: 'segment-batch-50-brotli', fieldMappings: {}, body: zlib.brotliCompressSync(JSON.stringify(segment)), encoding: 'br' },
+    { name: 'heap', fieldMappings: { eventName: 't', timestamp: 'ts', propertyContainer: 'props' }, body: Buffer.from(JSON.stringify(heap)), encoding: null },
+    {
+      name: 'nested-mappings',
+      fieldMappings: { eventName: 'info[0].action.code', timestamp: 'info[0].client_ts', userId: 'actor.ids.user', propertyContainer: 'meta.props' },
+      body: Buffer.from(JSON.stringify(nested)),
+      encoding: null
+    }
+  ];
+}
+
+/**
+ * Benchmark cases for recorded fixtures, parsed with the fieldMappings of
+ * the source they match now (as replay does)
+ * @param {Array<string>} files - Fixture files
+ * @param {object} configManager - ConfigManagerNode
+ */
+export function fixtureCases(files, configManager) {
+  return files.map(file => {
+    const fixture = readFixture(file);
+    const source = configManager.findSourceForUrl(fixture.url);
+    const header = name => Object.entries(fixture.headers || {}).find(([key]) => key.toLowerCase() === name)?.[1];
+    return {
+      name: file,
+      fieldMappings: source ? source.fieldMappings || {} : {},
+      body: Buffer.from(fixture.body, 'base64'),
+      encoding: header('content-encoding') || null,
+      contentType: header('content-type') || null,
+      url: fixture.url
+    };
+  });
+}
+
+function elapsedUs(start) {
+  return Number(process.hrtime.bigint() - start) / 1000;
+}
+
+function percentile(sorted, p) {
+  return sorted[Math.min(sorted.length - 1, Math.ceil(p / 100 * sorted.length) - 1)];
+}
+
+function round(us) {
+  return Math.round(us * 10) / 10;
+}
+
+/**
+ * Time decompression, JSON.parse and parsePayload for each case
+ * @param {Array<object>} cases - { name, fieldMappings, body, encoding, contentType, url }
+ * @param {object} options
+ * @param {number} options.iterations - Timed runs per case
+ * @param {number} options.warmup - Untimed runs first, so the JIT has settled
+ * @returns {Array<object>} - Per case: { name, bytes, events, iterations,
+ *   opsPerSec, meanUs, p50Us, p99Us, stages: { decompressUs, jsonUs, extractUs } }
+ *   (stage times are means), or { name, error } if the case does not parse
+ */
+export function runBenchmarks(cases, { iterations = 1000, warmup = 100 } = {}) {
+  return cases.map(({ name, fieldMappings, body, encoding, contentType = 'application/json', url = null }) => {
+    const totals = new Array(iterations);
+    const stages = { decompressUs: 0, jsonUs: 0, extractUs: 0 };
+    let events = 0;
+    try {
+      for (let i = -warmup; i < iterations; i++) {
+        const start = process.hrtime.bigint();
+        const bytes = inflateBody(body, encoding);
+        const decompressed = process.hrtime.bigint();
+        const data = bodyPayload(bytes, contentType, url);
+        const parsed = process.hrtime.bigint();
+        events = AnalyticsParser.parsePayload(data, fieldMappings).length;
+        if (i < 0) continue;
+
+        totals[i] = elapsedUs(start);
+        stages.decompressUs += Number(decompressed - start) / 1000;
+        stages.jsonUs += Number(parsed - decompressed) / 1000;
+        stages.extractUs += elapsedUs(parsed);
+      }
+    } catch (err) {
+      return { name, error: err.message };
+    }
+
+    const sorted = totals.sort((a, b) => a - b);
+    const meanUs = sorted.reduce((sum, us) => sum + us, 0) / iterations;
+    return {
+      name,
+      bytes: body.length,
+      events,
+      iterations,
+      opsPerSec: Math.round(1e6 / meanUs),
+      meanUs: round(meanUs),
+      p50Us: round(percentile(sorted, 50)),
+      p99Us: round(percentile(sorted, 99)),
+      stages: {
+        decompressUs: round(stages.decompressUs / iterations),
+        jsonUs: round(stages.jsonUs / iterations),
+        extractUs: round(stages.extractUs / iterations)
+      }
+    };
+  });
+}
+
+function post(session, method, params = {}) {
+  return new Promise((resolve, reject) => {
+    session.post(method, params, (err, result) => (err ? reject(err) : resolve(result)));
+  });
+}
+
+/**
+ * Run fn under the V8 CPU profiler and write the profile to file
+ * (.cpuprofile, for Chrome DevTools or speedscope)
+ * @returns {Promise<*>} - What fn returned
+ */
+export async function profileCpu(file, fn) {
+  const session = new inspector.Session();
+  session.connect();
+  try {
+    await post(session, 'Profiler.enable');
+    await post(session, 'Profiler.setSamplingInterval', { interval: 100 });
+    await post(session, 'Profiler.start');
+    const result = await fn();
+    const { profile } = await post(session, 'Profiler.stop');
+    fs.writeFileSync(file, JSON.stringify(profile));
+    return result;
+  } finally {
+    session.disconnect();
+  }
+}
@@ -0,0 +1,80 @@
+/**
+ * PayloadStats - Request body sizes per source, for GET /stats
+ *
+ * Keeps the sizes of each source's most recent request bodies (as received,
+ * so still compressed when the page compressed them) and summarizes them as
+ * average and percentiles, to help size maxEvents, parsing.maxQueue and sink
+ * batches for the traffic actually seen.
+ */
+
+export class PayloadStats {
+  /**
+   * @param {object} options
+   * @param {number} options.window - Recent bodies per source the summary covers
+   */
+  constructor({ window = 1000 } = {}) {
+    this.window = window;
+    this.sources = new Map(); // source ID -> { name, sizes, next, requests, bytes, compressed }
+  }
+
+  /**
+   * @param {object} source - SourceConfig
+   * @param {number} bytes - Body size as received
+   * @param {string} encoding - Content-Encoding header
+   */
+  record(source, bytes, encoding) {
+    let entry = this.sources.get(source.id);
+    if (!entry) {
+      entry = { name: source.name, sizes: [], next: 0, requests: 0, bytes: 0, compressed: 0 };
+      this.sources.set(source.id, entry);
+    }
+    // Ring of the last `window` sizes
+    if (entry.sizes.length < this.window) {
+      entry.sizes.push(bytes);
+    } else {
+      entry.sizes[entry.next] = bytes;
+      entry.next = (entry.next + 1) % this.window;
+    }
+    entry.requests++;
+    entry.bytes += bytes;
+    if (encoding) entry.compressed++;
+  }
+
+  clear() {
+    this.sources.clear();
+  }
+
+  /**
+   * @returns {Array<object>} - Per source: { id, name, requests, bytes,
+   *   compressedRequests, recent: { count, avg, p50, p90, p99, max } }, largest total first
+   */
+  summary() {
+    return [...this.sources].map(([id, entry]) => ({
+      id,
+      name: entry.name,
+      requests: entry.requests,
+      bytes: entry.bytes,
+      compressedRequests: entry.compressed,
+      recent: summarizeSizes(entry.sizes)
+    })).sort((a, b) => b.bytes - a.bytes);
+  }
+}
+
+/**
+ * Average and nearest-rank percentiles of a list of sizes
+ */
+export function summarizeSizes(sizes) {
+  if (sizes.length === 0) {
+    return { count: 0, avg: 0, p50: 0, p90: 0, p99: 0, max: 0 };
+  }
+  const sorted = [...sizes].sort((a, b) => a - b);
+  const percentile = p => sorted[Math.min(sorted.length - 1, Math.ceil(p / 100 * sorted.length) - 1)];
+  return {
+    count: sorted.length,
+    avg: Math.round(sorted.reduce((sum, size) => sum + size, 0) / sorted.length),
+    p50: percentile(50),
+    p90: percentile(90),
+    p99: percentile(99),
+    max: sorted[sorted.length - 1]
+  };
+}
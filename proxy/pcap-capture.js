@@ -0,0 +1,447 @@
+/**
+ * PcapCapture - Capture requests passively from network traffic (Linux)
+ *
+ * For processes that can't be pointed at a proxy at all: daemons with their
+ * own HTTP stack, containers, embedded runtimes. Plaintext HTTP/1.x is read
+ * from tcpdump's packet stream ("tcpdump -w -", which needs root or
+ * CAP_NET_RAW), reassembled per TCP connection and parsed into requests here.
+ * A pcap file can be read instead of a live interface.
+ *
+ * HTTPS can only be read with the client's TLS secrets: when keylogFile is
+ * set (the SSLKEYLOGFILE the client writes; Chrome, Firefox, curl and
+ * Node's --tls-keylog support it), tshark decrypts the traffic and reports
+ * each request's fields. That covers HTTP/1.1 over TLS; HTTP/2 bodies are not
+ * reported this way.
+ */
+
+import fs from 'fs';
+import readline from 'readline';
+import { spawn } from 'child_process';
+
+// Link-layer types (pcap header "network")
+const LINKTYPE_NULL = 0;
+const LINKTYPE_ETHERNET = 1;
+const LINKTYPE_RAW = 101;
+const LINKTYPE_LINUX_SLL = 113;
+const LINKTYPE_LINUX_SLL2 = 276;
+
+const REQUEST_LINE = /^([A-Z]+) (\S+) HTTP\/1\.[01]\r\n/;
+const MAX_FLOW_BYTES = 16 * 1024 * 1024; // A connection buffering more than this is dropped
+const FLOW_IDLE_MS = 60 * 1000;
+
+export class PcapCapture {
+  /**
+   * @param {object} options - pcap settings
+   * @param {string} options.interface - Interface to capture on ("any" = all)
+   * @param {Array<number>} options.ports - Server ports of plaintext HTTP
+   * @param {Array<number>} options.tlsPorts - Server ports of HTTPS (with keylogFile)
+   * @param {string} options.file - Read this pcap file instead of capturing live
+   * @param {string} options.keylogFile - Decrypt HTTPS with these TLS secrets (via tshark)
+   * @param {function} options.wants - ({ method, url }) => whether to capture the request
+   * @param {function} options.onRequest - Called with { method, url, headers, body }
+   */
+  constructor({ interface: iface = 'any', ports = [80], tlsPorts = [443], file = null, keylogFile = null, wants = () => true, onRequest }) {
+    this.iface = iface;
+    this.ports = ports;
+    this.tlsPorts = tlsPorts;
+    this.file = file;
+    this.keylogFile = keylogFile;
+    this.wants = wants;
+    this.onRequest = onRequest;
+    this.child = null;
+    this.reassembler = new HttpReassembler(ports, request => this.emit(request));
+    this.state = {
+      mode: keylogFile ? 'tshark' : file ? 'file' : 'tcpdump',
+      running: false,
+      packets: 0,
+      requests: 0,       // Requests handed to onRequest
+      lastError: null
+    };
+  }
+
+  start() {
+    if (this.keylogFile) {
+      this.startTshark();
+    } else if (this.file) {
+      this.read(fs.createReadStream(this.file));
+    } else {
+      this.startTcpdump();
+    }
+  }
+
+  startTcpdump() {
+    const filter = `tcp and (${this.ports.map(port => `port ${port}`).join(' or ')})`;
+    this.spawn('tcpdump', ['-i', this.iface, '-U', '-s', '0', '-w', '-', filter]);
+    if (this.child) this.read(this.child.stdout);
+  }
+
+  startTshark() {
+    const ports = this.tlsPorts.join(',');
+    this.spawn('tshark', [
+      '-i', this.iface, '-l', '-Q',
+      '-o', `tls.keylog_file:${this.keylogFile}`,
+      '-o', `http.tls.port:${ports}`,
+      '-o', 'http.decompress_body:FALSE',
+      '-f', `tcp and (${[...this.ports, ...this.tlsPorts].map(port => `port ${port}`).join(' or ')})`,
+      '-Y', 'http.request',
+      '-T', 'fields', '-E', 'separator=/t', '-E', 'occurrence=f',
+      '-e', 'http.request.method', '-e', 'http.request.full_uri', '-e', 'http.content_type',
+      '-e', 'http.content_encoding', '-e', 'http.referer', '-e', 'http.file_data'
+    ]);
+    if (!this.child) return;
+    readline.createInterface({ input: this.child.stdout }).on('line', line => {
+      const [method, url, contentType, contentEncoding, referer, data = ''] = line.split('\t');
+      if (!method || !url) return;
+      const headers = {};
+      if (contentType) headers['content-type'] = contentType;
+      if (contentEncoding) headers['content-encoding'] = contentEncoding;
+      if (referer) headers.referer = referer;
+      this.emit({ method, url, headers, body: fileData(data) });
+    });
+  }
+
+  spawn(command, args) {
+    const child = spawn(command, args, { stdio: ['ignore', 'pipe', 'pipe'] });
+    this.child = child;
+    this.state.running = true;
+    let stderr = '';
+    child.stderr.on('data', chunk => {
+      stderr = (stderr + chunk).slice(-2000);
+    });
+    child.on('error', err => {
+      this.state.running = false;
+      this.state.lastError = err.code === 'ENOENT' ? `${command} is not installed` : err.message;
+      console.warn(`[Pcap] Cannot run ${command}: ${this.state.lastError}`);
+    });
+    child.on('exit', code => {
+      this.state.running = false;
+      if (this.child !== child) return;
+      this.child = null;
+      if (code) {
+        const reason = stderr.trim().split('\n').pop() || `exit code ${code}`;
+        this.state.lastError = reason;
+        console.warn(`[Pcap] ${command} stopped: ${reason}${/permission|not permitted/i.test(reason) ? ' (needs root or CAP_NET_RAW)' : ''}`);
+      }
+    });
+  }
+
+  /**
+   * Parse a pcap stream (tcpdump's output or a file)
+   */
+  read(stream) {
+    this.state.running = true;
+    const reader = new PcapReader((linkType, packet) => {
+      this.state.packets++;
+      this.reassembler.packet(linkType, packet);
+    });
+    stream.on('data', chunk => {
+      try {
+        reader.feed(chunk);
+      } catch (err) {
+        this.state.lastError = err.message;
+        console.warn(`[Pcap] ${err.message}`);
+        stream.destroy();
+      }
+    });
+    stream.on('error', err => {
+      this.state.lastError = err.message;
+      console.warn(`[Pcap] Cannot read ${this.file || 'tcpdump output'}: ${err.message}`);
+    });
+    stream.on('end', () => {
+      if (stream !== (this.child && this.child.stdout)) this.state.running = false;
+    });
+  }
+
+  emit(request) {
+    if (!this.wants(request)) return;
+    this.state.requests++;
+    this.onRequest(request);
+  }
+
+  /**
+   * Mode, packet and request counts for GET /status
+   */
+  getStatus() {
+    return {
+      interface: this.file ? null : this.iface,
+      file: this.file,
+      ports: this.ports,
+      tlsPorts: this.keylogFile ? this.tlsPorts : [],
+      ...this.state,
+      connections: this.reassembler.flows.size
+    };
+  }
+
+  close() {
+    clearInterval(this.reassembler.sweeper);
+    if (this.child) {
+      const child = this.child;
+      this.child = null;
+      child.kill();
+    }
+  }
+}
+
+/**
+ * tshark prints byte fields as hex (Wireshark 4) or as text (older versions)
+ */
+function fileData(data) {
+  if (/^[0-9a-f]{2}(:?[0-9a-f]{2})*$/i.test(data)) return Buffer.from(data.replace(/:/g, ''), 'hex');
+  return Buffer.from(data, 'utf8');
+}
+
+/**
+ * Splits a pcap stream (classic format, either byte order, micro- or
+ * nanosecond timestamps) into packets
+ */
+export class PcapReader {
+  constructor(onPacket) {
+    this.onPacket = onPacket;
+    this.buffer = Buffer.alloc(0);
+    this.littleEndian = null;
+    this.linkType = null;
+  }
+
+  feed(chunk) {
+    this.buffer = this.buffer.length ? Buffer.concat([this.buffer, chunk]) : chunk;
+    if (this.linkType === null) {
+      if (this.buffer.length < 24) return;
+      const magic = this.buffer.readUInt32LE(0);
+      if (magic === 0xa1b2c3d4 || magic === 0xa1b23c4d) {
+        this.littleEndian = true;
+      } else if (magic === 0xd4c3b2a1 || magic === 0x4d3cb2a1) {
+        this.littleEndian = false;
+      } else {
+        throw new Error('Not a pcap stream (pcapng is not supported; convert it with "editcap -F pcap")');
+      }
+      this.linkType = this.read32(20) & 0xffff;
+      this.buffer = this.buffer.subarray(24);
+    }
+
+    while (this.buffer.length >= 16) {
+      const length = this.read32(8);
+      if (this.buffer.length < 16 + length) break;
+      this.onPacket(this.linkType, this.buffer.subarray(16, 16 + length));
+      this.buffer = this.buffer.subarray(16 + length);
+    }
+  }
+
+  read32(offset) {
+    return this.littleEndian ? this.buffer.readUInt32LE(offset) : this.buffer.readUInt32BE(offset);
+  }
+}
+
+/**
+ * The IP packet inside a link-layer frame, or null
+ */
+function ipPayload(linkType, frame) {
+  let offset;
+  let protocol;
+  switch (linkType) {
+    case LINKTYPE_ETHERNET:
+      offset = 14;
+      protocol = frame.readUInt16BE(12);
+      if (protocol === 0x8100) {
+        // 802.1Q VLAN tag
+        protocol = frame.readUInt16BE(16);
+        offset = 18;
+      }
+      break;
+    case LINKTYPE_LINUX_SLL:
+      offset = 16;
+      protocol = frame.readUInt16BE(14);
+      break;
+    case LINKTYPE_LINUX_SLL2:
+      offset = 20;
+      protocol = frame.readUInt16BE(0);
+      break;
+    case LINKTYPE_NULL:
+      // Address family in host byte order: 2 = IPv4, 24/28/30 = IPv6
+      offset = 4;
+      protocol = frame[0] === 2 || frame[3] === 2 ? 0x0800 : 0x86dd;
+      break;
+    case LINKTYPE_RAW:
+      offset = 0;
+      protocol = frame[0] >> 4 === 4 ? 0x0800 : 0x86dd;
+      break;
+    default:
+      return null;
+  }
+  if (protocol !== 0x0800 && protocol !== 0x86dd) return null;
+  return frame.subarray(offset);
+}
+
+/**
+ * Addresses, ports, sequence number, flags and payload of a TCP segment, or null
+ */
+function tcpSegment(ip) {
+  let src;
+  let dst;
+  let tcp;
+  if (ip[0] >> 4 === 4) {
+    const headerLength = (ip[0] & 0x0f) * 4;
+    const fragment = ip.readUInt16BE(6);
+    // Protocol 6 = TCP; fragments are skipped (TCP rarely fragments)
+    if (ip[9] !== 6 || (fragment & 0x3fff) !== 0) return null;
+    src = ip.subarray(12, 16).join('.');
+    dst = ip.subarray(16, 20).join('.');
+    tcp = ip.subarray(headerLength, Math.min(ip.readUInt16BE(2), ip.length));
+  } else if (ip[0] >> 4 === 6) {
+    // Next header 6 = TCP (extension headers are not followed)
+    if (ip[6] !== 6) return null;
+    src = ip.subarray(8, 24).toString('hex');
+    dst = ip.subarray(24, 40).toString('hex');
+    tcp = ip.subarray(40, 40 + ip.readUInt16BE(4));
+  } else {
+    return null;
+  }
+  if (tcp.length < 20) return null;
+  const flags = tcp[13];
+  return {
+    src,
+    dst,
+    srcPort: tcp.readUInt16BE(0),
+    dstPort: tcp.readUInt16BE(2),
+    seq: tcp.readUInt32BE(4),
+    syn: (flags & 0x02) !== 0,
+    fin: (flags & 0x01) !== 0,
+    rst: (flags & 0x04) !== 0,
+    payload: tcp.subarray((tcp[12] >> 4) * 4)
+  };
+}
+
+/**
+ * Reassembles the client-to-server side of TCP connections to the given
+ * ports and parses the HTTP/1.x requests in it
+ */
+export class HttpReassembler {
+  constructor(ports, onRequest) {
+    this.ports = new Set(ports);
+    this.onRequest = onRequest;
+    this.flows = new Map(); // "src:port>dst:port" -> { nextSeq, data, pending, host, lastSeen }
+    this.sweeper = setInterval(() => this.sweep(), FLOW_IDLE_MS);
+    this.sweeper.unref();
+  }
+
+  packet(linkType, frame) {
+    const ip = ipPayload(linkType, frame);
+    const segment = ip && tcpSegment(ip);
+    if (!segment || !this.ports.has(segment.dstPort)) return;
+
+    const key = `${segment.src}:${segment.srcPort}>${segment.dst}:${segment.dstPort}`;
+    let flow = this.flows.get(key);
+    if (segment.rst) {
+      this.flows.delete(key);
+      return;
+    }
+    if (segment.syn || !flow) {
+      // A connection seen from its SYN, or joined mid-stream
+      flow = { nextSeq: segment.syn ? (segment.seq + 1) >>> 0 : segment.seq, data: Buffer.alloc(0), pending: new Map(), lastSeen: 0 };
+      this.flows.set(key, flow);
+    }
+    flow.lastSeen = Date.now();
+
+    if (segment.payload.length > 0) this.segment(flow, segment.seq, segment.payload);
+    this.parse(flow);
+    if (segment.fin) this.flows.delete(key);
+  }
+
+  segment(flow, seq, payload) {
+    const offset = (seq - flow.nextSeq) | 0;
+    if (offset > 0) {
+      // Arrived early; held until the gap is filled
+      flow.pending.set(seq, payload);
+      return;
+    }
+    if (-offset >= payload.length) return; // Retransmitted
+    this.append(flow, payload.subarray(-offset));
+
+    for (let next = flow.pending.get(flow.nextSeq); next; next = flow.pending.get(flow.nextSeq)) {
+      flow.pending.delete(flow.nextSeq);
+      this.append(flow, next);
+    }
+  }
+
+  append(flow, data) {
+    flow.data = flow.data.length ? Buffer.concat([flow.data, data]) : Buffer.from(data);
+    flow.nextSeq = (flow.nextSeq + data.length) >>> 0;
+    if (flow.data.length > MAX_FLOW_BYTES) flow.data = Buffer.alloc(0);
+  }
+
+  /**
+   * Take every complete request off the front of the connection's data
+   */
+  parse(flow) {
+    while (flow.data.length > 0) {
+      const start = flow.data.subarray(0, 8192).toString('latin1');
+      if (!REQUEST_LINE.test(start)) {
+        // The request line hasn't all arrived yet
+        if (!start.includes('\r\n') && flow.data.length < 8192) return;
+        // Joined mid-request (or not HTTP): skip to the next request line
+        const next = flow.data.toString('latin1').search(/[A-Z]+ \S+ HTTP\/1\.[01]\r\n/);
+        flow.data = next === -1 ? Buffer.alloc(0) : flow.data.subarray(next);
+        continue;
+      }
+
+      const headerEnd = flow.data.indexOf('\r\n\r\n');
+      if (headerEnd === -1) return;
+      const lines = flow.data.subarray(0, headerEnd).toString('latin1').split('\r\n');
+      const [, method, target] = REQUEST_LINE.exec(lines[0] + '\r\n');
+      const headers = {};
+      for (const line of lines.slice(1)) {
+        const colon = line.indexOf(':');
+        if (colon > 0) headers[line.slice(0, colon).trim().toLowerCase()] = line.slice(colon + 1).trim();
+      }
+
+      const bodyStart = headerEnd + 4;
+      let body;
+      let end;
+      if (/chunked/i.test(headers['transfer-encoding'] || '')) {
+        const chunked = dechunk(flow.data, bodyStart);
+        if (!chunked) return;
+        ({ body, end } = chunked);
+        delete headers['transfer-encoding'];
+      } else {
+        const length = parseInt(headers['content-length'], 10) || 0;
+        if (flow.data.length < bodyStart + length) return;
+        body = flow.data.subarray(bodyStart, bodyStart + length);
+        end = bodyStart + length;
+      }
+
+      const url = /^https?:\/\//.test(target) ? target : `http://${headers.host || 'unknown'}${target}`;
+      this.onRequest({ method, url, headers, body: Buffer.from(body) });
+      flow.data = flow.data.subarray(end);
+    }
+  }
+
+  sweep() {
+    const cutoff = Date.now() - FLOW_IDLE_MS;
+    for (const [key, flow] of this.flows) {
+      if (flow.lastSeen < cutoff) this.flows.delete(key);
+    }
+  }
+}
+
+/**
+ * Decode a chunked body starting at offset
+ * @returns {object|null} - { body, end }, or null until the last chunk has arrived
+ */
+function dechunk(data, offset) {
+  const chunks = [];
+  let position = offset;
+  for (;;) {
+    const lineEnd = data.indexOf('\r\n', position);
+    if (lineEnd === -1) return null;
+    const size = parseInt(data.subarray(position, lineEnd).toString('latin1'), 16);
+    if (Number.isNaN(size)) return { body: Buffer.concat(chunks), end: data.length };
+    position = lineEnd + 2;
+    if (size === 0) {
+      // Trailers (usually none) end with an empty line
+      const trailerEnd = data.indexOf('\r\n\r\n', position - 2);
+      return trailerEnd === -1 ? null : { body: Buffer.concat(chunks), end: trailerEnd + 4 };
+    }
+    if (data.length < position + size + 2) return null;
+    chunks.push(data.subarray(position, position + size));
+    position += size + 2;
+  }
+}
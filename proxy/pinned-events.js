@@ -0,0 +1,111 @@
+/**
+ * PinnedEvents - A working set of events kept apart from the buffer
+ *
+ * Pinning copies a buffered event (POST /events/<id>/pin), so the events
+ * that reproduce a bug stay at hand through a long session: they survive
+ * POST /clear and the buffer rolling over, until unpinned or the proxy stops.
+ * Each pinned event carries _pinned: { at, note }. At most max events are
+ * kept; pinning past that unpins the oldest pin. Retries can give several
+ * events one ID, so pins are kept per copy ("<id>@<_sequence>"); a bare ID
+ * names the newest pin with that ID.
+ */
+
+/**
+ * The key a pinned event is kept under
+ */
+function pinKey(event) {
+  return event._sequence === undefined ? event.id : `${event.id}@${event._sequence}`;
+}
+
+export class PinnedEvents {
+  /**
+   * @param {number} max - Events kept (maxPinnedEvents)
+   * @param {function} nowMs - Returns epoch milliseconds
+   */
+  constructor(max = 500, nowMs = () => Date.now()) {
+    this.max = max;
+    this.nowMs = nowMs;
+    this.events = new Map(); // pinKey -> pinned copy, oldest pin first
+  }
+
+  /**
+   * Pin a copy of an event (pinning it again updates the note and moves it to
+   * the newest pin)
+   * @param {object} event - A buffered event
+   * @param {string} note - Why it was pinned (optional)
+   * @returns {object} - The pinned copy
+   */
+  pin(event, note = null) {
+    const key = pinKey(event);
+    this.events.delete(key);
+    const pinned = { ...event, _pinned: { at: new Date(this.nowMs()).toISOString(), note } };
+    this.events.set(key, pinned);
+    for (const id of this.events.keys()) {
+      if (this.events.size <= this.max) break;
+      this.events.delete(id);
+    }
+    return pinned;
+  }
+
+  /**
+   * Key of the pin a reference names: "<id>@<_sequence>", or a bare ID (its
+   * newest pin)
+   */
+  keyOf(ref) {
+    if (this.events.has(ref)) return ref;
+    let newest;
+    for (const [key, event] of this.events) {
+      if (event.id === ref) newest = key;
+    }
+    return newest;
+  }
+
+  /**
+   * @returns {boolean} - False if the event was not pinned
+   */
+  unpin(ref) {
+    const key = this.keyOf(ref);
+    return key !== undefined && this.events.delete(key);
+  }
+
+  has(ref) {
+    return this.keyOf(ref) !== undefined;
+  }
+
+  get(ref) {
+    const key = this.keyOf(ref);
+    return key === undefined ? undefined : this.events.get(key);
+  }
+
+  get size() {
+    return this.events.size;
+  }
+
+  /**
+   * @returns {number} - Events unpinned
+   */
+  clear() {
+    const count = this.events.size;
+    this.events.clear();
+    return count;
+  }
+
+  /**
+   * Pinned events, newest pin first
+   */
+  list() {
+    return [...this.events.values()].reverse();
+  }
+
+  /**
+   * Change the limit (e.g. after a reload), unpinning the oldest pins that no
+   * longer fit
+   */
+  resize(max) {
+    this.max = max;
+    for (const id of this.events.keys()) {
+      if (this.events.size <= this.max) break;
+      this.events.delete(id);
+    }
+  }
+}
@@ -0,0 +1,149 @@
+/**
+ * PinningDetector - Spot hosts whose clients reject the MITM certificate
+ *
+ * Apps that pin certificates abort the TLS handshake once they see the
+ * proxy's leaf: they send a certificate alert or just close the socket after
+ * the server hello. Those hosts never produce a decrypted request, so they
+ * are counted here and suggested for `bypassHosts` (tunnelled without
+ * interception).
+ */
+
+// Handshake failures that mean the client refused our certificate
+// (CLOSED_DURING_HANDSHAKE: the client hung up without an alert)
+const REJECTION_CODES = new Set([
+  'CLOSED_DURING_HANDSHAKE',
+  'ERR_SSL_SSLV3_ALERT_CERTIFICATE_UNKNOWN',
+  'ERR_SSL_SSLV3_ALERT_BAD_CERTIFICATE',
+  'ERR_SSL_TLSV1_ALERT_UNKNOWN_CA',
+  'ERR_SSL_SSLV3_ALERT_HANDSHAKE_FAILURE',
+  'ECONNRESET'
+]);
+
+/**
+ * Whether a TLS server handshake error looks like the client rejecting the
+ * certificate (as opposed to e.g. a protocol or cipher mismatch)
+ */
+export function isCertificateRejection(err) {
+  return REJECTION_CODES.has(err.code) ||
+    /disconnected before secure TLS connection/i.test(err.message || '');
+}
+
+/**
+ * Whether a hostname matches a bypass entry ("example.com" or "*.example.com")
+ */
+export function matchesHost(hostname, pattern) {
+  if (pattern.startsWith('*.')) {
+    return hostname.endsWith(pattern.slice(1));
+  }
+  return hostname === pattern;
+}
+
+export class PinningDetector {
+  /**
+   * @param {object} options
+   * @param {number} options.minFailures - Failed handshakes (with none succeeding) before a host is suggested
+   */
+  constructor({ minFailures = 3 } = {}) {
+    this.minFailures = minFailures;
+    this.hosts = new Map(); // hostname -> { failures, handshakes, lastError, lastFailureAt }
+  }
+
+  entryFor(hostname) {
+    let entry = this.hosts.get(hostname);
+    if (!entry) {
+      entry = { failures: 0, handshakes: 0, lastError: null, lastFailureAt: null };
+      this.hosts.set(hostname, entry);
+    }
+    return entry;
+  }
+
+  recordHandshake(hostname) {
+    this.entryFor(hostname).handshakes++;
+  }
+
+  recordFailure(hostname, err) {
+    if (!isCertificateRejection(err)) return;
+    const entry = this.entryFor(hostname);
+    entry.failures++;
+    entry.lastError = err.code || err.message;
+    entry.lastFailureAt = new Date().toISOString();
+    if (entry.failures === this.minFailures && entry.handshakes === 0) {
+      console.warn(`[Pinning] ${hostname} rejected the proxy certificate ${entry.failures} times; consider adding it to bypassHosts`);
+    }
+  }
+
+  /**
+   * Hosts with rejected handshakes, most failures first
+   * @param {Array<string>} bypassHosts - Current bypass list (already-bypassed hosts aren't suggested)
+   */
+  getReport(bypassHosts = []) {
+    const domains = [...this.hosts.entries()]
+      .filter(([, entry]) => entry.failures > 0)
+      .map(([host, entry]) => {
+        const bypassed = bypassHosts.some(pattern => matchesHost(host, pattern));
+        return {
+          host,
+          ...entry,
+          bypassed,
+          suggestBypass: !bypassed && entry.handshakes === 0 && entry.failures >= this.minFailures
+        };
+      })
+      .sort((a, b) => b.failures - a.failures);
+
+    return {
+      domains,
+      suggestedBypassHosts: domains.filter(d => d.suggestBypass).map(d => d.host)
+    };
+  }
+
+  clear() {
+    this.hosts.clear();
+  }
+
+  /**
+   * Watch the per-host HTTPS servers http-mitm-proxy creates for intercepted
+   * hosts. Handshake errors aren't passed to proxy.onError, so this hooks
+   * server creation (an internal method) to listen for them.
+   * @param {object} proxy - http-mitm-proxy Proxy
+   */
+  install(proxy) {
+    if (typeof proxy._createHttpsServer !== 'function') {
+      console.warn('[Pinning] http-mitm-proxy internals changed; pinning detection disabled');
+      return;
+    }
+
+    const createHttpsServer = proxy._createHttpsServer.bind(proxy);
+    proxy._createHttpsServer = (options, callback) => createHttpsServer(options, (port, httpsServer, wssServer) => {
+      const fallbackHost = (options.hosts || [])[0];
+
+      // Connections (by client port) that haven't completed their handshake
+      const pending = new Set();
+      let lastTlsError = null;
+
+      httpsServer.on('connection', rawSocket => {
+        const clientPort = rawSocket.remotePort;
+        pending.add(clientPort);
+        rawSocket.once('close', () => {
+          // The handshake error (if any) is emitted after the close
+          setImmediate(() => {
+            const err = lastTlsError || Object.assign(new Error('Client closed during handshake'), { code: 'CLOSED_DURING_HANDSHAKE' });
+            lastTlsError = null;
+            // Only count clients that sent a ClientHello
+            if (pending.delete(clientPort) && rawSocket.bytesRead > 0 && fallbackHost) {
+              this.recordFailure(fallbackHost, err);
+            }
+          });
+        });
+      });
+      httpsServer.on('secureConnection', socket => {
+        pending.delete(socket.remotePort);
+        const hostname = socket.servername || fallbackHost;
+        if (hostname) this.recordHandshake(hostname);
+      });
+      httpsServer.on('tlsClientError', err => {
+        lastTlsError = err;
+      });
+      return callback(port, httpsServer, wssServer);
+    });
+  }
+}
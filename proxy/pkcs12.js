@@ -0,0 +1,109 @@
+/**
+ * PKCS#12 - Certificate-only .p12 bundles for trust stores
+ *
+ * Java keystores and some device management tools only import PKCS#12. The
+ * bundle holds just the CA certificate (never its key), marked as a trusted
+ * entry for Java, with an HMAC-SHA256 integrity check keyed from the password
+ * (RFC 7292).
+ */
+
+import crypto from 'crypto';
+import { der } from './x509.js';
+
+const { tlv, sequence, set, octetString, explicit, integer, oid, nullValue } = der;
+
+const BMP_STRING = 0x1e;
+
+const OIDS = {
+  data: '1.2.840.113549.1.7.1',
+  certBag: '1.2.840.113549.1.12.10.1.3',
+  x509Certificate: '1.2.840.113549.1.9.22.1',
+  friendlyName: '1.2.840.113549.1.9.20',
+  javaTrustedKeyUsage: '2.16.840.1.113894.746875.1.1',
+  anyExtendedKeyUsage: '2.5.29.37.0',
+  sha256: '2.16.840.1.101.3.4.2.1'
+};
+
+const MAC_ITERATIONS = 2048;
+
+// UTF-16BE with a trailing NUL, as PKCS#12 encodes passwords
+function bmpPassword(password) {
+  const utf16 = Buffer.from(`${password}\0`, 'utf16le');
+  return utf16.swap16();
+}
+
+/**
+ * PKCS#12 key derivation (RFC 7292 appendix B.2) for SHA-256
+ * @param {number} id - 1 = encryption key, 2 = IV, 3 = MAC key
+ */
+function deriveKey(password, salt, id, iterations, length) {
+  const u = 32; // SHA-256 output
+  const v = 64; // SHA-256 block
+  const fill = buffer => {
+    if (buffer.length === 0) return buffer;
+    const out = Buffer.alloc(v * Math.ceil(buffer.length / v));
+    for (let i = 0; i < out.length; i++) out[i] = buffer[i % buffer.length];
+    return out;
+  };
+
+  const D = Buffer.alloc(v, id);
+  const I = Buffer.concat([fill(salt), fill(bmpPassword(password))]);
+  const blocks = [];
+
+  for (let produced = 0; produced < length; produced += u) {
+    let A = Buffer.concat([D, I]);
+    for (let r = 0; r < iterations; r++) {
+      A = crypto.createHash('sha256').update(A).digest();
+    }
+    blocks.push(A);
+
+    // I_j = (I_j + B + 1) mod 2^(v*8) for each v-byte block of I
+    const B = fill(A);
+    for (let j = 0; j < I.length; j += v) {
+      let carry = 1;
+      for (let k = v - 1; k >= 0; k--) {
+        const sum = I[j + k] + B[k] + carry;
+        I[j + k] = sum & 0xff;
+        carry = sum >> 8;
+      }
+    }
+  }
+
+  return Buffer.concat(blocks).subarray(0, length);
+}
+
+/**
+ * Build a PKCS#12 file containing a single trusted certificate
+ * @param {Buffer} certDer - DER-encoded certificate
+ * @param {object} options
+ * @param {string} options.password - Integrity password (may be empty)
+ * @param {string} options.friendlyName - Alias shown by keytool and keychains
+ * @returns {Buffer}
+ */
+export function createTrustStore(certDer, { password = '', friendlyName = 'loggy-proxy-ca' } = {}) {
+  const certBag = sequence(
+    oid(OIDS.certBag),
+    explicit(0, sequence(oid(OIDS.x509Certificate), explicit(0, octetString(certDer)))),
+    set(
+      sequence(oid(OIDS.friendlyName), set(tlv(BMP_STRING, Buffer.from(friendlyName, 'utf16le').swap16()))),
+      sequence(oid(OIDS.javaTrustedKeyUsage), set(oid(OIDS.anyExtendedKeyUsage)))
+    )
+  );
+
+  const safeContents = sequence(certBag);
+  const authenticatedSafe = sequence(sequence(oid(OIDS.data), explicit(0, octetString(safeContents))));
+
+  const salt = crypto.randomBytes(16);
+  const macKey = deriveKey(password, salt, 3, MAC_ITERATIONS, 32);
+  const mac = crypto.createHmac('sha256', macKey).update(authenticatedSafe).digest();
+
+  return sequence(
+    integer([3]),
+    sequence(oid(OIDS.data), explicit(0, octetString(authenticatedSafe))),
+    sequence(
+      sequence(sequence(oid(OIDS.sha256), nullValue), octetString(mac)),
+      octetString(salt),
+      integer([MAC_ITERATIONS >> 8, MAC_ITERATIONS & 0xff])
+    )
+  );
+}
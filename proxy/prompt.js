@@ -0,0 +1,43 @@
+/**
+ * Terminal prompts for the CLI and an interactively started proxy
+ */
+
+/**
+ * Read a line from the terminal without echoing it (for passphrases)
+ * @param {string} question - Prompt written to stderr
+ * @returns {Promise<string>}
+ */
+export function promptHidden(question) {
+  const { stdin, stderr } = process;
+
+  return new Promise((resolve, reject) => {
+    let input = '';
+
+    const finish = () => {
+      stdin.setRawMode(false);
+      stdin.pause();
+      stdin.removeListener('data', onData);
+      stderr.write('\n');
+    };
+
+    const onData = chars => {
+      for (const char of chars) {
+        if (char === '\r' || char === '\n') {
+          finish();
+          return resolve(input);
+        }
+        if (char === '\u0003') { // Ctrl-C
+          finish();
+          return reject(new Error('Cancelled'));
+        }
+        input = char === '\u007f' || char === '\b' ? input.slice(0, -1) : input + char;
+      }
+    };
+
+    stderr.write(question);
+    stdin.setRawMode(true);
+    stdin.setEncoding('utf8');
+    stdin.on('data', onData);
+    stdin.resume();
+  });
+}
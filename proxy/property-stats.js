@@ -0,0 +1,125 @@
+/**
+ * Property statistics (GET /properties)
+ *
+ * For each event name in the buffer, summarizes every property path seen
+ * (nested objects are walked, so "product.sku" is its own property):
+ * - types: how often each JSON type was seen ("string", "number", "boolean",
+ *   "object", "array", "null");
+ * - nullRate: the share of the name's events where the property was null or
+ *   missing;
+ * - distinct: how many different values it took (counted up to MAX_DISTINCT);
+ * - examples: a few of its different values, in the order events are given.
+ * Properties that are always null or have more than one non-null type are
+ * flagged, as they're usually tracking bugs.
+ */
+
+// Distinct values tracked per property; past this, "distinct" is a lower bound
+const MAX_DISTINCT = 1000;
+// Longest string example kept
+const MAX_EXAMPLE_LENGTH = 200;
+
+function typeOf(value) {
+  if (value === null || value === undefined) return 'null';
+  if (Array.isArray(value)) return 'array';
+  return typeof value;
+}
+
+/**
+ * Property paths of an event's properties, with their values. Objects are
+ * walked into; arrays are values.
+ */
+function flatten(properties, prefix = '', out = []) {
+  for (const [key, value] of Object.entries(properties)) {
+    const path = prefix ? `${prefix}.${key}` : key;
+    if (value && typeof value === 'object' && !Array.isArray(value) && Object.keys(value).length > 0) {
+      flatten(value, path, out);
+    } else {
+      out.push([path, value]);
+    }
+  }
+  return out;
+}
+
+function example(value) {
+  if (typeof value === 'string' && value.length > MAX_EXAMPLE_LENGTH) {
+    return value.slice(0, MAX_EXAMPLE_LENGTH) + '…';
+  }
+  return value;
+}
+
+/**
+ * Summarize the properties of events
+ * @param {Array<object>} events - Any order
+ * @param {object} options
+ * @param {number} options.examples - Example values kept per property
+ * @returns {Array<object>} - Per source and event name: { source, event, count,
+ *   properties: [{ path, types, present, nullRate, distinct, distinctCapped, examples, flags }] },
+ *   most frequent first
+ */
+export function propertyStats(events, { examples = 5 } = {}) {
+  const names = new Map(); // "<source>\n<event>" -> { source, event, count, properties: Map(path -> stats) }
+
+  for (const event of events) {
+    if (event._parseError || event._truncated) continue;
+    const key = `${event._source}\n${event.event}`;
+    let name = names.get(key);
+    if (!name) {
+      name = { source: event._source, event: event.event, count: 0, properties: new Map() };
+      names.set(key, name);
+    }
+    name.count++;
+
+    const properties = event.properties && typeof event.properties === 'object' ? event.properties : {};
+    for (const [path, value] of flatten(properties)) {
+      let stats = name.properties.get(path);
+      if (!stats) {
+        stats = { types: {}, present: 0, nulls: 0, values: new Set(), capped: false, examples: [] };
+        name.properties.set(path, stats);
+      }
+      const type = typeOf(value);
+      stats.types[type] = (stats.types[type] || 0) + 1;
+      stats.present++;
+      if (type === 'null') {
+        stats.nulls++;
+        continue;
+      }
+
+      let serialized;
+      try {
+        serialized = JSON.stringify(value);
+      } catch {
+        continue;
+      }
+      if (stats.values.has(serialized)) continue;
+      if (stats.values.size < MAX_DISTINCT) {
+        stats.values.add(serialized);
+        if (stats.examples.length < examples) stats.examples.push(example(value));
+      } else {
+        stats.capped = true;
+      }
+    }
+  }
+
+  return [...names.values()].map(name => ({
+    source: name.source,
+    event: name.event,
+    count: name.count,
+    properties: [...name.properties].map(([path, stats]) => {
+      const nonNullTypes = Object.keys(stats.types).filter(type => type !== 'null');
+      const flags = [];
+      if (nonNullTypes.length === 0) flags.push('always_null');
+      if (nonNullTypes.length > 1) flags.push('mixed_types');
+      return {
+        path,
+        types: stats.types,
+        present: stats.present,
+        // Missing counts as null: a property only some events send is as unreliable
+        nullRate: Math.round(((name.count - stats.present + stats.nulls) / name.count) * 1000) / 1000,
+        distinct: stats.values.size,
+        distinctCapped: stats.capped,
+        examples: stats.examples,
+        flags
+      };
+    }).sort((a, b) => a.path.localeCompare(b.path))
+  })).sort((a, b) => b.count - a.count);
+}
@@ -0,0 +1,112 @@
+/**
+ * RateLimiter - Per-source token buckets for captured events
+ *
+ * A source's bucket holds up to `burst` tokens and refills at
+ * `eventsPerSecond`; each captured event takes one, and an event that finds
+ * the bucket empty is throttled (not buffered, sent to sinks or alerted on).
+ * This keeps one runaway SDK, firing the same event in a loop, from evicting
+ * every other source's events from the buffer or flooding the sinks.
+ * Limits come from rateLimits (eventsPerSecond 0 = unlimited), with
+ * per-source overrides in rateLimits.sources.
+ */
+
+export class RateLimiter {
+  /**
+   * @param {object} options - The rateLimits settings
+   * @param {function} nowMs - Returns epoch milliseconds
+   */
+  constructor(options = {}, nowMs = () => Date.now()) {
+    this.nowMs = nowMs;
+    this.buckets = new Map(); // source ID -> { tokens, updatedAt, throttled, throttling, lastThrottledAt }
+    this.configure(options);
+  }
+
+  /**
+   * Apply new settings (e.g. after a reload). Buckets keep their counts and
+   * are refilled under the new limits
+   */
+  configure({ eventsPerSecond = 0, burst = 200, sources = {} } = {}) {
+    this.eventsPerSecond = eventsPerSecond;
+    this.burst = burst;
+    this.sources = sources || {};
+  }
+
+  /**
+   * @returns {object} - { eventsPerSecond, burst } for a source (eventsPerSecond 0 = unlimited)
+   */
+  limitFor(sourceId) {
+    const override = this.sources[sourceId] || {};
+    const eventsPerSecond = override.eventsPerSecond ?? this.eventsPerSecond;
+    const burst = override.burst ?? this.burst;
+    // A burst below one event would throttle everything
+    return { eventsPerSecond, burst: Math.max(1, burst || eventsPerSecond) };
+  }
+
+  /**
+   * Take a token for one event
+   * @returns {boolean} - false if the event is throttled
+   */
+  take(sourceId) {
+    const { eventsPerSecond, burst } = this.limitFor(sourceId);
+    if (!(eventsPerSecond > 0)) return true;
+
+    const now = this.nowMs();
+    let bucket = this.buckets.get(sourceId);
+    if (!bucket) {
+      bucket = { tokens: burst, updatedAt: now, throttled: 0, throttling: false, lastThrottledAt: null };
+      this.buckets.set(sourceId, bucket);
+    }
+    bucket.tokens = Math.min(burst, bucket.tokens + (now - bucket.updatedAt) / 1000 * eventsPerSecond);
+    bucket.updatedAt = now;
+
+    if (bucket.tokens >= 1) {
+      bucket.tokens -= 1;
+      if (bucket.throttling) {
+        bucket.throttling = false;
+        console.log(`[RateLimit] ${sourceId} is back under ${eventsPerSecond} events/s`);
+      }
+      return true;
+    }
+
+    bucket.throttled++;
+    bucket.lastThrottledAt = new Date(now).toISOString();
+    if (!bucket.throttling) {
+      bucket.throttling = true;
+      console.warn(`[RateLimit] Throttling ${sourceId}: over ${eventsPerSecond} events/s (burst ${burst})`);
+    }
+    return false;
+  }
+
+  /**
+   * Limits and throttle counts per source for GET /rate-limits: every source
+   * with a limit override, and every source that has used its bucket
+   * @returns {object} - { eventsPerSecond, burst, throttled, sources: [...] }
+   */
+  getStatus() {
+    const ids = new Set([...Object.keys(this.sources), ...this.buckets.keys()]);
+    const now = this.nowMs();
+    const sources = [...ids].map(source => {
+      const limit = this.limitFor(source);
+      const bucket = this.buckets.get(source);
+      const tokens = bucket && limit.eventsPerSecond > 0
+        ? Math.min(limit.burst, bucket.tokens + (now - bucket.updatedAt) / 1000 * limit.eventsPerSecond)
+        : limit.burst;
+      return {
+        source,
+        eventsPerSecond: limit.eventsPerSecond || null,
+        burst: limit.burst,
+        tokens: Math.floor(tokens),
+        throttled: bucket ? bucket.throttled : 0,
+        throttling: bucket ? bucket.throttling : false,
+        lastThrottledAt: bucket ? bucket.lastThrottledAt : null
+      };
+    }).sort((a, b) => b.throttled - a.throttled);
+
+    return {
+      eventsPerSecond: this.eventsPerSecond || null,
+      burst: this.burst,
+      throttled: sources.reduce((total, source) => total + source.throttled, 0),
+      sources
+    };
+  }
+}
@@ -0,0 +1,90 @@
+/**
+ * RawRequestStore - The requests behind the buffered events
+ *
+ * Each request a source captured is kept as received (URL, headers and the
+ * still-compressed body) under its request ID, the _requestId stamped on its
+ * events. POST /reprocess parses them again with the current sources and
+ * parser, so a fixed field mapping applies to traffic already captured. The
+ * response the server gave it (status and headers) is kept with it once it
+ * arrives (GET /requests/<id>). Headers are stored as the caller redacted
+ * them. The store is bounded by a request count and a byte total of bodies;
+ * the oldest requests go first. maxRequests 0 keeps none.
+ */
+
+export class RawRequestStore {
+  /**
+   * @param {object} options - The reprocess settings
+   */
+  constructor(options = {}) {
+    this.requests = new Map(); // request ID -> { url, headers, body, recordedAt, response }, oldest first
+    this.bytes = 0;
+    this.configure(options);
+  }
+
+  /**
+   * Apply new limits (e.g. after a reload), dropping what no longer fits
+   */
+  configure({ maxRequests = 1000, maxBytes = 16 * 1024 * 1024 } = {}) {
+    this.maxRequests = maxRequests;
+    this.maxBytes = maxBytes;
+    this.evict();
+  }
+
+  /**
+   * Keep a request's raw form
+   * @param {string} requestId
+   * @param {object} request - { url, headers, body (Buffer, copied) }
+   */
+  record(requestId, { url, headers, body }) {
+    if (!requestId || this.maxRequests === 0 || body.length > this.maxBytes) return;
+    this.delete(requestId);
+    this.requests.set(requestId, { url, headers: { ...headers }, body: Buffer.from(body), recordedAt: Date.now(), response: null });
+    this.bytes += body.length;
+    this.evict();
+  }
+
+  /**
+   * Keep the response to a recorded request (no-op once it is gone)
+   * @param {string} requestId
+   * @param {object} response - { status, headers }
+   */
+  recordResponse(requestId, { status, headers }) {
+    const request = this.requests.get(requestId);
+    if (!request) return;
+    request.response = { status, headers: { ...headers }, receivedAt: Date.now() };
+  }
+
+  evict() {
+    for (const requestId of this.requests.keys()) {
+      if (this.requests.size <= this.maxRequests && this.bytes <= this.maxBytes) break;
+      this.delete(requestId);
+    }
+  }
+
+  get(requestId) {
+    return this.requests.get(requestId);
+  }
+
+  has(requestId) {
+    return this.requests.has(requestId);
+  }
+
+  delete(requestId) {
+    const request = this.requests.get(requestId);
+    if (!request) return;
+    this.bytes -= request.body.length;
+    this.requests.delete(requestId);
+  }
+
+  clear() {
+    this.requests.clear();
+    this.bytes = 0;
+  }
+
+  /**
+   * @returns {object} - { requests, bytes, maxRequests, maxBytes }
+   */
+  getStatus() {
+    return { requests: this.requests.size, bytes: this.bytes, maxRequests: this.maxRequests, maxBytes: this.maxBytes };
+  }
+}
@@ -0,0 +1,176 @@
+/**
+ * Redaction - Masks sensitive values in captured events before they are
+ * stored, served by the API or handed to sinks
+ *
+ * Toggles (from the `redaction` proxy setting):
+ * - emails:  replace email-looking strings anywhere in properties/context
+ * - userIds: replace userId/anonymousId with a stable short hash
+ * - credentials (on unless false): replace the values of credential headers
+ *   (Authorization, Cookie, Set-Cookie, API key headers) in captured headers,
+ *   and of token/password/apiKey-style fields anywhere in properties/context.
+ *   The body previews of parse-error and uncategorized events are text, so
+ *   token=... and "password": "..." pairs and Bearer/Basic credentials in
+ *   them are masked instead (see redactText)
+ */
+
+import crypto from 'crypto';
+
+const EMAIL_PATTERN = /[A-Z0-9._%+-]+@[A-Z0-9.-]+\.[A-Z]{2,}/gi;
+const REDACTED_EMAIL = '[redacted-email]';
+
+const REDACTED_CREDENTIAL = '[redacted]';
+
+// Request and response headers that carry credentials (lower-case)
+export const CREDENTIAL_HEADERS = [
+  'authorization',
+  'proxy-authorization',
+  'cookie',
+  'set-cookie',
+  'x-api-key',
+  'x-auth-token',
+  'x-access-token',
+  'x-csrf-token',
+  'x-xsrf-token',
+  'x-amz-security-token',
+  'x-goog-api-key'
+];
+
+// Field names (lower-cased, "_" and "-" dropped) whose values are credentials
+const CREDENTIAL_FIELDS = new Set([
+  'token',
+  'accesstoken',
+  'refreshtoken',
+  'idtoken',
+  'authtoken',
+  'password',
+  'passwd',
+  'secret',
+  'clientsecret',
+  'apikey',
+  'apisecret'
+]);
+
+// key=value, key: value and "key": "value" pairs in text; the value is a
+// JSON string or runs to the next separator
+const TEXT_PAIR = /("?)([A-Za-z0-9$_-]+)\1(\s*[:=]\s*)("(?:[^"\\]|\\.)*"?|[^&\s,;}\]]+)/g;
+const TEXT_AUTHORIZATION = /\b(Bearer|Basic)\s+[A-Za-z0-9._~+/=-]+/gi;
+
+function isCredentialField(name) {
+  return CREDENTIAL_FIELDS.has(name.toLowerCase().replace(/[-_]/g, ''));
+}
+
+/**
+ * Stable, non-reversible stand-in for an identifier
+ */
+function hashId(value) {
+  return 'redacted-' + crypto.createHash('sha256').update(String(value)).digest('hex').slice(0, 12);
+}
+
+/**
+ * Recursively replace email addresses in strings
+ */
+function redactEmails(value) {
+  if (typeof value === 'string') {
+    return value.replace(EMAIL_PATTERN, REDACTED_EMAIL);
+  }
+  if (Array.isArray(value)) {
+    return value.map(redactEmails);
+  }
+  if (value && typeof value === 'object') {
+    const result = {};
+    for (const [key, child] of Object.entries(value)) {
+      result[key] = redactEmails(child);
+    }
+    return result;
+  }
+  return value;
+}
+
+/**
+ * Recursively replace the values of credential-named fields
+ */
+function redactCredentialFields(value) {
+  if (Array.isArray(value)) {
+    return value.map(redactCredentialFields);
+  }
+  if (value && typeof value === 'object') {
+    const result = {};
+    for (const [key, child] of Object.entries(value)) {
+      result[key] = isCredentialField(key) && child !== null && child !== undefined && child !== ''
+        ? REDACTED_CREDENTIAL
+        : redactCredentialFields(child);
+    }
+    return result;
+  }
+  return value;
+}
+
+/**
+ * Text (a body preview that didn't decode) with the values of credential
+ * fields and Authorization-style credentials masked
+ * @param {string} text
+ * @returns {string}
+ */
+export function redactText(text) {
+  return text
+    .replace(TEXT_PAIR, (pair, quote, key, separator, value) => {
+      if (!isCredentialField(key)) return pair;
+      return `${quote}${key}${quote}${separator}${value.startsWith('"') ? `"${REDACTED_CREDENTIAL}"` : REDACTED_CREDENTIAL}`;
+    })
+    .replace(TEXT_AUTHORIZATION, `$1 ${REDACTED_CREDENTIAL}`);
+}
+
+/**
+ * Headers with credential values replaced (returns a new object)
+ * @param {object} headers - Header name -> value
+ */
+export function redactHeaders(headers) {
+  const result = {};
+  for (const [name, value] of Object.entries(headers)) {
+    result[name] = CREDENTIAL_HEADERS.includes(name.toLowerCase()) ? REDACTED_CREDENTIAL : value;
+  }
+  return result;
+}
+
+/**
+ * Apply the enabled redactions to an event (returns a new object)
+ * @param {object} event - Captured event
+ * @param {object} options - Redaction toggles
+ * @returns {object} - Redacted event
+ */
+export function redactEvent(event, options = {}) {
+  let result = event;
+
+  if (options.emails) {
+    result = {
+      ...result,
+      properties: redactEmails(result.properties),
+      context: redactEmails(result.context),
+      userId: redactEmails(result.userId)
+    };
+  }
+
+  if (options.userIds) {
+    result = {
+      ...result,
+      userId: result.userId ? hashId(result.userId) : result.userId,
+      anonymousId: result.anonymousId ? hashId(result.anonymousId) : result.anonymousId
+    };
+  }
+
+  if (options.credentials !== false) {
+    result = {
+      ...result,
+      properties: redactCredentialFields(result.properties),
+      context: redactCredentialFields(result.context)
+    };
+    if ((result._parseError || result._uncategorized) && result.properties && typeof result.properties.body === 'string') {
+      result = { ...result, properties: { ...result.properties, body: redactText(result.properties.body) } };
+    }
+    if (result._metadata && result._metadata.headers) {
+      result = { ...result, _metadata: { ...result._metadata, headers: redactHeaders(result._metadata.headers) } };
+    }
+  }
+
+  return result;
+}
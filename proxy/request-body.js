@@ -0,0 +1,152 @@
+/**
+ * Analytics request bodies: decompression and parsing
+ *
+ * Shared by the proxy and fixture replay (see fixtures.js), so a recorded
+ * request is read exactly the way the proxy reads a live one.
+ */
+
+import zlib from 'zlib';
+import { AnalyticsParser } from '../parsers.js';
+
+// Bytes of an unparseable body kept (decompressed) for its __parse_error event
+export const BODY_PREVIEW_BYTES = 1024;
+
+/**
+ * Decompress body if needed based on Content-Encoding
+ * @param {Buffer} bodyBuffer - Raw body bytes
+ * @param {string} encoding - Content-Encoding header
+ * @param {function} onError - Called when decompression fails (the raw bytes are used instead)
+ * @param {number} maxBytes - Largest decompressed size accepted; past it, an
+ *   error with code ERR_BUFFER_TOO_LARGE is thrown rather than using the raw bytes
+ * @returns {Buffer}
+ */
+export function inflateBody(bodyBuffer, encoding, onError = () => {}, maxBytes = AnalyticsParser.MAX_DECOMPRESSED_BYTES) {
+  if (!encoding) return bodyBuffer;
+
+  const options = { maxOutputLength: maxBytes };
+  try {
+    if (encoding === 'gzip') {
+      return zlib.gunzipSync(bodyBuffer, options);
+    } else if (encoding === 'deflate') {
+      return zlib.inflateSync(bodyBuffer, options);
+    } else if (encoding === 'br') {
+      return zlib.brotliDecompressSync(bodyBuffer, options);
+    }
+  } catch (err) {
+    if (err.code === 'ERR_BUFFER_TOO_LARGE') throw err;
+    onError(err);
+  }
+  return bodyBuffer;
+}
+
+/**
+ * The payload in a decompressed body, decoded by its Content-Type (see
+ * AnalyticsParser.decodeBody; GA4 /g/collect hits also need the request URL)
+ * @param {Buffer} bytes - Decompressed body
+ * @param {string} contentType - Content-Type header (optional)
+ * @param {string} url - Request URL (optional)
+ * @returns {object} - Throws if the body is not in a format the parser reads
+ */
+export function bodyPayload(bytes, contentType = null, url = null) {
+  const { format, data } = AnalyticsParser.decodeBody(bytes, contentType, url);
+  if (!data || typeof data !== 'object') {
+    throw new Error(`Body is ${format}, not an analytics payload (Content-Type: ${AnalyticsParser.mediaType(contentType) || 'none'})`);
+  }
+  return data;
+}
+
+/**
+ * A GET hit (GA4 /g/collect, a tracking pixel) as the body it stands for:
+ * its query string, URL-encoded, so it is parsed (and recorded, and
+ * reprocessed) like a form POST
+ * @param {string} url - Request URL
+ * @param {object} headers - Request headers
+ * @returns {object|null} - { body, headers }, or null without a query string
+ */
+export function queryRequest(url, headers) {
+  const { search } = new URL(url);
+  if (search.length <= 1) return null;
+  const { 'content-encoding': encoding, 'content-length': length, ...rest } = headers;
+  return { body: Buffer.from(search.slice(1)), headers: { ...rest, 'content-type': 'application/x-www-form-urlencoded' } };
+}
+
+/**
+ * The one event for a request no source captured (promiscuous mode): its
+ * decoded payload as properties when it has one, else the start of the body
+ * @param {object} request - { method, url, headers, body } (body as received)
+ * @param {number} maxBodyBytes - Larger bodies (once decompressed) aren't decoded
+ * @returns {object} - Event, without source metadata
+ */
+export function uncategorizedEvent({ method, url, headers, body }, maxBodyBytes) {
+  const { hostname, pathname } = new URL(url);
+  const contentType = headers['content-type'] || null;
+  const encoding = headers['content-encoding'] || null;
+
+  let properties;
+  let bytes = null;
+  try {
+    bytes = inflateBody(body, encoding, err => { throw err; }, maxBodyBytes);
+    if (bytes.length > maxBodyBytes) throw new Error(`Body is over ${maxBodyBytes} bytes`);
+    properties = bodyPayload(bytes, contentType, url);
+  } catch (err) {
+    properties = {
+      body: bytes ? bytes.subarray(0, BODY_PREVIEW_BYTES).toString('utf-8') : null,
+      error: err.message
+    };
+  }
+
+  return {
+    id: AnalyticsParser.generateId(),
+    timestamp: AnalyticsParser.now(),
+    event: `${method} ${hostname}${pathname}`,
+    properties,
+    context: { method, host: hostname, path: pathname, contentType, contentEncoding: encoding, bodyBytes: body.length },
+    userId: null,
+    type: 'request',
+    _uncategorized: true
+  };
+}
+
+/**
+ * Events in a source's request body (throws if it does not decode, with the
+ * start of the decompressed body as err.bodyPreview; see bodyPayload). A body that decompresses past maxDecompressedBytes is not
+ * parsed: it yields a single "(body too large)" event marked _truncated instead.
+ * @param {object} source - SourceConfig
+ * @param {Buffer} bodyBuffer - Raw body bytes
+ * @param {string} encoding - Content-Encoding header
+ * @param {function} onError - See inflateBody
+ * @param {object} options
+ * @param {object} options.timings - If given, decompressMs and parseMs are set on it
+ * @param {number} options.maxDecompressedBytes - See inflateBody
+ * @param {string} options.url - Request URL (see bodyPayload)
+ * @param {string} options.contentType - Content-Type header (see bodyPayload)
+ * @returns {Array<object>} - Parsed events, without source metadata
+ */
+export function parseRequestBody(source, bodyBuffer, encoding, onError, {
+  timings = null,
+  url = null,
+  contentType = null,
+  maxDecompressedBytes = AnalyticsParser.MAX_DECOMPRESSED_BYTES
+} = {}) {
+  const startedAt = performance.now();
+  let bytes;
+  try {
+    bytes = inflateBody(bodyBuffer, encoding, onError, maxDecompressedBytes);
+  } catch (err) {
+    if (err.code !== 'ERR_BUFFER_TOO_LARGE') throw err;
+    return [AnalyticsParser.truncatedEvent({ encoding, compressedBytes: bodyBuffer.length, maxDecompressedBytes })];
+  }
+  const decompressedAt = performance.now();
+  let events;
+  try {
+    events = AnalyticsParser.parsePayload(bodyPayload(bytes, contentType, url), source.fieldMappings || {});
+  } catch (err) {
+    err.bodyPreview = bytes.subarray(0, BODY_PREVIEW_BYTES).toString('utf-8');
+    throw err;
+  }
+  if (timings) {
+    timings.decompressMs = decompressedAt - startedAt;
+    timings.parseMs = performance.now() - decompressedAt;
+  }
+  return events;
+}
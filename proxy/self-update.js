@@ -0,0 +1,189 @@
+/**
+ * Updating a release install in place for `loggy-proxy self-update`
+ *
+ * A release is the zip build.sh makes (loggy-extension.zip), published on
+ * GitHub with a SHA256SUMS file and an Ed25519 signature of it
+ * (SHA256SUMS.sig), made with the key config/release-key.pem pins. A release
+ * without a valid signature is refused. The zip is checked and unpacked next to
+ * this install, then swapped in with two renames; the old install is kept
+ * beside it until the next update. The user's settings and sources files,
+ * which live in config/ without a profile, are carried over.
+ */
+
+import crypto from 'crypto';
+import { execFileSync } from 'child_process';
+import fs from 'fs';
+import path from 'path';
+import { fileURLToPath } from 'url';
+import { nativeHostManifests } from './doctor.js';
+
+const __dirname = path.dirname(fileURLToPath(import.meta.url));
+
+export const INSTALL_DIR = path.join(__dirname, '..');
+export const RELEASE_ASSET = 'loggy-extension.zip';
+export const CHECKSUMS_ASSET = 'SHA256SUMS';
+export const SIGNATURE_ASSET = 'SHA256SUMS.sig';
+
+// The public half of the key releases are signed with; build.sh refuses to
+// sign with any other
+export const RELEASE_KEY_PATH = path.join(INSTALL_DIR, 'config', 'release-key.pem');
+
+// User files a release does not ship (or ships defaults for)
+const CARRIED_OVER = ['config/proxy-settings.json', 'config/proxy-sources.json'];
+
+// The installers copy this template, naming the host script in "path"
+const NATIVE_HOST_TEMPLATE = path.join('native-host', 'com.analytics_logger.proxy.json');
+
+/**
+ * Where an update is unpacked, and where the install it replaces is kept
+ */
+export function updatePaths(installDir = INSTALL_DIR) {
+  const parent = path.dirname(installDir);
+  const name = path.basename(installDir);
+  return {
+    staging: path.join(parent, `.${name}-update`),
+    previous: path.join(parent, `.${name}-previous`)
+  };
+}
+
+export function findAsset(release, name) {
+  return (release.assets || []).find(asset => asset.name === name) || null;
+}
+
+/**
+ * @param {object} asset - From the release's assets
+ * @returns {Promise<Buffer>}
+ */
+export async function downloadAsset(asset) {
+  const response = await fetch(asset.browser_download_url, {
+    headers: { Accept: 'application/octet-stream' },
+    signal: AbortSignal.timeout(120000)
+  });
+  if (!response.ok) {
+    throw new Error(`Downloading ${asset.name} failed: HTTP ${response.status}`);
+  }
+  return Buffer.from(await response.arrayBuffer());
+}
+
+/**
+ * Check a file against its line in a SHA256SUMS file ("<hex>  <name>")
+ * @throws {Error} - If the file is not listed or its checksum differs
+ */
+export function verifyChecksum(contents, name, checksums) {
+  const line = checksums.split('\n')
+    .map(entry => entry.trim().split(/\s+\*?/))
+    .find(([, file]) => file === name);
+  if (!line) {
+    throw new Error(`${CHECKSUMS_ASSET} does not list ${name}`);
+  }
+  const actual = crypto.createHash('sha256').update(contents).digest('hex');
+  if (actual !== line[0].toLowerCase()) {
+    throw new Error(`${name} does not match its checksum (expected ${line[0]}, got ${actual})`);
+  }
+}
+
+/**
+ * Check the Ed25519 signature of a SHA256SUMS file
+ * @param {Buffer} checksums
+ * @param {Buffer} signature - Raw signature bytes, or the same base64-encoded
+ * @param {string} publicKeyPem
+ * @throws {Error} - If the signature does not verify
+ */
+export function verifySignature(checksums, signature, publicKeyPem) {
+  const raw = signature.length === 64 ? signature : Buffer.from(signature.toString('utf8').trim(), 'base64');
+  if (!crypto.verify(null, checksums, crypto.createPublicKey(publicKeyPem), raw)) {
+    throw new Error(`${SIGNATURE_ASSET} does not verify with the release key`);
+  }
+}
+
+/**
+ * Unpack a release zip into a fresh directory and check it is a release
+ * @returns {object} - The build-info.json it contains
+ */
+export function extractRelease(zipFile, dir) {
+  fs.rmSync(dir, { recursive: true, force: true });
+  fs.mkdirSync(dir, { recursive: true });
+  try {
+    execFileSync('unzip', ['-q', zipFile, '-d', dir], { stdio: ['ignore', 'ignore', 'pipe'] });
+  } catch (err) {
+    throw new Error(err.code === 'ENOENT' ? 'Unpacking the release needs unzip' : `Could not unpack ${RELEASE_ASSET}: ${err.stderr || err.message}`);
+  }
+
+  for (const file of ['package.json', 'build-info.json', NATIVE_HOST_TEMPLATE]) {
+    if (!fs.existsSync(path.join(dir, file))) {
+      throw new Error(`${RELEASE_ASSET} has no ${file}; it is not a loggy release`);
+    }
+  }
+  if (!fs.existsSync(nativeHostPath(dir))) {
+    throw new Error(`${RELEASE_ASSET} has no native host (${path.relative(dir, nativeHostPath(dir))})`);
+  }
+  return JSON.parse(fs.readFileSync(path.join(dir, 'build-info.json'), 'utf8'));
+}
+
+/**
+ * Copy the user's files from the current install into the unpacked release
+ * @returns {Array<string>} - The files copied
+ */
+export function carryOverUserFiles(installDir, staging) {
+  return CARRIED_OVER.filter(file => fs.existsSync(path.join(installDir, file))).map(file => {
+    fs.mkdirSync(path.dirname(path.join(staging, file)), { recursive: true });
+    fs.copyFileSync(path.join(installDir, file), path.join(staging, file));
+    return file;
+  });
+}
+
+/**
+ * Replace the install with the unpacked release; the old one is moved to
+ * previous. If the second rename fails, the old install is moved back.
+ */
+export function swapInstall(installDir, staging, previous) {
+  fs.rmSync(previous, { recursive: true, force: true });
+  fs.renameSync(installDir, previous);
+  try {
+    fs.renameSync(staging, installDir);
+  } catch (err) {
+    fs.renameSync(previous, installDir);
+    throw err;
+  }
+}
+
+/**
+ * The native host script of an install
+ */
+export function nativeHostPath(installDir) {
+  let name = 'proxy-host.cjs';
+  try {
+    name = path.basename(JSON.parse(fs.readFileSync(path.join(installDir, NATIVE_HOST_TEMPLATE), 'utf8')).path) || name;
+  } catch (err) {
+    // Older releases: the host has always been proxy-host.cjs
+  }
+  return path.join(installDir, 'native-host', name);
+}
+
+/**
+ * Point native host manifests that used this install at its native host
+ * again, as the installers write them, when the path they name is gone or
+ * differs (a release can move the host)
+ * @returns {Array<string>} - The manifests rewritten
+ */
+export function reinstallNativeHost(installDir, previousHostPath) {
+  const hostPath = nativeHostPath(installDir);
+  if (process.platform !== 'win32') {
+    fs.chmodSync(hostPath, 0o755);
+  }
+
+  return (nativeHostManifests() || []).filter(({ manifestPath }) => {
+    let manifest;
+    try {
+      manifest = JSON.parse(fs.readFileSync(manifestPath, 'utf8'));
+    } catch (err) {
+      return false; // Reported by doctor
+    }
+    const ours = manifest.path === previousHostPath || String(manifest.path).startsWith(installDir + path.sep);
+    if (!ours || (manifest.path === hostPath && fs.existsSync(hostPath))) {
+      return false;
+    }
+    fs.writeFileSync(manifestPath, JSON.stringify({ ...manifest, path: hostPath }, null, 2) + '\n');
+    return true;
+  }).map(({ manifestPath }) => manifestPath);
+}
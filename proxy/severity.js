@@ -0,0 +1,213 @@
+/**
+ * Severity - Classifies captured events as ok, warn or error
+ *
+ * Rules come from the `severity` proxy setting:
+ *   {
+ *     name: 'Orders carry IDs',      // Shown with the issues it finds (default: the check)
+ *     check: 'required',             // 'required', 'plan' or 'pii'
+ *     level: 'error',                // 'warn' (default) or 'error'
+ *     source: 'segment',             // Only events of this source ID or name (optional)
+ *     event: 'Order*',               // Only events whose name matches this glob (optional)
+ *     properties: ['order_id', 'revenue'] // required: nested paths that must have a value
+ *   }
+ * - required: each listed property must be present, not null and not "";
+ * - plan: the tracking plan; `events` lists the names (globs) the source
+ *   may send, and any other name is an issue;
+ * - pii: email addresses, phone numbers, card numbers (Luhn-checked) and US
+ *   SSNs in properties, context, userId or anonymousId. `types` limits the
+ *   kinds looked for. Issues name the path, never the value.
+ *
+ * Events are classified before redaction, so redacted PII is still found.
+ * An event's severity is the worst level of its issues (ok without any).
+ */
+
+import { AnalyticsParser } from '../parsers.js';
+import { AlertManager } from './alerts.js';
+
+export const SEVERITY_LEVELS = ['ok', 'warn', 'error'];
+const CHECKS = ['required', 'plan', 'pii'];
+
+// Kinds of PII the pii check looks for
+const PII_PATTERNS = {
+  email: /[A-Z0-9._%+-]+@[A-Z0-9.-]+\.[A-Z]{2,}/i,
+  phone: /(?:\+\d{10,14}\b|(?<![\w+])(?:\+\d{1,3}[\s.-]?)?\(?\d{3}\)?[\s.-]\d{3}[\s.-]\d{4}(?!\d))/,
+  card: /(?<!\d)[3-6]\d{3}(?:[ -]?\d{4}){2}[ -]?\d{3,4}(?!\d)/g,
+  ssn: /(?<!\d)\d{3}-\d{2}-\d{4}(?!\d)/
+};
+export const PII_TYPES = Object.keys(PII_PATTERNS);
+
+/**
+ * Luhn checksum, so order numbers and timestamps are rarely taken for cards
+ */
+function passesLuhn(digits) {
+  let sum = 0;
+  for (let i = 0; i < digits.length; i++) {
+    let digit = Number(digits[digits.length - 1 - i]);
+    if (i % 2 === 1) {
+      digit *= 2;
+      if (digit > 9) digit -= 9;
+    }
+    sum += digit;
+  }
+  return sum % 10 === 0;
+}
+
+function containsPii(type, value) {
+  if (type !== 'card') return PII_PATTERNS[type].test(value);
+  return [...value.matchAll(PII_PATTERNS.card)].some(([match]) => passesLuhn(match.replace(/[ -]/g, '')));
+}
+
+/**
+ * String values of an object with their paths (prefix.key, prefix.list.0)
+ */
+function stringValues(value, path, out = []) {
+  if (typeof value === 'string') {
+    out.push([path, value]);
+  } else if (value && typeof value === 'object') {
+    for (const [key, child] of Object.entries(value)) {
+      stringValues(child, `${path}.${key}`, out);
+    }
+  }
+  return out;
+}
+
+/**
+ * @returns {string|null} - What is wrong with a rule, or null if it can be used
+ */
+export function validateSeverityRule(rule) {
+  if (!rule || typeof rule !== 'object') return 'a severity rule must be an object';
+  const label = `severity rule "${rule.name || rule.check}"`;
+  if (!CHECKS.includes(rule.check)) return `${label}: check must be one of ${CHECKS.join(', ')}`;
+  if (rule.level !== undefined && !['warn', 'error'].includes(rule.level)) return `${label}: level must be "warn" or "error"`;
+  if (rule.check === 'required' && (!Array.isArray(rule.properties) || rule.properties.length === 0)) {
+    return `${label}: list the required properties`;
+  }
+  if (rule.check === 'plan' && !Array.isArray(rule.events)) return `${label}: list the planned event names in "events"`;
+  if (rule.check === 'pii' && rule.types !== undefined &&
+      (!Array.isArray(rule.types) || rule.types.some(type => !PII_TYPES.includes(type)))) {
+    return `${label}: types must be among ${PII_TYPES.join(', ')}`;
+  }
+  return null;
+}
+
+export class SeverityRules {
+  /**
+   * @param {object} config - The severity settings ({ rules })
+   * @param {function} onProblem - Called with each rule that can't be used (it is skipped)
+   */
+  constructor(config = {}, onProblem = () => {}) {
+    this.onProblem = onProblem;
+    this.configure(config);
+  }
+
+  configure({ rules = [] } = {}) {
+    this.rules = [];
+    for (const rule of rules) {
+      const problem = validateSeverityRule(rule);
+      if (problem) {
+        this.onProblem(problem);
+        continue;
+      }
+      this.rules.push({
+        ...rule,
+        name: rule.name || rule.check,
+        level: rule.level || 'warn',
+        eventRegex: rule.event ? AlertManager.globToRegex(rule.event) : null,
+        planRegexes: (rule.events || []).map(name => AlertManager.globToRegex(name))
+      });
+    }
+  }
+
+  appliesTo(rule, event) {
+    if (rule.source) {
+      const source = rule.source.toLowerCase();
+      if (String(event._source).toLowerCase() !== source && String(event._sourceName).toLowerCase() !== source) {
+        return false;
+      }
+    }
+    return !rule.eventRegex || rule.eventRegex.test(event.event || '');
+  }
+
+  /**
+   * Messages for what a rule finds wrong with an event
+   */
+  findIssues(rule, event) {
+    if (rule.check === 'required') {
+      return rule.properties
+        .filter(path => {
+          const value = AnalyticsParser.getNestedValue(event.properties || {}, path);
+          return value === undefined || value === null || value === '';
+        })
+        .map(path => `missing required property "${path}"`);
+    }
+    if (rule.check === 'plan') {
+      const name = event.event || '';
+      return rule.planRegexes.some(regex => regex.test(name)) ? [] : [`"${name}" is not in the tracking plan`];
+    }
+
+    const values = [
+      ...stringValues(event.properties, 'properties'),
+      ...stringValues(event.context, 'context'),
+      ...stringValues(event.userId, 'userId'),
+      ...stringValues(event.anonymousId, 'anonymousId')
+    ];
+    const issues = [];
+    for (const type of rule.types || PII_TYPES) {
+      for (const [path, value] of values) {
+        if (containsPii(type, value)) issues.push(`${type} in ${path}`);
+      }
+    }
+    return issues;
+  }
+
+  /**
+   * @param {object} event - A captured event (before redaction)
+   * @returns {object} - { severity: 'ok'|'warn'|'error', issues: [{ rule, level, message }] }
+   */
+  classify(event) {
+    const issues = [];
+    for (const rule of this.rules) {
+      if (!this.appliesTo(rule, event)) continue;
+      for (const message of this.findIssues(rule, event)) {
+        issues.push({ rule: rule.name, level: rule.level, message });
+      }
+    }
+    const severity = issues.some(issue => issue.level === 'error') ? 'error' : issues.length > 0 ? 'warn' : 'ok';
+    return { severity, issues };
+  }
+}
+
+/**
+ * Aggregate counts of classified events (GET /severity)
+ * @param {Array<object>} events - Events carrying _severity and _issues
+ * @returns {object} - { ok, warn, error, sources: [{ id, name, ok, warn, error }],
+ *   rules: [{ rule, level, events, issues }] }, busiest first
+ */
+export function severityCounts(events) {
+  const totals = { ok: 0, warn: 0, error: 0 };
+  const sources = new Map(); // source ID -> counts
+  const rules = new Map(); // rule name -> { rule, level, events, issues }
+  for (const event of events) {
+    const severity = SEVERITY_LEVELS.includes(event._severity) ? event._severity : 'ok';
+    totals[severity]++;
+    if (!sources.has(event._source)) {
+      sources.set(event._source, { id: event._source, name: event._sourceName, ok: 0, warn: 0, error: 0 });
+    }
+    sources.get(event._source)[severity]++;
+
+    const seen = new Set();
+    for (const issue of event._issues || []) {
+      if (!rules.has(issue.rule)) rules.set(issue.rule, { rule: issue.rule, level: issue.level, events: 0, issues: 0 });
+      const entry = rules.get(issue.rule);
+      entry.issues++;
+      if (!seen.has(issue.rule)) entry.events++;
+      seen.add(issue.rule);
+    }
+  }
+  const problems = entry => entry.error * 2 + entry.warn;
+  return {
+    ...totals,
+    sources: [...sources.values()].sort((a, b) => problems(b) - problems(a)),
+    rules: [...rules.values()].sort((a, b) => b.events - a.events)
+  };
+}
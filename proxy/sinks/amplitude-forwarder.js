@@ -0,0 +1,49 @@
+/**
+ * AmplitudeForwarder - Sends captured events to an Amplitude project
+ *
+ * Uses the HTTP V2 API with a (test) project API key so dashboards can be
+ * validated against live captures.
+ */
+
+import { BatchingSink } from './batching-sink.js';
+
+const AMPLITUDE_URL = 'https://api2.amplitude.com/2/httpapi';
+
+export class AmplitudeForwarder extends BatchingSink {
+  constructor(options = {}) {
+    super('amplitude', options);
+
+    if (!options.apiKey) {
+      throw new Error('Amplitude forwarder requires an apiKey');
+    }
+
+    this.apiKey = options.apiKey;
+    this.endpoint = options.endpoint || AMPLITUDE_URL;
+  }
+
+  /**
+   * Convert a captured event into an Amplitude event
+   */
+  toAmplitudeEvent(event) {
+    const time = Date.parse(event.timestamp);
+    return {
+      event_type: event.event,
+      user_id: event.userId ? String(event.userId) : undefined,
+      // Amplitude requires a user_id or a device_id of at least 5 characters
+      device_id: event.anonymousId ? String(event.anonymousId) : 'loggy-proxy',
+      time: isNaN(time) ? Date.now() : time,
+      insert_id: event.id,
+      event_properties: {
+        ...event.properties,
+        loggy_source: event._source
+      }
+    };
+  }
+
+  async send(batch) {
+    await this.postJSON(this.endpoint, {
+      api_key: this.apiKey,
+      events: batch.map(event => this.toAmplitudeEvent(event))
+    });
+  }
+}
@@ -0,0 +1,103 @@
+/**
+ * BatchingSink - Base class for sinks that ship events over the network
+ *
+ * Events are buffered and flushed when the batch is full or the flush
+ * interval elapses. Subclasses implement `send(batch)` and return a promise;
+ * failed batches are logged and dropped so a down endpoint can't grow memory.
+ * A slow endpoint can't either: at most maxPending events are buffered or
+ * being sent, and past that the dropPolicy decides which go ("oldest" drops
+ * the oldest buffered event, "newest" the incoming one). Every dropped event
+ * is passed to onDrop. The flush interval starts with the first write, so a
+ * subclass constructor that throws on bad options leaves nothing running.
+ */
+
+export class BatchingSink {
+  constructor(name, options = {}) {
+    this.name = name;
+    this.maxBatchSize = options.maxBatchSize || 100;
+    this.flushIntervalMs = options.flushIntervalMs || 5000;
+    this.maxPending = options.maxPending || 10000;
+    this.dropPolicy = options.dropPolicy || 'oldest';
+    this.onDrop = options.onDrop || (() => {});
+    this.buffer = [];
+    this.sending = 0; // Events in batches not yet answered
+    this.stats = { sent: 0, failed: 0, dropped: 0 };
+    this.timer = null;
+  }
+
+  write(event) {
+    if (!this.timer) {
+      this.timer = setInterval(() => this.flush(), this.flushIntervalMs);
+      this.timer.unref();
+    }
+    if (this.buffer.length + this.sending >= this.maxPending) {
+      // Only buffered events can still be dropped; sent ones are on their way
+      const dropped = this.dropPolicy === 'oldest' && this.buffer.length > 0 ? this.buffer.shift() : event;
+      this.stats.dropped++;
+      this.onDrop(dropped);
+      if (dropped === event) return;
+    }
+    this.buffer.push(event);
+    if (this.buffer.length >= this.maxBatchSize) {
+      this.flush();
+    }
+  }
+
+  /**
+   * Send everything currently buffered
+   * @returns {Promise<void>}
+   */
+  async flush() {
+    if (this.buffer.length === 0) return;
+
+    const batch = this.buffer;
+    this.buffer = [];
+    this.sending += batch.length;
+
+    try {
+      await this.send(batch);
+      this.stats.sent += batch.length;
+    } catch (err) {
+      this.stats.failed += batch.length;
+      batch.forEach(event => this.onDrop(event));
+      console.error(`[Sinks] ${this.name} dropped ${batch.length} event(s):`, err.message);
+    } finally {
+      this.sending -= batch.length;
+    }
+  }
+
+  /**
+   * Deliver a batch of events (implemented by subclasses)
+   * @param {Array<object>} batch - Captured events
+   * @returns {Promise<void>}
+   */
+  async send(batch) {
+    throw new Error('send() not implemented');
+  }
+
+  /**
+   * POST a raw body and throw on non-2xx responses
+   */
+  async post(url, body, headers = {}) {
+    const response = await fetch(url, { method: 'POST', headers, body });
+
+    if (!response.ok) {
+      const text = await response.text().catch(() => '');
+      throw new Error(`HTTP ${response.status} ${text.slice(0, 200)}`);
+    }
+    return response;
+  }
+
+  /**
+   * POST a JSON body and throw on non-2xx responses
+   */
+  postJSON(url, body, headers = {}) {
+    return this.post(url, JSON.stringify(body), { 'Content-Type': 'application/json', ...headers });
+  }
+
+  close() {
+    clearInterval(this.timer);
+    this.timer = null;
+    return this.flush();
+  }
+}
@@ -0,0 +1,45 @@
+/**
+ * BigQuerySink - Streams captured events into a BigQuery table
+ *
+ * Uses tabledata.insertAll with an OAuth access token (from settings or
+ * GOOGLE_OAUTH_ACCESS_TOKEN, e.g. `gcloud auth print-access-token`). The
+ * event ID is sent as insertId so retried batches de-duplicate.
+ */
+
+import { BatchingSink } from './batching-sink.js';
+import { DEFAULT_COLUMNS, mapRow } from './schema-mapping.js';
+
+export class BigQuerySink extends BatchingSink {
+  constructor(options = {}) {
+    super('bigquery', options);
+
+    if (!options.projectId || !options.dataset || !options.table) {
+      throw new Error('BigQuery sink requires projectId, dataset and table');
+    }
+
+    this.accessToken = options.accessToken || process.env.GOOGLE_OAUTH_ACCESS_TOKEN;
+    if (!this.accessToken) {
+      throw new Error('BigQuery sink requires accessToken or GOOGLE_OAUTH_ACCESS_TOKEN');
+    }
+
+    this.endpoint = `https://bigquery.googleapis.com/bigquery/v2/projects/${options.projectId}` +
+      `/datasets/${options.dataset}/tables/${options.table}/insertAll`;
+    this.columns = options.columns || DEFAULT_COLUMNS;
+  }
+
+  async send(batch) {
+    const response = await this.postJSON(this.endpoint, {
+      rows: batch.map(event => ({
+        insertId: event.id,
+        json: mapRow(event, this.columns)
+      }))
+    }, { Authorization: `Bearer ${this.accessToken}` });
+
+    // insertAll reports per-row failures with a 200 status
+    const result = await response.json();
+    if (result.insertErrors?.length > 0) {
+      const first = result.insertErrors[0].errors?.[0];
+      throw new Error(`${result.insertErrors.length} row(s) rejected: ${first?.message || 'unknown error'}`);
+    }
+  }
+}
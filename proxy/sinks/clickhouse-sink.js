@@ -0,0 +1,32 @@
+/**
+ * ClickHouseSink - Batches captured events into a ClickHouse table
+ *
+ * Rows are inserted through the HTTP interface using JSONEachRow, so the
+ * target table only needs columns matching the configured schema mapping.
+ */
+
+import { BatchingSink } from './batching-sink.js';
+import { DEFAULT_COLUMNS, mapRow } from './schema-mapping.js';
+
+export class ClickHouseSink extends BatchingSink {
+  constructor(options = {}) {
+    super('clickhouse', options);
+
+    if (!options.url || !options.table) {
+      throw new Error('ClickHouse sink requires url and table');
+    }
+
+    this.url = options.url.replace(/\/$/, '');
+    this.table = options.table;
+    this.columns = options.columns || DEFAULT_COLUMNS;
+    this.headers = { 'Content-Type': 'application/x-ndjson' };
+    if (options.user) this.headers['X-ClickHouse-User'] = options.user;
+    if (options.password) this.headers['X-ClickHouse-Key'] = options.password;
+  }
+
+  async send(batch) {
+    const query = `INSERT INTO ${this.table} FORMAT JSONEachRow`;
+    const body = batch.map(event => JSON.stringify(mapRow(event, this.columns))).join('\n');
+    await this.post(`${this.url}/?query=${encodeURIComponent(query)}`, body, this.headers);
+  }
+}
@@ -0,0 +1,86 @@
+/**
+ * ElasticsearchSink - Indexes captured events into Elasticsearch/OpenSearch
+ *
+ * Events are written with the _bulk API. The index name may contain `{date}`
+ * (replaced with YYYY.MM.DD of the capture) for daily indices. When
+ * `template` is set, it is installed as an index template on startup so
+ * existing Kibana/OpenSearch Dashboards index patterns line up.
+ */
+
+import { BatchingSink } from './batching-sink.js';
+
+export class ElasticsearchSink extends BatchingSink {
+  constructor(options = {}) {
+    super('elasticsearch', options);
+
+    if (!options.url) {
+      throw new Error('Elasticsearch sink requires url');
+    }
+
+    this.url = options.url.replace(/\/$/, '');
+    this.index = options.index || 'loggy-events-{date}';
+    this.headers = {};
+
+    if (options.apiKey) {
+      this.headers.Authorization = `ApiKey ${options.apiKey}`;
+    } else if (options.username) {
+      const credentials = Buffer.from(`${options.username}:${options.password || ''}`).toString('base64');
+      this.headers.Authorization = `Basic ${credentials}`;
+    }
+
+    if (options.template) {
+      this.installTemplate(options.templateName || 'loggy-events', options.template);
+    }
+  }
+
+  /**
+   * Create or update the index template
+   */
+  async installTemplate(name, template) {
+    try {
+      const response = await fetch(`${this.url}/_index_template/${name}`, {
+        method: 'PUT',
+        headers: { 'Content-Type': 'application/json', ...this.headers },
+        body: JSON.stringify(template)
+      });
+      if (!response.ok) {
+        throw new Error(`HTTP ${response.status}`);
+      }
+      console.log(`[Sinks] elasticsearch installed index template "${name}"`);
+    } catch (err) {
+      console.error('[Sinks] elasticsearch could not install index template:', err.message);
+    }
+  }
+
+  /**
+   * Resolve the index name for an event
+   */
+  indexFor(event) {
+    const captured = new Date(event._metadata?.capturedAt || Date.now());
+    const date = captured.toISOString().slice(0, 10).replace(/-/g, '.');
+    return this.index.replace('{date}', date);
+  }
+
+  async send(batch) {
+    const lines = [];
+    batch.forEach(event => {
+      lines.push(JSON.stringify({ index: { _index: this.indexFor(event), _id: event.id } }));
+      lines.push(JSON.stringify({
+        ...event,
+        '@timestamp': event._metadata?.capturedAt || event.timestamp
+      }));
+    });
+
+    const response = await this.post(`${this.url}/_bulk`, lines.join('\n') + '\n', {
+      'Content-Type': 'application/x-ndjson',
+      ...this.headers
+    });
+
+    // _bulk reports per-document failures with a 200 status
+    const result = await response.json();
+    if (result.errors) {
+      const failed = result.items.filter(item => item.index?.error);
+      throw new Error(`${failed.length} document(s) rejected: ${failed[0]?.index.error.reason || 'unknown error'}`);
+    }
+  }
+}
@@ -0,0 +1,114 @@
+/**
+ * FileSink - Appends captured events to an NDJSON file
+ *
+ * Each event is written as one JSON line. The active file is rotated when it
+ * grows past maxBytes or gets older than maxAgeHours; rotated files are named
+ * `<base>-<timestamp>.jsonl` and pruned down to maxFiles.
+ */
+
+import fs from 'fs';
+import path from 'path';
+import { resolvePath } from '../../config/proxy-settings.js';
+
+export class FileSink {
+  constructor(options = {}) {
+    this.name = 'file';
+    this.filePath = resolvePath(options.path);
+    this.maxBytes = options.maxBytes || 0;
+    this.maxAgeMs = (options.maxAgeHours || 0) * 60 * 60 * 1000;
+    this.maxFiles = options.maxFiles || 0;
+
+    this.size = 0;
+    this.openedAt = Date.now();
+
+    fs.mkdirSync(path.dirname(this.filePath), { recursive: true });
+    this.open();
+  }
+
+  /**
+   * Open (or reopen) the active file, picking up its current size and age
+   */
+  open() {
+    if (fs.existsSync(this.filePath)) {
+      const stat = fs.statSync(this.filePath);
+      this.size = stat.size;
+      this.openedAt = stat.birthtimeMs || stat.mtimeMs;
+    } else {
+      this.size = 0;
+      this.openedAt = Date.now();
+    }
+  }
+
+  /**
+   * Append an event as a single JSON line
+   * @param {object} event - Captured event
+   */
+  write(event) {
+    if (this.shouldRotate()) {
+      this.rotate();
+    }
+
+    const line = JSON.stringify(event) + '\n';
+    fs.appendFileSync(this.filePath, line);
+    this.size += Buffer.byteLength(line);
+  }
+
+  shouldRotate() {
+    if (this.size === 0) return false;
+    if (this.maxBytes > 0 && this.size >= this.maxBytes) return true;
+    if (this.maxAgeMs > 0 && Date.now() - this.openedAt >= this.maxAgeMs) return true;
+    return false;
+  }
+
+  /**
+   * Move the active file aside and start a fresh one
+   */
+  rotate() {
+    const { dir, name, ext } = path.parse(this.filePath);
+    const stamp = new Date().toISOString().replace(/[:.]/g, '-');
+    let rotatedPath = path.join(dir, `${name}-${stamp}${ext}`);
+    for (let n = 1; fs.existsSync(rotatedPath); n++) {
+      rotatedPath = path.join(dir, `${name}-${stamp}.${n}${ext}`);
+    }
+
+    try {
+      fs.renameSync(this.filePath, rotatedPath);
+      console.log(`[FileSink] Rotated ${this.filePath} -> ${path.basename(rotatedPath)}`);
+    } catch (err) {
+      console.error('[FileSink] Rotation failed:', err.message);
+    }
+
+    this.size = 0;
+    this.openedAt = Date.now();
+    this.prune();
+  }
+
+  /**
+   * Delete the oldest rotated files beyond maxFiles
+   */
+  prune() {
+    if (this.maxFiles <= 0) return;
+
+    const { dir, name, ext } = path.parse(this.filePath);
+    // Only names rotate() gives, never other files next to the sink's
+    // (events-backup.jsonl)
+    const escape = text => text.replace(/[.*+?^${}()|[\]\\]/g, '\\$&');
+    const rotatedName = new RegExp(`^${escape(name)}-\\d{4}-\\d{2}-\\d{2}T\\d{2}-\\d{2}-\\d{2}-\\d{3}Z(\\.\\d+)?${escape(ext)}$`);
+    const rotated = fs.readdirSync(dir)
+      .filter(file => rotatedName.test(file))
+      .sort(); // ISO timestamps sort chronologically
+
+    const excess = rotated.slice(0, Math.max(0, rotated.length - this.maxFiles));
+    excess.forEach(file => {
+      try {
+        fs.unlinkSync(path.join(dir, file));
+      } catch (err) {
+        console.error('[FileSink] Could not remove old file:', err.message);
+      }
+    });
+  }
+
+  close() {
+    // Writes are synchronous, nothing to flush
+  }
+}
@@ -0,0 +1,94 @@
+/**
+ * Event sinks - Durable or external destinations for captured events
+ *
+ * A sink is any object with `name`, `write(event)` and `close()`. Sinks are
+ * created from the `sinks` section of the proxy settings; a failing sink is
+ * logged and never affects capture or the other sinks. Sinks that queue
+ * events drop them by the proxy's dropPolicy (or their own) when they can't
+ * keep up, and report each one through onDrop.
+ */
+
+import { FileSink } from './file-sink.js';
+import { SegmentForwarder } from './segment-forwarder.js';
+import { AmplitudeForwarder } from './amplitude-forwarder.js';
+import { MixpanelForwarder } from './mixpanel-forwarder.js';
+import { ClickHouseSink } from './clickhouse-sink.js';
+import { BigQuerySink } from './bigquery-sink.js';
+import { ElasticsearchSink } from './elasticsearch-sink.js';
+import { MqttSink } from './mqtt-sink.js';
+
+// Settings key -> sink class
+const SINK_TYPES = {
+  file: FileSink,
+  segment: SegmentForwarder,
+  amplitude: AmplitudeForwarder,
+  mixpanel: MixpanelForwarder,
+  clickhouse: ClickHouseSink,
+  bigquery: BigQuerySink,
+  elasticsearch: ElasticsearchSink,
+  mqtt: MqttSink
+};
+
+export class SinkManager {
+  constructor(sinks = []) {
+    this.sinks = sinks;
+  }
+
+  /**
+   * Build the enabled sinks from proxy settings
+   * @param {object} settings - Proxy settings
+   * @param {function} onDrop - Called with (sink name, event) for each event a sink drops
+   * @returns {SinkManager}
+   */
+  static fromSettings(settings, onDrop = () => {}) {
+    const config = settings.sinks || {};
+    const sinks = [];
+
+    for (const [key, SinkClass] of Object.entries(SINK_TYPES)) {
+      if (!config[key]?.enabled) continue;
+      try {
+        sinks.push(new SinkClass({
+          dropPolicy: settings.dropPolicy,
+          ...config[key],
+          onDrop: event => onDrop(key, event)
+        }));
+      } catch (err) {
+        console.error(`[Sinks] Could not start ${key} sink:`, err.message);
+      }
+    }
+
+    if (sinks.length > 0) {
+      console.log('[Sinks] Enabled:', sinks.map(s => s.name).join(', '));
+    }
+
+    return new SinkManager(sinks);
+  }
+
+  /**
+   * Hand an event to every sink
+   * @param {object} event - Captured event
+   */
+  write(event) {
+    for (const sink of this.sinks) {
+      try {
+        sink.write(event);
+      } catch (err) {
+        console.error(`[Sinks] ${sink.name} write failed:`, err.message);
+      }
+    }
+  }
+
+  /**
+   * Close all sinks, waiting for network sinks to flush
+   * @returns {Promise<void>}
+   */
+  async close() {
+    await Promise.all(this.sinks.map(async sink => {
+      try {
+        await sink.close();
+      } catch (err) {
+        console.error(`[Sinks] ${sink.name} close failed:`, err.message);
+      }
+    }));
+  }
+}
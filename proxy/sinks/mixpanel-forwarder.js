@@ -0,0 +1,46 @@
+/**
+ * MixpanelForwarder - Sends captured events to a Mixpanel project
+ *
+ * Uses the /track ingestion endpoint with a (test) project token so
+ * dashboards can be validated against live captures.
+ */
+
+import { BatchingSink } from './batching-sink.js';
+
+const MIXPANEL_URL = 'https://api.mixpanel.com/track';
+
+export class MixpanelForwarder extends BatchingSink {
+  constructor(options = {}) {
+    // Mixpanel accepts at most 50 events per /track request
+    super('mixpanel', { ...options, maxBatchSize: Math.min(options.maxBatchSize || 50, 50) });
+
+    if (!options.token) {
+      throw new Error('Mixpanel forwarder requires a project token');
+    }
+
+    this.token = options.token;
+    this.endpoint = options.endpoint || MIXPANEL_URL;
+  }
+
+  /**
+   * Convert a captured event into a Mixpanel event
+   */
+  toMixpanelEvent(event) {
+    const time = Date.parse(event.timestamp);
+    return {
+      event: event.event,
+      properties: {
+        ...event.properties,
+        token: this.token,
+        time: isNaN(time) ? Date.now() : time,
+        distinct_id: event.userId || event.anonymousId || 'loggy-proxy',
+        $insert_id: event.id,
+        loggy_source: event._source
+      }
+    };
+  }
+
+  async send(batch) {
+    await this.postJSON(this.endpoint, batch.map(event => this.toMixpanelEvent(event)));
+  }
+}
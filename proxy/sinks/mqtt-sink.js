@@ -0,0 +1,239 @@
+/**
+ * MqttSink - Publishes captured events to an MQTT broker
+ *
+ * Each event is published (QoS 0) as JSON to `<topicPrefix>/<source>/<event>`,
+ * e.g. `loggy/segment/Product Viewed`, so local tools can subscribe to
+ * `loggy/#` or a single source. Implements the small subset of MQTT 3.1.1
+ * needed for publishing (CONNECT, PUBLISH, PINGREQ, DISCONNECT) to avoid
+ * pulling in a client library. Events published while the socket's buffer is
+ * full wait in the same queue as ones published while disconnected.
+ */
+
+import net from 'net';
+import tls from 'tls';
+
+const MAX_PENDING = 1000;
+const RECONNECT_DELAY_MS = 5000;
+
+export class MqttSink {
+  constructor(options = {}) {
+    this.name = 'mqtt';
+
+    const brokerUrl = new URL(options.url || 'mqtt://localhost:1883');
+    this.secure = brokerUrl.protocol === 'mqtts:';
+    this.host = brokerUrl.hostname;
+    this.port = parseInt(brokerUrl.port, 10) || (this.secure ? 8883 : 1883);
+    this.username = options.username || decodeURIComponent(brokerUrl.username) || null;
+    this.password = options.password || decodeURIComponent(brokerUrl.password) || null;
+    this.clientId = options.clientId || `loggy-proxy-${process.pid}`;
+    this.topicPrefix = (options.topicPrefix || 'loggy').replace(/\/$/, '');
+    this.retain = options.retain || false;
+    this.keepAliveSeconds = options.keepAliveSeconds || 60;
+    this.dropPolicy = options.dropPolicy || 'oldest';
+    this.onDrop = options.onDrop || (() => {});
+
+    this.socket = null;
+    this.connected = false;
+    this.draining = false; // The socket's buffer is full; wait for 'drain'
+    this.closed = false;
+    this.pending = []; // { event, packet } published once connected (and drained)
+    this.received = Buffer.alloc(0); // Bytes of a packet not fully received yet
+    this.pingTimer = null;
+
+    this.connect();
+  }
+
+  connect() {
+    const onConnect = () => {
+      this.socket.write(this.encodeConnect());
+    };
+
+    this.socket = this.secure
+      ? tls.connect(this.port, this.host, { servername: this.host }, onConnect)
+      : net.connect(this.port, this.host, onConnect);
+
+    this.received = Buffer.alloc(0);
+    this.socket.on('data', data => this.receive(data));
+    this.socket.on('drain', () => {
+      this.draining = false;
+      this.flush();
+    });
+    this.socket.on('error', err => {
+      console.error('[Sinks] mqtt connection error:', err.message);
+    });
+    this.socket.on('close', () => {
+      this.connected = false;
+      this.draining = false;
+      clearInterval(this.pingTimer);
+      if (!this.closed) {
+        setTimeout(() => this.connect(), RECONNECT_DELAY_MS).unref();
+      }
+    });
+  }
+
+  /**
+   * Split what the broker sent into packets; a packet can arrive in pieces,
+   * and one chunk can hold several
+   */
+  receive(data) {
+    this.received = Buffer.concat([this.received, data]);
+    while (this.received.length >= 2) {
+      const length = MqttSink.decodeLength(this.received, 1);
+      if (length === null) return; // Length not fully received
+      if (length === false) {
+        console.error('[Sinks] mqtt broker sent a malformed packet');
+        this.socket.destroy();
+        return;
+      }
+      const end = 1 + length.bytes + length.value;
+      if (this.received.length < end) return;
+      const packet = this.received.subarray(0, end);
+      this.received = this.received.subarray(end);
+      this.handlePacket(packet);
+    }
+  }
+
+  handlePacket(data) {
+    const type = data[0] >> 4;
+
+    // CONNACK
+    if (type === 2) {
+      const returnCode = data[3];
+      if (returnCode !== 0) {
+        console.error('[Sinks] mqtt broker refused connection, code', returnCode);
+        this.socket.destroy();
+        return;
+      }
+
+      this.connected = true;
+      console.log(`[Sinks] mqtt connected to ${this.host}:${this.port}`);
+
+      this.pingTimer = setInterval(() => {
+        this.socket.write(Buffer.from([0xc0, 0x00])); // PINGREQ
+      }, this.keepAliveSeconds * 1000 / 2);
+      this.pingTimer.unref();
+
+      this.flush();
+    }
+  }
+
+  /**
+   * Publish queued events until the socket's buffer fills up
+   */
+  flush() {
+    while (this.connected && !this.draining && this.pending.length > 0) {
+      this.draining = !this.socket.write(this.pending.shift().packet);
+    }
+  }
+
+  write(event) {
+    const topic = [
+      this.topicPrefix,
+      MqttSink.topicLevel(event._source || 'unknown'),
+      MqttSink.topicLevel(event.event || 'unknown')
+    ].join('/');
+    const packet = this.encodePublish(topic, JSON.stringify(event));
+
+    if (this.connected && !this.draining && this.pending.length === 0) {
+      this.draining = !this.socket.write(packet);
+      return;
+    }
+    // Disconnected or buffer full: queue up to MAX_PENDING, then drop by dropPolicy
+    if (this.pending.length >= MAX_PENDING) {
+      if (this.dropPolicy === 'newest') {
+        this.onDrop(event);
+        return;
+      }
+      this.onDrop(this.pending.shift().event);
+    }
+    this.pending.push({ event, packet });
+  }
+
+  close() {
+    this.closed = true;
+    clearInterval(this.pingTimer);
+    if (this.socket) {
+      if (this.connected) {
+        this.socket.write(Buffer.from([0xe0, 0x00])); // DISCONNECT
+      }
+      this.socket.end();
+    }
+  }
+
+  // ============================================
+  // MQTT 3.1.1 packet encoding
+  // ============================================
+
+  encodeConnect() {
+    let flags = 0x02; // Clean session
+    const payload = [MqttSink.encodeString(this.clientId)];
+
+    if (this.username) {
+      flags |= 0x80;
+      payload.push(MqttSink.encodeString(this.username));
+    }
+    if (this.password) {
+      flags |= 0x40;
+      payload.push(MqttSink.encodeString(this.password));
+    }
+
+    const variableHeader = Buffer.concat([
+      MqttSink.encodeString('MQTT'),
+      Buffer.from([0x04, flags, this.keepAliveSeconds >> 8, this.keepAliveSeconds & 0xff])
+    ]);
+
+    return MqttSink.encodePacket(0x10, Buffer.concat([variableHeader, ...payload]));
+  }
+
+  encodePublish(topic, message) {
+    const header = 0x30 | (this.retain ? 0x01 : 0x00);
+    return MqttSink.encodePacket(header, Buffer.concat([
+      MqttSink.encodeString(topic),
+      Buffer.from(message)
+    ]));
+  }
+
+  static encodePacket(header, body) {
+    return Buffer.concat([Buffer.from([header]), MqttSink.encodeLength(body.length), body]);
+  }
+
+  static encodeLength(length) {
+    const bytes = [];
+    do {
+      let byte = length % 128;
+      length = Math.floor(length / 128);
+      if (length > 0) byte |= 0x80;
+      bytes.push(byte);
+    } while (length > 0);
+    return Buffer.from(bytes);
+  }
+
+  /**
+   * Read a remaining length (1 to 4 bytes, 7 bits each, low bits first)
+   * @returns {object|null|false} - { value, bytes }, null if incomplete, false if malformed
+   */
+  static decodeLength(data, offset) {
+    let value = 0;
+    for (let i = 0; i < 4; i++) {
+      if (offset + i >= data.length) return null;
+      const byte = data[offset + i];
+      value += (byte & 0x7f) * 128 ** i;
+      if ((byte & 0x80) === 0) return { value, bytes: i + 1 };
+    }
+    return false;
+  }
+
+  static encodeString(value) {
+    const data = Buffer.from(value);
+    const length = Buffer.alloc(2);
+    length.writeUInt16BE(data.length, 0);
+    return Buffer.concat([length, data]);
+  }
+
+  /**
+   * Make a value safe to use as a single topic level
+   */
+  static topicLevel(value) {
+    return String(value).replace(/[/+#\u0000]/g, '_') || '_';
+  }
+}
@@ -0,0 +1,44 @@
+/**
+ * Schema mapping - Turns captured events into flat table rows
+ *
+ * A mapping is `{ column: 'event.path' }`, using the same dot/bracket paths
+ * as source field mappings. Object values are stored as JSON strings so they
+ * fit a String/JSON column.
+ */
+
+import { AnalyticsParser } from '../../parsers.js';
+
+export const DEFAULT_COLUMNS = {
+  event_id: 'id',
+  event_name: 'event',
+  event_type: 'type',
+  timestamp: 'timestamp',
+  captured_at: '_metadata.capturedAt',
+  source: '_source',
+  url: '_metadata.url',
+  user_id: 'userId',
+  anonymous_id: 'anonymousId',
+  properties: 'properties',
+  context: 'context'
+};
+
+/**
+ * Build a row for an event
+ * @param {object} event - Captured event
+ * @param {object} columns - Column -> event path mapping
+ * @returns {object} - Flat row
+ */
+export function mapRow(event, columns = DEFAULT_COLUMNS) {
+  const row = {};
+  for (const [column, path] of Object.entries(columns)) {
+    const value = AnalyticsParser.getNestedValue(event, path);
+    if (value === undefined || value === null) {
+      row[column] = null;
+    } else if (typeof value === 'object') {
+      row[column] = JSON.stringify(value);
+    } else {
+      row[column] = value;
+    }
+  }
+  return row;
+}
@@ -0,0 +1,65 @@
+/**
+ * SegmentForwarder - Replays captured events into a Segment or RudderStack source
+ *
+ * Uses the HTTP Tracking API batch endpoint with the configured write key, so
+ * production traffic seen by the proxy can be mirrored into a dev workspace.
+ * For RudderStack, point `endpoint` at `<dataPlaneUrl>/v1/batch`.
+ */
+
+import { BatchingSink } from './batching-sink.js';
+
+const SEGMENT_BATCH_URL = 'https://api.segment.io/v1/batch';
+const SEGMENT_TYPES = ['track', 'page', 'screen', 'identify', 'group', 'alias'];
+
+export class SegmentForwarder extends BatchingSink {
+  constructor(options = {}) {
+    super('segment', options);
+
+    if (!options.writeKey) {
+      throw new Error('Segment forwarder requires a writeKey');
+    }
+
+    this.endpoint = options.endpoint || SEGMENT_BATCH_URL;
+    this.authHeader = 'Basic ' + Buffer.from(`${options.writeKey}:`).toString('base64');
+  }
+
+  /**
+   * Convert a captured event into a Segment message
+   */
+  toMessage(event) {
+    const type = SEGMENT_TYPES.includes(event.type) ? event.type : 'track';
+    const message = {
+      type,
+      messageId: event.id,
+      timestamp: event.timestamp,
+      userId: event.userId || undefined,
+      anonymousId: event.anonymousId || (event.userId ? undefined : 'loggy-proxy'),
+      context: {
+        ...(typeof event.context === 'object' ? event.context : {}),
+        loggy: {
+          source: event._source,
+          url: event._metadata?.url
+        }
+      }
+    };
+
+    if (type === 'track') {
+      message.event = event.event;
+      message.properties = event.properties;
+    } else if (type === 'page' || type === 'screen') {
+      message.name = event.event;
+      message.properties = event.properties;
+    } else {
+      message.traits = event.properties;
+    }
+
+    return message;
+  }
+
+  async send(batch) {
+    await this.postJSON(this.endpoint, {
+      batch: batch.map(event => this.toMessage(event)),
+      sentAt: new Date().toISOString()
+    }, { Authorization: this.authHeader });
+  }
+}
@@ -0,0 +1,219 @@
+/**
+ * Source management for `loggy-proxy sources`
+ *
+ * User and extension sources live in the profile's sources file (the same
+ * file the native host's syncSources writes); built-in sources come from
+ * default-sources.js and can be overridden there by ID but not removed.
+ * After a change a running proxy is asked to reload them over its API. An
+ * edit raises the source's version, so the extension's next sync keeps it.
+ */
+
+import fs from 'fs';
+import path from 'path';
+import { AnalyticsParser } from '../parsers.js';
+import { ConfigManagerNode, SourceConfig, looksLikeAnalyticsEndpoint } from '../config/config-manager-node.js';
+import { DEFAULT_SOURCES } from '../config/default-sources.js';
+import { reviseSource } from '../config/source-sync.js';
+import { PROFILE_PATHS } from '../config/proxy-settings.js';
+
+// Source properties settable from the command line
+const EDITABLE = ['name', 'domain', 'urlPattern', 'aliases', 'color', 'icon', 'enabled', 'fieldMappings', 'websocket'];
+
+function readSourcesFile(sourcesPath) {
+  try {
+    return JSON.parse(fs.readFileSync(sourcesPath, 'utf8'));
+  } catch (err) {
+    return {};
+  }
+}
+
+function writeSourcesFile(sourcesPath, sources) {
+  fs.mkdirSync(path.dirname(sourcesPath), { recursive: true });
+  fs.writeFileSync(sourcesPath, JSON.stringify(sources, null, 2));
+}
+
+/**
+ * Built-in and file sources, as the proxy loads them at startup
+ * @param {object} settings - Proxy settings (for enabledSources)
+ * @param {string} sourcesPath - Sources file (default: the profile's)
+ * @returns {ConfigManagerNode}
+ */
+export function loadSources(settings, sourcesPath = PROFILE_PATHS.sourcesPath) {
+  const configManager = new ConfigManagerNode(sourcesPath);
+  const log = console.log;
+  console.log = () => {}; // ConfigManager logs its load; keep CLI output clean
+  try {
+    configManager.load();
+  } finally {
+    console.log = log;
+  }
+  configManager.setEnabledSourceIds(settings.enabledSources);
+  return configManager;
+}
+
+/**
+ * Parse "eventName=code,timestamp=client_ts" into field mappings
+ */
+export function parseFieldMappings(value) {
+  const mappings = {};
+  for (const pair of String(value).split(',').map(p => p.trim()).filter(Boolean)) {
+    const [field, ...rest] = pair.split('=');
+    if (!field || rest.length === 0 || !rest.join('=')) {
+      throw new Error(`Invalid field mapping "${pair}" (expected field=path, e.g. eventName=code)`);
+    }
+    mappings[field.trim()] = rest.join('=').trim();
+  }
+  return mappings;
+}
+
+function validateChanges(changes) {
+  if (changes.domain !== undefined) {
+    const domain = SourceConfig.extractBaseDomain(String(changes.domain).replace(/^\w+:\/\//, '').split('/')[0]);
+    if (!domain || !domain.includes('.')) {
+      throw new Error(`Invalid domain "${changes.domain}" (expected e.g. segment.io)`);
+    }
+    changes.domain = domain;
+  }
+  if (changes.urlPattern !== undefined && changes.urlPattern && !String(changes.urlPattern).startsWith('/')) {
+    throw new Error(`Invalid URL pattern "${changes.urlPattern}" (expected a path glob such as /v1/*)`);
+  }
+  if (changes.aliases !== undefined) {
+    changes.aliases = changes.aliases.map(alias => {
+      const { host, pathPattern } = SourceConfig.parseAlias(alias);
+      if (!host.includes('.') || /[^a-z0-9.-]/.test(host)) {
+        throw new Error(`Invalid alias "${alias}" (expected a host such as events.eu1.segmentapis.com, optionally with a path glob)`);
+      }
+      return pathPattern ? `${host}${pathPattern}` : host;
+    });
+  }
+  if (changes.color !== undefined && !/^#[0-9a-f]{6}$/i.test(changes.color)) {
+    throw new Error(`Invalid color "${changes.color}" (expected #RRGGBB)`);
+  }
+  return changes;
+}
+
+/**
+ * Add a user source to the sources file
+ * @param {string} id - Source ID (letters, digits, "-" and "_")
+ * @param {object} config - name, domain, urlPattern, aliases, color, icon, enabled, fieldMappings, websocket
+ * @returns {object} - The saved source (toJSON form)
+ */
+export function addSource(id, config, sourcesPath = PROFILE_PATHS.sourcesPath) {
+  if (!/^[a-z0-9][a-z0-9_-]*$/i.test(id || '')) {
+    throw new Error(`Invalid source ID "${id}" (letters, digits, "-" and "_")`);
+  }
+  if (!config.domain) {
+    throw new Error('A source needs --domain');
+  }
+
+  const sources = readSourcesFile(sourcesPath);
+  if (sources[id] || DEFAULT_SOURCES[id]) {
+    throw new Error(`Source "${id}" already exists (use "sources edit ${id}")`);
+  }
+
+  const source = new SourceConfig(id, { ...validateChanges({ ...config }), createdBy: 'user' }).toJSON();
+  sources[id] = source;
+  writeSourcesFile(sourcesPath, sources);
+  return source;
+}
+
+/**
+ * Change a source; a built-in source is overridden in the sources file
+ * @returns {object} - The saved source (toJSON form)
+ */
+export function editSource(id, changes, sourcesPath = PROFILE_PATHS.sourcesPath) {
+  const sources = readSourcesFile(sourcesPath);
+  const current = sources[id] || (DEFAULT_SOURCES[id] && new SourceConfig(id, DEFAULT_SOURCES[id]).toJSON());
+  if (!current) {
+    throw new Error(`No source "${id}"`);
+  }
+
+  const updates = validateChanges(Object.fromEntries(
+    Object.entries(changes).filter(([key, value]) => EDITABLE.includes(key) && value !== undefined)
+  ));
+  if (Object.keys(updates).length === 0) {
+    throw new Error('Nothing to change (give --name, --domain, --url-pattern, --alias, --color, --icon, --map, --websocket, --no-websocket, --enable or --disable)');
+  }
+
+  // The proxy only writes back user sources (ConfigManagerNode.save), so an
+  // override of a built-in one is marked as the user's to survive that
+  const createdBy = sources[id] ? current.createdBy : 'user';
+  const source = reviseSource(current, new SourceConfig(id, { ...current, ...updates, createdBy }).toJSON());
+  if (!source.urlPattern) delete source.urlPattern;
+  sources[id] = source;
+  writeSourcesFile(sourcesPath, sources);
+  return source;
+}
+
+/**
+ * Remove a source from the sources file. Built-in sources cannot be removed;
+ * removing an override restores the built-in definition.
+ * @returns {object} - { removed, restoredDefault }
+ */
+export function removeSource(id, sourcesPath = PROFILE_PATHS.sourcesPath) {
+  const sources = readSourcesFile(sourcesPath);
+  if (!sources[id]) {
+    if (DEFAULT_SOURCES[id]) {
+      throw new Error(`"${id}" is a built-in source; disable it instead ("sources edit ${id} --disable")`);
+    }
+    throw new Error(`No source "${id}"`);
+  }
+
+  delete sources[id];
+  writeSourcesFile(sourcesPath, sources);
+  return { removed: id, restoredDefault: !!DEFAULT_SOURCES[id] };
+}
+
+/**
+ * Which source a request URL would be captured by, and the events its body
+ * would produce
+ * @param {ConfigManagerNode} configManager - From loadSources
+ * @param {string} url - Full request URL
+ * @param {string} body - Request body (JSON), optional
+ * @returns {object} - { url, source, candidates, looksLikeAnalytics, events, parseError }
+ */
+export function testSource(configManager, url, body = null) {
+  try {
+    new URL(url);
+  } catch (err) {
+    throw new Error(`Invalid URL "${url}" (include the scheme, e.g. https://api.segment.io/v1/t)`);
+  }
+
+  const source = configManager.findSourceForUrl(url);
+  const domain = SourceConfig.extractBaseDomainFromUrl(url);
+
+  // Sources for this domain that did not match, and why
+  const hostname = new URL(url).hostname;
+  const candidates = configManager.getAllSources()
+    .filter(s => s !== source && s.matchesHost(hostname))
+    .map(s => {
+      let reason = `"${source && source.id}" matched first`;
+      if (!s.enabled) reason = 'disabled';
+      else if (configManager.enabledSourceIds && !configManager.enabledSourceIds.includes(s.id)) reason = 'not in enabledSources';
+      else if (!s.matches(url)) reason = `URL path does not match its urlPattern (${s.urlPattern})`;
+      return { id: s.id, name: s.name, urlPattern: s.urlPattern, reason };
+    });
+
+  const result = {
+    url,
+    domain,
+    source: source ? source.toJSON() : null,
+    candidates,
+    looksLikeAnalytics: looksLikeAnalyticsEndpoint(url),
+    events: null,
+    parseError: null
+  };
+
+  if (body !== null) {
+    try {
+      const events = AnalyticsParser.parsePayload(JSON.parse(body), source ? source.fieldMappings || {} : {});
+      result.events = events.map(event => (source
+        ? { ...event, _source: source.id, _sourceName: source.name, _sourceColor: source.color }
+        : event));
+    } catch (err) {
+      result.parseError = err.message;
+    }
+  }
+
+  return result;
+}
@@ -0,0 +1,108 @@
+/**
+ * StageTimings - How long each stage of handling an intercepted request takes
+ *
+ * Stages: "tls_handshake" (client TLS handshake with the proxy),
+ * "certificate" (signing a leaf certificate for a host not in the cache),
+ * "body_read" (receiving a matched request's body), "parse_queue_wait"
+ * (waiting for a parse worker), "decompress" and "parse" (JSON and event
+ * extraction). Each is a histogram, served by GET /metrics in Prometheus
+ * format and summarized in GET /stats, so a slow page can be traced to a
+ * stage.
+ */
+
+export const STAGES = ['tls_handshake', 'certificate', 'body_read', 'parse_queue_wait', 'decompress', 'parse'];
+
+// Histogram bucket upper bounds, in seconds
+const BUCKETS = [0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5];
+
+export class StageTimings {
+  constructor() {
+    this.stages = new Map(STAGES.map(stage => [stage, {
+      buckets: new Array(BUCKETS.length).fill(0),
+      count: 0,
+      sumMs: 0,
+      maxMs: 0
+    }]));
+  }
+
+  /**
+   * @param {string} stage - One of STAGES
+   * @param {number} ms - Duration in milliseconds
+   */
+  observe(stage, ms) {
+    const entry = this.stages.get(stage);
+    if (!entry) return;
+    const seconds = ms / 1000;
+    const bucket = BUCKETS.findIndex(bound => seconds <= bound);
+    if (bucket !== -1) entry.buckets[bucket]++;
+    entry.count++;
+    entry.sumMs += ms;
+    entry.maxMs = Math.max(entry.maxMs, ms);
+  }
+
+  /**
+   * Start timing a stage
+   * @returns {function} - Call it when the stage is done
+   */
+  start(stage) {
+    const startedAt = performance.now();
+    return () => this.observe(stage, performance.now() - startedAt);
+  }
+
+  /**
+   * @returns {object} - stage -> { count, avgMs, maxMs }
+   */
+  summary() {
+    return Object.fromEntries([...this.stages].map(([stage, entry]) => [stage, {
+      count: entry.count,
+      avgMs: entry.count ? Math.round(entry.sumMs / entry.count * 100) / 100 : 0,
+      maxMs: Math.round(entry.maxMs * 100) / 100
+    }]));
+  }
+
+  /**
+   * The loggy_stage_duration_seconds histogram, as Prometheus text lines
+   */
+  prometheusLines() {
+    const lines = [
+      '# HELP loggy_stage_duration_seconds Time spent in each stage of handling intercepted requests',
+      '# TYPE loggy_stage_duration_seconds histogram'
+    ];
+    for (const [stage, entry] of this.stages) {
+      let cumulative = 0;
+      BUCKETS.forEach((bound, i) => {
+        cumulative += entry.buckets[i];
+        lines.push(`loggy_stage_duration_seconds_bucket{stage="${stage}",le="${bound}"} ${cumulative}`);
+      });
+      lines.push(`loggy_stage_duration_seconds_bucket{stage="${stage}",le="+Inf"} ${entry.count}`);
+      lines.push(`loggy_stage_duration_seconds_sum{stage="${stage}"} ${entry.sumMs / 1000}`);
+      lines.push(`loggy_stage_duration_seconds_count{stage="${stage}"} ${entry.count}`);
+    }
+    return lines;
+  }
+
+  /**
+   * Time TLS handshakes on the proxy's per-host HTTPS servers (wraps the
+   * same http-mitm-proxy internal as PinningDetector.install)
+   */
+  installHandshakeTiming(proxy) {
+    if (typeof proxy._createHttpsServer !== 'function') return;
+
+    const createHttpsServer = proxy._createHttpsServer.bind(proxy);
+    proxy._createHttpsServer = (options, callback) => createHttpsServer(options, (port, httpsServer, wssServer) => {
+      const started = new Map(); // client port -> time the connection arrived
+      httpsServer.on('connection', rawSocket => {
+        const clientPort = rawSocket.remotePort;
+        started.set(clientPort, performance.now());
+        rawSocket.once('close', () => started.delete(clientPort));
+      });
+      httpsServer.on('secureConnection', socket => {
+        const startedAt = started.get(socket.remotePort);
+        if (startedAt === undefined) return;
+        started.delete(socket.remotePort);
+        this.observe('tls_handshake', performance.now() - startedAt);
+      });
+      return callback(port, httpsServer, wssServer);
+    });
+  }
+}
@@ -0,0 +1,46 @@
+/**
+ * Capture timestamps (_metadata.capturedAt)
+ *
+ * RFC 3339 strings with millisecond or microsecond fractions, so events
+ * captured in quick succession still sort by capture time. Microseconds come
+ * from the high-resolution clock (performance.timeOrigin + performance.now()),
+ * which Date.now() can't give. "utc" writes a Z suffix as toISOString does;
+ * "local" writes the local time with its UTC offset (+02:00).
+ */
+
+export const TIMESTAMP_PRECISIONS = ['ms', 'us'];
+export const TIMESTAMP_ZONES = ['utc', 'local'];
+
+function pad(value, length = 2) {
+  return String(value).padStart(length, '0');
+}
+
+/**
+ * Current time in epoch milliseconds, with a sub-millisecond fraction
+ */
+export function preciseNow() {
+  return performance.timeOrigin + performance.now();
+}
+
+/**
+ * @param {number} epochMs - Epoch milliseconds (fraction kept for "us")
+ * @param {object} options
+ * @param {string} options.precision - "ms" or "us"
+ * @param {string} options.timeZone - "utc" or "local"
+ * @returns {string}
+ */
+export function formatTimestamp(epochMs, { precision = 'ms', timeZone = 'utc' } = {}) {
+  const micros = Math.floor(epochMs * 1000);
+  const date = new Date(Math.floor(micros / 1000));
+  const fraction = precision === 'us' ? pad(((micros % 1000000) + 1000000) % 1000000, 6) : pad(date.getUTCMilliseconds(), 3);
+
+  if (timeZone !== 'local') {
+    return `${date.toISOString().slice(0, 19)}.${fraction}Z`;
+  }
+
+  const offset = -date.getTimezoneOffset();
+  const sign = offset < 0 ? '-' : '+';
+  return `${date.getFullYear()}-${pad(date.getMonth() + 1)}-${pad(date.getDate())}` +
+    `T${pad(date.getHours())}:${pad(date.getMinutes())}:${pad(date.getSeconds())}.${fraction}` +
+    `${sign}${pad(Math.floor(Math.abs(offset) / 60))}:${pad(Math.abs(offset) % 60)}`;
+}
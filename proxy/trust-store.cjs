@@ -0,0 +1,212 @@
+/**
+ * OS trust store access for the proxy CA, shared by the native host and CLI
+ *
+ * Chrome trusts roots from the login keychain on macOS and from the NSS
+ * database on Linux; other platforms report trust as unknown.
+ */
+
+const { execFile } = require('child_process');
+const crypto = require('crypto');
+const fs = require('fs');
+const os = require('os');
+const path = require('path');
+const profiles = require('../config/profile.cjs');
+
+// CA generated by the proxy on first start: http-mitm-proxy's RSA CA, or the
+// P-256 CA when certificates.keyType is "ecdsa" (see certificate-authority.js).
+// certificates.dir / LOGGY_CERT_DIR moves them to <dir>/rsa and <dir>/ecdsa.
+const RSA_CA_DIR = path.join(os.homedir(), '.http-mitm-proxy');
+
+const NSS_DB = 'sql:' + path.join(os.homedir(), '.pki', 'nssdb');
+const LOGIN_KEYCHAIN = path.join(os.homedir(), 'Library', 'Keychains', 'login.keychain-db');
+const SYSTEM_KEYCHAIN = '/Library/Keychains/System.keychain';
+
+/**
+ * Run a command without a shell; always resolves with exit code and output
+ */
+function runCommand(file, args) {
+  return new Promise(resolve => {
+    execFile(file, args, { timeout: 30000 }, (err, stdout, stderr) => {
+      resolve({
+        code: err ? (typeof err.code === 'number' ? err.code : 1) : 0,
+        stdout: String(stdout || ''),
+        stderr: String(stderr || (err && typeof err.code !== 'number' ? err.message : ''))
+      });
+    });
+  });
+}
+
+/**
+ * Run a `security` command as root: sudo from a terminal, otherwise the
+ * macOS administrator password dialog (e.g. from the native host)
+ */
+function runSecurityAsAdmin(args) {
+  if (process.stdin.isTTY || process.stderr.isTTY) {
+    return runCommand('sudo', ['security', ...args]);
+  }
+  const quoted = args.map(arg => `quoted form of "${arg.replace(/\\/g, '\\\\').replace(/"/g, '\\"')}"`).join(' & " " & ');
+  return runCommand('osascript', ['-e', `do shell script "security " & ${quoted} with administrator privileges`]);
+}
+
+/**
+ * The CA for the configured key type, and its NSS nickname
+ * @param {object} settings - Proxy settings (only `certificates` is read)
+ */
+function getCaCert(settings = {}) {
+  const certificates = settings.certificates || {};
+  const keyType = certificates.keyType === 'ecdsa' ? 'ecdsa' : 'rsa';
+  // Read at call time: the native host switches profiles per message
+  const { caProfile, caHome, certDir: profileCertDir } = profiles.profilePaths();
+  const certDir = process.env.LOGGY_CERT_DIR || certificates.dir || profileCertDir;
+  const suffix = caProfile ? ` - ${caProfile}` : '';
+  return keyType === 'ecdsa'
+    ? { keyType, certPath: path.join(certDir ? path.join(certDir, 'ecdsa') : path.join(caHome, 'ca'), 'ca.pem'), nickname: `Loggy Proxy CA (ECDSA)${suffix}` }
+    : { keyType, certPath: path.join(certDir ? path.join(certDir, 'rsa') : RSA_CA_DIR, 'certs', 'ca.pem'), nickname: `Loggy Proxy CA${suffix}` };
+}
+
+/**
+ * Identity and validity of a certificate file
+ * @returns {object|null} - null when the file is missing or unreadable
+ */
+function describeCert(certPath) {
+  let cert;
+  try {
+    cert = new crypto.X509Certificate(fs.readFileSync(certPath));
+  } catch (err) {
+    return null;
+  }
+
+  const { asymmetricKeyType, asymmetricKeyDetails = {} } = cert.publicKey;
+  const validTo = new Date(cert.validTo);
+
+  return {
+    subject: cert.subject.replace(/\n/g, ', '),
+    issuer: cert.issuer.replace(/\n/g, ', '),
+    serialNumber: cert.serialNumber,
+    fingerprint: cert.fingerprint256,
+    fingerprintSha1: cert.fingerprint,
+    keyAlgorithm: asymmetricKeyType === 'ec'
+      ? `ECDSA ${asymmetricKeyDetails.namedCurve === 'prime256v1' ? 'P-256' : asymmetricKeyDetails.namedCurve}`
+      : `${String(asymmetricKeyType).toUpperCase()} ${asymmetricKeyDetails.modulusLength || ''}`.trim(),
+    isCA: cert.ca,
+    validFrom: new Date(cert.validFrom).toISOString(),
+    validTo: validTo.toISOString(),
+    daysRemaining: Math.floor((validTo.getTime() - Date.now()) / (24 * 60 * 60 * 1000)),
+    expired: validTo.getTime() <= Date.now()
+  };
+}
+
+/**
+ * Whether the CA exists and is trusted by the OS store Chrome uses.
+ * `present` tells a CA that was deleted from the store (null when unknown)
+ * from one that is still there with its trust settings removed
+ */
+async function getTrustStatus({ keyType, certPath, nickname }) {
+  const generated = fs.existsSync(certPath);
+  if (!generated) {
+    return { certPath, keyType, generated: false, trusted: false, present: false };
+  }
+
+  if (process.platform === 'darwin') {
+    const result = await runCommand('security', ['verify-cert', '-c', certPath]);
+    const trusted = result.code === 0;
+    let present = trusted || null;
+    if (!trusted) {
+      // Any keychain in the search list holding the same certificate
+      const cert = describeCert(certPath);
+      const found = await runCommand('security', ['find-certificate', '-a', '-Z']);
+      if (cert && found.code === 0) present = found.stdout.includes(cert.fingerprintSha1.replace(/:/g, ''));
+    }
+    return { certPath, keyType, generated: true, trusted, present, details: (result.stdout + result.stderr).trim() };
+  }
+  if (process.platform === 'linux') {
+    // One "<nickname>  <ssl>,<email>,<code signing>" line per certificate;
+    // C or T in the SSL flags makes it a trusted root for HTTPS
+    const result = await runCommand('certutil', ['-d', NSS_DB, '-L']);
+    if (result.code !== 0) {
+      return { certPath, keyType, generated: true, trusted: false, present: null, details: (result.stdout + result.stderr).trim() };
+    }
+    const line = result.stdout.split('\n').find(candidate => {
+      const match = /^(.*?)\s+(\S*,\S*,\S*)\s*$/.exec(candidate);
+      return match && match[1] === nickname;
+    });
+    const sslFlags = line ? line.trim().split(/\s+/).pop().split(',')[0] : '';
+    return {
+      certPath,
+      keyType,
+      generated: true,
+      trusted: /[CT]/.test(sslFlags),
+      present: Boolean(line),
+      details: line ? line.trim() : `"${nickname}" is not in ${NSS_DB}`
+    };
+  }
+  return { certPath, keyType, generated: true, trusted: null, present: null, details: `Trust check not supported on ${process.platform}` };
+}
+
+/**
+ * Install the CA as a trusted root
+ * @param {object} ca - From getCaCert
+ * @param {object} options
+ * @param {boolean} options.system - macOS: use the System keychain (all users; needs admin rights)
+ * @returns {Promise<object>} - { supported, code, output }
+ */
+async function addTrust({ certPath, nickname }, { system = false } = {}) {
+  if (system && process.platform !== 'darwin') {
+    return { supported: false, code: 1, output: `System keychain trust is only supported on macOS, not ${process.platform}` };
+  }
+
+  let result;
+  if (process.platform === 'darwin' && system) {
+    result = await runSecurityAsAdmin(['add-trusted-cert', '-d', '-r', 'trustRoot', '-k', SYSTEM_KEYCHAIN, certPath]);
+  } else if (process.platform === 'darwin') {
+    result = await runCommand('security', ['add-trusted-cert', '-d', '-r', 'trustRoot', '-k', LOGIN_KEYCHAIN, certPath]);
+  } else if (process.platform === 'linux') {
+    result = await runCommand('certutil', ['-d', NSS_DB, '-A', '-t', 'C,,', '-n', nickname, '-i', certPath]);
+  } else {
+    return { supported: false, code: 1, output: `Automatic trust is not supported on ${process.platform}` };
+  }
+  return { supported: true, code: result.code, output: (result.stdout + result.stderr).trim() };
+}
+
+/**
+ * Remove the CA's trust settings and delete it from the store
+ * @param {object} ca - From getCaCert
+ * @param {object} options
+ * @param {boolean} options.system - macOS: remove it from the System keychain
+ * @returns {Promise<object>} - { supported, code, output }
+ */
+async function removeTrust({ certPath, nickname }, { system = false } = {}) {
+  if (system && process.platform !== 'darwin') {
+    return { supported: false, code: 1, output: `System keychain trust is only supported on macOS, not ${process.platform}` };
+  }
+
+  if (process.platform === 'darwin') {
+    if (!fs.existsSync(certPath)) {
+      return { supported: true, code: 1, output: `${certPath} not found` };
+    }
+    const sha1 = new crypto.X509Certificate(fs.readFileSync(certPath)).fingerprint.replace(/:/g, '');
+    const run = system ? runSecurityAsAdmin : args => runCommand('security', args);
+    const untrusted = await run(['remove-trusted-cert', '-d', certPath]);
+    const deleted = await run(['delete-certificate', '-Z', sha1, system ? SYSTEM_KEYCHAIN : LOGIN_KEYCHAIN]);
+    return {
+      supported: true,
+      code: deleted.code,
+      output: [untrusted, deleted].map(r => (r.stdout + r.stderr).trim()).filter(Boolean).join('\n')
+    };
+  }
+  if (process.platform === 'linux') {
+    const result = await runCommand('certutil', ['-d', NSS_DB, '-D', '-n', nickname]);
+    return { supported: true, code: result.code, output: (result.stdout + result.stderr).trim() };
+  }
+  return { supported: false, code: 1, output: `Automatic untrust is not supported on ${process.platform}` };
+}
+
+module.exports = {
+  RSA_CA_DIR,
+  runCommand,
+  getCaCert,
+  describeCert,
+  getTrustStatus,
+  addTrust,
+  removeTrust
+};
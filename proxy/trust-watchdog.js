@@ -0,0 +1,140 @@
+/**
+ * TrustWatchdog - Notice when the proxy CA stops being trusted
+ *
+ * MDM tools and keychain clean-ups sometimes remove the CA's trust settings,
+ * or the CA itself, while the proxy is running; every intercepted HTTPS
+ * request then fails in the browser. The watchdog re-checks trust every
+ * `certificates.trustWatchdog.intervalMinutes`, logs when it is lost, and
+ * reports the result at GET /healthz. `retrust()` installs it again (once
+ * automatically with `autoRetrust`; on macOS that shows a password dialog).
+ */
+
+import { createRequire } from 'module';
+
+const require = createRequire(import.meta.url);
+const trustStore = require('./trust-store.cjs');
+
+export class TrustWatchdog {
+  /**
+   * @param {object} settings - Proxy settings selecting the CA the proxy signs with
+   * @param {object} options - certificates.trustWatchdog settings
+   * @param {number} options.intervalMinutes - How often to check (0 = only at startup and on demand)
+   * @param {boolean} options.autoRetrust - Re-trust once each time trust is lost
+   */
+  constructor(settings, options = {}) {
+    this.ca = trustStore.getCaCert(settings);
+    this.timer = null;
+    this.checking = null;
+    this.status = {
+      trusted: null,        // null = not checked yet, or not checkable on this platform
+      present: null,        // false once the CA was deleted from the store, not just distrusted
+      checkedAt: null,
+      lastTrustedAt: null,
+      lostAt: null,         // When a check first found it untrusted
+      details: '',
+      retrustedAt: null,
+      retrustOutput: null
+    };
+    this.configure(options);
+  }
+
+  /**
+   * Apply new settings (e.g. after a reload) and restart the timer
+   */
+  configure({ intervalMinutes = 10, autoRetrust = false } = {}) {
+    this.intervalMinutes = intervalMinutes;
+    this.autoRetrust = autoRetrust;
+    if (this.timer) {
+      this.stop();
+      this.schedule();
+    }
+  }
+
+  start() {
+    this.check();
+    this.schedule();
+  }
+
+  schedule() {
+    if (this.intervalMinutes > 0) {
+      this.timer = setInterval(() => this.check(), this.intervalMinutes * 60 * 1000);
+      this.timer.unref();
+    }
+  }
+
+  stop() {
+    clearInterval(this.timer);
+    this.timer = null;
+  }
+
+  /**
+   * Check trust now (concurrent callers share one check)
+   * @returns {Promise<object>} - getStatus()
+   */
+  check() {
+    if (!this.checking) {
+      this.checking = this.runCheck().finally(() => {
+        this.checking = null;
+      });
+    }
+    return this.checking;
+  }
+
+  async runCheck() {
+    const result = await trustStore.getTrustStatus(this.ca);
+    const now = new Date().toISOString();
+    const wasTrusted = this.status.trusted;
+
+    this.status.checkedAt = now;
+    this.status.details = result.details || '';
+    this.status.trusted = result.generated ? result.trusted : false;
+    this.status.present = result.present ?? null;
+
+    if (this.status.trusted) {
+      this.status.lastTrustedAt = now;
+      this.status.lostAt = null;
+    } else if (this.status.trusted === false && !this.status.lostAt) {
+      this.status.lostAt = now;
+      const removed = this.status.present === false;
+      console.warn(wasTrusted
+        ? `[Trust] CA ${this.ca.certPath} ${removed ? 'was removed from the trust store' : 'is no longer trusted'}; HTTPS interception will fail until it is re-trusted`
+        : `[Trust] CA ${this.ca.certPath} ${removed ? 'is not in the trust store' : 'is not trusted'}; HTTPS interception will fail until it is trusted`);
+      // Only re-trust trust that went missing, never the first install
+      if (wasTrusted && this.autoRetrust) {
+        await this.retrust();
+      }
+    }
+    return this.getStatus();
+  }
+
+  /**
+   * Install the CA as a trusted root again, then re-check
+   * @returns {Promise<object>} - getStatus() plus { supported, output }
+   */
+  async retrust() {
+    const result = await trustStore.addTrust(this.ca);
+    this.status.retrustedAt = new Date().toISOString();
+    this.status.retrustOutput = result.output;
+    if (result.supported && result.code === 0) {
+      console.log(`[Trust] Re-trusted CA ${this.ca.certPath}`);
+    } else {
+      console.warn(`[Trust] Could not re-trust CA ${this.ca.certPath}: ${result.output}`);
+    }
+
+    // Marked lost so a failed re-trust isn't retried by the check below; called
+    // from runCheck itself, so don't wait on the shared check promise
+    this.status.lostAt = this.status.lostAt || this.status.retrustedAt;
+    await this.runCheck();
+    return { ...this.getStatus(), supported: result.supported, output: result.output };
+  }
+
+  getStatus() {
+    return {
+      certPath: this.ca.certPath,
+      keyType: this.ca.keyType,
+      intervalMinutes: this.intervalMinutes,
+      autoRetrust: this.autoRetrust,
+      ...this.status
+    };
+  }
+}
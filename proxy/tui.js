@@ -0,0 +1,403 @@
+/**
+ * EventBrowser - Full-screen terminal event browser (`loggy-proxy ui`)
+ *
+ * A terminal version of the extension panel, built on readline keypress
+ * events and ANSI escapes so it needs no extra dependencies. The event list
+ * is on the left and the selected event's JSON on the right (below the list
+ * on narrow terminals). Events are polled from the proxy API like `tail`.
+ */
+
+import { spawnSync } from 'child_process';
+import readline from 'readline';
+import { badgeStyle, eventTime, summarizeProperties } from './event-format.js';
+
+const CSI = '\x1b[';
+const RESET = `${CSI}0m`;
+const DIM = `${CSI}2m`;
+const BOLD = `${CSI}1m`;
+const REVERSE = `${CSI}7m`;
+
+const HELP = '↑↓ move  PgUp/PgDn page  / search  1-9 toggle source  0 all sources  tab focus detail  c copy JSON  f follow  q quit';
+
+// Events fetched per poll (newest first)
+const PAGE_SIZE = 500;
+const MESSAGE_MS = 3000;
+
+/**
+ * Copy text with the platform clipboard tool, falling back to the OSC 52
+ * escape (understood by most terminals, including over SSH)
+ * @returns {string} - What was used
+ */
+export function copyToClipboard(text) {
+  const tools = {
+    darwin: [['pbcopy', []]],
+    win32: [['clip', []]]
+  }[process.platform] || [['wl-copy', []], ['xclip', ['-selection', 'clipboard']], ['xsel', ['--clipboard', '--input']]];
+
+  for (const [command, args] of tools) {
+    const result = spawnSync(command, args, { input: text });
+    if (!result.error && result.status === 0) return command;
+  }
+  process.stdout.write(`\x1b]52;c;${Buffer.from(text).toString('base64')}\x07`);
+  return 'terminal (OSC 52)';
+}
+
+/**
+ * Fit styled segments into exactly `width` columns
+ * @param {Array<[string, string]>} segments - [text, ANSI style] pairs
+ */
+function fitSegments(segments, width, lineStyle = '') {
+  let remaining = width;
+  let line = lineStyle;
+  for (const [text, style = ''] of segments) {
+    if (remaining <= 0) break;
+    const part = text.length > remaining ? `${text.slice(0, Math.max(0, remaining - 1))}…` : text;
+    line += `${style}${part}${style ? RESET + lineStyle : ''}`;
+    remaining -= part.length;
+  }
+  return `${line}${' '.repeat(Math.max(0, remaining))}${RESET}`;
+}
+
+export class EventBrowser {
+  /**
+   * @param {ProxyApiClient} client - Proxy API client
+   * @param {object} options
+   * @param {number} options.pollMs - How often to fetch new events
+   * @param {number} options.maxEvents - Events kept in memory (oldest dropped)
+   * @param {string} options.title - Header text
+   */
+  constructor(client, { pollMs = 1000, maxEvents = 1000, title = 'loggy-proxy' } = {}) {
+    this.client = client;
+    this.pollMs = pollMs;
+    this.maxEvents = maxEvents;
+    this.title = title;
+
+    this.events = [];           // Newest first
+    this.lastId = null;
+    this.sources = new Map();   // source ID -> name, numbered by first appearance
+    this.hiddenSources = new Set();
+
+    this.selectedId = null;
+    this.follow = true;         // Keep the newest event selected as events arrive
+    this.focus = 'list';        // 'list' or 'detail'
+    this.listOffset = 0;
+    this.detailOffset = 0;
+
+    this.query = '';
+    this.searching = false;
+    this.queryBeforeSearch = '';
+
+    this.connected = null;
+    this.message = '';          // Shown on the status line for MESSAGE_MS
+    this.messageAt = 0;
+    this.timer = null;
+    this.done = null;
+  }
+
+  /**
+   * Take over the terminal until the user quits
+   * @returns {Promise<void>}
+   */
+  run() {
+    const { stdin, stdout } = process;
+    readline.emitKeypressEvents(stdin);
+    stdin.setRawMode(true);
+    stdin.resume();
+    stdout.write(`${CSI}?1049h${CSI}?25l`); // Alternate screen, hide cursor
+
+    this.onKeypress = (str, key) => this.handleKey(str, key || {});
+    this.onResize = () => this.render();
+    this.onExit = () => this.restoreTerminal();
+    stdin.on('keypress', this.onKeypress);
+    stdout.on('resize', this.onResize);
+    // Also give the terminal back if the process exits underneath us
+    process.on('exit', this.onExit);
+
+    const finished = new Promise(resolve => {
+      this.done = resolve;
+    });
+    this.render();
+    this.poll();
+    return finished;
+  }
+
+  quit() {
+    clearTimeout(this.timer);
+    process.stdin.off('keypress', this.onKeypress);
+    process.stdout.off('resize', this.onResize);
+    process.off('exit', this.onExit);
+    this.restoreTerminal();
+    this.done();
+  }
+
+  restoreTerminal() {
+    process.stdin.setRawMode(false);
+    process.stdin.pause();
+    process.stdout.write(`${CSI}?25h${CSI}?1049l`); // Show cursor, leave alternate screen
+  }
+
+  async poll() {
+    try {
+      const events = await this.client.getRecentEvents(PAGE_SIZE);
+      this.connected = true;
+
+      // Everything before the last event already seen is new (all of it
+      // after the buffer was cleared)
+      const seen = this.lastId ? events.findIndex(e => e.id === this.lastId) : -1;
+      const fresh = seen === -1 ? events : events.slice(0, seen);
+      if (events.length > 0) this.lastId = events[0].id;
+
+      if (fresh.length > 0) {
+        for (const event of [...fresh].reverse()) {
+          if (!this.sources.has(event._source)) {
+            this.sources.set(event._source, { name: event._sourceName || event._source, color: event._sourceColor });
+          }
+        }
+        this.events = [...fresh, ...this.events].slice(0, this.maxEvents);
+        if (this.follow) this.selectFirst();
+      }
+    } catch (err) {
+      this.connected = false;
+    }
+    this.render();
+    this.timer = setTimeout(() => this.poll(), this.pollMs);
+  }
+
+  visibleEvents() {
+    const query = this.query.toLowerCase();
+    return this.events.filter(event => {
+      if (this.hiddenSources.has(event._source)) return false;
+      if (!query) return true;
+      return (event.event || '').toLowerCase().includes(query) ||
+        JSON.stringify(event.properties || {}).toLowerCase().includes(query);
+    });
+  }
+
+  selectFirst() {
+    const visible = this.visibleEvents();
+    this.selectedId = visible.length > 0 ? visible[0].id : null;
+    this.listOffset = 0;
+    this.detailOffset = 0;
+  }
+
+  selectedIndex(visible) {
+    const index = visible.findIndex(e => e.id === this.selectedId);
+    return index === -1 ? 0 : index;
+  }
+
+  moveSelection(delta) {
+    const visible = this.visibleEvents();
+    if (visible.length === 0) return;
+    const index = Math.min(visible.length - 1, Math.max(0, this.selectedIndex(visible) + delta));
+    this.selectedId = visible[index].id;
+    this.follow = index === 0;
+    this.detailOffset = 0;
+  }
+
+  handleKey(str, key) {
+    if (key.ctrl && key.name === 'c') return this.quit();
+
+    if (this.searching) {
+      if (key.name === 'return') {
+        this.searching = false;
+      } else if (key.name === 'escape') {
+        this.searching = false;
+        this.query = this.queryBeforeSearch;
+      } else if (key.name === 'backspace') {
+        this.query = this.query.slice(0, -1);
+      } else if (str && !key.ctrl && !key.meta && str >= ' ') {
+        this.query += str;
+      }
+      this.selectFirst();
+      return this.render();
+    }
+
+    const page = Math.max(1, this.layout().listHeight - 1);
+    const scrollDetail = delta => {
+      this.detailOffset = Math.max(0, this.detailOffset + delta);
+    };
+
+    // readline names "G" as "g" with shift
+    const name = key.shift && key.name && key.name.length === 1 ? key.name.toUpperCase() : key.name || str;
+    switch (name) {
+      case 'q':
+        return this.quit();
+      case 'up':
+      case 'k':
+        this.focus === 'detail' ? scrollDetail(-1) : this.moveSelection(-1);
+        break;
+      case 'down':
+      case 'j':
+        this.focus === 'detail' ? scrollDetail(1) : this.moveSelection(1);
+        break;
+      case 'pageup':
+        this.focus === 'detail' ? scrollDetail(-page) : this.moveSelection(-page);
+        break;
+      case 'pagedown':
+        this.focus === 'detail' ? scrollDetail(page) : this.moveSelection(page);
+        break;
+      case 'home':
+      case 'g':
+        this.follow = true;
+        this.selectFirst();
+        break;
+      case 'end':
+      case 'G':
+        this.moveSelection(Infinity);
+        break;
+      case 'tab':
+        this.focus = this.focus === 'list' ? 'detail' : 'list';
+        break;
+      case 'escape':
+        if (this.query) {
+          this.query = '';
+          this.selectFirst();
+        }
+        this.focus = 'list';
+        break;
+      case 'f':
+        this.follow = !this.follow;
+        if (this.follow) this.selectFirst();
+        this.showMessage(`Follow ${this.follow ? 'on' : 'off'}`);
+        break;
+      case 'c':
+        this.copySelected();
+        break;
+      default:
+        if (str === '/') {
+          this.searching = true;
+          this.queryBeforeSearch = this.query;
+        } else if (str === '0') {
+          this.hiddenSources.clear();
+          this.selectFirst();
+        } else if (/^[1-9]$/.test(str || '')) {
+          this.toggleSource(parseInt(str, 10) - 1);
+        }
+    }
+    this.render();
+  }
+
+  toggleSource(index) {
+    const id = [...this.sources.keys()][index];
+    if (id === undefined) return;
+    if (this.hiddenSources.has(id)) {
+      this.hiddenSources.delete(id);
+    } else {
+      this.hiddenSources.add(id);
+    }
+    this.selectFirst();
+  }
+
+  copySelected() {
+    const event = this.events.find(e => e.id === this.selectedId);
+    if (!event) {
+      this.showMessage('Nothing selected');
+      return;
+    }
+    const via = copyToClipboard(JSON.stringify(event, null, 2));
+    this.showMessage(`Copied "${event.event}" to the clipboard (${via})`);
+  }
+
+  showMessage(text) {
+    this.message = text;
+    this.messageAt = Date.now();
+  }
+
+  layout() {
+    const width = process.stdout.columns || 80;
+    const height = process.stdout.rows || 24;
+    const body = Math.max(2, height - 4); // Header, sources, status and help lines
+    const wide = width >= 110;
+    return wide
+      ? { width, height, wide, listWidth: Math.floor(width * 0.5), listHeight: body, detailHeight: body }
+      : { width, height, wide, listWidth: width, listHeight: Math.max(1, Math.floor(body / 2)), detailHeight: Math.max(1, body - Math.floor(body / 2) - 1) };
+  }
+
+  listLines(visible, { listWidth, listHeight }) {
+    const index = this.selectedIndex(visible);
+    if (index < this.listOffset) this.listOffset = index;
+    if (index >= this.listOffset + listHeight) this.listOffset = index - listHeight + 1;
+
+    const lines = [];
+    for (let row = 0; row < listHeight; row++) {
+      const event = visible[this.listOffset + row];
+      if (!event) {
+        lines.push(fitSegments([[row === 0 && visible.length === 0 ? (this.events.length === 0 ? '  Waiting for events...' : '  No events match') : '', DIM]], listWidth));
+        continue;
+      }
+      const selected = event.id === this.selectedId;
+      const source = ` ${event._sourceName || event._source} `;
+      const name = event.event || '(unnamed)';
+      const prefix = `${eventTime(event)} ${source} ${name}  `;
+      lines.push(fitSegments([
+        [eventTime(event) + ' ', selected ? '' : DIM],
+        [source, selected ? '' : badgeStyle(event._sourceColor)],
+        [` ${name}`, BOLD],
+        [`  ${summarizeProperties(event.properties, Math.max(10, listWidth - prefix.length))}`, selected ? '' : DIM]
+      ], listWidth, selected ? (this.focus === 'list' ? REVERSE : BOLD) : ''));
+    }
+    return lines;
+  }
+
+  detailLines(event, width, height) {
+    const json = event ? JSON.stringify(event, null, 2).split('\n') : [];
+    this.detailOffset = Math.min(this.detailOffset, Math.max(0, json.length - height));
+    const lines = [];
+    for (let row = 0; row < height; row++) {
+      const text = json[this.detailOffset + row];
+      lines.push(fitSegments([[text === undefined ? '' : text, '']], width));
+    }
+    return lines;
+  }
+
+  headerLine(visible, width) {
+    const state = this.connected === false ? 'disconnected, retrying' : this.follow ? 'following' : 'paused';
+    return fitSegments([
+      [` ${this.title} `, REVERSE + BOLD],
+      [`  ${visible.length}/${this.events.length} events  `, ''],
+      [state, this.connected === false ? `${CSI}31m` : DIM]
+    ], width);
+  }
+
+  sourcesLine(width) {
+    if (this.sources.size === 0) return fitSegments([['  No sources yet', DIM]], width);
+    const segments = [];
+    [...this.sources.entries()].slice(0, 9).forEach(([id, { name, color }], i) => {
+      const hidden = this.hiddenSources.has(id);
+      segments.push([` ${i + 1}`, DIM], [` ${name} `, hidden ? `${DIM}${CSI}9m` : badgeStyle(color)]);
+    });
+    return fitSegments(segments, width);
+  }
+
+  statusLine(width) {
+    if (this.searching) return fitSegments([[`/${this.query}█`, BOLD]], width);
+    const parts = [];
+    if (this.query) parts.push([`Search: "${this.query}" (esc clears)  `, '']);
+    if (this.message && Date.now() - this.messageAt < MESSAGE_MS) parts.push([this.message, DIM]);
+    return fitSegments(parts, width);
+  }
+
+  render() {
+    if (!this.done) return;
+    const layout = this.layout();
+    const { width, wide, listWidth, listHeight, detailHeight } = layout;
+    const visible = this.visibleEvents();
+    const selected = visible.find(e => e.id === this.selectedId) || null;
+
+    const lines = [this.headerLine(visible, width), this.sourcesLine(width)];
+    const list = this.listLines(visible, layout);
+    if (wide) {
+      const detailWidth = width - listWidth - 1;
+      const detail = this.detailLines(selected, detailWidth, detailHeight);
+      const divider = this.focus === 'detail' ? `${BOLD}│${RESET}` : `${DIM}│${RESET}`;
+      list.forEach((line, i) => lines.push(`${line}${divider}${detail[i]}`));
+    } else {
+      lines.push(...list);
+      lines.push(fitSegments([['─'.repeat(width), this.focus === 'detail' ? BOLD : DIM]], width));
+      lines.push(...this.detailLines(selected, width, detailHeight));
+    }
+    lines.push(this.statusLine(width), fitSegments([[HELP, DIM]], width));
+
+    process.stdout.write(`${CSI}H${lines.join('\r\n')}`);
+  }
+}
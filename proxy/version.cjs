@@ -0,0 +1,117 @@
+/**
+ * Version info shared by the native host and the proxy
+ *
+ * The version comes from package.json; build metadata (commit, build date)
+ * is written to build-info.json by build.sh. Source checkouts have no
+ * build-info.json, so the commit is read from git instead. The default
+ * sources revision is a hash of config/default-sources.js, so two installs
+ * with the same revision match the same built-in sources.
+ */
+
+const { execFileSync } = require('child_process');
+const crypto = require('crypto');
+const fs = require('fs');
+const path = require('path');
+
+const ROOT = path.join(__dirname, '..');
+const RELEASES_URL = 'https://api.github.com/repos/jnakagawa/loggy/releases/latest';
+
+function readJSON(filePath) {
+  try {
+    return JSON.parse(fs.readFileSync(filePath, 'utf8'));
+  } catch (err) {
+    return null;
+  }
+}
+
+/**
+ * Short commit of a source checkout, or null outside git
+ */
+function gitCommit() {
+  try {
+    return execFileSync('git', ['rev-parse', '--short', 'HEAD'], {
+      cwd: ROOT,
+      encoding: 'utf8',
+      stdio: ['ignore', 'pipe', 'ignore'],
+      timeout: 2000
+    }).trim() || null;
+  } catch (err) {
+    return null;
+  }
+}
+
+/**
+ * First 12 hex digits of the SHA-256 of the bundled default sources
+ */
+function defaultSourcesRevision() {
+  try {
+    const contents = fs.readFileSync(path.join(ROOT, 'config', 'default-sources.js'));
+    return crypto.createHash('sha256').update(contents).digest('hex').slice(0, 12);
+  } catch (err) {
+    return null;
+  }
+}
+
+/**
+ * Local version and build metadata
+ * @returns {object} - { version, commit, buildDate, defaultSourcesRevision, build }
+ *   where build is "release" (from build-info.json) or "source"
+ */
+function getVersionInfo() {
+  const pkg = readJSON(path.join(ROOT, 'package.json')) || {};
+  const build = readJSON(path.join(ROOT, 'build-info.json'));
+
+  return {
+    version: pkg.version || '0.0.0',
+    commit: build ? build.commit || null : gitCommit(),
+    buildDate: build ? build.buildDate || null : null,
+    defaultSourcesRevision: defaultSourcesRevision(),
+    build: build ? 'release' : 'source'
+  };
+}
+
+/**
+ * Compare two semver strings (ignores pre-release tags)
+ * @returns {number} - Negative if a < b, positive if a > b, 0 if equal
+ */
+function compareVersions(a, b) {
+  const parse = v => String(v).replace(/^v/, '').split('-')[0].split('.').map(n => parseInt(n, 10) || 0);
+  const [pa, pb] = [parse(a), parse(b)];
+  for (let i = 0; i < 3; i++) {
+    if ((pa[i] || 0) !== (pb[i] || 0)) return (pa[i] || 0) - (pb[i] || 0);
+  }
+  return 0;
+}
+
+/**
+ * The latest GitHub release, as the releases API returns it (tag_name,
+ * html_url, assets)
+ * @returns {Promise<object>}
+ */
+async function fetchLatestRelease() {
+  const response = await fetch(RELEASES_URL, {
+    headers: { Accept: 'application/vnd.github+json' },
+    signal: AbortSignal.timeout(5000)
+  });
+
+  if (!response.ok) {
+    throw new Error(`GitHub returned HTTP ${response.status}`);
+  }
+  return response.json();
+}
+
+/**
+ * Look up the latest GitHub release
+ * @returns {Promise<{latestVersion: string, releaseUrl: string, updateAvailable: boolean}>}
+ */
+async function checkForUpdate(currentVersion) {
+  const release = await fetchLatestRelease();
+  const latestVersion = String(release.tag_name || '').replace(/^v/, '');
+  return {
+    latestVersion,
+    releaseUrl: release.html_url,
+    updateAvailable: compareVersions(latestVersion, currentVersion) > 0
+  };
+}
+
+module.exports = { getVersionInfo, fetchLatestRelease, checkForUpdate, compareVersions, RELEASES_URL };
@@ -0,0 +1,99 @@
+/**
+ * WebSocketCapture - Analytics sent as WebSocket frames
+ *
+ * Some SDKs (session replay, realtime analytics) send events over a
+ * WebSocket rather than in HTTP requests. http-mitm-proxy relays the
+ * upgraded connections of the hosts it intercepts and decodes their frames;
+ * on a connection to a source that opts in (its `websocket` flag), each
+ * message the page sends whose data is a JSON object or array is handed to
+ * onFrame like a request body. Other frames (binary protocols, plain text)
+ * and frames over maxFrameBytes are skipped. Every frame is forwarded
+ * unchanged.
+ */
+
+// Upgrade headers that say nothing about the page or the payload
+const CONNECTION_HEADERS = /^(sec-websocket-|upgrade$|connection$|content-length$|content-encoding$)/i;
+
+/**
+ * A frame's data as a JSON body, or null when it isn't JSON
+ * @param {Buffer|ArrayBuffer|Array<Buffer>} data - Message data as ws delivers it
+ * @returns {Buffer|null}
+ */
+export function jsonFrame(data) {
+  const bytes = Array.isArray(data) ? Buffer.concat(data) : Buffer.from(data);
+  const text = bytes.toString('utf8').trim();
+  return /^[[{]/.test(text) ? Buffer.from(text) : null;
+}
+
+export class WebSocketCapture {
+  /**
+   * @param {object} options - The websockets settings
+   * @param {function} findSource - URL -> source capturing that URL's frames, or null
+   * @param {function} onFrame - Called with (source, body, headers, url) for each JSON frame
+   */
+  constructor(options, findSource, onFrame) {
+    this.findSource = findSource;
+    this.onFrame = onFrame;
+    this.connections = 0; // Connections whose frames were captured
+    this.frames = 0;
+    this.captured = 0;
+    this.skipped = 0; // Not JSON, or over maxFrameBytes
+    this.configure(options);
+  }
+
+  /**
+   * Apply new settings (e.g. after a reload); a source's flag is read when a
+   * connection opens
+   */
+  configure({ enabled = true, serverFrames = false, maxFrameBytes = 1024 * 1024 } = {}) {
+    this.enabled = enabled;
+    this.serverFrames = serverFrames;
+    this.maxFrameBytes = maxFrameBytes;
+  }
+
+  install(proxy) {
+    proxy.onWebSocketConnection((ctx, callback) => {
+      const { url, headers = {} } = ctx.proxyToServerWebSocketOptions;
+      const source = this.enabled && this.findSource(url);
+      if (source) {
+        this.connections++;
+        const frameHeaders = Object.fromEntries(
+          Object.entries(headers).filter(([name]) => !CONNECTION_HEADERS.test(name))
+        );
+        frameHeaders['content-type'] = 'application/json';
+        ctx.onWebSocketFrame((ctx, type, fromServer, data, flags, next) => {
+          if (type === 'message' && this.enabled && (!fromServer || this.serverFrames)) {
+            this.receive(source, data, frameHeaders, url);
+          }
+          next(null, data, flags);
+        });
+      }
+      callback();
+    });
+  }
+
+  receive(source, data, headers, url) {
+    this.frames++;
+    const size = Array.isArray(data) ? data.reduce((total, part) => total + part.length, 0) : data.byteLength;
+    const body = size <= this.maxFrameBytes ? jsonFrame(data) : null;
+    if (!body) {
+      this.skipped++;
+      return;
+    }
+    this.captured++;
+    this.onFrame(source, body, headers, url);
+  }
+
+  /**
+   * @returns {object} - { enabled, connections, frames, captured, skipped }
+   */
+  getStatus() {
+    return {
+      enabled: this.enabled,
+      connections: this.connections,
+      frames: this.frames,
+      captured: this.captured,
+      skipped: this.skipped
+    };
+  }
+}
@@ -0,0 +1,214 @@
+/**
+ * X.509 - Minimal DER encoder for signing certificates with Node's crypto
+ *
+ * http-mitm-proxy builds certificates with node-forge, which only handles RSA
+ * keys. This covers the subset of X.509 the proxy needs (names, validity,
+ * SANs and the usual CA/server extensions) for both RSA and ECDSA keys.
+ */
+
+import crypto from 'crypto';
+
+// DER tags
+const INTEGER = 0x02;
+const BIT_STRING = 0x03;
+const OCTET_STRING = 0x04;
+const NULL = 0x05;
+const OBJECT_ID = 0x06;
+const UTF8_STRING = 0x0c;
+const SEQUENCE = 0x30;
+const SET = 0x31;
+const UTC_TIME = 0x17;
+const GENERALIZED_TIME = 0x18;
+const BOOLEAN = 0x01;
+
+const OIDS = {
+  commonName: '2.5.4.3',
+  organizationName: '2.5.4.10',
+  organizationalUnitName: '2.5.4.11',
+  sha256WithRSAEncryption: '1.2.840.113549.1.1.11',
+  ecdsaWithSHA256: '1.2.840.10045.4.3.2',
+  subjectKeyIdentifier: '2.5.29.14',
+  keyUsage: '2.5.29.15',
+  subjectAltName: '2.5.29.17',
+  basicConstraints: '2.5.29.19',
+  authorityKeyIdentifier: '2.5.29.35',
+  extKeyUsage: '2.5.29.37',
+  serverAuth: '1.3.6.1.5.5.7.3.1',
+  clientAuth: '1.3.6.1.5.5.7.3.2'
+};
+
+// keyUsage bit positions (RFC 5280 4.2.1.3)
+const KEY_USAGE_BITS = {
+  digitalSignature: 0,
+  keyEncipherment: 2,
+  keyCertSign: 5,
+  cRLSign: 6
+};
+
+function encodeLength(length) {
+  if (length < 0x80) return Buffer.from([length]);
+  const bytes = [];
+  for (let n = length; n > 0; n >>= 8) bytes.unshift(n & 0xff);
+  return Buffer.from([0x80 | bytes.length, ...bytes]);
+}
+
+function tlv(tag, content) {
+  return Buffer.concat([Buffer.from([tag]), encodeLength(content.length), content]);
+}
+
+const sequence = (...items) => tlv(SEQUENCE, Buffer.concat(items));
+const set = (...items) => tlv(SET, Buffer.concat(items));
+const octetString = content => tlv(OCTET_STRING, content);
+const bitString = content => tlv(BIT_STRING, Buffer.concat([Buffer.from([0]), content]));
+const explicit = (n, content) => tlv(0xa0 + n, content);
+
+function integer(bytes) {
+  let content = Buffer.from(bytes);
+  while (content.length > 1 && content[0] === 0 && !(content[1] & 0x80)) content = content.subarray(1);
+  if (content[0] & 0x80) content = Buffer.concat([Buffer.from([0]), content]);
+  return tlv(INTEGER, content);
+}
+
+function oid(dotted) {
+  const parts = dotted.split('.').map(Number);
+  const bytes = [parts[0] * 40 + parts[1]];
+  for (const part of parts.slice(2)) {
+    const chunk = [part & 0x7f];
+    for (let n = part >>> 7; n > 0; n >>>= 7) chunk.unshift((n & 0x7f) | 0x80);
+    bytes.push(...chunk);
+  }
+  return tlv(OBJECT_ID, Buffer.from(bytes));
+}
+
+// Encoders shared with pkcs12.js
+export const der = { tlv, sequence, set, octetString, explicit, integer, oid, nullValue: tlv(NULL, Buffer.alloc(0)) };
+
+function time(date) {
+  // UTCTime through 2049, GeneralizedTime after (RFC 5280 4.1.2.5)
+  const iso = date.toISOString().replace(/[-:T]/g, '').slice(0, 14) + 'Z';
+  return date.getUTCFullYear() < 2050
+    ? tlv(UTC_TIME, Buffer.from(iso.slice(2)))
+    : tlv(GENERALIZED_TIME, Buffer.from(iso));
+}
+
+/**
+ * Encode a distinguished name
+ * @param {object} attrs - e.g. { commonName, organizationName, organizationalUnitName }
+ */
+function name(attrs) {
+  const rdns = Object.entries(attrs)
+    .filter(([, value]) => value)
+    .map(([key, value]) => set(sequence(oid(OIDS[key]), tlv(UTF8_STRING, Buffer.from(String(value))))));
+  return sequence(...rdns);
+}
+
+function extension(id, critical, value) {
+  return sequence(oid(OIDS[id]), ...(critical ? [tlv(BOOLEAN, Buffer.from([0xff]))] : []), octetString(value));
+}
+
+function keyUsage(usages) {
+  let bits = 0;
+  usages.forEach(usage => { bits |= 0x80 >> KEY_USAGE_BITS[usage]; });
+  // DER drops trailing zero bits from named bit lists
+  let unused = 0;
+  while (unused < 7 && !(bits & (1 << unused))) unused++;
+  return tlv(BIT_STRING, Buffer.from([unused, bits]));
+}
+
+function subjectAltName(hosts) {
+  return sequence(...hosts.map(host => {
+    if (/^[\d.]+$/.test(host)) {
+      return tlv(0x87, Buffer.from(host.split('.').map(Number))); // iPAddress
+    }
+    return tlv(0x82, Buffer.from(host)); // dNSName
+  }));
+}
+
+function readLength(buffer, offset) {
+  const first = buffer[offset];
+  if (first < 0x80) return { length: first, size: 1 };
+  const count = first & 0x7f;
+  let length = 0;
+  for (let i = 1; i <= count; i++) length = (length << 8) | buffer[offset + i];
+  return { length, size: 1 + count };
+}
+
+/**
+ * SHA-1 of the subjectPublicKey bits (RFC 5280 4.2.1.2, method 1)
+ * @param {crypto.KeyObject} publicKey
+ * @returns {Buffer}
+ */
+export function keyIdentifier(publicKey) {
+  // SubjectPublicKeyInfo ::= SEQUENCE { AlgorithmIdentifier, BIT STRING }
+  const spki = publicKey.export({ type: 'spki', format: 'der' });
+  let offset = 1 + readLength(spki, 1).size;
+  let keyBits = null;
+
+  while (offset < spki.length) {
+    const { length, size } = readLength(spki, offset + 1);
+    const start = offset + 1 + size;
+    if (spki[offset] === BIT_STRING) keyBits = spki.subarray(start + 1, start + length); // Skip unused-bits byte
+    offset = start + length;
+  }
+
+  return crypto.createHash('sha1').update(keyBits).digest();
+}
+
+function signatureAlgorithm(key) {
+  return key.asymmetricKeyType === 'ec'
+    ? sequence(oid(OIDS.ecdsaWithSHA256))
+    : sequence(oid(OIDS.sha256WithRSAEncryption), tlv(NULL, Buffer.alloc(0)));
+}
+
+/**
+ * Build and sign a certificate
+ * @param {object} options
+ * @param {crypto.KeyObject} options.publicKey - Subject's public key
+ * @param {crypto.KeyObject} options.signingKey - Issuer's private key
+ * @param {crypto.KeyObject} options.issuerPublicKey - Issuer's public key (for the authority key ID)
+ * @param {object} options.subject - Subject name attributes
+ * @param {object} options.issuer - Issuer name attributes
+ * @param {Date} options.notBefore
+ * @param {Date} options.notAfter
+ * @param {boolean} options.isCA - CA certificate (keyCertSign) vs server leaf
+ * @param {Array<string>} options.hosts - Subject alternative names for leaves
+ * @returns {string} - PEM certificate
+ */
+export function createCertificate(options) {
+  const { publicKey, signingKey, issuerPublicKey, subject, issuer, notBefore, notAfter, isCA, hosts = [] } = options;
+  const algorithm = signatureAlgorithm(signingKey);
+
+  const extensions = [
+    extension('basicConstraints', true, isCA ? sequence(tlv(BOOLEAN, Buffer.from([0xff]))) : sequence()),
+    extension('keyUsage', true, keyUsage(isCA
+      ? ['digitalSignature', 'keyCertSign', 'cRLSign']
+      : publicKey.asymmetricKeyType === 'ec' ? ['digitalSignature'] : ['digitalSignature', 'keyEncipherment'])),
+    extension('subjectKeyIdentifier', false, octetString(keyIdentifier(publicKey))),
+    extension('authorityKeyIdentifier', false, sequence(tlv(0x80, keyIdentifier(issuerPublicKey || publicKey))))
+  ];
+  if (!isCA) {
+    extensions.push(extension('extKeyUsage', false, sequence(oid(OIDS.serverAuth), oid(OIDS.clientAuth))));
+    extensions.push(extension('subjectAltName', false, subjectAltName(hosts)));
+  }
+
+  // Positive 128-bit serial
+  const serial = crypto.randomBytes(16);
+  serial[0] &= 0x7f;
+
+  const tbs = sequence(
+    explicit(0, integer([2])), // v3
+    integer(serial),
+    algorithm,
+    name(issuer),
+    sequence(time(notBefore), time(notAfter)),
+    name(subject),
+    publicKey.export({ type: 'spki', format: 'der' }),
+    explicit(3, sequence(...extensions))
+  );
+
+  const signature = crypto.sign('sha256', tbs, signingKey);
+  const der = sequence(tbs, algorithm, bitString(signature));
+
+  const base64 = der.toString('base64').match(/.{1,64}/g).join('\n');
+  return `-----BEGIN CERTIFICATE-----\n${base64}\n-----END CERTIFICATE-----\n`;
+}